@@ -0,0 +1,53 @@
+package otpgateway
+
+import (
+	"net/http"
+	"time"
+)
+
+// Redacted replaces the values of secrets in SentRecords.
+const Redacted = "[REDACTED]"
+
+// SentRecord is the audit record of a request a Provider sent to its
+// upstream to push a message, with the secrets (eg: API keys) redacted
+// and the recipient and OTP masked.
+type SentRecord struct {
+	Provider  string      `json:"provider"`
+	Endpoint  string      `json:"endpoint"`
+	Method    string      `json:"method"`
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body"`
+	Timestamp time.Time   `json:"timestamp"`
+
+	// MessageID is the ID issued by the upstream, if the request
+	// succeeded.
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// AuditSink receives the SentRecords of pushes, for instance, to
+// persist an audit trail. Record is called in the push path and
+// should never block.
+type AuditSink interface {
+	Record(SentRecord)
+}
+
+// AuditSetter is an optional interface implemented by Providers that
+// can emit SentRecords.
+type AuditSetter interface {
+	SetAuditSink(AuditSink)
+}
+
+// RedactHeaders returns a copy of the headers with the values of the
+// given (case insensitive) secret headers replaced with Redacted.
+func RedactHeaders(h http.Header, secrets ...string) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		out[k] = append([]string(nil), v...)
+	}
+	for _, k := range secrets {
+		if _, ok := out[http.CanonicalHeaderKey(k)]; ok {
+			out.Set(k, Redacted)
+		}
+	}
+	return out
+}
@@ -0,0 +1,23 @@
+package otpgateway
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("api-key", "secret")
+	h.Set("Content-Type", "application/json")
+
+	r := RedactHeaders(h, "API-Key", "Authorization")
+	assert.Equal(t, Redacted, r.Get("api-key"))
+	assert.Equal(t, "application/json", r.Get("Content-Type"))
+	_, ok := r["Authorization"]
+	assert.False(t, ok, "absent header added")
+
+	// The original is untouched.
+	assert.Equal(t, "secret", h.Get("api-key"))
+}
@@ -0,0 +1,62 @@
+package otpgateway
+
+import (
+	"context"
+	"log"
+)
+
+type ctxKey int
+
+const (
+	ctxReference ctxKey = iota
+	ctxLogger
+	ctxMetadata
+)
+
+// WithReference returns a context carrying a custom reference (eg: an
+// order or session ID) that Providers that support it pass to the
+// backend, which echoes it back in delivery reports.
+func WithReference(ctx context.Context, ref string) context.Context {
+	return context.WithValue(ctx, ctxReference, ref)
+}
+
+// ReferenceFromContext returns the custom reference set with
+// WithReference, if any.
+func ReferenceFromContext(ctx context.Context) string {
+	ref, _ := ctx.Value(ctxReference).(string)
+	return ref
+}
+
+// WithMetadata returns a context carrying key-value metadata (eg: a
+// campaign or tenant) that Providers that support it store with the
+// message in the backend's custom fields, which are echoed back in
+// delivery reports. Support and limits vary by Provider. solsms sends
+// up to 4 keys, listed in its MetadataFields config, as Kaleyra's
+// custom1 - custom4 fields. Other Providers, eg: twilio_verify, whose
+// backends don't support arbitrary metadata, ignore it.
+func WithMetadata(ctx context.Context, m map[string]string) context.Context {
+	return context.WithValue(ctx, ctxMetadata, m)
+}
+
+// MetadataFromContext returns the metadata set with WithMetadata, if
+// any. The map mustn't be modified.
+func MetadataFromContext(ctx context.Context) map[string]string {
+	m, _ := ctx.Value(ctxMetadata).(map[string]string)
+	return m
+}
+
+// WithLogger returns a context carrying a logger that Providers that
+// support it use for the log lines of a push, for instance, a logger
+// with a request specific prefix to correlate logs.
+func WithLogger(ctx context.Context, l *log.Logger) context.Context {
+	return context.WithValue(ctx, ctxLogger, l)
+}
+
+// LoggerFromContext returns the logger set with WithLogger or def if
+// there's none.
+func LoggerFromContext(ctx context.Context, def *log.Logger) *log.Logger {
+	if l, ok := ctx.Value(ctxLogger).(*log.Logger); ok && l != nil {
+		return l
+	}
+	return def
+}
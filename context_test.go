@@ -0,0 +1,33 @@
+package otpgateway
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReference(t *testing.T) {
+	assert.Equal(t, "", ReferenceFromContext(context.Background()))
+
+	ctx := WithReference(context.Background(), "order-123")
+	assert.Equal(t, "order-123", ReferenceFromContext(ctx))
+}
+
+func TestMetadata(t *testing.T) {
+	assert.Nil(t, MetadataFromContext(context.Background()))
+
+	m := map[string]string{"campaign": "diwali", "tenant": "acme"}
+	assert.Equal(t, m, MetadataFromContext(WithMetadata(context.Background(), m)))
+}
+
+func TestLogger(t *testing.T) {
+	def := log.New(ioutil.Discard, "", 0)
+	assert.Equal(t, def, LoggerFromContext(context.Background(), def))
+
+	l := log.New(ioutil.Discard, "req-1 ", 0)
+	assert.Equal(t, l, LoggerFromContext(WithLogger(context.Background(), l), def))
+	assert.Equal(t, def, LoggerFromContext(WithLogger(context.Background(), nil), def))
+}
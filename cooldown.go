@@ -0,0 +1,104 @@
+package otpgateway
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+// ErrCooldown is returned by Providers when a push is rejected because
+// the recipient was sent a message within the cooldown period.
+var ErrCooldown = errors.New("resend cooldown in effect")
+
+// CooldownStore records cooldowns (eg: between resends to a recipient).
+// A store shared by all gateway instances, such as Redis, enforces the
+// cooldowns cluster-wide.
+type CooldownStore interface {
+	// Acquire starts a cooldown on key for ttl if there isn't one and
+	// reports whether it did. false means the key is cooling down.
+	Acquire(key string, ttl time.Duration) (bool, error)
+}
+
+// CooldownSetter is an optional interface implemented by Providers that
+// enforce cooldowns and can use a shared CooldownStore instead of their
+// in-process one.
+type CooldownSetter interface {
+	SetCooldownStore(CooldownStore)
+}
+
+// redisCooldownStore is a Redis CooldownStore.
+type redisCooldownStore struct {
+	pool      *redis.Pool
+	keyPrefix string
+}
+
+// NewRedisCooldownStore returns a Redis implementation of CooldownStore.
+func NewRedisCooldownStore(c RedisConf) CooldownStore {
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "OTP"
+	}
+	return &redisCooldownStore{
+		pool:      newRedisPool(c),
+		keyPrefix: c.KeyPrefix,
+	}
+}
+
+// Acquire sets the key with SET NX so that only one instance can
+// start a cooldown on it.
+func (r *redisCooldownStore) Acquire(key string, ttl time.Duration) (bool, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	ms := int64(ttl / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	_, err := redis.String(c.Do("SET", fmt.Sprintf("%s:cooldown:%s", r.keyPrefix, key), "1", "NX", "PX", ms))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// memCooldownStore is an in-process CooldownStore.
+type memCooldownStore struct {
+	until map[string]time.Time
+	mu    sync.Mutex
+	clock clock.Clock
+}
+
+// NewMemCooldownStore returns an in-process CooldownStore that only
+// enforces cooldowns within a single gateway instance.
+func NewMemCooldownStore() CooldownStore {
+	return &memCooldownStore{until: make(map[string]time.Time), clock: clock.Real}
+}
+
+// Acquire starts a cooldown on the key if there isn't an unexpired one.
+func (m *memCooldownStore) Acquire(key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	if t, ok := m.until[key]; ok && now.Before(t) {
+		return false, nil
+	}
+
+	// Evict expired keys once in a while so that the map doesn't grow
+	// with every recipient ever seen.
+	if len(m.until) >= 1000 {
+		for k, t := range m.until {
+			if !now.Before(t) {
+				delete(m.until, k)
+			}
+		}
+	}
+	m.until[key] = now.Add(ttl)
+	return true, nil
+}
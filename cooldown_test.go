@@ -0,0 +1,74 @@
+package otpgateway
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+func TestRedisCooldownStore(t *testing.T) {
+	rd, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	// Two gateway instances sharing the Redis.
+	port, _ := strconv.Atoi(rd.Port())
+	conf := RedisConf{Host: rd.Host(), Port: port, KeyPrefix: "test"}
+	a, b := NewRedisCooldownStore(conf), NewRedisCooldownStore(conf)
+
+	ok, err := a.Acquire("ns:+919876543210", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok, "cooldown not started")
+
+	ok, err = b.Acquire("ns:+919876543210", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "cooldown not enforced across instances")
+
+	ok, err = a.Acquire("ns:+919876543210", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok, "cooldown not enforced")
+	assert.True(t, rd.Exists("test:cooldown:ns:+919876543210"))
+
+	// Other keys aren't affected.
+	ok, err = b.Acquire("ns:+14155551234", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The cooldown expires.
+	rd.FastForward(time.Minute)
+	ok, err = b.Acquire("ns:+919876543210", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok, "cooldown didn't expire")
+
+	// Errors are returned when Redis is down.
+	rd.Close()
+	_, err = a.Acquire("ns:+919876543210", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestMemCooldownStore(t *testing.T) {
+	var (
+		m   = NewMemCooldownStore()
+		clk = clock.NewFake(time.Now())
+	)
+	m.(*memCooldownStore).clock = clk
+	ok, err := m.Acquire("a", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, _ = m.Acquire("a", 50*time.Millisecond)
+	assert.False(t, ok, "cooldown not enforced")
+
+	ok, _ = m.Acquire("b", 50*time.Millisecond)
+	assert.True(t, ok)
+
+	clk.Advance(50 * time.Millisecond)
+	ok, _ = m.Acquire("a", 50*time.Millisecond)
+	assert.True(t, ok, "cooldown didn't expire")
+}
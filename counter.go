@@ -0,0 +1,139 @@
+package otpgateway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+// CounterStore keeps expiring counts (eg: of failures) that Providers
+// adapt their behaviour by. A store shared by all gateway instances,
+// such as Redis, shares the counts across them.
+type CounterStore interface {
+	// Incr increments the count of key and returns the new count. A new
+	// count expires after ttl, which isn't extended by increments.
+	Incr(key string, ttl time.Duration) (int, error)
+
+	// Get returns the unexpired count of key or 0.
+	Get(key string) (int, error)
+}
+
+// CounterSetter is an optional interface implemented by Providers that
+// keep counts and can use a shared CounterStore instead of their
+// in-process one.
+type CounterSetter interface {
+	SetCounterStore(CounterStore)
+}
+
+// redisCounterStore is a Redis CounterStore.
+type redisCounterStore struct {
+	pool      *redis.Pool
+	keyPrefix string
+}
+
+// NewRedisCounterStore returns a Redis implementation of CounterStore.
+func NewRedisCounterStore(c RedisConf) CounterStore {
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "OTP"
+	}
+	return &redisCounterStore{
+		pool:      newRedisPool(c),
+		keyPrefix: c.KeyPrefix,
+	}
+}
+
+// Incr creates the count with its expiry with SET NX if it doesn't
+// exist and increments it with INCR, which keeps the expiry, in a
+// transaction so that a count is never left without an expiry.
+func (r *redisCounterStore) Incr(key string, ttl time.Duration) (int, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	ms := int64(ttl / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	key = fmt.Sprintf("%s:counter:%s", r.keyPrefix, key)
+	c.Send("MULTI")
+	c.Send("SET", key, 0, "PX", ms, "NX")
+	c.Send("INCR", key)
+	rep, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range rep {
+		if e, ok := v.(redis.Error); ok {
+			return 0, e
+		}
+	}
+	return redis.Int(rep[1], nil)
+}
+
+// Get returns the count of the key.
+func (r *redisCounterStore) Get(key string) (int, error) {
+	c := r.pool.Get()
+	defer c.Close()
+
+	n, err := redis.Int(c.Do("GET", fmt.Sprintf("%s:counter:%s", r.keyPrefix, key)))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// memCounterStore is an in-process CounterStore.
+type memCounterStore struct {
+	counts map[string]memCount
+	mu     sync.Mutex
+	clock  clock.Clock
+}
+
+type memCount struct {
+	n       int
+	expires time.Time
+}
+
+// NewMemCounterStore returns an in-process CounterStore whose counts
+// are only seen by a single gateway instance.
+func NewMemCounterStore() CounterStore {
+	return &memCounterStore{counts: make(map[string]memCount), clock: clock.Real}
+}
+
+// Incr increments the count of the key, starting a new one if it has
+// expired.
+func (m *memCounterStore) Incr(key string, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	c, ok := m.counts[key]
+	if !ok || !now.Before(c.expires) {
+		// Evict expired counts once in a while so that the map doesn't
+		// grow with every key ever seen.
+		if len(m.counts) >= 1000 {
+			for k, c := range m.counts {
+				if !now.Before(c.expires) {
+					delete(m.counts, k)
+				}
+			}
+		}
+		c = memCount{expires: now.Add(ttl)}
+	}
+	c.n++
+	m.counts[key] = c
+	return c.n, nil
+}
+
+// Get returns the count of the key if it hasn't expired.
+func (m *memCounterStore) Get(key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.counts[key]; ok && m.clock.Now().Before(c.expires) {
+		return c.n, nil
+	}
+	return 0, nil
+}
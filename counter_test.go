@@ -0,0 +1,80 @@
+package otpgateway
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+func TestRedisCounterStore(t *testing.T) {
+	rd, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+
+	// Two gateway instances sharing the Redis.
+	port, _ := strconv.Atoi(rd.Port())
+	conf := RedisConf{Host: rd.Host(), Port: port, KeyPrefix: "test"}
+	a, b := NewRedisCounterStore(conf), NewRedisCounterStore(conf)
+
+	n, err := a.Get("fails:IN")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = a.Incr("fails:IN", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = b.Incr("fails:IN", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n, "count not shared across instances")
+	n, err = a.Get("fails:IN")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, rd.Exists("test:counter:fails:IN"))
+	assert.Equal(t, time.Minute, rd.TTL("test:counter:fails:IN"), "count created without an expiry")
+
+	// Increments don't extend the expiry.
+	rd.FastForward(30 * time.Second)
+	a.Incr("fails:IN", time.Minute)
+	assert.Equal(t, 30*time.Second, rd.TTL("test:counter:fails:IN"))
+	rd.FastForward(30 * time.Second)
+
+	// The count expires.
+	n, err = b.Get("fails:IN")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n, "count didn't expire")
+
+	// Errors are returned when Redis is down.
+	rd.Close()
+	_, err = a.Incr("fails:IN", time.Minute)
+	assert.Error(t, err)
+	_, err = a.Get("fails:IN")
+	assert.Error(t, err)
+}
+
+func TestMemCounterStore(t *testing.T) {
+	var (
+		m   = NewMemCounterStore()
+		clk = clock.NewFake(time.Now())
+	)
+	m.(*memCounterStore).clock = clk
+	n, _ := m.Incr("a", 50*time.Millisecond)
+	assert.Equal(t, 1, n)
+	n, _ = m.Incr("a", 50*time.Millisecond)
+	assert.Equal(t, 2, n)
+	n, _ = m.Get("a")
+	assert.Equal(t, 2, n)
+	n, _ = m.Get("b")
+	assert.Equal(t, 0, n)
+
+	clk.Advance(50 * time.Millisecond)
+	n, _ = m.Get("a")
+	assert.Equal(t, 0, n, "count didn't expire")
+	n, _ = m.Incr("a", 50*time.Millisecond)
+	assert.Equal(t, 1, n, "expired count not reset")
+}
@@ -0,0 +1,17 @@
+package otpgateway
+
+import "context"
+
+// CredentialProvider supplies the current API key of a Provider's
+// upstream, for instance, short lived keys fetched from Vault or a
+// secrets manager.
+type CredentialProvider interface {
+	APIKey(ctx context.Context) (string, error)
+}
+
+// CredentialSetter is an optional interface implemented by Providers
+// that can fetch their API key from a CredentialProvider instead of
+// using the static key in their config.
+type CredentialSetter interface {
+	SetCredentialProvider(CredentialProvider)
+}
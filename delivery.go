@@ -0,0 +1,94 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Terminal delivery statuses reported by StatusCheckers. Any other
+// status is considered pending.
+const (
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrNoStatusChecker is returned by Reconcile when the Provider
+	// doesn't implement StatusChecker.
+	ErrNoStatusChecker = errors.New("provider doesn't support delivery status lookups")
+
+	// ErrRateLimited may be wrapped by Providers when their upstream
+	// rejects a request for exceeding its rate limit.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// DeliveryReport is the last known delivery status of a message sent
+// by a Provider.
+type DeliveryReport struct {
+	Provider  string    `json:"provider"`
+	MessageID string    `json:"message_id"`
+	Status    string    `json:"status"`
+	SentAt    time.Time `json:"sent_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeliveryStore stores the delivery reports of sent messages.
+type DeliveryStore interface {
+	// Pending returns the reports of the given Provider sent after
+	// the given time that don't have a terminal status yet.
+	Pending(provider string, after time.Time) ([]DeliveryReport, error)
+
+	// Update sets the status of a report.
+	Update(provider, messageID, status string) error
+}
+
+// IsTerminalStatus checks whether a delivery status is final.
+func IsTerminalStatus(status string) bool {
+	return status == StatusDelivered || status == StatusFailed
+}
+
+// Reconcile looks up the status of the Provider's pending reports
+// sent in the last since duration and updates the ones that have
+// reached a terminal status. It returns the number of reports updated.
+//
+// Lookups are done one at a time. Reconcile stops at the first lookup
+// that fails with ErrRateLimited or when ctx is done, returning the
+// error along with the count so far, so that the next run can pick up
+// the remaining reports. Other lookup errors skip the report.
+func Reconcile(ctx context.Context, store DeliveryStore, p Provider, since time.Duration) (int, error) {
+	sc, ok := p.(StatusChecker)
+	if !ok {
+		return 0, ErrNoStatusChecker
+	}
+
+	reports, err := store.Pending(p.ID(), time.Now().Add(-since))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, r := range reports {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		status, err := sc.DeliveryStatus(ctx, r.MessageID)
+		if err != nil {
+			if errors.Is(err, ErrRateLimited) || ctx.Err() != nil {
+				return n, err
+			}
+			continue
+		}
+		if !IsTerminalStatus(status) || status == r.Status {
+			continue
+		}
+
+		if err := store.Update(r.Provider, r.MessageID, status); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
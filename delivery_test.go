@@ -0,0 +1,154 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memDeliveryStore struct {
+	reports []DeliveryReport
+}
+
+func (m *memDeliveryStore) Pending(provider string, after time.Time) ([]DeliveryReport, error) {
+	var out []DeliveryReport
+	for _, r := range m.reports {
+		if r.Provider == provider && r.SentAt.After(after) && !IsTerminalStatus(r.Status) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (m *memDeliveryStore) Update(provider, id, status string) error {
+	for i, r := range m.reports {
+		if r.Provider == provider && r.MessageID == id {
+			m.reports[i].Status = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown report %s", id)
+}
+
+func (m *memDeliveryStore) status(id string) string {
+	for _, r := range m.reports {
+		if r.MessageID == id {
+			return r.Status
+		}
+	}
+	return ""
+}
+
+type statusProv struct {
+	healthProv
+	statuses map[string]string
+	errs     map[string]error
+	calls    []string
+}
+
+func (s *statusProv) DeliveryStatus(ctx context.Context, id string) (string, error) {
+	s.calls = append(s.calls, id)
+	if err := s.errs[id]; err != nil {
+		return "", err
+	}
+	return s.statuses[id], nil
+}
+
+func newDeliveryStore(ids ...string) *memDeliveryStore {
+	m := &memDeliveryStore{}
+	for _, id := range ids {
+		m.reports = append(m.reports, DeliveryReport{
+			Provider:  "dummy",
+			MessageID: id,
+			Status:    "sent",
+			SentAt:    time.Now().Add(-time.Minute),
+		})
+	}
+	return m
+}
+
+func TestReconcile(t *testing.T) {
+	store := newDeliveryStore("a", "b", "c", "d")
+	// Old and other providers' reports are skipped.
+	store.reports = append(store.reports,
+		DeliveryReport{Provider: "dummy", MessageID: "old", Status: "sent", SentAt: time.Now().Add(-time.Hour)},
+		DeliveryReport{Provider: "other", MessageID: "e", Status: "sent", SentAt: time.Now()})
+
+	p := &statusProv{
+		healthProv: healthProv{id: "dummy"},
+		statuses: map[string]string{
+			"a": StatusDelivered,
+			"b": "sent",
+			"c": StatusFailed,
+			"d": "queued",
+		},
+	}
+	n, err := Reconcile(context.Background(), store, p, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, p.calls)
+	assert.Equal(t, StatusDelivered, store.status("a"))
+	assert.Equal(t, "sent", store.status("b"))
+	assert.Equal(t, StatusFailed, store.status("c"))
+	assert.Equal(t, "sent", store.status("d"))
+
+	// Terminal reports aren't looked up again.
+	p.calls = nil
+	n, err = Reconcile(context.Background(), store, p, 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, []string{"b", "d"}, p.calls)
+
+	// Lookup errors skip the report.
+	store = newDeliveryStore("a", "b")
+	p.calls = nil
+	p.errs = map[string]error{"a": errors.New("boom")}
+	p.statuses["b"] = StatusDelivered
+	n, err = Reconcile(context.Background(), store, p, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "sent", store.status("a"))
+
+	// Rate limits stop the run.
+	store = newDeliveryStore("a", "b", "c")
+	p.calls = nil
+	p.errs = map[string]error{"b": fmt.Errorf("upstream: %w", ErrRateLimited)}
+	n, err = Reconcile(context.Background(), store, p, time.Hour)
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"a", "b"}, p.calls)
+
+	// Providers without status lookups.
+	_, err = Reconcile(context.Background(), store, &healthProv{id: "dummy"}, time.Hour)
+	assert.Equal(t, ErrNoStatusChecker, err)
+}
+
+func TestReconcileCancel(t *testing.T) {
+	store := newDeliveryStore("a", "b", "c")
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &cancelProv{statusProv: statusProv{
+		healthProv: healthProv{id: "dummy"},
+		statuses:   map[string]string{"a": StatusDelivered, "b": StatusDelivered, "c": StatusDelivered},
+	}, cancel: cancel}
+
+	n, err := Reconcile(ctx, store, p, time.Hour)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"a"}, p.calls)
+	assert.Equal(t, "sent", store.status("b"))
+}
+
+// cancelProv cancels the context after the first lookup.
+type cancelProv struct {
+	statusProv
+	cancel func()
+}
+
+func (c *cancelProv) DeliveryStatus(ctx context.Context, id string) (string, error) {
+	defer c.cancel()
+	return c.statusProv.DeliveryStatus(ctx, id)
+}
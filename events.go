@@ -0,0 +1,163 @@
+package otpgateway
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types emitted by Providers.
+const (
+	EventSend  = "send"
+	EventFail  = "fail"
+	EventRetry = "retry"
+
+	// Events parsed from callbacks by WebhookParsers.
+	EventDelivery = "delivery"
+	EventOptOut   = "optout"
+)
+
+// Event represents a message delivery event emitted by a Provider.
+type Event struct {
+	Type      string    `json:"type"`
+	Provider  string    `json:"provider"`
+	Namespace string    `json:"namespace"`
+	ID        string    `json:"id"`
+	MessageID string    `json:"message_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Duration  float64   `json:"duration_ms"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Status is the delivery status of delivery events (see
+	// IsTerminalStatus) and Address, the recipient of delivery events
+	// or the sender of opt-out events.
+	Status  string `json:"status,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	// Reference is the caller's reference echoed back in delivery
+	// events, eg: an order ID sent with the message.
+	Reference string `json:"reference,omitempty"`
+}
+
+// EventSink receives events from Providers. Emit is called in the
+// push path and should never block.
+type EventSink interface {
+	Emit(Event)
+}
+
+// SocketSink is an EventSink that writes events as newline delimited
+// JSON to a TCP, UDP (eg: statsd style listeners), or unix socket.
+// Events are buffered and written in the background. When the
+// buffer is full, events are dropped and counted.
+type SocketSink struct {
+	network string
+	addr    string
+	timeout time.Duration
+
+	ch      chan Event
+	dropped uint64
+	conn    net.Conn
+	wg      sync.WaitGroup
+
+	// mu guards closed so that events emitted after Close are dropped.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewSocketSink returns a SocketSink that writes to the given network
+// ("tcp", "udp", "unix") and address. bufSize is the number of events
+// that are buffered before events start getting dropped.
+func NewSocketSink(network, addr string, bufSize int, timeout time.Duration) *SocketSink {
+	if bufSize < 1 {
+		bufSize = 1000
+	}
+	if timeout == 0 {
+		timeout = time.Second * 2
+	}
+	s := &SocketSink{
+		network: network,
+		addr:    addr,
+		timeout: timeout,
+		ch:      make(chan Event, bufSize),
+	}
+
+	s.wg.Add(1)
+	go s.worker()
+	return s
+}
+
+// Emit queues an event for writing without blocking. If the
+// buffer is full, the event is dropped.
+func (s *SocketSink) Emit(e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	select {
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events that were dropped, either
+// because the buffer was full or the socket write failed.
+func (s *SocketSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close writes out the buffered events and closes the socket. Events
+// emitted after Close are dropped.
+func (s *SocketSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.ch)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// worker writes events from the buffer to the socket, (re)connecting
+// as required.
+func (s *SocketSink) worker() {
+	defer s.wg.Done()
+
+	for e := range s.ch {
+		b, err := json.Marshal(e)
+		if err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+			continue
+		}
+		b = append(b, '\n')
+
+		if s.conn == nil {
+			c, err := net.DialTimeout(s.network, s.addr, s.timeout)
+			if err != nil {
+				atomic.AddUint64(&s.dropped, 1)
+				continue
+			}
+			s.conn = c
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+		if _, err := s.conn.Write(b); err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+			s.conn.Close()
+			s.conn = nil
+		}
+	}
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
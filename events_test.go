@@ -0,0 +1,72 @@
+package otpgateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	out := make(chan Event, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var e Event
+		sc := bufio.NewScanner(c)
+		if sc.Scan() {
+			json.Unmarshal(sc.Bytes(), &e)
+		}
+		out <- e
+	}()
+
+	s := NewSocketSink("tcp", ln.Addr().String(), 10, 0)
+	s.Emit(Event{Type: EventSend, Provider: "dummy", ID: "myotpid"})
+	s.Close()
+
+	select {
+	case e := <-out:
+		assert.Equal(t, EventSend, e.Type, "event type doesn't match")
+		assert.Equal(t, "dummy", e.Provider, "event provider doesn't match")
+		assert.Equal(t, "myotpid", e.ID, "event ID doesn't match")
+	case <-time.After(time.Second * 2):
+		t.Fatal("event not received")
+	}
+	assert.Equal(t, uint64(0), s.Dropped())
+}
+
+func TestSocketSinkNonBlocking(t *testing.T) {
+	// A listener that accepts connections but never reads them.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s := NewSocketSink("tcp", ln.Addr().String(), 1, time.Millisecond*100)
+	start := time.Now()
+	for i := 0; i < 100000; i++ {
+		s.Emit(Event{Type: EventSend, Provider: "dummy"})
+	}
+	assert.True(t, time.Since(start) < time.Second, "Emit blocked on a slow sink")
+	assert.True(t, s.Dropped() > 0, "drops weren't counted")
+
+	// Events emitted after Close are dropped.
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+	n := s.Dropped()
+	s.Emit(Event{Type: EventSend, Provider: "dummy"})
+	assert.Equal(t, n+1, s.Dropped())
+}
@@ -11,6 +11,7 @@ require (
 	github.com/jordan-wright/email v0.0.0-20190109025503-f0c364f2e0b6
 	github.com/knadh/koanf v0.4.0
 	github.com/knadh/stuffbin v1.0.0
+	github.com/nyaruka/phonenumbers v1.0.71
 	github.com/spf13/pflag v1.0.3
 	github.com/stretchr/testify v1.3.0
 	github.com/yuin/gopher-lua v0.0.0-20190125051437-7b9317363aa9 // indirect
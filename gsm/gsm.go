@@ -0,0 +1,280 @@
+// Package gsm provides helpers for working with the GSM 03.38 (GSM-7)
+// character set used by SMS.
+package gsm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// basicChars is the GSM-7 basic character set, sans the escape character.
+	basicChars = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+	// extChars is the GSM-7 extension table. Each of these characters
+	// occupies two septets (escape + character).
+	extChars = "\f^{}\\[~]|€"
+
+	// Max characters (septets for GSM-7, UTF-16 code units for UCS-2)
+	// in a single SMS and in each part of a concatenated SMS, which
+	// loses some space to the concatenation header (UDH).
+	gsmSingle  = 160
+	gsmPart    = 153
+	ucs2Single = 70
+	ucs2Part   = 67
+
+	// Length of the concatenation UDH and the max parts it can number.
+	udhLen   = 6
+	maxParts = 255
+)
+
+var (
+	basic = makeSet(basicChars)
+	ext   = makeSet(extChars)
+
+	// translit maps common non GSM-7 characters, usually introduced by
+	// copy-pasting from word processors, to their GSM-7 equivalents.
+	translit = map[rune]string{
+		'‘':      "'",
+		'’':      "'",
+		'‚':      "'",
+		'‛':      "'",
+		'′':      "'",
+		'´':      "'",
+		'`':      "'",
+		'“':      `"`,
+		'”':      `"`,
+		'„':      `"`,
+		'″':      `"`,
+		'«':      `"`,
+		'»':      `"`,
+		'‐':      "-",
+		'‑':      "-",
+		'‒':      "-",
+		'–':      "-",
+		'—':      "-",
+		'―':      "-",
+		'−':      "-",
+		'…':      "...",
+		'•':      "*",
+		'\t':     " ",
+		'\u00a0': " ",
+		'\u2002': " ",
+		'\u2003': " ",
+		'\u2009': " ",
+		'\u202f': " ",
+		'\u200b': "",
+		'\ufeff': "",
+	}
+)
+
+// IsGSM7 tells if all the characters in the string can be encoded
+// in the GSM-7 character set (including the extension table).
+func IsGSM7(s string) bool {
+	return IndexNonGSM7(s) == -1
+}
+
+// IndexNonGSM7 returns the byte index of the first character in the
+// string that can't be encoded in GSM-7, or -1 if there's none.
+func IndexNonGSM7(s string) int {
+	for i, r := range s {
+		if !basic[r] && !ext[r] {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndexNonUCS2 returns the byte index of the first character in the
+// string that can't be encoded in UCS-2, which only covers the Basic
+// Multilingual Plane (eg: not most emoji), or -1 if there's none.
+func IndexNonUCS2(s string) int {
+	for i, r := range s {
+		if r > 0xffff {
+			return i
+		}
+	}
+	return -1
+}
+
+// Transliterate replaces common non GSM-7 characters (curly quotes,
+// dashes, special spaces etc.) with their GSM-7 equivalents. Characters
+// that have no equivalent are left as is.
+func Transliterate(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if t, ok := translit[r]; ok {
+			b.WriteString(t)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Segments returns the number of SMS segments (parts) the string is
+// sent as. GSM-7 strings fit 160 characters in one segment and 153 in
+// each segment of concatenated messages where extension characters
+// take two. Other strings are sent as UCS-2 with 70 and 67 UTF-16 code
+// units. Extension characters and surrogate pairs are never split
+// across segments.
+func Segments(s string) int {
+	return len(split(s))
+}
+
+// Part is a segment of a concatenated SMS.
+type Part struct {
+	// UDH is the user data header with the concatenation information
+	// element: 05 00 03 <ref> <total> <seq>. It's nil if the string
+	// fits in a single segment.
+	UDH []byte
+
+	// Text is the part of the string carried by the segment.
+	Text string
+}
+
+// Ref returns the reference number of the message the part is of.
+// A part without a UDH is the only part of its message.
+func (p Part) Ref() byte {
+	if len(p.UDH) < udhLen {
+		return 0
+	}
+	return p.UDH[3]
+}
+
+// Total returns the number of parts of the message.
+func (p Part) Total() int {
+	if len(p.UDH) < udhLen {
+		return 1
+	}
+	return int(p.UDH[4])
+}
+
+// Seq returns the 1-based sequence number of the part.
+func (p Part) Seq() int {
+	if len(p.UDH) < udhLen {
+		return 1
+	}
+	return int(p.UDH[5])
+}
+
+// Split splits the string into the segments it's sent as (see Segments)
+// for gateways that don't concatenate long messages themselves, eg:
+// SMPP. Parts of a concatenated message carry a UDH with the given
+// reference number, which should differ between the messages sent to
+// a recipient around the same time. The parts are to be sent with
+// the encoding of the whole string: GSM-7 if IsGSM7, else UCS-2.
+func Split(s string, ref byte) ([]Part, error) {
+	texts := split(s)
+	if len(texts) > maxParts {
+		return nil, fmt.Errorf("string is %d segments, more than the max of %d", len(texts), maxParts)
+	}
+	if len(texts) == 1 {
+		return []Part{{Text: texts[0]}}, nil
+	}
+
+	out := make([]Part, len(texts))
+	for i, t := range texts {
+		out[i] = Part{
+			UDH:  []byte{udhLen - 1, 0x00, 0x03, ref, byte(len(texts)), byte(i + 1)},
+			Text: t,
+		}
+	}
+	return out, nil
+}
+
+// Join reassembles the parts of a concatenated message, which may be
+// in any order, into the original string. It returns an error if the
+// parts are of different messages or some are missing.
+func Join(parts []Part) (string, error) {
+	if len(parts) == 0 {
+		return "", errors.New("no parts")
+	}
+	if len(parts) == 1 && parts[0].UDH == nil {
+		return parts[0].Text, nil
+	}
+
+	var (
+		ref   = parts[0].Ref()
+		total = parts[0].Total()
+		texts = make([]string, total)
+		seen  = make([]bool, total)
+	)
+	for _, p := range parts {
+		if len(p.UDH) < udhLen || p.UDH[1] != 0x00 {
+			return "", errors.New("part without a concatenation header")
+		}
+		if p.Ref() != ref || p.Total() != total {
+			return "", errors.New("parts of different messages")
+		}
+		n := p.Seq()
+		if n < 1 || n > total || seen[n-1] {
+			return "", fmt.Errorf("invalid or duplicate part %d of %d", n, total)
+		}
+		texts[n-1] = p.Text
+		seen[n-1] = true
+	}
+	if len(parts) != total {
+		return "", fmt.Errorf("%d of %d parts", len(parts), total)
+	}
+	return strings.Join(texts, ""), nil
+}
+
+// split packs the characters of the string into the segments it's
+// sent as.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	// Size of each character in units of the encoding.
+	var (
+		sizes = make([]int, 0, len(s))
+		total = 0
+		isGSM = IsGSM7(s)
+	)
+	for _, r := range s {
+		n := 1
+		if isGSM && ext[r] {
+			n = 2
+		} else if !isGSM && r > 0xffff {
+			n = 2
+		}
+		sizes = append(sizes, n)
+		total += n
+	}
+
+	single, part := gsmSingle, gsmPart
+	if !isGSM {
+		single, part = ucs2Single, ucs2Part
+	}
+	if total <= single {
+		return []string{s}
+	}
+
+	// Pack the characters into parts.
+	var (
+		out          []string
+		cur, i, from = 0, 0, 0
+	)
+	for at := range s {
+		if n := sizes[i]; cur+n > part {
+			out = append(out, s[from:at])
+			from, cur = at, 0
+		}
+		cur += sizes[i]
+		i++
+	}
+	return append(out, s[from:])
+}
+
+func makeSet(chars string) map[rune]bool {
+	out := make(map[rune]bool)
+	for _, r := range chars {
+		out[r] = true
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package gsm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsGSM7(t *testing.T) {
+	assert.True(t, IsGSM7("Your code is 482910. Don't share it!"))
+	assert.True(t, IsGSM7("Price: €10 [approx]"))
+	assert.False(t, IsGSM7("Your code is 482910 — don’t share it"))
+	assert.False(t, IsGSM7("आपका कोड 482910"))
+}
+
+func TestIndexNonGSM7(t *testing.T) {
+	assert.Equal(t, -1, IndexNonGSM7("Price: €10 [approx]"))
+	assert.Equal(t, -1, IndexNonGSM7(""))
+	assert.Equal(t, 13, IndexNonGSM7("Your code is 😀 482910"))
+	assert.Equal(t, 0, IndexNonGSM7("आपका कोड 482910"))
+}
+
+func TestIndexNonUCS2(t *testing.T) {
+	assert.Equal(t, -1, IndexNonUCS2("आपका कोड 482910 — don’t share it"))
+	assert.Equal(t, 13, IndexNonUCS2("Your code is 😀 482910"))
+	assert.Equal(t, -1, IndexNonUCS2("Price: €10"))
+}
+
+func TestTransliterate(t *testing.T) {
+	for in, out := range map[string]string{
+		"Your code is 482910 — don’t share it": "Your code is 482910 - don't share it",
+		"“482910” is your code…":               `"482910" is your code...`,
+		"Code:\u00a0482910":                    "Code: 482910",
+		"Plain 482910":                         "Plain 482910",
+	} {
+		got := Transliterate(in)
+		assert.Equal(t, out, got, "transliteration doesn't match")
+		assert.True(t, IsGSM7(got), "transliterated string isn't GSM-7")
+	}
+
+	// Characters without an equivalent are left as is.
+	assert.Equal(t, "कोड 482910", Transliterate("कोड 482910"))
+}
+
+func TestSegments(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		segs int
+	}{
+		{"", 0},
+		{"Your code is 482910", 1},
+		{strings.Repeat("a", 160), 1},
+		{strings.Repeat("a", 161), 2},
+		{strings.Repeat("a", 306), 2},
+		{strings.Repeat("a", 307), 3},
+
+		// Extension characters take two septets.
+		{strings.Repeat("€", 80), 1},
+		{strings.Repeat("€", 81), 2},
+		// ... and aren't split across parts.
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 100), 2},
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), 3},
+
+		// UCS-2.
+		{strings.Repeat("क", 70), 1},
+		{strings.Repeat("क", 71), 2},
+		{strings.Repeat("क", 134), 2},
+		{strings.Repeat("क", 135), 3},
+		{"Your code is 482910 — don’t share it", 1},
+
+		// Surrogate pairs take two code units.
+		{strings.Repeat("😀", 35), 1},
+		{strings.Repeat("😀", 36), 2},
+	} {
+		assert.Equal(t, c.segs, Segments(c.in), "%d chars", len([]rune(c.in)))
+	}
+}
+
+func TestSplit(t *testing.T) {
+	// A single segment has no UDH.
+	parts, err := Split("Your code is 482910", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, []Part{{Text: "Your code is 482910"}}, parts)
+	assert.Equal(t, 1, parts[0].Seq())
+	assert.Equal(t, 1, parts[0].Total())
+
+	for _, c := range []struct {
+		in    string
+		sizes []int
+	}{
+		{strings.Repeat("a", 307), []int{153, 153, 1}},
+		{strings.Repeat("a", 152) + "€" + strings.Repeat("a", 152), []int{152, 152, 1}},
+		{strings.Repeat("क", 135), []int{67, 67, 1}},
+		{strings.Repeat("😀", 67), []int{33, 33, 1}},
+	} {
+		parts, err := Split(c.in, 0x2a)
+		assert.NoError(t, err)
+		if !assert.Len(t, parts, 3) {
+			continue
+		}
+		for i, p := range parts {
+			assert.Equal(t, []byte{0x05, 0x00, 0x03, 0x2a, 0x03, byte(i + 1)}, p.UDH)
+			assert.Equal(t, byte(0x2a), p.Ref())
+			assert.Equal(t, 3, p.Total())
+			assert.Equal(t, i+1, p.Seq())
+			assert.Equal(t, c.sizes[i], len([]rune(p.Text)))
+		}
+
+		// Parts reassemble in any order.
+		s, err := Join([]Part{parts[2], parts[0], parts[1]})
+		assert.NoError(t, err)
+		assert.Equal(t, c.in, s)
+	}
+
+	_, err = Split(strings.Repeat("a", 153*256), 1)
+	assert.Error(t, err)
+}
+
+func TestJoin(t *testing.T) {
+	a, _ := Split(strings.Repeat("a", 307), 1)
+	b, _ := Split(strings.Repeat("b", 307), 2)
+
+	s, err := Join([]Part{{Text: "single"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "single", s)
+
+	for name, parts := range map[string][]Part{
+		"empty":     nil,
+		"missing":   a[:2],
+		"duplicate": {a[0], a[0], a[2]},
+		"mixed":     {a[0], b[1], a[2]},
+		"no udh":    {a[0], {Text: "x"}, a[2]},
+	} {
+		_, err := Join(parts)
+		assert.Error(t, err, name)
+	}
+}
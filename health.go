@@ -0,0 +1,81 @@
+package otpgateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// HealthChecker is an optional interface implemented by Providers
+// that can check whether their upstream is reachable.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Warmer is an optional interface implemented by Providers that can
+// validate their configuration, such as credentials, against their
+// upstream without sending a message. Unlike HealthCheck, which checks
+// reachability periodically, Warmup is meant to be run once at startup
+// so that bad credentials fail the startup instead of the first push.
+type Warmer interface {
+	Warmup(ctx context.Context) error
+}
+
+// Warmup runs the Provider's Warmup if it implements Warmer.
+func Warmup(ctx context.Context, p Provider) error {
+	w, ok := p.(Warmer)
+	if !ok {
+		return nil
+	}
+	if err := w.Warmup(ctx); err != nil {
+		return fmt.Errorf("error warming up provider %s: %w", p.ID(), err)
+	}
+	return nil
+}
+
+// HealthCheckAll concurrently runs the health checks of the given
+// providers with the deadline of ctx and returns the results keyed
+// by provider ID. Providers that don't implement HealthChecker are
+// reported as healthy (nil). A check that doesn't return before ctx
+// is done is reported with the context's error.
+func HealthCheckAll(ctx context.Context, providers []Provider) map[string]error {
+	var (
+		out = make(map[string]error, len(providers))
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+	)
+
+	for _, p := range providers {
+		hc, ok := p.(HealthChecker)
+		if !ok {
+			out[p.ID()] = nil
+			continue
+		}
+
+		wg.Add(1)
+		go func(id string, hc HealthChecker) {
+			defer wg.Done()
+
+			// Buffered so that a check that outlives the deadline
+			// doesn't block forever.
+			ch := make(chan error, 1)
+			go func() {
+				ch <- hc.HealthCheck(ctx)
+			}()
+
+			var err error
+			select {
+			case err = <-ch:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			mu.Lock()
+			out[id] = err
+			mu.Unlock()
+		}(p.ID(), hc)
+	}
+	wg.Wait()
+
+	return out
+}
@@ -0,0 +1,90 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+type healthProv struct {
+	id    string
+	err   error
+	delay time.Duration
+}
+
+func (h *healthProv) ID() string                      { return h.id }
+func (h *healthProv) ChannelName() string             { return "dummychannel" }
+func (h *healthProv) ChannelDesc() string             { return "dummy channel description" }
+func (h *healthProv) AddressName() string             { return "dummyaddress" }
+func (h *healthProv) AddressDesc() string             { return "dummy address description" }
+func (h *healthProv) ValidateAddress(to string) error { return nil }
+func (h *healthProv) SenderIdentity() string          { return "dummysender" }
+func (h *healthProv) MaxAddressLen() int              { return 10 }
+func (h *healthProv) MaxOTPLen() int                  { return 6 }
+func (h *healthProv) MaxBodyLen() int                 { return 140 }
+func (h *healthProv) Push(otp models.OTP, subject string, body []byte) error {
+	return nil
+}
+
+type checkedProv struct {
+	healthProv
+}
+
+func (c *checkedProv) HealthCheck(ctx context.Context) error {
+	if c.delay > 0 {
+		time.Sleep(c.delay)
+	}
+	return c.err
+}
+
+func TestHealthCheckAll(t *testing.T) {
+	errDown := errors.New("upstream down")
+	provs := []Provider{
+		&healthProv{id: "nocheck"},
+		&checkedProv{healthProv{id: "healthy"}},
+		&checkedProv{healthProv{id: "failing", err: errDown}},
+		&checkedProv{healthProv{id: "slow", delay: time.Second}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	res := HealthCheckAll(ctx, provs)
+	assert.True(t, time.Since(start) < time.Millisecond*500, "deadline not respected")
+
+	assert.Len(t, res, 4)
+	assert.NoError(t, res["nocheck"])
+	assert.NoError(t, res["healthy"])
+	assert.Equal(t, errDown, res["failing"])
+	assert.Equal(t, context.DeadlineExceeded, res["slow"])
+
+	assert.Empty(t, HealthCheckAll(context.Background(), nil))
+}
+
+type warmProv struct {
+	healthProv
+	warmed int
+}
+
+func (w *warmProv) Warmup(ctx context.Context) error {
+	w.warmed++
+	return w.err
+}
+
+func TestWarmup(t *testing.T) {
+	assert.NoError(t, Warmup(context.Background(), &healthProv{id: "nowarmup"}))
+
+	w := &warmProv{healthProv: healthProv{id: "valid"}}
+	assert.NoError(t, Warmup(context.Background(), w))
+	assert.Equal(t, 1, w.warmed)
+
+	errAuth := errors.New("invalid credentials")
+	err := Warmup(context.Background(), &warmProv{healthProv: healthProv{id: "invalid", err: errAuth}})
+	assert.True(t, errors.Is(err, errAuth))
+	assert.Contains(t, err.Error(), "invalid")
+}
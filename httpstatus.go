@@ -0,0 +1,58 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrInvalidAddress may be wrapped by Providers when the address
+	// (eg: a phone number) a message is to be sent to is invalid.
+	ErrInvalidAddress = errors.New("invalid address")
+
+	// ErrUpstreamUnavailable may be wrapped by Providers when their
+	// upstream (eg: a carrier API) can't be reached or fails to respond.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+)
+
+// HTTPStatuser is an optional interface implemented by Provider errors
+// that map to a specific HTTP status code.
+type HTTPStatuser interface {
+	HTTPStatus() int
+}
+
+// HTTPStatusFor returns the HTTP status code that the gateway responds
+// to a client with for an error of a Provider. Errors implementing
+// HTTPStatuser map to their status code and errors that don't wrap a
+// known error map to 500.
+func HTTPStatusFor(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var s HTTPStatuser
+	if errors.As(err, &s) {
+		if c := s.HTTPStatus(); c > 0 {
+			return c
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidAddress), errors.Is(err, ErrUnknownProvider):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSuppressed):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrCooldown):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrProviderDisabled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
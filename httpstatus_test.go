@@ -0,0 +1,42 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type statusErr int
+
+func (e statusErr) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e statusErr) HTTPStatus() int { return int(e) }
+
+func TestHTTPStatusFor(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{nil, http.StatusOK},
+		{ErrInvalidAddress, http.StatusBadRequest},
+		{fmt.Errorf("invalid mobile number: %w", ErrInvalidAddress), http.StatusBadRequest},
+		{ErrUnknownProvider, http.StatusBadRequest},
+		{ErrNotExist, http.StatusNotFound},
+		{ErrSuppressed, http.StatusUnprocessableEntity},
+		{ErrRateLimited, http.StatusTooManyRequests},
+		{fmt.Errorf("telnyx: %w", ErrRateLimited), http.StatusTooManyRequests},
+		{ErrCooldown, http.StatusTooManyRequests},
+		{ErrUpstreamUnavailable, http.StatusBadGateway},
+		{ErrProviderDisabled, http.StatusServiceUnavailable},
+		{fmt.Errorf("error sending request: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{statusErr(http.StatusPaymentRequired), http.StatusPaymentRequired},
+		{fmt.Errorf("wrapped: %w", statusErr(http.StatusConflict)), http.StatusConflict},
+		{statusErr(0), http.StatusInternalServerError},
+		{errors.New("unknown"), http.StatusInternalServerError},
+	} {
+		assert.Equal(t, tc.code, HTTPStatusFor(tc.err), "%v", tc.err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package clock provides a time source that can be replaced with a
+// fake one in tests to drive time dependent logic (cooldowns, rate
+// limits, expiry) deterministically without real sleeps.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// Real is the real clock backed by the time package.
+var Real Clock = realClock{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a manually advanced Clock for tests.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFake returns a fake clock set to the given time.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+// Now returns the fake clock's current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives the fake time once the clock
+// has been advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d and fires the After channels
+// that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// BlockUntil blocks until n After channels are waiting for the clock to
+// be advanced, for tests to advance it once the code under test waits.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		waiting := len(f.waiters)
+		f.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
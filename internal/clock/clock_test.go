@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	var (
+		start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		c     = NewFake(start)
+	)
+	assert.Equal(t, start, c.Now())
+
+	var (
+		short = c.After(time.Second)
+		long  = c.After(time.Minute)
+		now   = c.After(0)
+	)
+	assert.Len(t, now, 1)
+
+	c.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), c.Now())
+	assert.Len(t, short, 1)
+	assert.Len(t, long, 0)
+
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Second+time.Minute), <-long)
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	c := NewFake(time.Now())
+	fired := make(chan struct{})
+	go func() {
+		<-c.After(time.Second)
+		close(fired)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Second)
+	<-fired
+}
+
+func TestReal(t *testing.T) {
+	assert.WithinDuration(t, time.Now(), Real.Now(), time.Second)
+	select {
+	case <-Real.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("real After didn't fire")
+	}
+}
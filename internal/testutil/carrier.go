@@ -0,0 +1,211 @@
+// Package testutil provides a fake carrier HTTP server for Provider
+// tests that can be scripted to return successes, errors, rate limits,
+// timeouts and malformed bodies, and that records the requests it
+// receives for assertions.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is a scripted response of the Carrier.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+
+	// Delay is the duration to wait before responding.
+	Delay time.Duration
+
+	// Hang makes the Carrier not respond until the client gives up
+	// (eg: times out) or the Carrier is closed.
+	Hang bool
+}
+
+// OK returns a 200 response with the JSON body.
+func OK(body string) Response {
+	return JSON(http.StatusOK, body)
+}
+
+// JSON returns a response with the status code and the JSON body.
+func JSON(status int, body string) Response {
+	return Response{
+		Status: status,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   body,
+	}
+}
+
+// RateLimited returns a 429 response with a Retry-After header of
+// the given duration in seconds.
+func RateLimited(retryAfter time.Duration, body string) Response {
+	r := JSON(http.StatusTooManyRequests, body)
+	r.Header.Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+	return r
+}
+
+// Timeout returns a response that's never sent, so that the client
+// times out.
+func Timeout() Response {
+	return Response{Hang: true}
+}
+
+// Malformed returns a 200 JSON response with a truncated body that
+// can't be parsed.
+func Malformed() Response {
+	return OK(`{"id": "msg1", "status":`)
+}
+
+// Request is a request received by the Carrier.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+
+	// Form is the parsed body of form requests.
+	Form url.Values
+}
+
+// DecodeJSON unmarshals the JSON body of the request into v.
+func (r Request) DecodeJSON(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Carrier is a fake carrier API server. Responses are served in the
+// order they're scripted, after which the default response is served.
+type Carrier struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	script []Response
+	def    Response
+	reqs   []Request
+
+	// closing is closed when the Carrier is closed to release the
+	// hanging requests.
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCarrier starts a Carrier whose default response is def.
+func NewCarrier(def Response) *Carrier {
+	c := &Carrier{def: def, closing: make(chan struct{})}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+// Script queues responses to be served in order to the next requests.
+func (c *Carrier) Script(rs ...Response) {
+	c.mu.Lock()
+	c.script = append(c.script, rs...)
+	c.mu.Unlock()
+}
+
+// SetDefault sets the response served once the script is exhausted.
+func (c *Carrier) SetDefault(r Response) {
+	c.mu.Lock()
+	c.def = r
+	c.mu.Unlock()
+}
+
+// Requests returns the requests received so far.
+func (c *Carrier) Requests() []Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Request(nil), c.reqs...)
+}
+
+// LastRequest returns the last request received or false if there's
+// none.
+func (c *Carrier) LastRequest() (Request, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reqs) == 0 {
+		return Request{}, false
+	}
+	return c.reqs[len(c.reqs)-1], true
+}
+
+// Reset clears the recorded requests and the remaining script.
+func (c *Carrier) Reset() {
+	c.mu.Lock()
+	c.reqs = nil
+	c.script = nil
+	c.mu.Unlock()
+}
+
+// Close releases the hanging requests and shuts down the server.
+func (c *Carrier) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	c.Server.Close()
+}
+
+func (c *Carrier) handle(w http.ResponseWriter, r *http.Request) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusBadRequest)
+		return
+	}
+	req := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: make(http.Header, len(r.Header)),
+		Body:   b,
+	}
+	for k, v := range r.Header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		req.Form, _ = url.ParseQuery(string(bytes.TrimSpace(b)))
+	}
+
+	c.mu.Lock()
+	c.reqs = append(c.reqs, req)
+	resp := c.def
+	if len(c.script) > 0 {
+		resp = c.script[0]
+		c.script = c.script[1:]
+	}
+	c.mu.Unlock()
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		case <-c.closing:
+			return
+		}
+	}
+	if resp.Hang {
+		select {
+		case <-r.Context().Done():
+		case <-c.closing:
+		}
+		return
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	w.WriteHeader(resp.Status)
+	w.Write([]byte(resp.Body))
+}
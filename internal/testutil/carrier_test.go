@@ -0,0 +1,138 @@
+package testutil
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, h *http.Client, u string) (*http.Response, string) {
+	resp, err := h.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+func TestScript(t *testing.T) {
+	c := NewCarrier(OK(`{"id": "default"}`))
+	defer c.Close()
+
+	c.Script(
+		OK(`{"id": "msg1"}`),
+		JSON(http.StatusBadRequest, `{"code": "E101"}`),
+		RateLimited(30*time.Second, `{"code": "E429"}`),
+		Malformed(),
+	)
+
+	// Scripted responses are served in order.
+	resp, body := get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"id": "msg1"}`, body)
+
+	resp, body = get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, `{"code": "E101"}`, body)
+
+	resp, _ = get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+
+	resp, body = get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var v map[string]interface{}
+	assert.Error(t, Request{Body: []byte(body)}.DecodeJSON(&v), "malformed body parsed")
+
+	// ... followed by the default.
+	_, body = get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, `{"id": "default"}`, body)
+	c.SetDefault(JSON(http.StatusServiceUnavailable, ""))
+	resp, _ = get(t, http.DefaultClient, c.URL+"/messages")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, c.Requests(), 6)
+}
+
+func TestTimeout(t *testing.T) {
+	c := NewCarrier(Timeout())
+	defer c.Close()
+
+	h := &http.Client{Timeout: 50 * time.Millisecond}
+	_, err := h.Get(c.URL)
+	var ne net.Error
+	if assert.True(t, errors.As(err, &ne), err) {
+		assert.True(t, ne.Timeout())
+	}
+
+	// Delayed responses are sent after the delay.
+	r := OK(`{}`)
+	r.Delay = 20 * time.Millisecond
+	c.Script(r)
+	start := time.Now()
+	resp, _ := get(t, http.DefaultClient, c.URL)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= r.Delay)
+
+	// Closing releases hanging requests.
+	done := make(chan struct{})
+	go func() {
+		http.Get(c.URL)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hanging request not released on Close")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	c := NewCarrier(OK(`{}`))
+	defer c.Close()
+
+	_, ok := c.LastRequest()
+	assert.False(t, ok)
+
+	form := url.Values{"to": {"+919876543210"}, "body": {"Your code is 482910"}}
+	req, _ := http.NewRequest(http.MethodPost, c.URL+"/sid/messages?v=1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("api-key", "key")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	resp, err = http.Post(c.URL+"/sid/messages", "application/json", strings.NewReader(`{"to": "+14155551234"}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	reqs := c.Requests()
+	if assert.Len(t, reqs, 2) {
+		r := reqs[0]
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/sid/messages", r.Path)
+		assert.Equal(t, "1", r.Query.Get("v"))
+		assert.Equal(t, "key", r.Header.Get("api-key"))
+		assert.Equal(t, form, r.Form)
+	}
+
+	last, ok := c.LastRequest()
+	assert.True(t, ok)
+	var body map[string]string
+	assert.NoError(t, last.DecodeJSON(&body))
+	assert.Equal(t, "+14155551234", body["to"])
+	assert.Nil(t, last.Form)
+
+	c.Reset()
+	assert.Empty(t, c.Requests())
+}
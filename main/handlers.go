@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -48,6 +47,10 @@ type otpErrResp struct {
 	MaxAttempts int     `json:"max_attempts"`
 }
 
+type providerEnabledResp struct {
+	Enabled bool `json:"enabled"`
+}
+
 type tpl struct {
 	Title       string
 	Description string
@@ -67,11 +70,10 @@ type tpl struct {
 }
 
 type pushTpl struct {
-	To        string
-	Namespace string
-	Channel   string
-	OTP       string
-	OTPURL    string
+	otpgateway.TemplateContext
+
+	Channel string
+	OTPURL  string
 }
 
 // ErrNotExist is thrown when an OTP (requested by namespace / ID)
@@ -92,6 +94,50 @@ func handleGetProviders(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, out)
 }
 
+// handleGetProviderUI returns the UI descriptor of a provider that
+// frontends render its channel and address input with.
+func handleGetProviderUI(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		id  = chi.URLParam(r, "id")
+	)
+	pro, ok := app.providers[id]
+	if !ok {
+		sendErrorResponse(w, "unknown provider", http.StatusNotFound, nil)
+		return
+	}
+	sendResponse(w, otpgateway.DescribeUI(pro))
+}
+
+// handleSetProviderEnabled enables or disables a provider at runtime,
+// eg: to take a failing provider out of service. The state is only held
+// in the memory of this instance.
+func handleSetProviderEnabled(w http.ResponseWriter, r *http.Request) {
+	var (
+		app = r.Context().Value("app").(*App)
+		id  = chi.URLParam(r, "id")
+	)
+	pro, ok := app.providers[id]
+	if !ok {
+		sendErrorResponse(w, "unknown provider", http.StatusNotFound, nil)
+		return
+	}
+	t, ok := pro.(otpgateway.Toggler)
+	if !ok {
+		sendErrorResponse(w, "provider can't be disabled", http.StatusBadRequest, nil)
+		return
+	}
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		sendErrorResponse(w, "invalid `enabled` value", http.StatusBadRequest, nil)
+		return
+	}
+
+	t.SetEnabled(enabled)
+	app.logger.Printf("provider %s enabled: %v", id, enabled)
+	sendResponse(w, providerEnabledResp{Enabled: t.Enabled()})
+}
+
 func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	// check if store is reachable
 	var (
@@ -189,9 +235,16 @@ func handleSetOTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// If the address is a test address of the provider, use its fixed OTP.
+	if tp, ok := pro.(otpgateway.TestOTPer); ok && otpVal == "" && to != "" {
+		if o, ok := tp.TestOTP(to); ok {
+			otpVal = o
+		}
+	}
+
 	// If there's no incoming OTP, generate a random one.
 	if otpVal == "" {
-		o, err := generateRandomString(pro.MaxOTPLen(), numChars)
+		o, err := otpgateway.GenerateOTPFor(pro)
 		if err != nil {
 			app.logger.Printf("error generating OTP: %v", err)
 			sendErrorResponse(w, "error generating OTP", http.StatusInternalServerError, nil)
@@ -242,7 +295,7 @@ func handleSetOTP(w http.ResponseWriter, r *http.Request) {
 	if to != "" {
 		if err := push(newOTP, app.providerTpls[pro.ID()], pro, app.RootURL); err != nil {
 			app.logger.Printf("error sending OTP: %v", err)
-			sendErrorResponse(w, "error sending OTP", http.StatusInternalServerError, nil)
+			sendErrorResponse(w, "error sending OTP", otpgateway.HTTPStatusFor(err), nil)
 			return
 		}
 	}
@@ -509,7 +562,7 @@ func verifyOTP(namespace, id, otp string, deleteOnVerify bool, app *App) (models
 	if isLocked(out) {
 		errMsg = fmt.Sprintf("Too many attempts. Please retry after %0.f seconds.",
 			out.TTL.Seconds())
-	} else if out.OTP != otp {
+	} else if !otpgateway.CompareOTP(out.OTP, otp) {
 		errMsg = "OTP does not match"
 	}
 
@@ -563,14 +616,7 @@ func sendErrorResponse(w http.ResponseWriter, message string, code int, data int
 // generateRandomString generates a cryptographically random,
 // alphanumeric string of length n.
 func generateRandomString(totalLen int, chars string) (string, error) {
-	bytes := make([]byte, totalLen)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	for k, v := range bytes {
-		bytes[k] = chars[v%byte(len(chars))]
-	}
-	return string(bytes), nil
+	return otpgateway.GenerateOTP(totalLen, chars)
 }
 
 // isLocked tells if an OTP is locked after exceeding attempts.
@@ -588,11 +634,9 @@ func push(otp models.OTP, tpl *providerTpl, p otpgateway.Provider, rootURL strin
 		out  = &bytes.Buffer{}
 
 		data = pushTpl{
-			Channel:   p.ChannelName(),
-			Namespace: otp.Namespace,
-			To:        otp.To,
-			OTP:       otp.OTP,
-			OTPURL:    getURL(rootURL, otp, true),
+			TemplateContext: otpgateway.NewTemplateContext(otp, otpgateway.SenderOf(p, otp.To)),
+			Channel:         p.ChannelName(),
+			OTPURL:          getURL(rootURL, otp, true),
 		}
 	)
 
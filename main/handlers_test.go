@@ -21,7 +21,9 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-type dummyProv struct{}
+type dummyProv struct {
+	otpgateway.Toggle
+}
 
 // ID returns the Provider's ID.
 func (d *dummyProv) ID() string {
@@ -48,6 +50,11 @@ func (d *dummyProv) AddressDesc() string {
 	return "dummy address description"
 }
 
+// SenderIdentity returns the sender messages appear from.
+func (d *dummyProv) SenderIdentity() string {
+	return "dummysender"
+}
+
 // ValidateAddress "validates" an e-mail address.
 func (d *dummyProv) ValidateAddress(to string) error {
 	if to != dummyToAddress {
@@ -58,6 +65,9 @@ func (d *dummyProv) ValidateAddress(to string) error {
 
 // Push pushes an e-mail to the SMTP server.
 func (d *dummyProv) Push(to models.OTP, subject string, m []byte) error {
+	if !d.Enabled() {
+		return otpgateway.ErrProviderDisabled
+	}
 	return nil
 }
 
@@ -79,6 +89,8 @@ func (d *dummyProv) MaxBodyLen() int {
 const (
 	dummyNamespace = "myapp"
 	dummySecret    = "mysecret"
+	dummyAdmin     = "admin"
+	dummyAdminPass = "adminsecret"
 	dummyProvider  = "dummyprovider"
 	dummyOTPID     = "myotp123"
 	dummyToAddress = "dummy@to.com"
@@ -124,6 +136,8 @@ func init() {
 	authCreds := map[string]string{dummyNamespace: dummySecret}
 	r := chi.NewRouter()
 	r.Get("/api/providers", auth(authCreds, wrap(app, handleGetProviders)))
+	r.Get("/api/providers/{id}/ui", auth(authCreds, wrap(app, handleGetProviderUI)))
+	r.Put("/api/providers/{id}/enabled", auth(map[string]string{dummyAdmin: dummyAdminPass}, wrap(app, handleSetProviderEnabled)))
 	r.Get("/api/health", auth(authCreds, wrap(app, handleHealthCheck)))
 	r.Put("/api/otp/{id}", auth(authCreds, wrap(app, handleSetOTP)))
 	r.Post("/api/otp/{id}", auth(authCreds, wrap(app, handleVerifyOTP)))
@@ -143,6 +157,75 @@ func TestGetProviders(t *testing.T) {
 	assert.Equal(t, out.Data, []interface{}{dummyProvider}, "providers don't match")
 }
 
+func TestGetProviderUI(t *testing.T) {
+	var (
+		data = otpgateway.UIDescriptor{}
+		out  = httpResp{Data: &data}
+	)
+	r := testRequest(t, http.MethodGet, "/api/providers/"+dummyProvider+"/ui", nil, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "non 200 response")
+	assert.Equal(t, dummyProvider, data.ID)
+	assert.Equal(t, "dummychannel", data.ChannelName)
+	assert.Equal(t, "dummyaddress", data.AddressName)
+
+	r = testRequest(t, http.MethodGet, "/api/providers/unknown/ui", nil, &httpResp{})
+	assert.Equal(t, http.StatusNotFound, r.StatusCode, "unknown provider found")
+}
+
+func TestSetProviderEnabled(t *testing.T) {
+	rdis.FlushDB()
+	var (
+		data = providerEnabledResp{}
+		out  = httpResp{Data: &data}
+		p    = url.Values{}
+	)
+
+	// Disabled providers fail sends.
+	p.Set("enabled", "false")
+	r := testAuthRequest(t, dummyAdmin, dummyAdminPass, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "non 200 response")
+	assert.False(t, data.Enabled)
+
+	otp := url.Values{}
+	otp.Set("to", dummyToAddress)
+	otp.Set("provider", dummyProvider)
+	r = testRequest(t, http.MethodPut, "/api/otp/"+dummyOTPID, otp, &httpResp{})
+	assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode, "disabled provider sent")
+
+	p.Set("enabled", "true")
+	r = testAuthRequest(t, dummyAdmin, dummyAdminPass, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "non 200 response")
+	assert.True(t, data.Enabled)
+	r = testRequest(t, http.MethodPut, "/api/otp/"+dummyOTPID, otp, &httpResp{})
+	assert.Equal(t, http.StatusOK, r.StatusCode, "enabled provider didn't send")
+
+	p.Set("enabled", "maybe")
+	r = testAuthRequest(t, dummyAdmin, dummyAdminPass, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &httpResp{})
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode, "invalid value accepted")
+	r = testAuthRequest(t, dummyAdmin, dummyAdminPass, http.MethodPut, "/api/providers/unknown/enabled", p, &httpResp{})
+	assert.Equal(t, http.StatusNotFound, r.StatusCode, "unknown provider found")
+}
+
+func TestSetProviderEnabledAuth(t *testing.T) {
+	p := url.Values{}
+	p.Set("enabled", "false")
+
+	// Namespace credentials can't toggle providers shared by all namespaces.
+	r := testRequest(t, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &httpResp{})
+	assert.Equal(t, http.StatusUnauthorized, r.StatusCode, "namespace credentials accepted")
+	r = testAuthRequest(t, dummyAdmin, dummySecret, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &httpResp{})
+	assert.Equal(t, http.StatusUnauthorized, r.StatusCode, "invalid admin secret accepted")
+
+	var (
+		data = providerEnabledResp{}
+		out  = httpResp{Data: &data}
+	)
+	p.Set("enabled", "true")
+	r = testAuthRequest(t, dummyAdmin, dummyAdminPass, http.MethodPut, "/api/providers/"+dummyProvider+"/enabled", p, &out)
+	assert.Equal(t, http.StatusOK, r.StatusCode, "admin credentials rejected")
+	assert.True(t, data.Enabled)
+}
+
 func TestHealthCheck(t *testing.T) {
 	var out httpResp
 	r := testRequest(t, http.MethodGet, "/api/health", nil, &out)
@@ -273,12 +356,16 @@ func TestCheckOTPAttempts(t *testing.T) {
 }
 
 func testRequest(t *testing.T, method, path string, p url.Values, out interface{}) *http.Response {
+	return testAuthRequest(t, dummyNamespace, dummySecret, method, path, p, out)
+}
+
+func testAuthRequest(t *testing.T, user, secret, method, path string, p url.Values, out interface{}) *http.Response {
 	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(p.Encode()))
 	if err != nil {
 		t.Fatal(err)
 		return nil
 	}
-	req.SetBasicAuth(dummyNamespace, dummySecret)
+	req.SetBasicAuth(user, secret)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	// HTTP client.
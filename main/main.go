@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"log"
@@ -48,6 +49,9 @@ type App struct {
 	FaviconURL string
 }
 
+// warmupTimeout is the max duration of a provider's warmup at startup.
+const warmupTimeout = time.Second * 10
+
 var (
 	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
 	ko     = koanf.New(".")
@@ -136,6 +140,16 @@ func loadProviders(names []string) (map[string]otpgateway.Provider, error) {
 		if p.ID() != id {
 			return nil, fmt.Errorf("provider plugin ID doesn't match '%s' != %s", id, p.ID())
 		}
+
+		// Validate the credentials before the first OTP is sent.
+		if cfg.Warmup {
+			ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+			err := otpgateway.Warmup(ctx, p)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+		}
 		out[p.ID()] = p
 	}
 	return out, nil
@@ -159,6 +173,20 @@ func loadAuth() map[string]string {
 	return out
 }
 
+// loadAdminAuth loads the username:secret of the admin APIs, which are
+// separate from the namespace credentials as the admin APIs affect all
+// namespaces. It returns nil if the admin credentials aren't configured.
+func loadAdminAuth() map[string]string {
+	var (
+		user   = ko.String("admin.username")
+		secret = ko.String("admin.secret")
+	)
+	if user == "" || secret == "" {
+		return nil
+	}
+	return map[string]string{user: secret}
+}
+
 // loadProviderTemplates loads a provider's templates.
 func loadProviderTemplates(providers []string) (map[string]*providerTpl, error) {
 	out := make(map[string]*providerTpl)
@@ -248,6 +276,21 @@ func main() {
 	ko.Unmarshal("store.redis", &rc)
 	app.store = otpgateway.NewRedisStore(rc)
 
+	// Enforce the providers' resend cooldowns and share their counts
+	// across instances.
+	var (
+		cooldowns = otpgateway.NewRedisCooldownStore(rc)
+		counters  = otpgateway.NewRedisCounterStore(rc)
+	)
+	for _, p := range provs {
+		if c, ok := p.(otpgateway.CooldownSetter); ok {
+			c.SetCooldownStore(cooldowns)
+		}
+		if c, ok := p.(otpgateway.CounterSetter); ok {
+			c.SetCounterStore(counters)
+		}
+	}
+
 	// Compile static templates.
 	tpl, err := stuffbin.ParseTemplatesGlob(nil, app.fs, "/static/*.html")
 	if err != nil {
@@ -263,11 +306,19 @@ func main() {
 	// Register handles.
 	r := chi.NewRouter()
 	r.Get("/api/providers", auth(authCreds, wrap(app, handleGetProviders)))
+	r.Get("/api/providers/{id}/ui", auth(authCreds, wrap(app, handleGetProviderUI)))
 	r.Get("/api/health", wrap(app, handleHealthCheck))
 	r.Put("/api/otp/{id}", auth(authCreds, wrap(app, handleSetOTP)))
 	r.Post("/api/otp/{id}/status", auth(authCreds, wrap(app, handleCheckOTPStatus)))
 	r.Post("/api/otp/{id}", auth(authCreds, wrap(app, handleVerifyOTP)))
 
+	// Admin APIs are only available with the admin credentials.
+	if adminCreds := loadAdminAuth(); adminCreds != nil {
+		r.Put("/api/providers/{id}/enabled", auth(adminCreds, wrap(app, handleSetProviderEnabled)))
+	} else {
+		logger.Printf("no admin credentials in config. Admin APIs are disabled")
+	}
+
 	r.Get("/otp/{namespace}/{id}", wrap(app, handleOTPView))
 	r.Get("/otp/{namespace}/{id}/address", wrap(app, handleAddressView))
 	r.Post("/otp/{namespace}/{id}/address", wrap(app, handleAddressView))
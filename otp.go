@@ -0,0 +1,99 @@
+package otpgateway
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zplzpl/otpgateway/models"
+)
+
+// DigitsAlphabet is the default alphabet of generated OTPs.
+const DigitsAlphabet = "0123456789"
+
+// Max length of a generated OTP.
+const maxGenerateLen = 1024
+
+// OTPAlphabeter is an optional interface implemented by Providers whose
+// OTPs are drawn from an alphabet other than DigitsAlphabet, eg:
+// alphanumeric codes.
+type OTPAlphabeter interface {
+	OTPAlphabet() string
+}
+
+// CompareOTP compares an expected OTP with user input in constant time.
+// Both are hashed first so that the time taken doesn't leak the length
+// of the expected OTP either. An empty expected OTP never matches.
+func CompareOTP(expected, got string) bool {
+	if expected == "" {
+		return false
+	}
+	a := sha256.Sum256([]byte(expected))
+	b := sha256.Sum256([]byte(got))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// GenerateOTP generates a cryptographically random OTP of the given
+// length from the characters of alphabet, which should be (at most
+// 256) unique single byte characters. Random bytes that would bias
+// the distribution of the characters (the remainder of 256 / len
+// alphabet) are discarded and redrawn.
+func GenerateOTP(length int, alphabet string) (string, error) {
+	if length < 1 || length > maxGenerateLen {
+		return "", fmt.Errorf("invalid OTP length %d", length)
+	}
+	if len(alphabet) == 0 || len(alphabet) > 256 {
+		return "", errors.New("alphabet should have 1 - 256 characters")
+	}
+	var seen [256]bool
+	for i := 0; i < len(alphabet); i++ {
+		if seen[alphabet[i]] {
+			return "", fmt.Errorf("duplicate character %q in alphabet", alphabet[i])
+		}
+		seen[alphabet[i]] = true
+	}
+
+	var (
+		n    = len(alphabet)
+		max  = 256 - 256%n
+		out  = make([]byte, 0, length)
+		rbuf = make([]byte, length+length/2)
+	)
+	for len(out) < length {
+		if _, err := rand.Read(rbuf); err != nil {
+			return "", err
+		}
+		for _, b := range rbuf {
+			if int(b) >= max {
+				continue
+			}
+			out = append(out, alphabet[int(b)%n])
+			if len(out) == length {
+				break
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// GenerateOTPFor generates an OTP of the Provider's MaxOTPLen from its
+// OTPAlphabet, or digits if it has none.
+func GenerateOTPFor(p Provider) (string, error) {
+	alphabet := DigitsAlphabet
+	if a, ok := p.(OTPAlphabeter); ok && a.OTPAlphabet() != "" {
+		alphabet = a.OTPAlphabet()
+	}
+	return GenerateOTP(p.MaxOTPLen(), alphabet)
+}
+
+// TTLOf returns the remaining validity of an OTP from its TTL or
+// TTLSeconds, or 0 if it's unknown.
+func TTLOf(otp models.OTP) time.Duration {
+	if otp.TTL != 0 {
+		return otp.TTL
+	}
+	return time.Duration(otp.TTLSeconds * float64(time.Second))
+}
@@ -0,0 +1,107 @@
+package otpgateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+func TestCompareOTP(t *testing.T) {
+	assert.True(t, CompareOTP("482910", "482910"))
+	assert.False(t, CompareOTP("482910", "482911"))
+	assert.False(t, CompareOTP("482910", "48291"))
+	assert.False(t, CompareOTP("482910", "4829100"))
+	assert.False(t, CompareOTP("482910", ""))
+	assert.False(t, CompareOTP("", ""), "empty OTP matched")
+}
+
+type alphabetProv struct {
+	healthProv
+	alphabet string
+}
+
+func (a *alphabetProv) OTPAlphabet() string { return a.alphabet }
+
+func TestGenerateOTP(t *testing.T) {
+	for _, tc := range []struct {
+		length   int
+		alphabet string
+	}{
+		{6, DigitsAlphabet},
+		{1, "X"},
+		{32, "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"},
+		{100, "ab"},
+	} {
+		o, err := GenerateOTP(tc.length, tc.alphabet)
+		assert.NoError(t, err)
+		assert.Len(t, o, tc.length)
+		for _, c := range o {
+			assert.Contains(t, tc.alphabet, string(c))
+		}
+	}
+
+	for _, tc := range []struct {
+		length   int
+		alphabet string
+	}{
+		{0, DigitsAlphabet},
+		{-1, DigitsAlphabet},
+		{6, ""},
+		{6, "0123456789012"},
+		{6, strings.Repeat("x", 257)},
+	} {
+		_, err := GenerateOTP(tc.length, tc.alphabet)
+		assert.Error(t, err, "%d %q", tc.length, tc.alphabet)
+	}
+}
+
+func TestGenerateOTPDistribution(t *testing.T) {
+	// 7 characters don't divide 256, so a plain modulo would favour
+	// the first 4 (256 % 7) by ~3%.
+	const (
+		alphabet = "ABCDEFG"
+		samples  = 70000
+	)
+	counts := make(map[rune]int)
+	for i := 0; i < samples/70; i++ {
+		o, err := GenerateOTP(70, alphabet)
+		assert.NoError(t, err)
+		for _, c := range o {
+			counts[c]++
+		}
+	}
+	assert.Len(t, counts, len(alphabet))
+
+	// The chi-square critical value for 6 degrees of freedom at p = 0.0001.
+	var (
+		exp = float64(samples) / float64(len(alphabet))
+		chi float64
+	)
+	for _, n := range counts {
+		chi += (float64(n) - exp) * (float64(n) - exp) / exp
+	}
+	assert.True(t, chi < 27.86, "chi-square %f too high for a uniform distribution", chi)
+}
+
+func TestGenerateOTPFor(t *testing.T) {
+	o, err := GenerateOTPFor(&healthProv{})
+	assert.NoError(t, err)
+	assert.Len(t, o, (&healthProv{}).MaxOTPLen())
+	for _, c := range o {
+		assert.Contains(t, DigitsAlphabet, string(c))
+	}
+
+	o, err = GenerateOTPFor(&alphabetProv{alphabet: "XY"})
+	assert.NoError(t, err)
+	assert.Regexp(t, "^[XY]+$", o)
+}
+
+func TestTTLOf(t *testing.T) {
+	assert.Equal(t, time.Duration(0), TTLOf(models.OTP{}))
+	assert.Equal(t, time.Minute, TTLOf(models.OTP{TTL: time.Minute}))
+	assert.Equal(t, 1500*time.Millisecond, TTLOf(models.OTP{TTLSeconds: 1.5}))
+	assert.Equal(t, time.Minute, TTLOf(models.OTP{TTL: time.Minute, TTLSeconds: 1.5}), "TTL not preferred")
+}
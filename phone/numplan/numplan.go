@@ -0,0 +1,115 @@
+// Package numplan validates and inspects phone numbers against the
+// numbering plans of their countries with libphonenumber. It's separate
+// from package phone so that providers that only check the format of
+// numbers don't link the numbering plan metadata.
+package numplan
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+// ErrNotMobile is returned when a number is valid but is not a number
+// that can receive SMS, for instance, a landline.
+var ErrNotMobile = errors.New("not a mobile number")
+
+// ParseStrict validates a number against the numbering plan of its
+// country and returns it in the E.164 format. Numbers without a country
+// code are parsed as numbers of the given region (ISO 3166-1 alpha-2
+// country code, eg: IN) if it's set. Fixed line (landline) numbers that
+// can't receive SMS are rejected.
+func ParseStrict(num, region string) (string, error) {
+	n, err := phonenumbers.Parse(num, strings.ToUpper(region))
+	if err != nil {
+		return "", phone.ErrInvalid
+	}
+	if !phonenumbers.IsValidNumber(n) {
+		return "", phone.ErrInvalid
+	}
+	if phonenumbers.GetNumberType(n) == phonenumbers.FIXED_LINE {
+		return "", ErrNotMobile
+	}
+	return phonenumbers.Format(n, phonenumbers.E164), nil
+}
+
+// Region returns the ISO 3166-1 alpha-2 country code (eg: IN) of a
+// number with a country code, or an empty string if it can't be
+// determined. The leading + is optional.
+func Region(num string) string {
+	if !strings.HasPrefix(num, "+") {
+		num = "+" + num
+	}
+	n, err := phonenumbers.Parse(num, "")
+	if err != nil {
+		return ""
+	}
+	return phonenumbers.GetRegionCodeForNumber(n)
+}
+
+// CallingCode returns the country calling code (eg: 91) of a number with
+// a country code, or an empty string if it can't be determined. The
+// leading + is optional.
+func CallingCode(num string) string {
+	if !strings.HasPrefix(num, "+") {
+		num = "+" + num
+	}
+	n, err := phonenumbers.Parse(num, "")
+	if err != nil || n.GetCountryCode() == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(n.GetCountryCode()))
+}
+
+// Qualify returns a number in the E.164 format. Numbers with a leading +
+// are normalized (see StripTrunkPrefix) and numbers without one are parsed as national
+// numbers of the given region (ISO 3166-1 alpha-2 country code, eg: IN).
+// If the region is empty, such numbers are rejected with phone.ErrAmbiguous.
+func Qualify(num, region string) (string, error) {
+	num = strings.TrimSpace(num)
+	if strings.HasPrefix(num, "+") {
+		n, err := phone.Normalize(num)
+		if err != nil {
+			return "", err
+		}
+		return StripTrunkPrefix(n), nil
+	}
+	if region == "" {
+		return "", phone.ErrAmbiguous
+	}
+
+	n, err := phonenumbers.Parse(num, strings.ToUpper(region))
+	if err != nil || !phonenumbers.IsPossibleNumber(n) {
+		return "", phone.ErrInvalid
+	}
+	return phonenumbers.Format(n, phonenumbers.E164), nil
+}
+
+// StripTrunkPrefix removes the national trunk prefix of a country (eg: 0
+// in IN and GB) entered after the country code of an E.164 number,
+// eg: +9109876543210 => +919876543210. Numbers that aren't valid without
+// the prefix are returned as is.
+func StripTrunkPrefix(num string) string {
+	n, err := phonenumbers.Parse(num, "")
+	if err != nil || !phonenumbers.IsValidNumber(n) {
+		return num
+	}
+
+	var (
+		cc     = strconv.Itoa(int(n.GetCountryCode()))
+		prefix = phonenumbers.GetNddPrefixForRegion(phonenumbers.GetRegionCodeForCountryCode(int(n.GetCountryCode())), true)
+		rest   = strings.TrimPrefix(num, "+"+cc)
+	)
+	if prefix == "" || !strings.HasPrefix(rest, prefix) {
+		return num
+	}
+
+	// The parser strips the trunk prefix.
+	if out := phonenumbers.Format(n, phonenumbers.E164); out == "+"+cc+rest[len(prefix):] {
+		return out
+	}
+	return num
+}
@@ -0,0 +1,90 @@
+package numplan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+func TestParseStrict(t *testing.T) {
+	// Valid mobile.
+	n, err := ParseStrict("+919876543210", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "+919876543210", n)
+
+	// National format with a region.
+	n, err = ParseStrict("98765 43210", "IN")
+	assert.NoError(t, err)
+	assert.Equal(t, "+919876543210", n)
+
+	// Valid digits, but invalid for the numbering plan.
+	_, err = ParseStrict("+911111111111", "")
+	assert.Equal(t, phone.ErrInvalid, err)
+	_, err = ParseStrict("+4412345", "")
+	assert.Equal(t, phone.ErrInvalid, err)
+
+	// Landline.
+	_, err = ParseStrict("+442071838750", "")
+	assert.Equal(t, ErrNotMobile, err)
+
+	// No country code or region.
+	_, err = ParseStrict("9876543210", "")
+	assert.Equal(t, phone.ErrInvalid, err)
+}
+
+func TestRegion(t *testing.T) {
+	assert.Equal(t, "IN", Region("+919876543210"))
+	assert.Equal(t, "IN", Region("919876543210"))
+	assert.Equal(t, "GB", Region("+447400123456"))
+	assert.Equal(t, "US", Region("+14155552671"))
+	assert.Equal(t, "", Region("abc"))
+	assert.Equal(t, "", Region(""))
+}
+
+func TestCallingCode(t *testing.T) {
+	assert.Equal(t, "91", CallingCode("+919876543210"))
+	assert.Equal(t, "44", CallingCode("447400123456"))
+	assert.Equal(t, "1", CallingCode("+12025550123"))
+	assert.Equal(t, "", CallingCode("abc"))
+}
+
+func TestQualify(t *testing.T) {
+	for _, c := range []struct {
+		num    string
+		region string
+		out    string
+		err    error
+	}{
+		{"+91 98765 43210", "", "+919876543210", nil},
+		{"+919876543210", "GB", "+919876543210", nil},
+		{"098765 43210", "IN", "+919876543210", nil},
+		{"9876543210", "in", "+919876543210", nil},
+		{"07400 123456", "GB", "+447400123456", nil},
+		{"+91 09876543210", "", "+919876543210", nil},
+		{"9876543210", "", "", phone.ErrAmbiguous},
+		{"12", "IN", "", phone.ErrInvalid},
+		{"abc", "IN", "", phone.ErrInvalid},
+	} {
+		out, err := Qualify(c.num, c.region)
+		assert.Equal(t, c.err, err, c.num)
+		assert.Equal(t, c.out, out, c.num)
+	}
+}
+
+func TestStripTrunkPrefix(t *testing.T) {
+	for in, out := range map[string]string{
+		"+9109876543210":  "+919876543210",
+		"+4407400123456":  "+447400123456",
+		"+49015123456789": "+4915123456789",
+
+		// Valid numbers and numbers without a trunk prefix are left as is.
+		"+919876543210": "+919876543210",
+		"+393123456789": "+393123456789",
+		"+12025550123":  "+12025550123",
+		"+910123":       "+910123",
+		"abc":           "abc",
+	} {
+		assert.Equal(t, out, StripTrunkPrefix(in), in)
+	}
+}
@@ -0,0 +1,68 @@
+// Package phone provides helpers for validating and normalizing
+// phone numbers for SMS providers by their format. Validation against
+// the countries' numbering plans is in the numplan subpackage.
+package phone
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrInvalid is returned when a number is not a valid phone number.
+	ErrInvalid = errors.New("invalid phone number")
+
+	// ErrAmbiguous is returned when a number has no country code and
+	// there's no default region to qualify it with.
+	ErrAmbiguous = errors.New("number has no country code")
+)
+
+// reE164 matches a phone number in the E.164 format, eg: +919876543210.
+var reE164 = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// MaxE164Len is the maximum length of an E.164 number including the +.
+const MaxE164Len = 16
+
+// IsE164 tells if the given number is in the E.164 format.
+func IsE164(num string) bool {
+	return reE164.MatchString(num)
+}
+
+// Normalize strips the common formatting characters (spaces, dashes,
+// dots, parentheses) from a number and returns it if it's in the
+// E.164 format.
+func Normalize(num string) (string, error) {
+	n := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(num))
+
+	if !IsE164(n) {
+		return "", ErrInvalid
+	}
+	return n, nil
+}
+
+// DoubleZeroToPlus replaces the leading 00 international call prefix
+// used in many countries with a +, eg: 00447400123456 => +447400123456.
+// Other numbers are returned as is.
+func DoubleZeroToPlus(num string) string {
+	num = strings.TrimSpace(num)
+	if strings.HasPrefix(num, "00") {
+		return "+" + num[2:]
+	}
+	return num
+}
+
+// Mask masks a number for logging, leaving the country code or first
+// 3 characters and the last 4 digits visible, eg: +91******3210.
+func Mask(num string) string {
+	if len(num) <= 7 {
+		return strings.Repeat("*", len(num))
+	}
+	return num[:3] + strings.Repeat("*", len(num)-7) + num[len(num)-4:]
+}
@@ -0,0 +1,52 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsE164(t *testing.T) {
+	for _, n := range []string{"+919876543210", "+14155551234", "+442071838750"} {
+		assert.True(t, IsE164(n), "valid number rejected: "+n)
+	}
+	for _, n := range []string{"", "919876543210", "+0123456789", "+91 98765 43210", "+1234", "+1234567890123456"} {
+		assert.False(t, IsE164(n), "invalid number accepted: "+n)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for in, out := range map[string]string{
+		"+919876543210":     "+919876543210",
+		" +91 98765 43210 ": "+919876543210",
+		"+1 (415) 555-1234": "+14155551234",
+		"+44.20.7183.8750":  "+442071838750",
+	} {
+		n, err := Normalize(in)
+		assert.NoError(t, err)
+		assert.Equal(t, out, n)
+	}
+	for _, n := range []string{"", "9876543210", "+91 abc", "phone"} {
+		_, err := Normalize(n)
+		assert.Equal(t, ErrInvalid, err, "invalid number accepted: "+n)
+	}
+}
+
+func TestDoubleZeroToPlus(t *testing.T) {
+	for in, out := range map[string]string{
+		"00447400123456":  "+447400123456",
+		" 00919876543210": "+919876543210",
+		"+919876543210":   "+919876543210",
+		"09876543210":     "09876543210",
+		"9876543210":      "9876543210",
+	} {
+		assert.Equal(t, out, DoubleZeroToPlus(in), in)
+	}
+}
+
+func TestMask(t *testing.T) {
+	assert.Equal(t, "+91******3210", Mask("+919876543210"))
+	assert.Equal(t, "987***3210", Mask("9876543210"))
+	assert.Equal(t, "*****", Mask("12345"))
+	assert.Equal(t, "", Mask(""))
+}
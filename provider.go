@@ -1,18 +1,27 @@
 package otpgateway
 
-import "github.com/zplzpl/otpgateway/models"
+import (
+	"context"
+	"time"
+
+	"github.com/zplzpl/otpgateway/models"
+)
 
 // ProviderConf represents the common configuration types for a Provider.
 type ProviderConf struct {
 	Template string `mapstructure:"template"`
 	Subject  string `mapstructure:"subject"`
 	Config   string `mapstructure:"config"`
+
+	// Warmup runs the Provider's Warmup, if it implements Warmer,
+	// at startup.
+	Warmup bool `mapstructure:"warmup"`
 }
 
-// NewProvider represents an initialisation function that takes
+// ProviderFactory represents an initialisation function that takes
 // an arbitrary JSON encoded configuration set and returns an
 // instance of a Provider.
-type NewProvider func(jsonCfg []byte) (Provider, error)
+type ProviderFactory func(jsonCfg []byte) (Provider, error)
 
 // Provider is an interface for a generic messaging backend,
 // for instance, e-mail, SMS etc.
@@ -40,6 +49,11 @@ type Provider interface {
 	// registered without an address.
 	AddressDesc() string
 
+	// SenderIdentity returns the sender the messages appear from, for
+	// instance, an SMS sender ID or a From e-mail address. It's empty
+	// if the Provider has no notion of a sender.
+	SenderIdentity() string
+
 	// ValidateAddress validates the 'to' address the Provider
 	// is supposed to send the OTP to, for instance, an e-mail
 	// or a phone number.
@@ -60,3 +74,154 @@ type Provider interface {
 	// that can be sent by the Provider.
 	MaxBodyLen() int
 }
+
+// PushResult represents the outcome of a message pushed by a Provider.
+type PushResult struct {
+	// ID is the message (or verification) ID issued by the backend.
+	ID string `json:"id"`
+
+	// Status is the backend's status of the message, eg: "sent".
+	Status string `json:"status"`
+
+	// Timing is the latency breakdown of the upstream request, if the
+	// Provider collects it.
+	Timing *RequestTiming `json:"timing,omitempty"`
+
+	// SLABreached reports whether a successful push took longer than
+	// the Provider's SLA, in which case the message may arrive too late
+	// and callers may want to retry on another channel.
+	SLABreached bool `json:"sla_breached,omitempty"`
+}
+
+// RequestTiming is the latency breakdown of a Provider's upstream HTTP
+// request. Phases that didn't happen, eg: DNS and connect on a reused
+// connection, are 0.
+type RequestTiming struct {
+	DNS     time.Duration `json:"dns"`
+	Connect time.Duration `json:"connect"`
+	TLS     time.Duration `json:"tls"`
+
+	// FirstByte is the time from the start of the request to the first
+	// response byte and Total, to the end of the response.
+	FirstByte time.Duration `json:"first_byte"`
+	Total     time.Duration `json:"total"`
+
+	// Reused reports whether a kept-alive connection was reused.
+	Reused bool `json:"reused"`
+}
+
+// ResultPusher is an optional interface implemented by Providers
+// that accept a context and report the result of a push.
+type ResultPusher interface {
+	PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (PushResult, error)
+}
+
+// Verifier is an optional interface implemented by Providers where the
+// backend generates and verifies the OTP itself. id is the ID returned
+// in the PushResult.
+type Verifier interface {
+	Verify(ctx context.Context, id, code string) error
+}
+
+// StatusChecker is an optional interface implemented by Providers that
+// can look up the delivery status of a message, eg: "sent", "delivered"
+// or "failed". id is the ID returned in the PushResult.
+type StatusChecker interface {
+	DeliveryStatus(ctx context.Context, id string) (string, error)
+}
+
+// PreviewResult is a message rendered by a Previewer without sending it.
+type PreviewResult struct {
+	// Normalized address and the sender the message is sent to and from.
+	To     string
+	Sender string
+
+	Subject string
+	Body    string
+
+	// Number of SMS segments (parts) the body is sent as (0 for non-SMS
+	// channels) and the estimated cost, if the Provider knows it.
+	Segments int
+	Cost     float64
+}
+
+// Previewer is an optional interface implemented by Providers that can
+// render a message exactly as it would be sent, without sending it,
+// for instance, to preview configurations.
+type Previewer interface {
+	Preview(otp models.OTP, subject string, body []byte) (PreviewResult, error)
+}
+
+// TestOTPer is an optional interface implemented by Providers that
+// support test addresses (eg: for app store reviewers) that always
+// receive a fixed OTP without a message being sent.
+type TestOTPer interface {
+	// TestOTP returns the fixed OTP for a test address, if it is one.
+	TestOTP(to string) (string, bool)
+}
+
+// SenderResolver is an optional interface implemented by Providers whose
+// sender identity depends on the destination, for instance, per-country
+// sender IDs.
+type SenderResolver interface {
+	// SenderFor returns the sender messages to the given address
+	// appear from.
+	SenderFor(to string) string
+}
+
+// OTPPusher is an optional interface implemented by Providers that can
+// format a standard message body themselves, for integrations that only
+// have an OTP to send.
+type OTPPusher interface {
+	// PushOTP pushes a message with the OTP in the Provider's default
+	// body to the address.
+	PushOTP(ctx context.Context, to, otp string) (PushResult, error)
+}
+
+// UIDescriptor describes the channel of a Provider and the address it
+// sends to so that frontends can render channel pickers and address
+// inputs without hardcoding them per Provider.
+type UIDescriptor struct {
+	ID          string `json:"id"`
+	ChannelName string `json:"channel_name"`
+	ChannelDesc string `json:"channel_desc"`
+	AddressName string `json:"address_name"`
+	AddressDesc string `json:"address_desc"`
+
+	// AddressPattern is a regular expression that valid addresses match
+	// in full, like the HTML input pattern attribute. It's a hint for
+	// client side validation and ValidateAddress is authoritative. It's
+	// empty if there's none.
+	AddressPattern string `json:"address_pattern"`
+
+	// AddressExample is an example address, for instance, for an input
+	// placeholder.
+	AddressExample string `json:"address_example"`
+
+	MaxAddressLen int `json:"max_address_len"`
+	MaxOTPLen     int `json:"max_otp_len"`
+}
+
+// UIDescriber is an optional interface implemented by Providers that
+// describe their address inputs for UIs beyond the Provider methods.
+type UIDescriber interface {
+	UIDescriptor() UIDescriptor
+}
+
+// DescribeUI returns the UIDescriptor of a Provider. Providers that
+// don't implement UIDescriber are described with the Provider methods
+// and have no address pattern or example.
+func DescribeUI(p Provider) UIDescriptor {
+	if d, ok := p.(UIDescriber); ok {
+		return d.UIDescriptor()
+	}
+	return UIDescriptor{
+		ID:            p.ID(),
+		ChannelName:   p.ChannelName(),
+		ChannelDesc:   p.ChannelDesc(),
+		AddressName:   p.AddressName(),
+		AddressDesc:   p.AddressDesc(),
+		MaxAddressLen: p.MaxAddressLen(),
+		MaxOTPLen:     p.MaxOTPLen(),
+	}
+}
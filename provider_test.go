@@ -0,0 +1,34 @@
+package otpgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type describedProv struct {
+	healthProv
+}
+
+func (d *describedProv) UIDescriptor() UIDescriptor {
+	return UIDescriptor{ID: d.id, AddressPattern: `[0-9]+`, AddressExample: "12345"}
+}
+
+func TestDescribeUI(t *testing.T) {
+	// Providers are described with their methods by default.
+	d := DescribeUI(&healthProv{id: "dummy"})
+	assert.Equal(t, UIDescriptor{
+		ID:            "dummy",
+		ChannelName:   "dummychannel",
+		ChannelDesc:   "dummy channel description",
+		AddressName:   "dummyaddress",
+		AddressDesc:   "dummy address description",
+		MaxAddressLen: 10,
+		MaxOTPLen:     6,
+	}, d)
+
+	d = DescribeUI(&describedProv{healthProv{id: "described"}})
+	assert.Equal(t, "described", d.ID)
+	assert.Equal(t, `[0-9]+`, d.AddressPattern)
+	assert.Equal(t, "12345", d.AddressExample)
+}
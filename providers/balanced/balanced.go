@@ -0,0 +1,205 @@
+// Package balanced implements a composite otpgateway.Provider that
+// distributes pushes across multiple child Providers by weight, for
+// instance, to stay under the rate limits of individual accounts.
+package balanced
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/internal/clock"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+const (
+	providerID      = "balanced"
+	defaultCooldown = time.Second * 30
+)
+
+// ErrNoHealthyProvider is returned when all child providers are
+// unhealthy and there's nothing to push to.
+var ErrNoHealthyProvider = errors.New("no healthy providers available")
+
+// Child represents a child Provider and its relative weight.
+type Child struct {
+	Provider otpgateway.Provider
+	Weight   int
+}
+
+// Config represents the configuration of the balanced Provider.
+type Config struct {
+	// ID is the optional ID of the Provider. Defaults to "balanced".
+	ID string
+
+	// Cooldown is the duration for which a child that failed to push
+	// is skipped. Defaults to 30 seconds.
+	Cooldown time.Duration
+}
+
+type child struct {
+	otpgateway.Provider
+
+	weight    int
+	current   int
+	downUntil time.Time
+}
+
+// balanced is a smooth weighted round-robin balancer of Providers.
+type balanced struct {
+	cfg      Config
+	children []*child
+	clock    clock.Clock
+	mu       sync.Mutex
+}
+
+// Compile time check for the Provider interface.
+var _ otpgateway.Provider = (*balanced)(nil)
+
+// New returns a Provider that distributes each Push across the given
+// children in proportion to their weights (smooth weighted round-robin).
+// A child whose Push fails is skipped until its cooldown elapses.
+func New(c Config, children []Child) (otpgateway.Provider, error) {
+	if len(children) == 0 {
+		return nil, errors.New("no child providers")
+	}
+	if c.ID == "" {
+		c.ID = providerID
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = defaultCooldown
+	}
+
+	b := &balanced{cfg: c, clock: clock.Real}
+	for _, ch := range children {
+		if ch.Provider == nil {
+			return nil, errors.New("invalid child provider")
+		}
+		if ch.Weight < 1 {
+			return nil, errors.New("child weight should be >= 1")
+		}
+		b.children = append(b.children, &child{Provider: ch.Provider, weight: ch.Weight})
+	}
+	return b, nil
+}
+
+// ID returns the Provider's ID.
+func (b *balanced) ID() string {
+	return b.cfg.ID
+}
+
+// ChannelName returns the channel name of the first child.
+func (b *balanced) ChannelName() string {
+	return b.children[0].ChannelName()
+}
+
+// ChannelDesc returns the channel help text of the first child.
+func (b *balanced) ChannelDesc() string {
+	return b.children[0].ChannelDesc()
+}
+
+// AddressName returns the address name of the first child.
+func (b *balanced) AddressName() string {
+	return b.children[0].AddressName()
+}
+
+// AddressDesc returns the address help text of the first child.
+func (b *balanced) AddressDesc() string {
+	return b.children[0].AddressDesc()
+}
+
+// SenderIdentity returns the sender identity of the first child.
+func (b *balanced) SenderIdentity() string {
+	return b.children[0].SenderIdentity()
+}
+
+// ValidateAddress validates the address against all children as
+// a push may be routed to any one of them.
+func (b *balanced) ValidateAddress(to string) error {
+	for _, c := range b.children {
+		if err := c.ValidateAddress(to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Push pushes the message via the next healthy child. If the child
+// fails, it's marked unhealthy for the cooldown duration. Disabled
+// children (see otpgateway.Toggler) are skipped.
+func (b *balanced) Push(otp models.OTP, subject string, body []byte) error {
+	c, err := b.next()
+	if err != nil {
+		return err
+	}
+
+	if err := c.Push(otp, subject, body); err != nil {
+		b.mu.Lock()
+		c.downUntil = b.clock.Now().Add(b.cfg.Cooldown)
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// MaxAddressLen returns the smallest max address length of all children.
+func (b *balanced) MaxAddressLen() int {
+	n := b.children[0].MaxAddressLen()
+	for _, c := range b.children[1:] {
+		if v := c.MaxAddressLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+// MaxOTPLen returns the smallest max OTP length of all children.
+func (b *balanced) MaxOTPLen() int {
+	n := b.children[0].MaxOTPLen()
+	for _, c := range b.children[1:] {
+		if v := c.MaxOTPLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+// MaxBodyLen returns the smallest max body length of all children.
+func (b *balanced) MaxBodyLen() int {
+	n := b.children[0].MaxBodyLen()
+	for _, c := range b.children[1:] {
+		if v := c.MaxBodyLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+// next picks the next healthy child using the smooth weighted
+// round-robin algorithm.
+func (b *balanced) next() (*child, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		now   = b.clock.Now()
+		total = 0
+		best  *child
+	)
+	for _, c := range b.children {
+		if now.Before(c.downUntil) || !otpgateway.IsEnabled(c.Provider) {
+			continue
+		}
+		c.current += c.weight
+		total += c.weight
+		if best == nil || c.current > best.current {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil, ErrNoHealthyProvider
+	}
+	best.current -= total
+	return best, nil
+}
@@ -0,0 +1,159 @@
+package balanced
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/internal/clock"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+type dummyProv struct {
+	otpgateway.Toggle
+
+	id     string
+	fail   bool
+	pushes int
+}
+
+func (d *dummyProv) ID() string                      { return d.id }
+func (d *dummyProv) ChannelName() string             { return "dummychannel" }
+func (d *dummyProv) ChannelDesc() string             { return "dummy channel description" }
+func (d *dummyProv) AddressName() string             { return "dummyaddress" }
+func (d *dummyProv) AddressDesc() string             { return "dummy address description" }
+func (d *dummyProv) ValidateAddress(to string) error { return nil }
+func (d *dummyProv) SenderIdentity() string          { return "dummysender" }
+func (d *dummyProv) MaxAddressLen() int              { return 10 }
+func (d *dummyProv) MaxOTPLen() int                  { return 6 }
+func (d *dummyProv) MaxBodyLen() int                 { return 140 }
+func (d *dummyProv) Push(otp models.OTP, subject string, body []byte) error {
+	if !d.Enabled() {
+		return otpgateway.ErrProviderDisabled
+	}
+	d.pushes++
+	if d.fail {
+		return errors.New("push failed")
+	}
+	return nil
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	var (
+		a = &dummyProv{id: "a"}
+		b = &dummyProv{id: "b"}
+		c = &dummyProv{id: "c"}
+	)
+	p, err := New(Config{}, []Child{{a, 5}, {b, 3}, {c, 2}})
+	assert.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, 500, a.pushes, "distribution doesn't match weight")
+	assert.Equal(t, 300, b.pushes, "distribution doesn't match weight")
+	assert.Equal(t, 200, c.pushes, "distribution doesn't match weight")
+}
+
+func TestUnhealthySkipped(t *testing.T) {
+	var (
+		a = &dummyProv{id: "a", fail: true}
+		b = &dummyProv{id: "b"}
+	)
+	p, err := New(Config{}, []Child{{a, 10}, {b, 1}})
+	assert.NoError(t, err)
+
+	// The heavier child is picked first and fails.
+	assert.Error(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 1, a.pushes)
+
+	// It should be skipped for the cooldown duration.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, 1, a.pushes, "unhealthy child wasn't skipped")
+	assert.Equal(t, 10, b.pushes)
+
+	// All children down.
+	b.fail = true
+	assert.Error(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, ErrNoHealthyProvider, p.Push(models.OTP{}, "", nil))
+}
+
+func TestDisabledSkipped(t *testing.T) {
+	var (
+		a = &dummyProv{id: "a"}
+		b = &dummyProv{id: "b"}
+	)
+	p, err := New(Config{}, []Child{{a, 10}, {b, 1}})
+	assert.NoError(t, err)
+
+	// The disabled child is skipped for the next healthy one.
+	a.SetEnabled(false)
+	assert.Equal(t, otpgateway.ErrProviderDisabled, a.Push(models.OTP{}, "", nil))
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, 0, a.pushes, "disabled child wasn't skipped")
+	assert.Equal(t, 10, b.pushes)
+
+	// ... until it's enabled again.
+	a.SetEnabled(true)
+	assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 1, a.pushes)
+
+	// All children disabled.
+	a.SetEnabled(false)
+	b.SetEnabled(false)
+	assert.Equal(t, ErrNoHealthyProvider, p.Push(models.OTP{}, "", nil))
+}
+
+func TestCooldownExpiry(t *testing.T) {
+	var (
+		a   = &dummyProv{id: "a", fail: true}
+		b   = &dummyProv{id: "b"}
+		clk = clock.NewFake(time.Now())
+	)
+	p, err := New(Config{Cooldown: time.Minute}, []Child{{a, 10}, {b, 1}})
+	assert.NoError(t, err)
+	p.(*balanced).clock = clk
+
+	assert.Error(t, p.Push(models.OTP{}, "", nil))
+	assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 1, a.pushes)
+
+	// Still down just before the cooldown expires.
+	clk.Advance(time.Minute - time.Second)
+	assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 1, a.pushes)
+
+	// After the cooldown, the child is tried again (half-open) and
+	// a failure puts it back in cooldown.
+	clk.Advance(time.Second)
+	assert.Error(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 2, a.pushes)
+	assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 2, a.pushes)
+
+	// A successful trial makes it healthy again.
+	a.fail = false
+	clk.Advance(time.Minute)
+	for i := 0; i < 11; i++ {
+		assert.NoError(t, p.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, 12, a.pushes)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{}, nil)
+	assert.Error(t, err, "empty children accepted")
+
+	_, err = New(Config{}, []Child{{&dummyProv{}, 0}})
+	assert.Error(t, err, "zero weight accepted")
+
+	p, err := New(Config{ID: "mybalancer"}, []Child{{&dummyProv{}, 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "mybalancer", p.ID())
+}
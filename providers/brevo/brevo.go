@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID = "brevo"
+	apiURL     = "https://api.brevo.com/v3"
+	statusSent = "sent"
+
+	channelSMS   = "sms"
+	channelEmail = "email"
+
+	maxOTPLen       = 6
+	maxSMSBodyLen   = 140
+	maxEmailLen     = 100
+	maxEmailBodyLen = 100 * 1024
+)
+
+var reMail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// APIError is Brevo's error envelope returned with non 2xx responses.
+type APIError struct {
+	HTTPStatus int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+// Error returns the error message.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("brevo error: %s: %s (HTTP %d)", e.Code, e.Message, e.HTTPStatus)
+}
+
+// brevo is the Brevo (Sendinblue) Provider that sends OTPs either as
+// transactional SMSes or e-mails.
+type brevo struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*brevo)(nil)
+	_ otpgateway.ResultPusher = (*brevo)(nil)
+)
+
+type cfg struct {
+	RootURL   string `json:"RootURL"`
+	APIKey    string `json:"APIKey"`
+	Channel   string `json:"Channel"`
+	Sender    string `json:"Sender"`
+	FromEmail string `json:"FromEmail"`
+	FromName  string `json:"FromName"`
+	HTML      bool   `json:"HTML"`
+	Timeout   int    `json:"Timeout"`
+
+	// Subject of e-mails when the subject passed to Push is empty.
+	DefaultSubject string `json:"DefaultSubject"`
+
+	// Human readable sender name of e-mails. FromName is its older
+	// alias. It's ignored for SMSes.
+	DisplayName string `json:"DisplayName"`
+}
+
+type smsReq struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Content   string `json:"content"`
+	Type      string `json:"type"`
+	Tag       string `json:"tag,omitempty"`
+}
+
+type emailAddr struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+type emailReq struct {
+	Sender      emailAddr   `json:"sender"`
+	To          []emailAddr `json:"to"`
+	Subject     string      `json:"subject"`
+	HTMLContent string      `json:"htmlContent,omitempty"`
+	TextContent string      `json:"textContent,omitempty"`
+}
+
+// apiResp represents a successful response. messageId is a number for
+// SMSes and a string for e-mails.
+type apiResp struct {
+	MessageID json.RawMessage `json:"messageId"`
+}
+
+// New returns an instance of the Brevo Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	APIKey: "", // API key,
+// 	Channel: "sms", // sms or email,
+// 	Sender: "", // SMS sender name (sms),
+// 	FromEmail: "", // From e-mail address (email),
+// 	FromName: "", // Optional. From name (email)
+// 	HTML: false, // Optional. Send the e-mail body as HTML (email)
+// 	Timeout: 5, // Optional HTTP timeout in seconds
+// 	DefaultSubject: "", // Optional. E-mail subject when the subject is empty (email)
+// 	DisplayName: "" // Optional. From name (email), same as FromName
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.APIKey == "" {
+		return nil, errors.New("invalid APIKey")
+	}
+	switch c.Channel {
+	case channelSMS:
+		if c.Sender == "" {
+			return nil, errors.New("invalid Sender")
+		}
+	case channelEmail:
+		if !reMail.MatchString(c.FromEmail) {
+			return nil, errors.New("invalid FromEmail")
+		}
+	default:
+		return nil, errors.New("Channel should be sms or email")
+	}
+	if c.DisplayName == "" {
+		c.DisplayName = c.FromName
+	}
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/")
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &brevo{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (*brevo) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (b *brevo) ChannelName() string {
+	if b.cfg.Channel == channelEmail {
+		return "E-mail"
+	}
+	return "SMS"
+}
+
+// AddressName returns the Provider's address name.
+func (b *brevo) AddressName() string {
+	if b.cfg.Channel == channelEmail {
+		return "E-mail ID"
+	}
+	return "Mobile number"
+}
+
+// ChannelDesc returns help text for the Provider.
+func (b *brevo) ChannelDesc() string {
+	if b.cfg.Channel == channelEmail {
+		return `
+		A verification code has been e-mailed to you.
+		Please enter the code here to complete the verification.`
+	}
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, maxOTPLen)
+}
+
+// AddressDesc returns help text for the address.
+func (b *brevo) AddressDesc() string {
+	if b.cfg.Channel == channelEmail {
+		return "Please enter the e-mail ID you want to verify"
+	}
+	return "Please enter your mobile number with the country code, eg: +33612345678"
+}
+
+// ValidateAddress validates an e-mail address or a phone number in
+// the E.164 format depending on the channel.
+func (b *brevo) ValidateAddress(to string) error {
+	if b.cfg.Channel == channelEmail {
+		if !reMail.MatchString(to) {
+			return errors.New("invalid e-mail address")
+		}
+		return nil
+	}
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	return nil
+}
+
+// SenderIdentity returns the SMS sender name or the From e-mail.
+func (b *brevo) SenderIdentity() string {
+	if b.cfg.Channel == channelEmail {
+		return b.cfg.FromEmail
+	}
+	return b.cfg.Sender
+}
+
+// Push sends the OTP. The subject is only used for e-mails.
+func (b *brevo) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := b.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP as an SMS or an e-mail and returns the
+// Brevo message ID.
+func (b *brevo) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	if err := b.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	var (
+		path string
+		req  interface{}
+	)
+	if b.cfg.Channel == channelEmail {
+		if subject == "" {
+			subject = b.cfg.DefaultSubject
+		}
+		e := emailReq{
+			Sender:  emailAddr{Name: b.cfg.DisplayName, Email: b.cfg.FromEmail},
+			To:      []emailAddr{{Email: otp.To}},
+			Subject: subject,
+		}
+		if b.cfg.HTML {
+			e.HTMLContent = string(body)
+		} else {
+			e.TextContent = string(body)
+		}
+		path, req = "/smtp/email", e
+	} else {
+		// Brevo expects the recipient without the +.
+		path, req = "/transactionalSMS/sms", smsReq{
+			Sender:    b.cfg.Sender,
+			Recipient: strings.TrimPrefix(otp.To, "+"),
+			Content:   string(body),
+			Type:      "transactional",
+			Tag:       otp.Namespace,
+		}
+	}
+
+	r, err := b.do(ctx, path, req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	id := strings.Trim(string(r.MessageID), `"`)
+	if id == "" || id == "null" {
+		return otpgateway.PushResult{}, errors.New("messageId invalid")
+	}
+	return otpgateway.PushResult{ID: id, Status: statusSent}, nil
+}
+
+// do POSTs the request as JSON to the API.
+func (b *brevo) do(ctx context.Context, path string, data interface{}) (apiResp, error) {
+	j, err := json.Marshal(data)
+	if err != nil {
+		return apiResp{}, err
+	}
+
+	req, err := http.NewRequest("POST", b.cfg.RootURL+path, bytes.NewReader(j))
+	if err != nil {
+		return apiResp{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("api-key", b.cfg.APIKey)
+
+	resp, err := b.h.Do(req)
+	if err != nil {
+		return apiResp{}, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return apiResp{}, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &APIError{HTTPStatus: resp.StatusCode}
+		if err := json.Unmarshal(rb, e); err != nil {
+			e.Message = string(rb)
+		}
+		return apiResp{}, e
+	}
+
+	var r apiResp
+	if err := json.Unmarshal(rb, &r); err != nil {
+		return apiResp{}, err
+	}
+	return r, nil
+}
+
+// MaxAddressLen returns the maximum allowed length for the address.
+func (b *brevo) MaxAddressLen() int {
+	if b.cfg.Channel == channelEmail {
+		return maxEmailLen
+	}
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*brevo) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (b *brevo) MaxBodyLen() int {
+	if b.cfg.Channel == channelEmail {
+		return maxEmailBodyLen
+	}
+	return maxSMSBodyLen
+}
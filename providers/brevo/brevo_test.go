@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+33612345678",
+	OTP:       "123456",
+}
+
+type testServer struct {
+	*httptest.Server
+	path string
+	key  string
+	body map[string]interface{}
+}
+
+func newTestServer(code int, resp string) *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.path = r.URL.Path
+		ts.key = r.Header.Get("api-key")
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &ts.body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(resp))
+	}))
+	return ts
+}
+
+func newTestProv(t *testing.T, url, extra string) *brevo {
+	p, err := New([]byte(`{"RootURL": "` + url + `", "APIKey": "key", ` + extra + `}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*brevo)
+}
+
+func TestSMS(t *testing.T) {
+	srv := newTestServer(http.StatusCreated, `{"reference": "ab1cde2fgh3i4jklmno", "messageId": 1511882900176220}`)
+	defer srv.Close()
+
+	b := newTestProv(t, srv.URL, `"Channel": "sms", "Sender": "MyApp"`)
+	res, err := b.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1511882900176220", res.ID)
+	assert.Equal(t, "/transactionalSMS/sms", srv.path)
+	assert.Equal(t, "key", srv.key)
+	assert.Equal(t, "33612345678", srv.body["recipient"])
+	assert.Equal(t, "MyApp", srv.body["sender"])
+	assert.Equal(t, "Your OTP is 123456", srv.body["content"])
+
+	assert.NoError(t, b.ValidateAddress("+33612345678"))
+	assert.Error(t, b.ValidateAddress("user@example.com"))
+}
+
+func TestEmail(t *testing.T) {
+	srv := newTestServer(http.StatusCreated, `{"messageId": "<201798300811.5787683@smtp-relay.mailin.fr>"}`)
+	defer srv.Close()
+
+	b := newTestProv(t, srv.URL, `"Channel": "email", "FromEmail": "otp@example.com", "HTML": true`)
+	o := mockOTP
+	o.To = "user@example.com"
+	res, err := b.PushContext(context.Background(), o, "Verification", []byte("<p>Your OTP is 123456</p>"))
+	assert.NoError(t, err)
+	assert.Equal(t, "<201798300811.5787683@smtp-relay.mailin.fr>", res.ID)
+	assert.Equal(t, "/smtp/email", srv.path)
+	assert.Equal(t, "Verification", srv.body["subject"])
+	assert.Equal(t, "<p>Your OTP is 123456</p>", srv.body["htmlContent"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"email": "user@example.com"}}, srv.body["to"])
+
+	assert.NoError(t, b.ValidateAddress("user@example.com"))
+	assert.Error(t, b.ValidateAddress("+33612345678"))
+}
+
+func TestDefaultSubject(t *testing.T) {
+	srv := newTestServer(http.StatusCreated, `{"messageId": "<1@smtp-relay.mailin.fr>"}`)
+	defer srv.Close()
+
+	b := newTestProv(t, srv.URL, `"Channel": "email", "FromEmail": "otp@example.com", "DefaultSubject": "Your code"`)
+	o := mockOTP
+	o.To = "user@example.com"
+
+	_, err := b.PushContext(context.Background(), o, "", []byte("Your OTP is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Your code", srv.body["subject"])
+	assert.Equal(t, "Your OTP is 123456", srv.body["textContent"])
+
+	_, err = b.PushContext(context.Background(), o, "Verification", []byte("Your OTP is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Verification", srv.body["subject"])
+}
+
+func TestAuthFailure(t *testing.T) {
+	srv := newTestServer(http.StatusUnauthorized, `{"code": "unauthorized", "message": "Key not found"}`)
+	defer srv.Close()
+
+	b := newTestProv(t, srv.URL, `"Channel": "sms", "Sender": "MyApp"`)
+	_, err := b.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+
+	var e *APIError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "unauthorized", e.Code)
+		assert.Equal(t, "Key not found", e.Message)
+		assert.Equal(t, http.StatusUnauthorized, e.HTTPStatus)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []string{
+		`{"APIKey": "key", "Channel": "fax"}`,
+		`{"APIKey": "key", "Channel": "sms"}`,
+		`{"APIKey": "key", "Channel": "email", "FromEmail": "invalid"}`,
+		`{"Channel": "sms", "Sender": "MyApp"}`,
+	} {
+		_, err := New([]byte(c))
+		assert.Error(t, err, c)
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	p, err := New([]byte(`{"APIKey": "key", "Channel": "sms", "Sender": "MyApp"}`))
+	assert.NoError(t, err)
+
+	_, ok := p.(otpgateway.Provider)
+	assert.True(t, ok, "not a Provider")
+	_, ok = p.(otpgateway.ResultPusher)
+	assert.True(t, ok, "not a ResultPusher")
+}
+
+func TestDisplayName(t *testing.T) {
+	srv := newTestServer(http.StatusCreated, `{"messageId": "<1@smtp-relay.mailin.fr>"}`)
+	defer srv.Close()
+
+	o := mockOTP
+	o.To = "user@example.com"
+	for _, extra := range []string{`"DisplayName": "My App"`, `"FromName": "My App"`} {
+		b := newTestProv(t, srv.URL, `"Channel": "email", "FromEmail": "otp@example.com", `+extra)
+		_, err := b.PushContext(context.Background(), o, "Verification", []byte("Your OTP is 123456"))
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"name": "My App", "email": "otp@example.com"}, srv.body["sender"], extra)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID  = "clicksend"
+	channelName = "SMS"
+	addressName = "Mobile number"
+	apiURL      = "https://rest.clicksend.com/v3/sms/send"
+	statusOK    = "SUCCESS"
+	statusSent  = "sent"
+	maxOTPLen   = 6
+	maxBodyLen  = 140
+)
+
+// Errors mapped from ClickSend message statuses.
+var (
+	ErrInsufficientBalance = errors.New("insufficient account balance")
+	ErrInvalidRecipient    = errors.New("invalid recipient")
+	ErrUnknownFailure      = errors.New("unknown delivery failure")
+)
+
+// statusErrors maps ClickSend response and message statuses to errors.
+var statusErrors = map[string]error{
+	"INSUFFICIENT_CREDIT": ErrInsufficientBalance,
+	"INVALID_RECIPIENT":   ErrInvalidRecipient,
+}
+
+// clicksend is the ClickSend SMS Provider.
+type clicksend struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*clicksend)(nil)
+	_ otpgateway.ResultPusher = (*clicksend)(nil)
+)
+
+type cfg struct {
+	RootURL  string `json:"RootURL"`
+	Username string `json:"Username"`
+	APIKey   string `json:"APIKey"`
+	From     string `json:"From"`
+	Timeout  int    `json:"Timeout"`
+}
+
+type apiMsg struct {
+	Source string `json:"source"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Body   string `json:"body"`
+}
+
+type apiReq struct {
+	Messages []apiMsg `json:"messages"`
+}
+
+// apiResp represents the response from the ClickSend API.
+type apiResp struct {
+	ResponseCode string `json:"response_code"`
+	ResponseMsg  string `json:"response_msg"`
+	Data         struct {
+		Messages []struct {
+			MessageID string `json:"message_id"`
+			Status    string `json:"status"`
+		} `json:"messages"`
+	} `json:"data"`
+}
+
+// New returns an instance of the ClickSend Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional URL of the send API,
+// 	Username: "", // ClickSend username,
+// 	APIKey: "", // ClickSend API key,
+// 	From: "", // Optional. Sender ID or number
+// 	Timeout: 5 // Optional HTTP timeout in seconds
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.Username == "" || c.APIKey == "" {
+		return nil, errors.New("invalid Username or APIKey")
+	}
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &clicksend{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (*clicksend) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (*clicksend) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*clicksend) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (*clicksend) ChannelDesc() string {
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, maxOTPLen)
+}
+
+// AddressDesc returns help text for the phone number.
+func (*clicksend) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +61411111111"
+}
+
+// ValidateAddress validates a phone number in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (*clicksend) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	return nil
+}
+
+// Push sends the OTP SMS. SMSes have no subject and the subject is unused.
+func (c *clicksend) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := c.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP SMS and returns the ClickSend message ID.
+func (c *clicksend) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// SMSes have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := c.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	b, err := json.Marshal(apiReq{Messages: []apiMsg{{
+		Source: "otpgateway",
+		From:   c.cfg.From,
+		To:     otp.To,
+		Body:   string(body),
+	}}})
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	req, err := http.NewRequest("POST", c.cfg.RootURL, bytes.NewReader(b))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(c.cfg.Username, c.cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.h.Do(req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	var r apiResp
+	if err := json.Unmarshal(rb, &r); err != nil {
+		return otpgateway.PushResult{}, fmt.Errorf("error parsing response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if r.ResponseCode != statusOK {
+		return otpgateway.PushResult{}, statusError(r.ResponseCode, r.ResponseMsg)
+	}
+	if len(r.Data.Messages) == 0 {
+		return otpgateway.PushResult{}, errors.New("no messages in response")
+	}
+
+	m := r.Data.Messages[0]
+	if m.Status != statusOK {
+		return otpgateway.PushResult{}, statusError(m.Status, "")
+	}
+	if m.MessageID == "" {
+		return otpgateway.PushResult{}, errors.New("message_id invalid")
+	}
+	return otpgateway.PushResult{ID: m.MessageID, Status: statusSent}, nil
+}
+
+// statusError maps a ClickSend status to an error.
+func statusError(status, msg string) error {
+	e, ok := statusErrors[status]
+	if !ok {
+		e = ErrUnknownFailure
+	}
+	if msg == "" {
+		return fmt.Errorf("%w (status %s)", e, status)
+	}
+	return fmt.Errorf("%w: %s (status %s)", e, msg, status)
+}
+
+// SenderIdentity returns the sender ID or number messages are sent from.
+func (c *clicksend) SenderIdentity() string {
+	return c.cfg.From
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (*clicksend) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*clicksend) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (*clicksend) MaxBodyLen() int {
+	return maxBodyLen
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+61411111111",
+	OTP:       "123456",
+}
+
+const (
+	respSuccess = `{
+		"http_code": 200,
+		"response_code": "SUCCESS",
+		"response_msg": "Messages queued for delivery.",
+		"data": {"messages": [{"message_id": "BF7AD270-0DE2-418B-B606-71D527D9C1AE", "status": "SUCCESS"}]}
+	}`
+	respNoBalance = `{
+		"http_code": 200,
+		"response_code": "SUCCESS",
+		"response_msg": "Messages queued for delivery.",
+		"data": {"messages": [{"message_id": "", "status": "INSUFFICIENT_CREDIT"}]}
+	}`
+	respInvalid = `{
+		"http_code": 200,
+		"response_code": "SUCCESS",
+		"response_msg": "",
+		"data": {"messages": [{"message_id": "", "status": "INVALID_RECIPIENT"}]}
+	}`
+)
+
+func newTestProv(t *testing.T, resp string) (*clicksend, *http.Request, func()) {
+	var (
+		req = &http.Request{}
+		msg apiReq
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*req = *r
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &msg)
+		assert.Len(t, msg.Messages, 1)
+		w.Write([]byte(resp))
+	}))
+
+	p, err := New([]byte(`{"RootURL": "` + srv.URL + `", "Username": "user", "APIKey": "key", "From": "MyApp"}`))
+	assert.NoError(t, err)
+	return p.(*clicksend), req, srv.Close
+}
+
+func TestPush(t *testing.T) {
+	c, req, done := newTestProv(t, respSuccess)
+	defer done()
+
+	res, err := c.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "BF7AD270-0DE2-418B-B606-71D527D9C1AE", res.ID)
+	assert.Equal(t, "sent", res.Status)
+
+	u, p, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", u)
+	assert.Equal(t, "key", p)
+}
+
+func TestPushFailures(t *testing.T) {
+	c, _, done := newTestProv(t, respNoBalance)
+	_, err := c.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+	assert.True(t, errors.Is(err, ErrInsufficientBalance), err)
+	done()
+
+	c, _, done = newTestProv(t, respInvalid)
+	_, err = c.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+	assert.True(t, errors.Is(err, ErrInvalidRecipient), err)
+	done()
+
+	c, _, done = newTestProv(t, `{"response_code": "INVALID_CREDENTIALS", "response_msg": "bad credentials"}`)
+	_, err = c.PushContext(context.Background(), mockOTP, "", []byte("Your OTP is 123456"))
+	assert.True(t, errors.Is(err, ErrUnknownFailure), err)
+	done()
+}
+
+func TestValidateAddress(t *testing.T) {
+	var c clicksend
+	assert.NoError(t, c.ValidateAddress("+61411111111"))
+	assert.Error(t, c.ValidateAddress("0411111111"))
+	assert.Error(t, c.ValidateAddress(""))
+}
+
+func TestInterfaces(t *testing.T) {
+	p, err := New([]byte(`{"Username": "user", "APIKey": "key"}`))
+	assert.NoError(t, err)
+
+	_, ok := p.(otpgateway.Provider)
+	assert.True(t, ok, "not a Provider")
+	_, ok = p.(otpgateway.ResultPusher)
+	assert.True(t, ok, "not a ResultPusher")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/gsm"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID   = "kannel"
+	channelName  = "SMS"
+	addressName  = "Mobile number"
+	statusSent   = "sent"
+	statusQueued = "queued"
+	maxOTPLen    = 6
+	maxBodyLen   = 140
+
+	// Max size of the plain text response that's read.
+	maxRespLen = 4096
+)
+
+// Errors mapped from Kannel's sendsms responses.
+var (
+	ErrAuthFailed       = errors.New("authorization failed")
+	ErrTemporaryFailure = fmt.Errorf("%w: temporary failure", otpgateway.ErrUpstreamUnavailable)
+	ErrRejected         = errors.New("message rejected")
+)
+
+// kannel is the Provider for the Kannel SMS gateway's sendsms
+// HTTP interface.
+type kannel struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*kannel)(nil)
+	_ otpgateway.ResultPusher = (*kannel)(nil)
+)
+
+type cfg struct {
+	URL      string `json:"URL"`
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+	From     string `json:"From"`
+	Timeout  int    `json:"Timeout"`
+
+	// HTTP method (GET, POST) of the sendsms requests. Default GET.
+	// POST requests send the params as a form in the body.
+	HTTPMethod string `json:"HTTPMethod"`
+}
+
+// New returns an instance of the Kannel Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	URL: "", // sendsms URL, eg: http://localhost:13013/cgi-bin/sendsms,
+// 	Username: "", // sendsms username,
+// 	Password: "", // sendsms password,
+// 	From: "", // Optional. Sender ID or number
+// 	Timeout: 5, // Optional HTTP timeout in seconds
+// 	HTTPMethod: "GET" // Optional. HTTP method (GET, POST)
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" || c.Username == "" || c.Password == "" {
+		return nil, errors.New("invalid URL or Username or Password")
+	}
+	c.HTTPMethod = strings.ToUpper(c.HTTPMethod)
+	if c.HTTPMethod == "" {
+		c.HTTPMethod = http.MethodGet
+	}
+	if c.HTTPMethod != http.MethodGet && c.HTTPMethod != http.MethodPost {
+		return nil, fmt.Errorf("unsupported HTTPMethod %s", c.HTTPMethod)
+	}
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &kannel{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (*kannel) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (*kannel) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*kannel) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (*kannel) ChannelDesc() string {
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, maxOTPLen)
+}
+
+// AddressDesc returns help text for the phone number.
+func (*kannel) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +14155551234"
+}
+
+// ValidateAddress validates a phone number in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (*kannel) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	return nil
+}
+
+// Push sends the OTP SMS. SMSes have no subject and the subject is unused.
+func (k *kannel) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := k.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP SMS. Kannel doesn't return message IDs,
+// so the OTP's ID is returned in the result.
+func (k *kannel) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// SMSes have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := k.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	p := url.Values{}
+	p.Set("username", k.cfg.Username)
+	p.Set("password", k.cfg.Password)
+	p.Set("to", otp.To)
+	p.Set("text", string(body))
+	if k.cfg.From != "" {
+		p.Set("from", k.cfg.From)
+	}
+
+	// Non GSM-7 bodies are sent as UCS-2.
+	if !gsm.IsGSM7(string(body)) {
+		p.Set("coding", "2")
+		p.Set("charset", "UTF-8")
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	if k.cfg.HTTPMethod == http.MethodPost {
+		req, err = http.NewRequest(http.MethodPost, k.cfg.URL, strings.NewReader(p.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodGet, k.cfg.URL+"?"+p.Encode(), nil)
+	}
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := k.h.Do(req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRespLen))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	status, err := parseResp(resp.StatusCode, string(b))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	return otpgateway.PushResult{ID: otp.ID, Status: status}, nil
+}
+
+// parseResp interprets Kannel's plain text sendsms response, eg:
+// "0: Accepted for delivery" or "Authorization failed for sendsms".
+func parseResp(code int, body string) (string, error) {
+	body = strings.TrimSpace(body)
+
+	switch {
+	case strings.HasPrefix(body, "0:"):
+		return statusSent, nil
+	case strings.HasPrefix(body, "3:"):
+		return statusQueued, nil
+	case code == http.StatusForbidden || strings.HasPrefix(body, "Authorization failed"):
+		return "", fmt.Errorf("%w: %s", ErrAuthFailed, body)
+	case code == http.StatusServiceUnavailable || strings.HasPrefix(body, "Temporal failure"):
+		return "", fmt.Errorf("%w: %s", ErrTemporaryFailure, body)
+	}
+	return "", fmt.Errorf("%w: %s (HTTP %d)", ErrRejected, body, code)
+}
+
+// SenderIdentity returns the sender ID or number messages are sent from.
+func (k *kannel) SenderIdentity() string {
+	return k.cfg.From
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (*kannel) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*kannel) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (*kannel) MaxBodyLen() int {
+	return maxBodyLen
+}
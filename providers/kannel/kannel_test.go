@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+14155551234",
+	OTP:       "123456",
+}
+
+func TestParseResp(t *testing.T) {
+	for _, c := range []struct {
+		code   int
+		body   string
+		status string
+		err    error
+	}{
+		{http.StatusAccepted, "0: Accepted for delivery", "sent", nil},
+		{http.StatusAccepted, "3: Queued for later delivery\n", "queued", nil},
+		{http.StatusForbidden, "Authorization failed for sendsms", "", ErrAuthFailed},
+		{http.StatusServiceUnavailable, "Temporal failure, try again later.", "", ErrTemporaryFailure},
+		{http.StatusBadRequest, "Sender missing and no global set, rejected", "", ErrRejected},
+		{http.StatusAccepted, "", "", ErrRejected},
+	} {
+		st, err := parseResp(c.code, c.body)
+		assert.Equal(t, c.status, st, c.body)
+		if c.err == nil {
+			assert.NoError(t, err, c.body)
+		} else {
+			assert.True(t, errors.Is(err, c.err), c.body)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	var (
+		method string
+		params url.Values
+		resp   = "0: Accepted for delivery"
+		code   = http.StatusAccepted
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method = r.Method
+		params = r.Form
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(code)
+		w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"URL": "` + srv.URL + `/cgi-bin/sendsms", "Username": "user", "Password": "pass", "From": "MYAPP"}`))
+	assert.NoError(t, err)
+	k := p.(*kannel)
+
+	res, err := k.PushContext(context.Background(), mockOTP, "", []byte("Your code is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "myotpid", Status: "sent"}, res)
+	assert.Equal(t, http.MethodGet, method)
+	assert.Equal(t, "user", params.Get("username"))
+	assert.Equal(t, "pass", params.Get("password"))
+	assert.Equal(t, "MYAPP", params.Get("from"))
+	assert.Equal(t, "+14155551234", params.Get("to"))
+	assert.Equal(t, "Your code is 123456", params.Get("text"))
+	assert.Empty(t, params.Get("coding"))
+
+	// Unicode bodies.
+	assert.NoError(t, k.Push(mockOTP, "", []byte("आपका कोड 123456")))
+	assert.Equal(t, "2", params.Get("coding"))
+	assert.Equal(t, "UTF-8", params.Get("charset"))
+
+	// Rejected.
+	code, resp = http.StatusForbidden, "Authorization failed for sendsms"
+	assert.True(t, errors.Is(k.Push(mockOTP, "", []byte("Your code is 123456")), ErrAuthFailed))
+
+	// POST.
+	code, resp = http.StatusAccepted, "0: Accepted for delivery"
+	p, err = New([]byte(`{"URL": "` + srv.URL + `", "Username": "user", "Password": "pass", "HTTPMethod": "post"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, p.(*kannel).Push(mockOTP, "", []byte("Your code is 123456")))
+	assert.Equal(t, http.MethodPost, method)
+	assert.Equal(t, "Your code is 123456", params.Get("text"))
+
+	// Names of recipients are dropped.
+	o := mockOTP
+	o.To = "Alice <+14155551234>"
+	assert.NoError(t, k.ValidateAddress(o.To))
+	assert.NoError(t, k.Push(o, "", []byte("Your code is 123456")))
+	assert.Equal(t, "+14155551234", params.Get("to"))
+
+	o.To = "4155551234"
+	assert.Error(t, k.Push(o, "", []byte("Your code is 123456")), "non E.164 number accepted")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte(`{"URL": "http://localhost", "Username": "user"}`))
+	assert.Error(t, err, "missing Password accepted")
+
+	_, err = New([]byte(`{"URL": "http://localhost", "Username": "user", "Password": "pass", "HTTPMethod": "PUT"}`))
+	assert.Error(t, err, "invalid HTTPMethod accepted")
+}
+
+func TestHTTPStatus(t *testing.T) {
+	_, err := parseResp(http.StatusServiceUnavailable, "Temporal failure, try again later.")
+	assert.Equal(t, http.StatusBadGateway, otpgateway.HTTPStatusFor(err))
+	_, err = parseResp(http.StatusBadRequest, "Sender missing and no global set, rejected")
+	assert.Equal(t, http.StatusInternalServerError, otpgateway.HTTPStatusFor(err))
+}
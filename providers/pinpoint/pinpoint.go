@@ -4,21 +4,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/pinpoint"
+	"github.com/aws/aws-sdk-go/service/pinpoint/pinpointiface"
+	"github.com/zplzpl/otpgateway"
 	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
 )
 
 const (
 	providerID    = "pinpoint"
 	channelName   = "SMS"
 	addressName   = "Mobile number"
-	maxAddresslen = 10
+	maxAddresslen = phone.MaxE164Len
 	maxOTPlen     = 6
 )
 
@@ -26,21 +28,50 @@ var (
 	channelType = "SMS"
 )
 
-var reNum = regexp.MustCompile(`\+?([0-9]){8,15}`)
+// deliveryStatusTimeout is Pinpoint's TIMEOUT delivery status. The
+// aws-sdk-go version in go.mod (v1.23) doesn't define it as
+// pinpoint.DeliveryStatusTimeout yet.
+const deliveryStatusTimeout = "TIMEOUT"
+
+// Errors mapped from Pinpoint's message delivery statuses.
+var (
+	ErrOptedOut         = fmt.Errorf("%w: recipient has opted out of messages", otpgateway.ErrSuppressed)
+	ErrDuplicate        = errors.New("duplicate recipient address")
+	ErrPermanentFailure = errors.New("permanent delivery failure")
+	ErrTemporaryFailure = fmt.Errorf("%w: temporary delivery failure", otpgateway.ErrUpstreamUnavailable)
+	ErrThrottled        = fmt.Errorf("%w: message was throttled", otpgateway.ErrRateLimited)
+	ErrTimeout          = errors.New("message delivery timed out")
+	ErrUnknownFailure   = errors.New("unknown delivery failure")
+)
+
+// deliveryErrors maps Pinpoint delivery statuses to errors.
+var deliveryErrors = map[string]error{
+	pinpoint.DeliveryStatusOptOut:           ErrOptedOut,
+	pinpoint.DeliveryStatusDuplicate:        ErrDuplicate,
+	pinpoint.DeliveryStatusPermanentFailure: ErrPermanentFailure,
+	pinpoint.DeliveryStatusTemporaryFailure: ErrTemporaryFailure,
+	pinpoint.DeliveryStatusThrottled:        ErrThrottled,
+	deliveryStatusTimeout:                   ErrTimeout,
+	pinpoint.DeliveryStatusUnknownFailure:   ErrUnknownFailure,
+}
 
 // sms is the default representation of the sms interface.
 type sms struct {
 	cfg *cfg
-	p   *pinpoint.Pinpoint
+	p   pinpointiface.PinpointAPI
 }
 
+// Compile time check for the Provider interface.
+var _ otpgateway.Provider = (*sms)(nil)
+
 type cfg struct {
-	AppID        string `json:"AppID"`
-	AWSAccessKey string `json:"AWSAccessKey"`
-	AWSSecretKey string `json:"AWSSecretKey"`
-	AWSRegion    string `json:"AWSRegion"`
-	MessageType  string `json:"MessageType"`
-	SenderID     string `json:"SenderID"`
+	AppID             string `json:"AppID"`
+	AWSAccessKey      string `json:"AWSAccessKey"`
+	AWSSecretKey      string `json:"AWSSecretKey"`
+	AWSRegion         string `json:"AWSRegion"`
+	MessageType       string `json:"MessageType"`
+	SenderID          string `json:"SenderID"`
+	OriginationNumber string `json:"OriginationNumber"`
 }
 
 // New returns an instance of the SMS package. cfg is configuration
@@ -51,7 +82,8 @@ type cfg struct {
 // 	AWSSecretKey: "", // AWS secret key,
 // 	AWSRegion: "", // AWS region name,
 // 	MessageType: "", // MessageType to signify if it is transactional sms,
-// 	SenderID: "" // Unique sender id
+// 	SenderID: "", // Unique sender id
+// 	OriginationNumber: "" // Optional long code / short code to send from
 // }
 func New(jsonCfg []byte) (interface{}, error) {
 	var c *cfg
@@ -72,6 +104,13 @@ func New(jsonCfg []byte) (interface{}, error) {
 	if c.AWSSecretKey == "" {
 		return nil, errors.New("invalid AWSSecretKey")
 	}
+	if c.MessageType == "" {
+		c.MessageType = pinpoint.MessageTypeTransactional
+	}
+	if c.MessageType != pinpoint.MessageTypeTransactional &&
+		c.MessageType != pinpoint.MessageTypePromotional {
+		return nil, errors.New("invalid MessageType")
+	}
 
 	sess := session.Must(session.NewSession())
 	svc := pinpoint.New(sess,
@@ -112,42 +151,78 @@ func (s *sms) AddressDesc() string {
 	return "Please enter your mobile number"
 }
 
-// ValidateAddress "validates" a phone number.
+// ValidateAddress validates a phone number, which after sanitization,
+// should be in the E.164 format. Names of recipients written as
+// "Name <number>" are dropped.
 func (s *sms) ValidateAddress(to string) error {
-	if !reNum.MatchString(to) {
+	if !phone.IsE164(sanitizePhone(otpgateway.ParseRecipient(to).Address)) {
 		return errors.New("invalid mobile number")
 	}
 	return nil
 }
 
-// Push pushes out an SMS.
+// Push pushes out an SMS. SMSes have no subject and the subject is unused.
 func (s *sms) Push(otp models.OTP, subject string, body []byte) error {
-	var msg = string(body)
+	var (
+		msg  = string(body)
+		to   = sanitizePhone(otpgateway.ParseRecipient(otp.To).Address)
+		smsg = &pinpoint.SMSMessage{
+			Body:        &msg,
+			MessageType: &s.cfg.MessageType,
+		}
+	)
+	if s.cfg.SenderID != "" {
+		smsg.SenderId = &s.cfg.SenderID
+	}
+	if s.cfg.OriginationNumber != "" {
+		smsg.OriginationNumber = &s.cfg.OriginationNumber
+	}
 
 	payload := &pinpoint.SendMessagesInput{
 		ApplicationId: &s.cfg.AppID,
 		MessageRequest: &pinpoint.MessageRequest{
 			Addresses: map[string]*pinpoint.AddressConfiguration{
-				sanitizePhone(otp.To): &pinpoint.AddressConfiguration{
+				to: &pinpoint.AddressConfiguration{
 					ChannelType: &channelType,
 				},
 			},
 			MessageConfiguration: &pinpoint.DirectMessageConfiguration{
-				SMSMessage: &pinpoint.SMSMessage{
-					Body:        &msg,
-					MessageType: &s.cfg.MessageType,
-					SenderId:    &s.cfg.SenderID,
-				},
+				SMSMessage: smsg,
 			},
 		},
 	}
-	if _, err := s.p.SendMessages(payload); err != nil {
+	out, err := s.p.SendMessages(payload)
+	if err != nil {
 		return err
 	}
 
+	// Check the delivery status of the recipient.
+	if out.MessageResponse == nil {
+		return errors.New("empty response from pinpoint")
+	}
+	res, ok := out.MessageResponse.Result[to]
+	if !ok || res == nil {
+		return errors.New("recipient not found in pinpoint response")
+	}
+	if st := aws.StringValue(res.DeliveryStatus); st != pinpoint.DeliveryStatusSuccessful {
+		e, ok := deliveryErrors[st]
+		if !ok {
+			e = ErrUnknownFailure
+		}
+		return fmt.Errorf("%w: %s", e, aws.StringValue(res.StatusMessage))
+	}
+
 	return nil
 }
 
+// SenderIdentity returns the sender ID or the origination number.
+func (s *sms) SenderIdentity() string {
+	if s.cfg.SenderID != "" {
+		return s.cfg.SenderID
+	}
+	return s.cfg.OriginationNumber
+}
+
 // MaxAddressLen returns the maximum allowed length for the mobile number.
 func (s *sms) MaxAddressLen() int {
 	return maxAddresslen
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pinpoint"
+	"github.com/aws/aws-sdk-go/service/pinpoint/pinpointiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+// dummyClient is a mock Pinpoint client that responds to SendMessages
+// with the given delivery status.
+type dummyClient struct {
+	pinpointiface.PinpointAPI
+
+	status string
+	err    error
+	input  *pinpoint.SendMessagesInput
+}
+
+func (d *dummyClient) SendMessages(in *pinpoint.SendMessagesInput) (*pinpoint.SendMessagesOutput, error) {
+	d.input = in
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	res := map[string]*pinpoint.MessageResult{}
+	for addr := range in.MessageRequest.Addresses {
+		res[addr] = &pinpoint.MessageResult{
+			DeliveryStatus: aws.String(d.status),
+			StatusCode:     aws.Int64(200),
+			StatusMessage:  aws.String("status message"),
+		}
+	}
+	return &pinpoint.SendMessagesOutput{
+		MessageResponse: &pinpoint.MessageResponse{Result: res},
+	}, nil
+}
+
+func newTestSMS(c *dummyClient) *sms {
+	return &sms{
+		cfg: &cfg{
+			AppID:             "myapp",
+			MessageType:       pinpoint.MessageTypeTransactional,
+			SenderID:          "SENDER",
+			OriginationNumber: "+14155550000",
+		},
+		p: c,
+	}
+}
+
+var mockOTP = models.OTP{
+	To:  "+919876543210",
+	OTP: "482910",
+}
+
+func TestPush(t *testing.T) {
+	var (
+		c = &dummyClient{status: pinpoint.DeliveryStatusSuccessful}
+		s = newTestSMS(c)
+	)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	msg := c.input.MessageRequest.MessageConfiguration.SMSMessage
+	assert.Equal(t, "myapp", aws.StringValue(c.input.ApplicationId))
+	assert.Contains(t, c.input.MessageRequest.Addresses, mockOTP.To)
+	assert.Equal(t, "Your code is 482910", aws.StringValue(msg.Body))
+	assert.Equal(t, "SENDER", aws.StringValue(msg.SenderId))
+	assert.Equal(t, "+14155550000", aws.StringValue(msg.OriginationNumber))
+	assert.Equal(t, pinpoint.MessageTypeTransactional, aws.StringValue(msg.MessageType))
+}
+
+func TestPushErrors(t *testing.T) {
+	for st, e := range map[string]error{
+		pinpoint.DeliveryStatusOptOut:           ErrOptedOut,
+		pinpoint.DeliveryStatusPermanentFailure: ErrPermanentFailure,
+		pinpoint.DeliveryStatusThrottled:        ErrThrottled,
+		"SOMETHING_NEW":                         ErrUnknownFailure,
+	} {
+		s := newTestSMS(&dummyClient{status: st})
+		err := s.Push(mockOTP, "", []byte("Your code is 482910"))
+		assert.True(t, errors.Is(err, e), "error doesn't match for "+st)
+	}
+
+	// API error.
+	s := newTestSMS(&dummyClient{err: errors.New("api error")})
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+}
+
+func TestValidateAddress(t *testing.T) {
+	s := newTestSMS(&dummyClient{})
+	for _, n := range []string{"+919876543210", "+14155551234", "9876543210", "00919876543210"} {
+		assert.NoError(t, s.ValidateAddress(n), "valid number rejected: "+n)
+	}
+	for _, n := range []string{"", "12345", "+0123456789", "abcdefghij", "+91 98765 43210"} {
+		assert.Error(t, s.ValidateAddress(n), "invalid number accepted: "+n)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for st, code := range map[string]int{
+		pinpoint.DeliveryStatusOptOut:           http.StatusUnprocessableEntity,
+		pinpoint.DeliveryStatusThrottled:        http.StatusTooManyRequests,
+		pinpoint.DeliveryStatusTemporaryFailure: http.StatusBadGateway,
+		pinpoint.DeliveryStatusPermanentFailure: http.StatusInternalServerError,
+	} {
+		s := newTestSMS(&dummyClient{status: st})
+		err := s.Push(mockOTP, "", []byte("Your code is 482910"))
+		assert.Equal(t, code, otpgateway.HTTPStatusFor(err), st)
+	}
+}
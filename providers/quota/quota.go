@@ -0,0 +1,97 @@
+// Package quota implements an otpgateway.Provider that wraps another
+// Provider and enforces a hard daily limit on the number of pushes,
+// for instance, to prevent bill shock from a misbehaving client.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/internal/clock"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+// ErrQuotaExceeded is returned by Push once the day's limit is reached.
+// It wraps otpgateway.ErrRateLimited so that it's reported as a 429.
+var ErrQuotaExceeded = fmt.Errorf("daily send quota exceeded: %w", otpgateway.ErrRateLimited)
+
+// Config represents the configuration of the quota Provider.
+type Config struct {
+	// ID is the optional ID of the Provider. Defaults to the ID of
+	// the wrapped Provider so that it can be swapped in transparently.
+	ID string
+
+	// DailyLimit is the max number of pushes in a day.
+	DailyLimit int
+
+	// Location is the time zone whose midnight the quota resets at.
+	// Defaults to the local time zone.
+	Location *time.Location
+
+	// Store is the optional store of the day's counts. A store shared
+	// by all gateway instances, such as otpgateway.NewRedisCounterStore,
+	// enforces the quota across them. Defaults to an in-process store.
+	Store otpgateway.CounterStore
+}
+
+// quota wraps a Provider with a daily limit.
+type quota struct {
+	otpgateway.Provider
+
+	cfg   Config
+	clock clock.Clock
+}
+
+// Compile time check for the Provider interface.
+var _ otpgateway.Provider = (*quota)(nil)
+
+// New returns a Provider that delegates to p and fails pushes with
+// ErrQuotaExceeded once DailyLimit pushes have been attempted in a
+// day. Failed pushes count towards the limit as they may be billed.
+func New(c Config, p otpgateway.Provider) (otpgateway.Provider, error) {
+	if p == nil {
+		return nil, errors.New("invalid provider")
+	}
+	if c.DailyLimit < 1 {
+		return nil, errors.New("DailyLimit should be >= 1")
+	}
+	if c.ID == "" {
+		c.ID = p.ID()
+	}
+	if c.Location == nil {
+		c.Location = time.Local
+	}
+	if c.Store == nil {
+		c.Store = otpgateway.NewMemCounterStore()
+	}
+	return &quota{Provider: p, cfg: c, clock: clock.Real}, nil
+}
+
+// ID returns the Provider's ID.
+func (q *quota) ID() string {
+	return q.cfg.ID
+}
+
+// Push pushes the message via the wrapped Provider if the day's quota
+// isn't exhausted.
+func (q *quota) Push(otp models.OTP, subject string, body []byte) error {
+	var (
+		now     = q.clock.Now().In(q.cfg.Location)
+		y, m, d = now.Date()
+		next    = time.Date(y, m, d+1, 0, 0, 0, 0, q.cfg.Location)
+		key     = "quota:" + q.cfg.ID + ":" + now.Format("2006-01-02")
+	)
+
+	// Keep the count for a while past midnight so that instances with
+	// slightly skewed clocks see the same count.
+	n, err := q.cfg.Store.Incr(key, next.Sub(now)+time.Hour)
+	if err != nil {
+		return fmt.Errorf("error checking send quota: %w", err)
+	}
+	if n > q.cfg.DailyLimit {
+		return ErrQuotaExceeded
+	}
+	return q.Provider.Push(otp, subject, body)
+}
@@ -0,0 +1,115 @@
+package quota
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/internal/clock"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+type dummyProv struct {
+	id     string
+	pushes int
+}
+
+func (d *dummyProv) ID() string                      { return d.id }
+func (d *dummyProv) ChannelName() string             { return "dummychannel" }
+func (d *dummyProv) ChannelDesc() string             { return "dummy channel description" }
+func (d *dummyProv) AddressName() string             { return "dummyaddress" }
+func (d *dummyProv) AddressDesc() string             { return "dummy address description" }
+func (d *dummyProv) ValidateAddress(to string) error { return nil }
+func (d *dummyProv) SenderIdentity() string          { return "dummysender" }
+func (d *dummyProv) MaxAddressLen() int              { return 10 }
+func (d *dummyProv) MaxOTPLen() int                  { return 6 }
+func (d *dummyProv) MaxBodyLen() int                 { return 140 }
+func (d *dummyProv) Push(otp models.OTP, subject string, body []byte) error {
+	d.pushes++
+	return nil
+}
+
+func newQuota(t *testing.T, limit int, s otpgateway.CounterStore, now time.Time) (*quota, *dummyProv, *clock.Fake) {
+	d := &dummyProv{id: "dummy"}
+	p, err := New(Config{DailyLimit: limit, Location: now.Location(), Store: s}, d)
+	assert.NoError(t, err)
+
+	clk := clock.NewFake(now)
+	q := p.(*quota)
+	q.clock = clk
+	return q, d, clk
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{}, &dummyProv{})
+	assert.Error(t, err, "zero DailyLimit accepted")
+	_, err = New(Config{DailyLimit: 1}, nil)
+	assert.Error(t, err, "nil provider accepted")
+
+	p, err := New(Config{DailyLimit: 1}, &dummyProv{id: "dummy"})
+	assert.NoError(t, err)
+	assert.Equal(t, "dummy", p.ID(), "ID not defaulted to the wrapped provider's")
+	assert.Equal(t, "dummysender", p.SenderIdentity())
+}
+
+func TestUnderLimit(t *testing.T) {
+	q, d, _ := newQuota(t, 3, nil, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, q.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, 2, d.pushes)
+}
+
+func TestAtLimit(t *testing.T) {
+	q, d, _ := newQuota(t, 3, nil, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, q.Push(models.OTP{}, "", nil))
+	}
+	assert.Equal(t, ErrQuotaExceeded, q.Push(models.OTP{}, "", nil))
+	assert.Equal(t, ErrQuotaExceeded, q.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 3, d.pushes, "push went through over the quota")
+	assert.Equal(t, http.StatusTooManyRequests, otpgateway.HTTPStatusFor(ErrQuotaExceeded))
+}
+
+func TestResetAtMidnight(t *testing.T) {
+	loc := time.FixedZone("UTC+5:30", 5*3600+1800)
+	q, d, clk := newQuota(t, 1, nil, time.Date(2020, 1, 1, 23, 50, 0, 0, loc))
+
+	assert.NoError(t, q.Push(models.OTP{}, "", nil))
+	assert.Equal(t, ErrQuotaExceeded, q.Push(models.OTP{}, "", nil))
+
+	// Midnight in UTC isn't midnight in the configured location.
+	clk.Advance(5 * time.Minute)
+	assert.Equal(t, ErrQuotaExceeded, q.Push(models.OTP{}, "", nil))
+
+	clk.Advance(5 * time.Minute)
+	assert.NoError(t, q.Push(models.OTP{}, "", nil), "quota didn't reset at midnight")
+	assert.Equal(t, ErrQuotaExceeded, q.Push(models.OTP{}, "", nil))
+	assert.Equal(t, 2, d.pushes)
+}
+
+func TestRedisStore(t *testing.T) {
+	rd, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer rd.Close()
+
+	port, _ := strconv.Atoi(rd.Port())
+	conf := otpgateway.RedisConf{Host: rd.Host(), Port: port, KeyPrefix: "test"}
+
+	// Two instances sharing the store share the quota.
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	a, _, _ := newQuota(t, 2, otpgateway.NewRedisCounterStore(conf), now)
+	b, _, _ := newQuota(t, 2, otpgateway.NewRedisCounterStore(conf), now)
+
+	assert.NoError(t, a.Push(models.OTP{}, "", nil))
+	assert.NoError(t, b.Push(models.OTP{}, "", nil))
+	assert.Equal(t, ErrQuotaExceeded, a.Push(models.OTP{}, "", nil))
+	assert.Equal(t, ErrQuotaExceeded, b.Push(models.OTP{}, "", nil))
+
+	assert.True(t, rd.Exists("test:counter:quota:dummy:2020-01-01"))
+	assert.True(t, rd.TTL("test:counter:quota:dummy:2020-01-01") > 14*time.Hour)
+}
@@ -0,0 +1,214 @@
+// Package router implements a composite otpgateway.Provider that routes
+// each push to a Provider by the destination number's country, for
+// instance, to send Indian numbers via a local gateway and everything
+// else via an international one.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+	"github.com/zplzpl/otpgateway/phone/numplan"
+)
+
+const providerID = "router"
+
+// ErrUnroutable is returned when a number doesn't match any country
+// route and there's no default Provider.
+var ErrUnroutable = fmt.Errorf("%w: no provider to route the number to", otpgateway.ErrInvalidAddress)
+
+// Config represents the configuration of the router Provider.
+type Config struct {
+	// ID is the optional ID of the Provider. Defaults to "router".
+	ID string
+
+	// Routes maps countries (ISO 3166-1 alpha-2 codes, eg: IN) to
+	// Providers.
+	Routes map[string]otpgateway.Provider
+
+	// Default is the optional Provider numbers that don't match any
+	// route are sent via.
+	Default otpgateway.Provider
+}
+
+type router struct {
+	cfg Config
+
+	// all is the list of unique Providers, the default first followed
+	// by the routes sorted by country.
+	all []otpgateway.Provider
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider       = (*router)(nil)
+	_ otpgateway.ResultPusher   = (*router)(nil)
+	_ otpgateway.SenderResolver = (*router)(nil)
+)
+
+// New returns a Provider that routes each Push by the country of the
+// normalized (E.164) destination number.
+func New(c Config) (otpgateway.Provider, error) {
+	if len(c.Routes) == 0 && c.Default == nil {
+		return nil, errors.New("no routes or default provider")
+	}
+	if c.ID == "" {
+		c.ID = providerID
+	}
+
+	var (
+		r     = &router{cfg: Config{ID: c.ID, Default: c.Default, Routes: make(map[string]otpgateway.Provider, len(c.Routes))}}
+		keys  = make([]string, 0, len(c.Routes))
+		added = make(map[otpgateway.Provider]bool)
+	)
+	for cn, p := range c.Routes {
+		if p == nil {
+			return nil, errors.New("invalid provider for " + cn)
+		}
+		cn = strings.ToUpper(cn)
+		r.cfg.Routes[cn] = p
+		keys = append(keys, cn)
+	}
+	sort.Strings(keys)
+
+	if c.Default != nil {
+		r.all = append(r.all, c.Default)
+		added[c.Default] = true
+	}
+	for _, k := range keys {
+		if p := r.cfg.Routes[k]; !added[p] {
+			r.all = append(r.all, p)
+			added[p] = true
+		}
+	}
+	return r, nil
+}
+
+// resolve returns the Provider for a number. Numbers whose route is
+// disabled (see otpgateway.Toggler) are routed to the default Provider.
+func (r *router) resolve(to string) (otpgateway.Provider, error) {
+	if n, err := phone.Normalize(to); err == nil {
+		if p, ok := r.cfg.Routes[numplan.Region(n)]; ok {
+			if otpgateway.IsEnabled(p) || r.cfg.Default == nil {
+				return p, nil
+			}
+		}
+	}
+	if r.cfg.Default == nil {
+		return nil, ErrUnroutable
+	}
+	return r.cfg.Default, nil
+}
+
+// ID returns the Provider's ID.
+func (r *router) ID() string {
+	return r.cfg.ID
+}
+
+// ChannelName returns the channel name of the default (or first) Provider.
+func (r *router) ChannelName() string {
+	return r.all[0].ChannelName()
+}
+
+// ChannelDesc returns the channel help text of the default (or first) Provider.
+func (r *router) ChannelDesc() string {
+	return r.all[0].ChannelDesc()
+}
+
+// AddressName returns the address name of the default (or first) Provider.
+func (r *router) AddressName() string {
+	return r.all[0].AddressName()
+}
+
+// AddressDesc returns the address help text of the default (or first) Provider.
+func (r *router) AddressDesc() string {
+	return r.all[0].AddressDesc()
+}
+
+// SenderIdentity returns the sender identity of the default (or first) Provider.
+func (r *router) SenderIdentity() string {
+	return r.all[0].SenderIdentity()
+}
+
+// SenderFor returns the sender identity of the Provider the number
+// is routed to.
+func (r *router) SenderFor(to string) string {
+	p, err := r.resolve(to)
+	if err != nil {
+		return ""
+	}
+	if s, ok := p.(otpgateway.SenderResolver); ok {
+		return s.SenderFor(to)
+	}
+	return p.SenderIdentity()
+}
+
+// ValidateAddress validates the address with the Provider it's routed to.
+func (r *router) ValidateAddress(to string) error {
+	p, err := r.resolve(to)
+	if err != nil {
+		return err
+	}
+	return p.ValidateAddress(to)
+}
+
+// Push pushes the message via the Provider the destination is routed to.
+func (r *router) Push(otp models.OTP, subject string, body []byte) error {
+	p, err := r.resolve(otp.To)
+	if err != nil {
+		return err
+	}
+	return p.Push(otp, subject, body)
+}
+
+// PushContext pushes the message via the Provider the destination is
+// routed to and returns its result if it's a ResultPusher.
+func (r *router) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	p, err := r.resolve(otp.To)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	if rp, ok := p.(otpgateway.ResultPusher); ok {
+		return rp.PushContext(ctx, otp, subject, body)
+	}
+	return otpgateway.PushResult{}, p.Push(otp, subject, body)
+}
+
+// MaxAddressLen returns the smallest max address length of all Providers.
+func (r *router) MaxAddressLen() int {
+	n := r.all[0].MaxAddressLen()
+	for _, p := range r.all[1:] {
+		if v := p.MaxAddressLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+// MaxOTPLen returns the smallest max OTP length of all Providers.
+func (r *router) MaxOTPLen() int {
+	n := r.all[0].MaxOTPLen()
+	for _, p := range r.all[1:] {
+		if v := p.MaxOTPLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+// MaxBodyLen returns the smallest max body length of all Providers.
+func (r *router) MaxBodyLen() int {
+	n := r.all[0].MaxBodyLen()
+	for _, p := range r.all[1:] {
+		if v := p.MaxBodyLen(); v < n {
+			n = v
+		}
+	}
+	return n
+}
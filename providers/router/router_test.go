@@ -0,0 +1,116 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+type dummyProv struct {
+	otpgateway.Toggle
+
+	id     string
+	pushes []string
+}
+
+func (d *dummyProv) ID() string                      { return d.id }
+func (d *dummyProv) ChannelName() string             { return "dummychannel" }
+func (d *dummyProv) ChannelDesc() string             { return "dummy channel description" }
+func (d *dummyProv) AddressName() string             { return "dummyaddress" }
+func (d *dummyProv) AddressDesc() string             { return "dummy address description" }
+func (d *dummyProv) SenderIdentity() string          { return d.id + "sender" }
+func (d *dummyProv) ValidateAddress(to string) error { return nil }
+func (d *dummyProv) MaxAddressLen() int              { return 16 }
+func (d *dummyProv) MaxOTPLen() int                  { return 6 }
+func (d *dummyProv) MaxBodyLen() int                 { return 140 }
+
+func (d *dummyProv) Push(otp models.OTP, subject string, body []byte) error {
+	if !d.Enabled() {
+		return otpgateway.ErrProviderDisabled
+	}
+	d.pushes = append(d.pushes, otp.To)
+	return nil
+}
+
+func TestRouting(t *testing.T) {
+	var (
+		in  = &dummyProv{id: "msg91"}
+		def = &dummyProv{id: "kaleyra"}
+	)
+	p, err := New(Config{
+		Routes:  map[string]otpgateway.Provider{"in": in},
+		Default: def,
+	})
+	assert.NoError(t, err)
+
+	// Matched country.
+	assert.NoError(t, p.Push(models.OTP{To: "+91 98765 43210"}, "", nil))
+	assert.Equal(t, []string{"+91 98765 43210"}, in.pushes)
+
+	// Default fallback.
+	assert.NoError(t, p.Push(models.OTP{To: "+447400123456"}, "", nil))
+	assert.NoError(t, p.Push(models.OTP{To: "invalid"}, "", nil))
+	assert.Equal(t, []string{"+447400123456", "invalid"}, def.pushes)
+
+	assert.Equal(t, "kaleyrasender", p.SenderIdentity())
+	assert.Equal(t, "msg91sender", p.(otpgateway.SenderResolver).SenderFor("+919876543210"))
+}
+
+func TestDisabledRoute(t *testing.T) {
+	var (
+		in  = &dummyProv{id: "msg91"}
+		def = &dummyProv{id: "kaleyra"}
+	)
+	p, err := New(Config{
+		Routes:  map[string]otpgateway.Provider{"IN": in},
+		Default: def,
+	})
+	assert.NoError(t, err)
+
+	// Numbers of a disabled route are sent via the default.
+	in.SetEnabled(false)
+	assert.NoError(t, p.Push(models.OTP{To: "+919876543210"}, "", nil))
+	assert.Empty(t, in.pushes)
+	assert.Equal(t, []string{"+919876543210"}, def.pushes)
+
+	in.SetEnabled(true)
+	assert.NoError(t, p.Push(models.OTP{To: "+919876543210"}, "", nil))
+	assert.Equal(t, []string{"+919876543210"}, in.pushes)
+
+	// Without a default, the disabled route fails.
+	p, err = New(Config{Routes: map[string]otpgateway.Provider{"IN": in}})
+	assert.NoError(t, err)
+	in.SetEnabled(false)
+	assert.Equal(t, otpgateway.ErrProviderDisabled, p.Push(models.OTP{To: "+919876543210"}, "", nil))
+}
+
+func TestUnroutable(t *testing.T) {
+	in := &dummyProv{id: "msg91"}
+	p, err := New(Config{Routes: map[string]otpgateway.Provider{"IN": in}})
+	assert.NoError(t, err)
+
+	assert.True(t, errors.Is(p.Push(models.OTP{To: "+447400123456"}, "", nil), ErrUnroutable))
+	assert.Equal(t, ErrUnroutable, p.ValidateAddress("+447400123456"))
+	assert.NoError(t, p.ValidateAddress("+919876543210"))
+	assert.Empty(t, in.pushes)
+	assert.Equal(t, "msg91", p.(*router).all[0].ID())
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err, "empty config accepted")
+
+	_, err = New(Config{Routes: map[string]otpgateway.Provider{"IN": nil}})
+	assert.Error(t, err, "nil provider accepted")
+}
+
+func TestUnroutableHTTPStatus(t *testing.T) {
+	p, err := New(Config{Routes: map[string]otpgateway.Provider{"IN": &dummyProv{id: "msg91"}}})
+	assert.NoError(t, err)
+	err = p.Push(models.OTP{To: "+447400123456"}, "", nil)
+	assert.Equal(t, http.StatusBadRequest, otpgateway.HTTPStatusFor(err))
+}
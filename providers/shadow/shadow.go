@@ -0,0 +1,198 @@
+// Package shadow implements a composite otpgateway.Provider that sends
+// every push via a primary Provider and, in the background, duplicates
+// it to a shadow Provider, for instance, to compare the deliverability
+// of a new carrier with the current one before cutting over.
+//
+// Recipients receive the shadow messages too unless the shadow Provider
+// only sends to test numbers or runs in a sandbox mode.
+package shadow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+const (
+	defaultTimeout     = time.Second * 30
+	defaultMaxInFlight = 100
+)
+
+// Discrepancy is the outcome of a push that succeeded on one of the
+// primary and shadow Providers and failed on the other.
+type Discrepancy struct {
+	To string
+
+	Primary    otpgateway.PushResult
+	PrimaryErr error
+
+	Shadow    otpgateway.PushResult
+	ShadowErr error
+}
+
+// Config represents the configuration of the shadow Provider.
+type Config struct {
+	// ID is the optional ID of the Provider. Defaults to the ID of
+	// the primary Provider so that it can be swapped in transparently.
+	ID string
+
+	// OnDiscrepancy is the optional hook that's called with the outcome
+	// of pushes that succeeded on only one of the Providers. It's called
+	// from the background goroutine of the shadow push.
+	OnDiscrepancy func(Discrepancy)
+
+	// Timeout is the max duration of a shadow push. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// MaxInFlight is the max number of concurrent shadow pushes beyond
+	// which shadow pushes are skipped. Defaults to 100.
+	MaxInFlight int
+}
+
+// shadow pushes via a primary Provider and duplicates pushes to a
+// shadow Provider.
+type shadow struct {
+	otpgateway.Provider
+
+	cfg    Config
+	shadow otpgateway.Provider
+
+	// sem limits the shadow pushes in flight.
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+// outcome is the result of a push.
+type outcome struct {
+	res otpgateway.PushResult
+	err error
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*shadow)(nil)
+	_ otpgateway.ResultPusher = (*shadow)(nil)
+	_ io.Closer               = (*shadow)(nil)
+)
+
+// New returns a Provider that pushes via primary and returns its result
+// while asynchronously pushing the same message via shadowProv. The
+// shadow push never blocks or fails the primary push.
+func New(c Config, primary, shadowProv otpgateway.Provider) (otpgateway.Provider, error) {
+	if primary == nil || shadowProv == nil {
+		return nil, errors.New("invalid provider")
+	}
+	if c.Timeout < 0 || c.MaxInFlight < 0 {
+		return nil, errors.New("Timeout and MaxInFlight should be >= 0")
+	}
+	if c.ID == "" {
+		c.ID = primary.ID()
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = defaultMaxInFlight
+	}
+	return &shadow{
+		Provider: primary,
+		cfg:      c,
+		shadow:   shadowProv,
+		sem:      make(chan struct{}, c.MaxInFlight),
+	}, nil
+}
+
+// ID returns the Provider's ID.
+func (s *shadow) ID() string {
+	return s.cfg.ID
+}
+
+// Push pushes the message via the primary Provider.
+func (s *shadow) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := s.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext pushes the message via the primary Provider and returns
+// its result. The message is pushed via the shadow Provider at the same
+// time unless MaxInFlight shadow pushes are already in flight.
+func (s *shadow) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (res otpgateway.PushResult, err error) {
+	select {
+	case s.sem <- struct{}{}:
+		primary := make(chan outcome, 1)
+		s.wg.Add(1)
+		go s.pushShadow(otp, subject, body, primary)
+
+		// The shadow push waits for the primary's outcome, which has to
+		// be sent even if the primary Provider panics.
+		defer func() {
+			if r := recover(); r != nil {
+				primary <- outcome{err: fmt.Errorf("primary provider panicked: %v", r)}
+				panic(r)
+			}
+			primary <- outcome{res: res, err: err}
+		}()
+	default:
+	}
+
+	return push(ctx, s.Provider, otp, subject, body)
+}
+
+// pushShadow pushes the message via the shadow Provider and compares the
+// outcome with the primary's once it's received on primary.
+func (s *shadow) pushShadow(otp models.OTP, subject string, body []byte, primary <-chan outcome) {
+	defer func() {
+		<-s.sem
+		s.wg.Done()
+	}()
+
+	// The shadow push isn't bound to the caller's context as the
+	// caller may return as soon as the primary push is done.
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+
+	var sh outcome
+	func() {
+		// A panicking shadow Provider mustn't take down the gateway.
+		defer func() {
+			if r := recover(); r != nil {
+				sh.err = fmt.Errorf("shadow provider panicked: %v", r)
+			}
+		}()
+		sh.res, sh.err = push(ctx, s.shadow, otp, subject, body)
+	}()
+
+	pr := <-primary
+	if (pr.err == nil) == (sh.err == nil) || s.cfg.OnDiscrepancy == nil {
+		return
+	}
+	s.cfg.OnDiscrepancy(Discrepancy{
+		To:         otp.To,
+		Primary:    pr.res,
+		PrimaryErr: pr.err,
+		Shadow:     sh.res,
+		ShadowErr:  sh.err,
+	})
+}
+
+// Close waits for the shadow pushes in flight to finish. It doesn't
+// close the primary and shadow Providers.
+func (s *shadow) Close() error {
+	s.wg.Wait()
+	return nil
+}
+
+// push pushes a message via p and returns its result if it's a
+// ResultPusher.
+func push(ctx context.Context, p otpgateway.Provider, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	if rp, ok := p.(otpgateway.ResultPusher); ok {
+		return rp.PushContext(ctx, otp, subject, body)
+	}
+	return otpgateway.PushResult{}, p.Push(otp, subject, body)
+}
@@ -0,0 +1,201 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+type dummyProv struct {
+	id    string
+	err   error
+	block chan struct{}
+	panic bool
+
+	mu     sync.Mutex
+	pushes []string
+}
+
+func (d *dummyProv) ID() string                      { return d.id }
+func (d *dummyProv) ChannelName() string             { return "dummychannel" }
+func (d *dummyProv) ChannelDesc() string             { return "dummy channel description" }
+func (d *dummyProv) AddressName() string             { return "dummyaddress" }
+func (d *dummyProv) AddressDesc() string             { return "dummy address description" }
+func (d *dummyProv) SenderIdentity() string          { return d.id + "sender" }
+func (d *dummyProv) ValidateAddress(to string) error { return nil }
+func (d *dummyProv) MaxAddressLen() int              { return 16 }
+func (d *dummyProv) MaxOTPLen() int                  { return 6 }
+func (d *dummyProv) MaxBodyLen() int                 { return 140 }
+
+func (d *dummyProv) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := d.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+func (d *dummyProv) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	if d.block != nil {
+		<-d.block
+	}
+	if d.panic {
+		panic("boom")
+	}
+	d.mu.Lock()
+	d.pushes = append(d.pushes, otp.To)
+	d.mu.Unlock()
+	if d.err != nil {
+		return otpgateway.PushResult{}, d.err
+	}
+	return otpgateway.PushResult{ID: d.id + "-msg", Status: "sent"}, nil
+}
+
+func (d *dummyProv) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pushes)
+}
+
+// recorder records the discrepancies reported by the hook.
+type recorder struct {
+	mu sync.Mutex
+	d  []Discrepancy
+}
+
+func (r *recorder) record(d Discrepancy) {
+	r.mu.Lock()
+	r.d = append(r.d, d)
+	r.mu.Unlock()
+}
+
+func newShadow(t *testing.T, c Config, primary, sh *dummyProv) (*shadow, *recorder) {
+	r := &recorder{}
+	c.OnDiscrepancy = r.record
+	p, err := New(c, primary, sh)
+	assert.NoError(t, err)
+	return p.(*shadow), r
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{}, nil, &dummyProv{})
+	assert.Error(t, err, "nil primary accepted")
+	_, err = New(Config{}, &dummyProv{}, nil)
+	assert.Error(t, err, "nil shadow accepted")
+	_, err = New(Config{MaxInFlight: -1}, &dummyProv{}, &dummyProv{})
+	assert.Error(t, err, "negative MaxInFlight accepted")
+
+	p, err := New(Config{}, &dummyProv{id: "primary"}, &dummyProv{id: "new"})
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", p.ID(), "ID not defaulted to the primary's")
+	assert.Equal(t, "primarysender", p.SenderIdentity())
+}
+
+func TestPrimaryResult(t *testing.T) {
+	otp := models.OTP{To: "+919876543210"}
+	for _, tc := range []struct {
+		name       string
+		primaryErr error
+		shadowErr  error
+		mismatch   bool
+	}{
+		{"both succeed", nil, nil, false},
+		{"shadow fails", nil, errors.New("shadow down"), true},
+		{"primary fails", errors.New("primary down"), nil, true},
+		{"both fail", errors.New("primary down"), errors.New("shadow down"), false},
+	} {
+		var (
+			pr   = &dummyProv{id: "primary", err: tc.primaryErr}
+			sh   = &dummyProv{id: "new", err: tc.shadowErr}
+			s, r = newShadow(t, Config{}, pr, sh)
+		)
+
+		res, err := s.PushContext(context.Background(), otp, "", []byte("Your code is 123456"))
+		assert.Equal(t, tc.primaryErr, err, tc.name)
+		if tc.primaryErr == nil {
+			assert.Equal(t, "primary-msg", res.ID, tc.name)
+		}
+		assert.Equal(t, tc.primaryErr, s.Push(otp, "", nil), tc.name)
+
+		assert.NoError(t, s.Close())
+		assert.Equal(t, 2, sh.count(), "%s: shadow not invoked", tc.name)
+		if !tc.mismatch {
+			assert.Empty(t, r.d, tc.name)
+			continue
+		}
+		if assert.Len(t, r.d, 2, tc.name) {
+			d := r.d[0]
+			assert.Equal(t, otp.To, d.To)
+			assert.Equal(t, tc.primaryErr, d.PrimaryErr)
+			assert.Equal(t, tc.shadowErr, d.ShadowErr)
+			if tc.shadowErr == nil {
+				assert.Equal(t, "new-msg", d.Shadow.ID)
+			} else {
+				assert.Equal(t, "primary-msg", d.Primary.ID)
+			}
+		}
+	}
+}
+
+func TestShadowDoesntBlock(t *testing.T) {
+	var (
+		pr   = &dummyProv{id: "primary"}
+		sh   = &dummyProv{id: "new", block: make(chan struct{})}
+		s, _ = newShadow(t, Config{MaxInFlight: 1}, pr, sh)
+	)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Push(models.OTP{To: "+919876543210"}, "", nil)
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("primary push blocked by the shadow push")
+	}
+
+	// With MaxInFlight shadow pushes in flight, pushes skip the shadow.
+	assert.NoError(t, s.Push(models.OTP{To: "+919876543210"}, "", nil))
+	close(sh.block)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 2, pr.count())
+	assert.Equal(t, 1, sh.count())
+}
+
+func TestShadowPanic(t *testing.T) {
+	var (
+		pr   = &dummyProv{id: "primary"}
+		sh   = &dummyProv{id: "new", panic: true}
+		s, r = newShadow(t, Config{}, pr, sh)
+	)
+	assert.NoError(t, s.Push(models.OTP{To: "+919876543210"}, "", nil))
+	assert.NoError(t, s.Close())
+	if assert.Len(t, r.d, 1) {
+		assert.Contains(t, r.d[0].ShadowErr.Error(), "panicked")
+	}
+}
+
+func TestPrimaryPanic(t *testing.T) {
+	var (
+		pr   = &dummyProv{id: "primary", panic: true}
+		sh   = &dummyProv{id: "new"}
+		s, r = newShadow(t, Config{MaxInFlight: 1}, pr, sh)
+	)
+
+	// The panic reaches the caller and the shadow push still completes
+	// and frees its slot.
+	for i := 0; i < 2; i++ {
+		assert.Panics(t, func() {
+			s.Push(models.OTP{To: "+919876543210"}, "", nil)
+		})
+		assert.NoError(t, s.Close())
+	}
+	assert.Equal(t, 2, sh.count(), "shadow pushes stopped after a primary panic")
+	if assert.Len(t, r.d, 2) {
+		assert.Contains(t, r.d[0].PrimaryErr.Error(), "panicked")
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/mail"
 	"net/smtp"
 	"regexp"
+	"text/template"
 	"time"
 
 	"github.com/jordan-wright/email"
+	"github.com/zplzpl/otpgateway"
 	"github.com/zplzpl/otpgateway/models"
 )
 
@@ -34,14 +38,47 @@ type cfg struct {
 	FromEmail    string `json:"FromEmail"`
 	SendTimeout  int    `json:"SendTimeout"`
 	MaxConns     int    `json:"MaxConns"`
+
+	// SubjectTemplate is an optional Go template for the e-mail subject.
+	// It overrides the subject passed to Push, which is available in the
+	// template as {{ .Subject }}, along with {{ .OTP }}, {{ .To }},
+	// and {{ .Namespace }}.
+	SubjectTemplate string `json:"SubjectTemplate"`
+
+	// DefaultSubject is the subject used when the subject passed
+	// to Push is empty.
+	DefaultSubject string `json:"DefaultSubject"`
+
+	// DisplayName is the optional human readable sender name shown
+	// along with the FromEmail, eg: "My App" <otp@myapp.com>.
+	DisplayName string `json:"DisplayName"`
+
+	// IgnoreRecipientName sends e-mails to the bare address of
+	// recipients written with a name, eg: John Doe <john@doe.com>,
+	// which are otherwise addressed with the name.
+	IgnoreRecipientName bool `json:"IgnoreRecipientName"`
+}
+
+// subjectData is the data passed to the subject template.
+type subjectData struct {
+	otpgateway.TemplateContext
+
+	Subject string
 }
 
 type emailer struct {
 	cfg     cfg
 	timeout time.Duration
 	mailer  *email.Pool
+	subject *template.Template
 }
 
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider  = (*emailer)(nil)
+	_ otpgateway.Previewer = (*emailer)(nil)
+)
+
 // New creates and returns an e-mail Provider backend.
 func New(jsonCfg []byte) (interface{}, error) {
 	var c cfg
@@ -70,6 +107,16 @@ func New(jsonCfg []byte) (interface{}, error) {
 		auth = smtp.PlainAuth("", c.User, c.Password, c.Host)
 	}
 
+	// Compile the optional subject template.
+	var subj *template.Template
+	if c.SubjectTemplate != "" {
+		t, err := template.New("subject").Parse(c.SubjectTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SubjectTemplate: %v", err)
+		}
+		subj = t
+	}
+
 	pool, err := email.NewPool(fmt.Sprintf("%s:%d", c.Host, c.Port), c.MaxConns, auth)
 	if err != nil {
 		return nil, err
@@ -85,6 +132,7 @@ func New(jsonCfg []byte) (interface{}, error) {
 		mailer:  pool,
 		cfg:     c,
 		timeout: time.Second * time.Duration(t),
+		subject: subj,
 	}, nil
 }
 
@@ -118,22 +166,101 @@ func (e *emailer) AddressDesc() string {
 	return `Please enter the e-mail ID you want to verify`
 }
 
-// ValidateAddress "validates" an e-mail address.
+// ValidateAddress "validates" an e-mail address, which may have a name,
+// eg: John Doe <john@doe.com>.
 func (e *emailer) ValidateAddress(to string) error {
-	if !reMail.MatchString(to) {
-		return errors.New("invalid e-mail address")
+	_, err := e.recipient(to)
+	return err
+}
+
+// recipient returns the address e-mails to the given OTP recipient are
+// sent to.
+func (e *emailer) recipient(to string) (string, error) {
+	if reMail.MatchString(to) {
+		return to, nil
+	}
+
+	a, err := mail.ParseAddress(to)
+	if err != nil || !reMail.MatchString(a.Address) {
+		return "", errors.New("invalid e-mail address")
+	}
+	if a.Name == "" || e.cfg.IgnoreRecipientName {
+		return a.Address, nil
 	}
-	return nil
+	return a.String(), nil
 }
 
 // Push pushes an e-mail to the SMTP server.
 func (e *emailer) Push(otp models.OTP, subject string, m []byte) error {
-	return e.mailer.Send(&email.Email{
-		From:    e.cfg.FromEmail,
-		To:      []string{otp.To},
-		Subject: subject,
+	msg, err := e.makeEmail(otp, subject, m)
+	if err != nil {
+		return err
+	}
+	return e.mailer.Send(msg, e.timeout)
+}
+
+// makeEmail composes the e-mail for an OTP.
+func (e *emailer) makeEmail(otp models.OTP, subject string, m []byte) (*email.Email, error) {
+	to, err := e.recipient(otp.To)
+	if err != nil {
+		return nil, err
+	}
+	subj, err := e.makeSubject(otp, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	from := e.cfg.FromEmail
+	if e.cfg.DisplayName != "" {
+		from = (&mail.Address{Name: e.cfg.DisplayName, Address: e.cfg.FromEmail}).String()
+	}
+	return &email.Email{
+		From:    from,
+		To:      []string{to},
+		Subject: subj,
 		HTML:    m,
-	}, e.timeout)
+	}, nil
+}
+
+// Preview renders the e-mail exactly as Push would send it without
+// sending it.
+func (e *emailer) Preview(otp models.OTP, subject string, m []byte) (otpgateway.PreviewResult, error) {
+	msg, err := e.makeEmail(otp, subject, m)
+	if err != nil {
+		return otpgateway.PreviewResult{}, err
+	}
+	return otpgateway.PreviewResult{
+		To:      msg.To[0],
+		Sender:  msg.From,
+		Subject: msg.Subject,
+		Body:    string(msg.HTML),
+	}, nil
+}
+
+// makeSubject renders the subject template, if there's one, or returns
+// the given subject as is. An empty subject is replaced with the
+// DefaultSubject.
+func (e *emailer) makeSubject(otp models.OTP, subject string) (string, error) {
+	if subject == "" {
+		subject = e.cfg.DefaultSubject
+	}
+	if e.subject == nil {
+		return subject, nil
+	}
+
+	var b bytes.Buffer
+	if err := e.subject.Execute(&b, subjectData{
+		TemplateContext: otpgateway.NewTemplateContext(otp, e.SenderIdentity()),
+		Subject:         subject,
+	}); err != nil {
+		return "", fmt.Errorf("error rendering subject: %v", err)
+	}
+	return b.String(), nil
+}
+
+// SenderIdentity returns the From e-mail address.
+func (e *emailer) SenderIdentity() string {
+	return e.cfg.FromEmail
 }
 
 // MaxAddressLen returns the maximum allowed length of the e-mail address.
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	To:        "john@doe.com",
+	OTP:       "482910",
+}
+
+func newEmailer(t *testing.T, cfg string) *emailer {
+	p, err := New([]byte(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*emailer)
+}
+
+func TestSubjectTemplate(t *testing.T) {
+	e := newEmailer(t, `{"SubjectTemplate": "Your code: {{ .OTP }}"}`)
+	s, err := e.makeSubject(mockOTP, "Verification")
+	assert.NoError(t, err)
+	assert.Equal(t, "Your code: 482910", s, "subject doesn't match")
+
+	// Without the OTP variable.
+	e = newEmailer(t, `{"SubjectTemplate": "{{ .Namespace }}: {{ .Subject }}"}`)
+	s, err = e.makeSubject(mockOTP, "Verification")
+	assert.NoError(t, err)
+	assert.Equal(t, "myapp: Verification", s, "subject doesn't match")
+	assert.NotContains(t, s, mockOTP.OTP)
+}
+
+func TestSubjectTemplateContext(t *testing.T) {
+	e := newEmailer(t, `{"SubjectTemplate": "{{ .Extra.name }}, your {{ .Namespace }} code from {{ .Sender }}"}`)
+	o := mockOTP
+	o.Extra = []byte(`{"name": "John"}`)
+	s, err := e.makeSubject(o, "Verification")
+	assert.NoError(t, err)
+	assert.Equal(t, "John, your myapp code from "+e.SenderIdentity(), s)
+}
+
+func TestSubjectNoTemplate(t *testing.T) {
+	e := newEmailer(t, `{}`)
+	s, err := e.makeSubject(mockOTP, "Verification")
+	assert.NoError(t, err)
+	assert.Equal(t, "Verification", s, "subject doesn't match")
+}
+
+func TestSubjectTemplateInvalid(t *testing.T) {
+	_, err := New([]byte(`{"SubjectTemplate": "{{ .OTP "}`))
+	assert.Error(t, err, "invalid template accepted")
+}
+
+func TestDefaultSubject(t *testing.T) {
+	e := newEmailer(t, `{"DefaultSubject": "Your verification code"}`)
+	s, err := e.makeSubject(mockOTP, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Your verification code", s)
+
+	// The caller's subject takes precedence.
+	s, err = e.makeSubject(mockOTP, "Verification")
+	assert.NoError(t, err)
+	assert.Equal(t, "Verification", s)
+
+	// The default is available in the template.
+	e = newEmailer(t, `{"DefaultSubject": "Verification", "SubjectTemplate": "{{ .Namespace }}: {{ .Subject }}"}`)
+	s, err = e.makeSubject(mockOTP, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "myapp: Verification", s)
+}
+
+func TestDisplayName(t *testing.T) {
+	e := newEmailer(t, `{"FromEmail": "otp@myapp.com", "DisplayName": "My App"}`)
+	m, err := e.makeEmail(mockOTP, "Verification", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, `"My App" <otp@myapp.com>`, m.From)
+
+	b, err := m.Bytes()
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "From: \"My App\" <otp@myapp.com>\r\n")
+
+	// Without a display name.
+	e = newEmailer(t, `{"FromEmail": "otp@myapp.com"}`)
+	m, err = e.makeEmail(mockOTP, "Verification", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "otp@myapp.com", m.From)
+}
+
+func TestPreview(t *testing.T) {
+	e := newEmailer(t, `{"FromEmail": "otp@myapp.com", "DisplayName": "My App", "DefaultSubject": "Your code"}`)
+	p, err := e.Preview(mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+
+	m, err := e.makeEmail(mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PreviewResult{
+		To:      "john@doe.com",
+		Sender:  m.From,
+		Subject: m.Subject,
+		Body:    string(m.HTML),
+	}, p)
+	assert.Equal(t, `"My App" <otp@myapp.com>`, p.Sender)
+	assert.Equal(t, "Your code", p.Subject)
+}
+
+func TestRecipientName(t *testing.T) {
+	e := newEmailer(t, `{"FromEmail": "otp@myapp.com"}`)
+	for in, out := range map[string]string{
+		"john@doe.com":               "john@doe.com",
+		"<john@doe.com>":             "john@doe.com",
+		"John Doe <john@doe.com>":    `"John Doe" <john@doe.com>`,
+		`"Doe, John" <john@doe.com>`: `"Doe, John" <john@doe.com>`,
+	} {
+		assert.NoError(t, e.ValidateAddress(in), in)
+
+		o := mockOTP
+		o.To = in
+		m, err := e.makeEmail(o, "Verification", []byte("Your code is 482910"))
+		assert.NoError(t, err, in)
+		assert.Equal(t, []string{out}, m.To, in)
+	}
+	for _, to := range []string{"", "john", "John Doe <john>", "John Doe john@doe.com>"} {
+		assert.Error(t, e.ValidateAddress(to), to)
+	}
+
+	// The name can be ignored.
+	e = newEmailer(t, `{"FromEmail": "otp@myapp.com", "IgnoreRecipientName": true}`)
+	o := mockOTP
+	o.To = "John Doe <john@doe.com>"
+	m, err := e.makeEmail(o, "Verification", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"john@doe.com"}, m.To)
+}
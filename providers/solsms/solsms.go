@@ -1,36 +1,412 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/gsm"
+	"github.com/zplzpl/otpgateway/internal/clock"
 	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+	"github.com/zplzpl/otpgateway/phone/numplan"
+	"github.com/zplzpl/otpgateway/webhook"
 )
 
 const (
 	providerID    = "solsms"
-	channelName   = "SMS"
 	addressName   = "Mobile number"
-	maxAddresslen = 11
-	maxOTPlen     = 6
-	apiURL        = "https://api.kaleyra.io/v1/"
-	statusOK      = "OK"
+	maxAddresslen = phone.MaxE164Len
+	minOTPLen     = 4
+	maxOTPlen     = 10
+	maxBodyLen    = 140
+	apiURL        = "https://api.kaleyra.io/"
+
+	defaultAPIVersion = "v1"
+	defaultChannel    = "sms"
+	encodingForm      = "form"
+	encodingJSON      = "json"
+
+	// Retry jitter modes.
+	jitterFull  = "full"
+	jitterEqual = "equal"
+	jitterNone  = "none"
+
+	// Serializations of the recipient list param.
+	recipientsScalar = "scalar"
+	recipientsComma  = "comma"
+	recipientsArray  = "array"
+
+	// Body of PushOTP SMSes when there's no DefaultBody.
+	defaultOTPBody = "Your verification code is %s"
+
+	// Character sets bodies are validated against.
+	charsetGSM7 = "gsm7"
+	charsetUCS2 = "ucs2"
+
+	// Default idle connection timeout in seconds. This is kept shorter
+	// than the usual server side idle timeouts (60s) so that the client
+	// doesn't reuse connections that the server has already closed.
+	defaultIdleConnTimeout = 30
+	defaultMaxRetries      = 2
+	defaultOTPLen          = 6
+	defaultRetryWait       = 500
+	defaultCredentialTTL   = 60
+	statusOK               = "OK"
+	statusSent             = "sent"
+	statusQueued           = "queued"
+	statusDelivered        = "delivered"
+	statusFailed           = "failed"
+	statusPending          = "pending"
+
+	// Request signing schemes and the headers of the hmac-sha256 scheme.
+	signingHMAC     = "hmac-sha256"
+	signingWebhook  = "webhook"
+	headerTimestamp = "X-Timestamp"
+	headerSignature = "X-Signature"
+
+	// Max number of entries in the status cache.
+	maxStatusCacheSize = 10000
+
+	// Idle duration after which namespace rate buckets are evicted.
+	nsBucketIdle = time.Minute
+
+	// Defaults of WaitForDelivery in milliseconds.
+	defaultDeliveryWaitTimeout  = 30000
+	defaultDeliveryPollInterval = 1000
+
+	// Max number of Kaleyra's custom fields for metadata.
+	maxMetadataFields = 4
+
+	// Default seconds for which a country switched to the
+	// NumericFallbackSender stays switched.
+	defaultAdaptiveSenderCooldown = 3600
+)
+
+// versionEncodings are the default request encodings of the
+// Kaleyra API versions.
+var versionEncodings = map[string]string{
+	"v1": encodingForm,
+	"v2": encodingJSON,
+}
+
+// channel is a Kaleyra messaging channel.
+type channel struct {
+	// Name returned by ChannelName.
+	name string
+
+	// How the code is sent, in the help text.
+	medium string
+
+	// API path that messages on the channel are sent to.
+	path string
+}
+
+// channels are the Kaleyra channels that can be sent on.
+var channels = map[string]channel{
+	"sms":      {name: "SMS", medium: "in an SMS", path: "/messages"},
+	"whatsapp": {name: "WhatsApp", medium: "on WhatsApp", path: "/messages"},
+	"voice":    {name: "Voice", medium: "in a voice call", path: "/voice/outbound"},
+}
+
+// httpMethods are the allowed API request methods.
+var httpMethods = map[string]bool{
+	http.MethodPost: true,
+	http.MethodPut:  true,
+	http.MethodGet:  true,
+}
+
+// dlrStatuses maps the Kaleyra delivery statuses to the terminal
+// statuses. Others are in-flight and are reported as "sent".
+var dlrStatuses = map[string]string{
+	"DELIVRD":   statusDelivered,
+	"DELIVERED": statusDelivered,
+	"UNDELIV":   statusFailed,
+	"FAILED":    statusFailed,
+	"EXPIRED":   statusFailed,
+	"REJECTD":   statusFailed,
+	"REJECTED":  statusFailed,
+}
+
+// tlsVersions maps the MinTLSVersion values to the TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// pendingStatuses are the API statuses of messages that have been
+// accepted for asynchronous delivery.
+var pendingStatuses = map[string]bool{
+	"QUEUED":  true,
+	"PENDING": true,
+}
+
+var (
+	// ErrOTPMismatch is returned by Verify when the OTP doesn't match.
+	ErrOTPMismatch = errors.New("OTP does not match")
+
+	// ErrInvalidOTP is returned by ValidateOTP when the OTP isn't
+	// OTPLength digits.
+	ErrInvalidOTP = errors.New("invalid OTP")
+
+	// ErrEmptyBody is returned when the body is empty and there's
+	// no DefaultBody to fall back to.
+	ErrEmptyBody = errors.New("empty SMS body")
+
+	// ErrBodyTooLong is returned when the body exceeds the max body length.
+	ErrBodyTooLong = errors.New("SMS body is too long")
+
+	// ErrBodyTooShort is returned when the rendered body is shorter
+	// than MinBodyLen, which usually indicates a broken template.
+	ErrBodyTooShort = errors.New("SMS body is too short")
+
+	// ErrTooManySegments is returned when the body would be sent as
+	// more than MaxSegments SMS segments.
+	ErrTooManySegments = errors.New("SMS body exceeds the max segments")
+
+	// ErrQueueFull is returned by Push when the async queue is full.
+	ErrQueueFull = errors.New("SMS queue is full")
+
+	// ErrQueueClosed is returned by Push after the provider is closed.
+	ErrQueueClosed = errors.New("SMS queue is closed")
+
+	// ErrContentBlocked is returned when the body contains a URL or a
+	// banned substring.
+	ErrContentBlocked = errors.New("SMS body content blocked")
+
+	// ErrUnparsableResponse is returned when a 2xx API response has a
+	// body that can't be parsed.
+	ErrUnparsableResponse = errors.New("unparsable API response")
+
+	// ErrDuplicateSuppressed is returned by Push when the same body was
+	// sent to the number within the DedupeWindow.
+	ErrDuplicateSuppressed = errors.New("duplicate SMS suppressed")
+
+	// ErrCountryBlocked is returned by Push when the number is in
+	// BlockedCountries or BlockedPrefixes or isn't in AllowedCountries.
+	ErrCountryBlocked = errors.New("destination country blocked")
+
+	// ErrMessageNotFound is returned by DeliveryStatus when the API
+	// has no message with the ID.
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrDeliveryFailed is returned with WaitForDelivery when the SMS
+	// was sent but its delivery failed.
+	ErrDeliveryFailed = errors.New("SMS delivery failed")
+
+	// ErrDeliveryTimeout is returned with WaitForDelivery when the SMS
+	// was sent but its delivery wasn't confirmed within the timeout.
+	ErrDeliveryTimeout = errors.New("SMS delivery not confirmed in time")
+
+	// ErrAuthFailed is returned by Warmup when the API rejects an
+	// account's SID or API key.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrUnsupportedCharacter is returned when the body has a character
+	// that can't be encoded in the configured Charset.
+	ErrUnsupportedCharacter = errors.New("unsupported character in SMS body")
 )
 
+// defaultNumericSenderCountries are the countries where SMSes are
+// sent from the NumericFallbackSender if NumericSenderCountries isn't set.
+var defaultNumericSenderCountries = []string{"US", "CA"}
+
 var reNum = regexp.MustCompile(`\+?([0-9]){8,15}`)
 
+// uiAddressPattern is the pattern of mobile numbers for UIs.
+const uiAddressPattern = `\+?[0-9]{8,15}`
+
+// reURL matches URLs with a scheme or a "www." and bare domains, eg:
+// example.com/x.
+var reURL = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}\b`)
+
 // sms is the default representation of the sms interface.
 type sms struct {
-	cfg *cfg
-	h   *http.Client
+	otpgateway.Toggle
+
+	cfg        *cfg
+	h          *http.Client
+	events     otpgateway.EventSink
+	stats      otpgateway.StatsCounter
+	suppressed *otpgateway.SuppressionList
+	defBody    *template.Template
+
+	// Parsed BodyTemplatesByCountry.
+	countryBodies map[string]*template.Template
+
+	// Default logger used when there's none in the push context.
+	log *log.Logger
+
+	// Upper cased RetryableErrorCodes.
+	retryCodes map[string]bool
+
+	// Optional DNS cache used by the HTTP transport to dial.
+	dns *dnsCache
+
+	// Optional cache of delivery statuses.
+	statuses *statusCache
+
+	// Optional cache of the IDs of recently sent SMSes by the hash of
+	// their number and body.
+	dedupe *statusCache
+
+	// Time source for the request signature timestamps and the retries.
+	clock clock.Clock
+
+	// Optional per-country and per-namespace rate limiters.
+	limiter   *rateLimiter
+	nsLimiter *nsLimiter
+
+	// Optional counts of the failed deliveries from alphanumeric senders
+	// by country for AdaptiveSenderThreshold. In-process unless a shared
+	// store is set with SetCounterStore.
+	senderFails otpgateway.CounterStore
+
+	// Optional resend cooldowns. In-process unless a shared store is
+	// set with SetCooldownStore.
+	cooldowns otpgateway.CooldownStore
+
+	// Optional telemetry set with SetTelemetry.
+	tracer otpgateway.Tracer
+	meter  otpgateway.Meter
+
+	// Optional sink of the audit records set with SetAuditSink.
+	audit otpgateway.AuditSink
+
+	// Optional source of the default account's API key set with
+	// SetCredentialProvider.
+	creds *credCache
+
+	// def is the default account and accounts are the additional,
+	// routed ones.
+	def      *account
+	accounts []*account
+
+	// Optional async queue. pending is the number of queued and
+	// in-flight jobs and drained is closed when it drops to 0.
+	queue   chan job
+	workers sync.WaitGroup
+	mu      sync.Mutex
+	pending int
+	drained chan struct{}
+	closed  bool
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider         = (*sms)(nil)
+	_ otpgateway.ResultPusher     = (*sms)(nil)
+	_ otpgateway.Verifier         = (*sms)(nil)
+	_ otpgateway.TestOTPer        = (*sms)(nil)
+	_ otpgateway.SenderResolver   = (*sms)(nil)
+	_ otpgateway.StatusChecker    = (*sms)(nil)
+	_ otpgateway.Previewer        = (*sms)(nil)
+	_ otpgateway.Instrumented     = (*sms)(nil)
+	_ otpgateway.ErrorReporter    = (*sms)(nil)
+	_ otpgateway.CooldownSetter   = (*sms)(nil)
+	_ otpgateway.AuditSetter      = (*sms)(nil)
+	_ otpgateway.WebhookParser    = (*sms)(nil)
+	_ otpgateway.CredentialSetter = (*sms)(nil)
+	_ otpgateway.Warmer           = (*sms)(nil)
+	_ otpgateway.CounterSetter    = (*sms)(nil)
+	_ otpgateway.UIDescriber      = (*sms)(nil)
+	_ otpgateway.Toggler          = (*sms)(nil)
+	_ otpgateway.OTPPusher        = (*sms)(nil)
+)
+
+// account is a Kaleyra account (SID) that SMSes are sent from.
+type account struct {
+	SID    string `json:"SID"`
+	APIKey string `json:"APIKey"`
+	Sender string `json:"Sender"`
+
+	// OTPs of these namespaces or to numbers of these countries
+	// (ISO 3166-1 alpha-2 codes, eg: IN) are sent from the account.
+	Namespaces []string `json:"Namespaces"`
+	Countries  []string `json:"Countries"`
+
+	// rootURL is the API URL composed with the SID.
+	rootURL string
+}
+
+// statusCodeKey is the context key of the pointer the HTTP status
+// code of the API response is recorded to for the telemetry.
+type statusCodeKey struct{}
+
+// expiryKey is the context key of the time after which an SMS is no
+// longer retried. It's taken when the SMS is pushed, so that the time
+// spent in the async queue counts towards the OTP's validity.
+type expiryKey struct{}
+
+// sentKey is the context key of the sentRequest a request's headers
+// are recorded to for the audit records.
+type sentKey struct{}
+
+// sentRequest is the part of a sent request that's only known once
+// it's made.
+type sentRequest struct {
+	header http.Header
+	at     time.Time
+}
+
+// responseKey is the context key of the apiResponse a request's
+// response is recorded to for the response log.
+type responseKey struct{}
+
+// apiResponse is the raw response of a request.
+type apiResponse struct {
+	status int
+	body   []byte
+}
+
+// timingKey is the context key of the timing recorder of a request.
+type timingKey struct{}
+
+// timing records the phases of a request from the httptrace hooks,
+// which may be called concurrently, eg: by parallel dials.
+type timing struct {
+	mu    sync.Mutex
+	start time.Time
+	dns   time.Time
+	conn  time.Time
+	tls   time.Time
+	res   otpgateway.RequestTiming
+}
+
+// job is an SMS queued for async delivery.
+type job struct {
+	otp     models.OTP
+	subject string
+	body    []byte
+	expiry  time.Time
 }
 
 type cfg struct {
@@ -40,147 +416,3255 @@ type cfg struct {
 	Sender       string `json:"Sender"`
 	Timeout      int    `json:"Timeout"`
 	MaxIdleConns int    `json:"MaxIdleConns"`
+
+	// Idle connection timeout in seconds and the maximum number of
+	// connections per host (0 = unlimited).
+	IdleConnTimeout int `json:"IdleConnTimeout"`
+	MaxConnsPerHost int `json:"MaxConnsPerHost"`
+
+	// Open a new connection for every request. This is meant for
+	// debugging stale connection issues or for very low volumes.
+	DisableKeepAlives bool `json:"DisableKeepAlives"`
+
+	// Optional socket ("tcp", "udp", "unix") to emit
+	// send / fail events to as JSON.
+	EventsNetwork string `json:"EventsNetwork"`
+	EventsAddress string `json:"EventsAddress"`
+
+	// Replace common non GSM-7 characters (curly quotes, dashes etc.)
+	// in the body with GSM-7 equivalents to avoid UCS-2 encoding that
+	// reduces the segment length to 70 characters.
+	TransliterateToGSM bool `json:"TransliterateToGSM"`
+
+	// Optional character set (gsm7, ucs2) that bodies are validated
+	// against after the transliteration, for routes or message types
+	// (eg: flash SMS) that only support it. Bodies with characters that
+	// can't be encoded, eg: emoji, fail with ErrUnsupportedCharacter
+	// instead of being corrupted. Empty = not validated.
+	Charset string `json:"Charset"`
+
+	// Optional OTP policy shown in the channel description.
+	// CodeValidity is in seconds.
+	MaxAttempts  int `json:"MaxAttempts"`
+	CodeValidity int `json:"CodeValidity"`
+
+	// Use Kaleyra's OTP (verify) API instead of the messages API.
+	// Kaleyra generates and sends the OTP and it has to be verified
+	// with Verify() using the ID returned by PushContext().
+	UseOTPEndpoint bool `json:"UseOTPEndpoint"`
+
+	// Validate numbers against the numbering plans of their countries
+	// (libphonenumber) instead of the simple digits check. Numbers are
+	// sent in the E.164 format.
+	StrictValidation bool `json:"StrictValidation"`
+
+	// Treat responses with a QUEUED / PENDING status (accepted for async
+	// delivery) as successful pushes with the "queued" status.
+	AcceptPending bool `json:"AcceptPending"`
+
+	// Optional path to a CSV file of opted out numbers
+	// (first column) that SMSes should not be sent to.
+	SuppressionFile string `json:"SuppressionFile"`
+
+	// Optional Go template for the body that's sent when the body
+	// is empty and by PushOTP, eg: "Your verification code is
+	// {{ .OTP }}". If it's not set, pushing an empty body returns
+	// ErrEmptyBody.
+	DefaultBody string `json:"DefaultBody"`
+
+	// Display the OTP in the body in groups of N digits separated
+	// by spaces, eg: "482 910" for 3. 0 disables grouping.
+	GroupOTPDigits int `json:"GroupOTPDigits"`
+
+	// Optional map of test numbers to fixed OTPs (eg: for app store
+	// reviewers and QA). SMSes to these numbers are never sent and are
+	// reported as delivered. This should not be set in production.
+	TestNumbers map[string]string `json:"TestNumbers"`
+
+	// If AsyncQueueSize is set, Push enqueues SMSes and returns
+	// immediately and a pool of Workers (default 1) sends them.
+	AsyncQueueSize int `json:"AsyncQueueSize"`
+	Workers        int `json:"Workers"`
+
+	// Optional additional accounts that SMSes are routed to by
+	// namespace or destination country. SMSes that don't match
+	// any account are sent from the default (top level) account.
+	Accounts []*account `json:"Accounts"`
+
+	// Kaleyra API version (default v1) and the request encoding
+	// ("form" or "json"). If Encoding isn't set, it's picked based
+	// on the API version. With either, control characters other than
+	// newlines, carriage returns, and tabs are stripped from bodies.
+	APIVersion string `json:"APIVersion"`
+	Encoding   string `json:"Encoding"`
+
+	// Maximum number of SMS segments (parts) a body may be sent as
+	// to cap costs. 0 limits bodies to MaxBodyLen characters.
+	MaxSegments int `json:"MaxSegments"`
+
+	// Kaleyra error codes (or messages) that are transient and are
+	// retried up to MaxRetries times, RetryWait milliseconds apart.
+	RetryableErrorCodes []string `json:"RetryableErrorCodes"`
+	MaxRetries          int      `json:"MaxRetries"`
+	RetryWait           int      `json:"RetryWait"`
+
+	// Randomization of the RetryWait so that SMSes that fail together
+	// aren't retried in sync: full (0 - RetryWait), equal (RetryWait/2
+	// - RetryWait), or none. Default full.
+	RetryJitter string `json:"RetryJitter"`
+
+	// Retry even if the retry would be sent after the OTP expires. By
+	// default, retries are skipped once the OTP's TTL would pass before
+	// they're sent as the code can't be used anymore.
+	RetryAfterExpiry bool `json:"RetryAfterExpiry"`
+
+	// Optional region (ISO 3166-1 alpha-2 country code, eg: IN) that
+	// numbers without a country code are qualified with to E.164.
+	// Without it, national format numbers (eg: 09876543210) are
+	// rejected as ambiguous.
+	DefaultRegion string `json:"DefaultRegion"`
+
+	// Treat a leading 00 as the international call prefix and replace
+	// it with a + (eg: 00447400123456 => +447400123456). Default true.
+	// Set it to false where 00 can be the start of a national number.
+	TreatDoubleZeroAsPlus *bool `json:"TreatDoubleZeroAsPlus"`
+
+	// Disable Kaleyra's URL shortening (link tracking) in the body,
+	// which is enabled by default as OTP bodies shouldn't be tracked.
+	// Set it to false to leave the account's behaviour as is.
+	DisableLinkTracking *bool `json:"DisableLinkTracking"`
+
+	// Cache the resolved IPs of the API host for DNSCacheTTL seconds
+	// instead of resolving it on every new connection. 0 disables it.
+	DNSCacheTTL int `json:"DNSCacheTTL"`
+
+	// HTTP method (POST, PUT, GET) of the API requests for compatible
+	// gateways. Default POST. GET requests send the params in the
+	// query string.
+	HTTPMethod string `json:"HTTPMethod"`
+
+	// Cache the results of DeliveryStatus lookups by ID to avoid
+	// hammering the API when they're polled. Terminal statuses
+	// (delivered, failed) are cached for StatusCacheTTL and
+	// in-flight ones for PendingStatusCacheTTL seconds. 0 disables them.
+	StatusCacheTTL        int `json:"StatusCacheTTL"`
+	PendingStatusCacheTTL int `json:"PendingStatusCacheTTL"`
+
+	// Minimum length in characters of the rendered body (excluding
+	// surrounding whitespace) to catch broken templates before the
+	// SMS is billed. 0 disables the check.
+	MinBodyLen int `json:"MinBodyLen"`
+
+	// Minimum TLS version ("1.2", "1.3") of the API connections.
+	// Defaults to Go's default minimum.
+	MinTLSVersion string `json:"MinTLSVersion"`
+
+	// Sign the requests with an HMAC secret for gateways (eg: resellers)
+	// that require it. The "hmac-sha256" scheme (default) sends the hex
+	// HMAC-SHA256 of the unix timestamp followed by the body in the
+	// X-Signature header and the timestamp in X-Timestamp. The "webhook"
+	// scheme signs the requests like the webhook package. GET requests
+	// sign the query string. If APIKey is empty, the api-key header
+	// isn't sent.
+	SigningSecret string `json:"SigningSecret"`
+	SigningScheme string `json:"SigningScheme"`
+
+	// Optional cost of an SMS segment for estimating the cost of
+	// messages in Preview.
+	CostPerSegment float64 `json:"CostPerSegment"`
+
+	// Max SMSes per second to a destination country, keyed by the
+	// calling code (eg: "91"), and to the countries that aren't listed.
+	// Pushes over the rate wait for their turn. 0 = unlimited.
+	RateByCountry map[string]int `json:"RateByCountry"`
+	RatePerSecond int            `json:"RatePerSecond"`
+
+	// Max SMSes per second per OTP namespace (tenant), in addition
+	// to the above. Pushes over the rate wait for their turn.
+	// 0 = unlimited.
+	RatePerNamespace int `json:"RatePerNamespace"`
+
+	// Attempt HTTP/2 even though the transport is customized (eg: with
+	// MinTLSVersion or DNSCacheTTL), which otherwise disables Go's
+	// automatic HTTP/2.
+	ForceHTTP2 bool `json:"ForceHTTP2"`
+
+	// Send numbers to the API without the leading + (eg: 14155551234)
+	// for upstreams that reject it. Validation accepts both forms.
+	StripLeadingPlus bool `json:"StripLeadingPlus"`
+
+	// Number of OTP digits, between minOTPLen and maxOTPlen. Default 6.
+	OTPLength int `json:"OTPLength"`
+
+	// Min seconds between SMSes to the same number in a namespace.
+	// Pushes within the cooldown fail with ErrCooldown. 0 = disabled.
+	ResendCooldown int `json:"ResendCooldown"`
+
+	// Reject bodies containing URLs or any of the (case insensitive)
+	// BannedSubstrings with ErrContentBlocked, eg: to catch misused
+	// templates. The OTP itself is exempt.
+	BlockURLsInBody  bool     `json:"BlockURLsInBody"`
+	BannedSubstrings []string `json:"BannedSubstrings"`
+
+	// Numeric sender (long code) used instead of an alphanumeric sender
+	// to the countries that don't allow alphanumeric senders. Defaults
+	// to US and CA.
+	NumericFallbackSender  string   `json:"NumericFallbackSender"`
+	NumericSenderCountries []string `json:"NumericSenderCountries"`
+
+	// Don't return from PushContext until the delivery status of the
+	// sent SMS, polled every DeliveryPollInterval milliseconds (default
+	// 1000), is delivered or failed (ErrDeliveryFailed), or until
+	// DeliveryWaitTimeout milliseconds (default 30000) when the status
+	// is pending (ErrDeliveryTimeout). Not supported with Accounts or
+	// UseOTPEndpoint as the statuses are looked up with the default
+	// account.
+	WaitForDelivery      bool `json:"WaitForDelivery"`
+	DeliveryWaitTimeout  int  `json:"DeliveryWaitTimeout"`
+	DeliveryPollInterval int  `json:"DeliveryPollInterval"`
+
+	// Keys of the metadata set with otpgateway.WithMetadata that are
+	// sent, in order, as Kaleyra's custom1 - custom4 fields. Other keys
+	// are ignored.
+	MetadataFields []string `json:"MetadataFields"`
+
+	// Switch the SMSes to a country from an alphanumeric sender to the
+	// NumericFallbackSender once AdaptiveSenderThreshold delivery reports
+	// from the country are failures, until AdaptiveSenderCooldown seconds
+	// (default 3600) later when the alphanumeric sender is tried again.
+	// Failures are counted from the callbacks parsed by ParseWebhook.
+	// 0 = disabled.
+	AdaptiveSenderThreshold int `json:"AdaptiveSenderThreshold"`
+	AdaptiveSenderCooldown  int `json:"AdaptiveSenderCooldown"`
+
+	// Collect the DNS, connect, TLS and first byte timings of the push
+	// requests in the PushResult.
+	DetailedTiming bool `json:"DetailedTiming"`
+
+	// Fail pushes whose 2xx responses can't be parsed or have no status.
+	// By default, unparsable 2xx responses are logged and treated as
+	// sent without a message ID as the SMS was likely accepted.
+	StrictResponseParsing bool `json:"StrictResponseParsing"`
+
+	// Suppress SMSes with the same body to the same number within
+	// DedupeWindow seconds of a sent one with ErrDuplicateSuppressed,
+	// or with a success and the sent SMS's ID if DedupeSilent is set.
+	// 0 = disabled.
+	DedupeWindow int  `json:"DedupeWindow"`
+	DedupeSilent bool `json:"DedupeSilent"`
+
+	// Token that Kaleyra's callbacks (delivery reports and inbound
+	// SMSes) must carry in the token param of the callback URL, eg:
+	// https://example.com/webhooks/solsms?token=xxx. Callbacks are
+	// rejected if it's not set.
+	CallbackToken string `json:"CallbackToken"`
+
+	// Max size in bytes of the API response headers. Responses with
+	// larger headers fail. 0 = Go's default (1 MB).
+	MaxResponseHeaderBytes int `json:"MaxResponseHeaderBytes"`
+
+	// Countries (ISO 3166-1 alpha-2 codes, eg: IN) and number prefixes
+	// (eg: +8823) that SMSes are never sent to, for instance, high risk
+	// premium rate destinations. If AllowedCountries is set, SMSes are
+	// only sent to them. Pushes to the others fail with
+	// ErrCountryBlocked.
+	BlockedCountries []string `json:"BlockedCountries"`
+	BlockedPrefixes  []string `json:"BlockedPrefixes"`
+	AllowedCountries []string `json:"AllowedCountries"`
+
+	// Seconds the API key fetched from a CredentialProvider set with
+	// SetCredentialProvider is cached for. Default 60.
+	CredentialCacheTTL int `json:"CredentialCacheTTL"`
+
+	// Milliseconds within which a push should succeed, including the
+	// retries, for the SMS to arrive in time. Slower pushes succeed but
+	// are marked SLABreached in the PushResult. 0 = disabled.
+	SLATimeout int `json:"SLATimeout"`
+
+	// Serialization of the to param: scalar (a single number), comma
+	// (comma separated numbers), or array (a JSON array or repeated
+	// params in forms). Default scalar.
+	RecipientFormat string `json:"RecipientFormat"`
+
+	// Fraction (0.0 - 1.0) of successful SMS API responses that are
+	// logged in full for debugging deliverability. Error responses are
+	// always logged when it's set. The recipient is masked and the OTP
+	// and the API key are redacted. 0 = disabled.
+	ResponseLogSampleRate float64 `json:"ResponseLogSampleRate"`
+
+	// Kaleyra channel (sms, whatsapp, voice) that messages are sent
+	// on. WhatsApp messages are sent from the Sender number and voice
+	// calls read out the body with the Sender as the caller ID.
+	Channel string `json:"Channel"`
+
+	// Optional Go templates for the body keyed by the destination's
+	// country (eg: "IN"), for countries that mandate specific wording.
+	// They replace the body (or DefaultBody) of SMSes to the country
+	// and must include {{ .OTP }}.
+	BodyTemplatesByCountry map[string]string `json:"BodyTemplatesByCountry"`
+}
+
+// ConfigError is returned by ParseConfig and New when a config
+// field is invalid.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// RequestError is returned when the HTTP request to the API fails
+// without a response.
+type RequestError struct {
+	Err error
+
+	// Sent indicates whether the request was fully written to the
+	// connection before the failure. If it wasn't, the API couldn't have
+	// received the message and the request is safe to retry. If it was,
+	// the message may have been received and retrying may result in
+	// a duplicate SMS.
+	Sent bool
+}
+
+func (e *RequestError) Error() string {
+	if e.Sent {
+		return fmt.Sprintf("error reading response: %v", e.Err)
+	}
+	return fmt.Sprintf("error sending request: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// SafeToRetry tells if the request can be retried without
+// the risk of sending a duplicate message.
+func (e *RequestError) SafeToRetry() bool {
+	return !e.Sent
+}
+
+// Is tells errors.Is that the API is unavailable.
+func (e *RequestError) Is(target error) bool {
+	return target == otpgateway.ErrUpstreamUnavailable
+}
+
+// NumberError is returned when a mobile number is invalid. Err is the
+// reason, if known.
+type NumberError struct {
+	Err error
+}
+
+func (e *NumberError) Error() string {
+	if e.Err == nil {
+		return "invalid mobile number"
+	}
+	return fmt.Sprintf("invalid mobile number: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *NumberError) Unwrap() error {
+	return e.Err
+}
+
+// Is tells errors.Is that the number is an invalid address.
+func (e *NumberError) Is(target error) bool {
+	return target == otpgateway.ErrInvalidAddress
 }
 
 // solSMSAPIResp represents the response from solsms API.
 type solSMSAPIResp struct {
-	Code string      `json:"code,omitempty"`
-	Id   string      `json:"id"`
-	Data interface{} `json:"data"`
+	Code    string          `json:"code,omitempty"`
+	Message string          `json:"message"`
+	Id      messageID       `json:"id"`
+	Status  string          `json:"status"`
+	Data    json.RawMessage `json:"data"`
 }
 
-// New returns an instance of the SMS package. cfg is configuration
-// represented as a JSON string. Supported options are.
-// {
-// 	RootURL: "", // Optional root URL of the API,
-// 	APIKey: "", // API Key,
-// 	Sender: "", // Sender name
-// 	Timeout: 5 // Optional HTTP timeout in seconds
-// }
-func New(jsonCfg []byte) (interface{}, error) {
-	var c *cfg
-	if err := json.Unmarshal(jsonCfg, &c); err != nil {
-		return nil, err
+// messageID is an ID in an API response that's a JSON string or
+// a number depending on the account and the API version.
+type messageID string
+
+// UnmarshalJSON unmarshals a string or a number (as is, without
+// float conversion) ID. null is unmarshalled as an empty ID.
+func (m *messageID) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*m = ""
+		return nil
 	}
-	if c.APIKey == "" || c.Sender == "" || c.SID == "" {
-		return nil, errors.New("invalid APIKey or Sender or SID")
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*m = messageID(s)
+		return nil
 	}
-	if c.RootURL == "" {
-		c.RootURL = apiURL
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("invalid message ID: %s", b)
 	}
+	*m = messageID(n.String())
+	return nil
+}
 
-	c.RootURL = strings.TrimRight(c.RootURL, "/") + "/" + c.SID + "/messages"
+// KaleyraError is returned when the API responds with an error code.
+// Field is the request field or recipient the error pertains to and
+// Detail is the error description, if the API returned them. Status is
+// the HTTP status code of the response, if known.
+type KaleyraError struct {
+	Code   string
+	Field  string
+	Detail string
+	Status int
+}
 
-	// Initialize the HTTP client.
-	t := 5
-	if c.Timeout != 0 {
-		t = c.Timeout
+// Error returns the error message.
+func (e *KaleyraError) Error() string {
+	msg := "send sms error: " + e.Code
+	if e.Field != "" {
+		msg += ": " + e.Field
 	}
-	h := &http.Client{
-		Timeout: time.Duration(t) * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   1,
-			ResponseHeaderTimeout: time.Second * time.Duration(t),
-		},
+	if e.Detail != "" {
+		msg += ": " + e.Detail
 	}
+	return msg
+}
 
-	return &sms{
-		cfg: c,
-		h:   h}, nil
+// Is reports whether the error is otpgateway.ErrRateLimited, which
+// it is when the API responded with HTTP 429.
+func (e *KaleyraError) Is(target error) bool {
+	return target == otpgateway.ErrRateLimited && e.Status == http.StatusTooManyRequests
 }
 
-// ID returns the Provider's ID.
-func (s *sms) ID() string {
-	return providerID
+// errDetail is an error nested in the data of an API error response,
+// either as an array or as data.errors.
+type errDetail struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"`
+	To      string `json:"to"`
+	Message string `json:"message"`
 }
 
-// ChannelName returns the Provider's name.
-func (s *sms) ChannelName() string {
-	return channelName
+// newKaleyraError returns a KaleyraError from an error response with
+// the first of the nested error details, if any.
+func newKaleyraError(r solSMSAPIResp) *KaleyraError {
+	e := &KaleyraError{Code: r.Code, Detail: r.Message}
+
+	var (
+		list []errDetail
+		obj  struct {
+			Errors []errDetail `json:"errors"`
+		}
+	)
+	if err := json.Unmarshal(r.Data, &list); err != nil || len(list) == 0 {
+		if err := json.Unmarshal(r.Data, &obj); err == nil {
+			list = obj.Errors
+		}
+	}
+	if len(list) == 0 {
+		return e
+	}
+
+	d := list[0]
+	if d.Code != "" {
+		e.Code = d.Code
+	}
+	e.Field = d.Field
+	if e.Field == "" {
+		e.Field = d.To
+	}
+	if d.Message != "" {
+		e.Detail = d.Message
+	}
+	return e
 }
 
-// AddressName returns the e-mail Provider's address name.
-func (*sms) AddressName() string {
-	return addressName
+// DLR represents a delivery report posted by Kaleyra to the callback URL.
+type DLR struct {
+	ID        string
+	To        string
+	Status    string
+	Error     string
+	Reference string
 }
 
-// ChannelDesc returns help text for the SMS verification Provider.
-func (s *sms) ChannelDesc() string {
-	return fmt.Sprintf(`
-		We've sent a %d digit code in an SMS to your mobile.
-		Enter it here to verify your mobile number.`, maxOTPlen)
+// verifyAPIResp represents the response from the Kaleyra OTP API.
+type verifyAPIResp struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	Data    struct {
+		VerifyID messageID `json:"verify_id"`
+		Message  string    `json:"message"`
+	} `json:"data"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
 }
 
-// AddressDesc returns help text for the phone number.
-func (s *sms) AddressDesc() string {
-	return "Please enter your mobile number"
+func init() {
+	otpgateway.Register(providerID, func(jsonCfg []byte) (otpgateway.Provider, error) {
+		s, err := New(jsonCfg)
+		if err != nil {
+			return nil, err
+		}
+		return s.(otpgateway.Provider), nil
+	})
 }
 
-// ValidateAddress "validates" a phone number.
-func (s *sms) ValidateAddress(to string) error {
-	if !reNum.MatchString(to) {
-		return errors.New("invalid mobile number")
+// New returns an instance of the SMS package. cfg is configuration
+// represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	APIKey: "", // API Key,
+// 	Sender: "", // Sender name
+// 	Timeout: 5, // Optional HTTP timeout in seconds
+// 	MaxIdleConns: 1, // Optional max idle connections
+// 	IdleConnTimeout: 30, // Optional idle connection timeout in seconds
+// 	MaxConnsPerHost: 0, // Optional max connections (0 = unlimited)
+// 	DisableKeepAlives: false, // Optional. New connection per request (debugging)
+// 	EventsNetwork: "tcp", // Optional events socket network (tcp, udp, unix)
+// 	EventsAddress: "", // Optional events socket address
+// 	TransliterateToGSM: false, // Optional. Replace non GSM-7 punctuation
+// 	Charset: "", // Optional. Reject bodies that can't be encoded in it (gsm7, ucs2)
+// 	MaxAttempts: 0, // Optional. Verification attempts shown in the help text
+// 	CodeValidity: 0, // Optional. OTP validity in seconds shown in the help text
+// 	UseOTPEndpoint: false, // Optional. Use Kaleyra's OTP generate / verify API
+// 	StrictValidation: false, // Optional. Validate numbers against numbering plans
+// 	AcceptPending: false, // Optional. Treat queued / pending responses as success
+// 	SuppressionFile: "", // Optional. CSV file of opted out numbers
+// 	DefaultBody: "", // Optional. Body template used when the body is empty
+// 	GroupOTPDigits: 0, // Optional. Group the OTP digits in the body, eg: 3
+// 	TestNumbers: {}, // Optional. Test numbers => fixed OTPs that are never sent
+// 	AsyncQueueSize: 0, // Optional. Queue SMSes and send them in the background
+// 	Workers: 1, // Optional. Number of async workers
+// 	Accounts: [], // Optional. [{SID, APIKey, Sender, Namespaces, Countries}] routed accounts
+// 	APIVersion: "v1", // Optional. Kaleyra API version
+// 	Encoding: "", // Optional. Request encoding (form, json). Defaults based on APIVersion
+// 	MaxSegments: 0, // Optional. Max SMS segments per body (0 = MaxBodyLen chars)
+// 	RetryableErrorCodes: [], // Optional. Transient error codes to retry, eg: ["E110"]
+// 	MaxRetries: 2, // Optional. Max retries of retryable errors
+// 	RetryWait: 500, // Optional. Wait between retries in milliseconds
+// 	RetryJitter: "full", // Optional. Retry wait jitter (full, equal, none)
+// 	RetryAfterExpiry: false, // Optional. Retry even after the OTP's TTL has passed
+// 	DefaultRegion: "", // Optional. Country of numbers without a country code, eg: IN
+// 	TreatDoubleZeroAsPlus: true, // Optional. Replace a leading 00 with a +
+// 	DisableLinkTracking: true, // Optional. Disable URL shortening in the body
+// 	DNSCacheTTL: 0, // Optional. Cache resolved API IPs for N seconds
+// 	HTTPMethod: "POST", // Optional. API request method (POST, PUT, GET)
+// 	StatusCacheTTL: 0, // Optional. Cache terminal statuses for N seconds
+// 	PendingStatusCacheTTL: 0, // Optional. Cache in-flight statuses for N seconds
+// 	MinBodyLen: 0, // Optional. Min rendered body length in characters
+// 	MinTLSVersion: "", // Optional. Min TLS version, eg: 1.2
+// 	SigningSecret: "", // Optional. HMAC secret for signing the requests
+// 	SigningScheme: "hmac-sha256", // Optional. Signing scheme (hmac-sha256, webhook)
+// 	CostPerSegment: 0, // Optional. SMS segment cost for the Preview estimates
+// 	RateByCountry: {}, // Optional. Calling code => max SMSes per second, eg: {"91": 50}
+// 	RatePerSecond: 0, // Optional. Max SMSes per second to the other countries
+// 	RatePerNamespace: 0, // Optional. Max SMSes per second per namespace
+// 	ForceHTTP2: false, // Optional. Attempt HTTP/2 with the custom transport
+// 	StripLeadingPlus: false, // Optional. Send numbers to the API without the +
+// 	OTPLength: 6, // Optional. Number of OTP digits (4 - 10)
+// 	ResendCooldown: 0, // Optional. Min seconds between SMSes to a number
+// 	BlockURLsInBody: false, // Optional. Reject bodies containing URLs
+// 	BannedSubstrings: [], // Optional. Reject bodies containing these
+// 	NumericFallbackSender: "", // Optional. Numeric sender for NumericSenderCountries
+// 	NumericSenderCountries: ["US", "CA"], // Optional. Countries without alphanumeric senders
+// 	DetailedTiming: false, // Optional. Collect request phase timings in the PushResult
+// 	StrictResponseParsing: false, // Optional. Fail pushes with unparsable 2xx responses
+// 	DedupeWindow: 0, // Optional. Suppress identical SMSes to a number for N seconds
+// 	DedupeSilent: false, // Optional. Report suppressed duplicates as sent
+// 	BodyTemplatesByCountry: {}, // Optional. Country => body template, eg: {"IN": "..."}
+// 	Channel: "sms", // Optional. Kaleyra channel (sms, whatsapp, voice)
+// 	CallbackToken: "", // Optional. Token in the URL of Kaleyra's callbacks
+// 	MaxResponseHeaderBytes: 0, // Optional. Max API response header size in bytes
+// 	BlockedCountries: [], // Optional. Countries SMSes are never sent to, eg: ["XX"]
+// 	BlockedPrefixes: [], // Optional. Number prefixes SMSes are never sent to, eg: ["+8823"]
+// 	AllowedCountries: [], // Optional. Countries SMSes are only sent to
+// 	CredentialCacheTTL: 60, // Optional. Seconds to cache keys from a CredentialProvider
+// 	SLATimeout: 0, // Optional. Milliseconds after which successful pushes are marked slow
+// 	RecipientFormat: "scalar", // Optional. Serialization of the to param (scalar, comma, array)
+// 	AdaptiveSenderThreshold: 0, // Optional. Failed DLRs after which a country is sent from NumericFallbackSender
+// 	AdaptiveSenderCooldown: 3600, // Optional. Seconds before the alphanumeric sender is tried again
+// 	MetadataFields: [], // Optional. Up to 4 metadata keys sent as custom1 - custom4
+// 	WaitForDelivery: false, // Optional. Wait for the delivery status of sent SMSes
+// 	DeliveryWaitTimeout: 30000, // Optional. Milliseconds to wait for the delivery status
+// 	DeliveryPollInterval: 1000, // Optional. Milliseconds between the delivery status lookups
+// 	ResponseLogSampleRate: 0 // Optional. Fraction (0.0 - 1.0) of API responses logged in full
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	c, err := ParseConfig(jsonCfg)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-// Push pushes out an SMS.
-func (s *sms) Push(otp models.OTP, subject string, body []byte) error {
+	// Each account gets its own API URL.
+	def := &account{SID: c.SID, APIKey: c.APIKey, Sender: c.Sender}
+	def.rootURL = c.RootURL + "/" + def.SID
+	for _, a := range c.Accounts {
+		a.rootURL = c.RootURL + "/" + a.SID
+	}
 
-	var p = url.Values{}
-	p.Set("sender", s.cfg.Sender)
-	p.Set("to", otp.To)
-	p.Set("body", string(body))
+	// Initialize the HTTP client.
+	t := time.Duration(c.Timeout) * time.Second
+	tr := &http.Transport{
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		IdleConnTimeout:       time.Second * time.Duration(c.IdleConnTimeout),
+		DisableKeepAlives:     c.DisableKeepAlives,
+		ResponseHeaderTimeout: t,
+		ForceAttemptHTTP2:     c.ForceHTTP2,
 
-	// Make the request.
-	req, err := http.NewRequest("POST", s.cfg.RootURL, strings.NewReader(p.Encode()))
-	if err != nil {
-		return err
+		MaxResponseHeaderBytes: int64(c.MaxResponseHeaderBytes),
+	}
+	if c.MinTLSVersion != "" {
+		tr.TLSClientConfig = &tls.Config{MinVersion: tlsVersions[c.MinTLSVersion]}
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("api-key", s.cfg.APIKey)
-	log.Println(req)
+	var dns *dnsCache
+	if c.DNSCacheTTL > 0 {
+		dns = newDNSCache(time.Duration(c.DNSCacheTTL)*time.Second, &net.Dialer{Timeout: t})
+		tr.DialContext = dns.DialContext
+	}
+	h := &http.Client{
+		Timeout:   t,
+		Transport: tr,
+	}
+	statuses := newStatusCache(time.Duration(c.StatusCacheTTL)*time.Second,
+		time.Duration(c.PendingStatusCacheTTL)*time.Second)
 
-	resp, err := s.h.Do(req)
-	if err != nil {
-		return err
+	s := &sms{
+		cfg:        c,
+		h:          h,
+		dns:        dns,
+		statuses:   statuses,
+		dedupe:     newStatusCache(time.Duration(c.DedupeWindow)*time.Second, 0),
+		clock:      clock.Real,
+		limiter:    newRateLimiter(c.RatePerSecond, c.RateByCountry),
+		nsLimiter:  newNSLimiter(c.RatePerNamespace),
+		def:        def,
+		accounts:   c.Accounts,
+		log:        log.New(os.Stderr, "", log.LstdFlags),
+		suppressed: otpgateway.NewSuppressionList()}
+
+	if c.ResendCooldown > 0 {
+		s.cooldowns = otpgateway.NewMemCooldownStore()
+	}
+	if c.AdaptiveSenderThreshold > 0 {
+		s.senderFails = otpgateway.NewMemCounterStore()
 	}
-	defer resp.Body.Close()
 
-	// Read the response.
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	if len(c.TestNumbers) > 0 {
+		log.Printf("%s: WARNING: %d TestNumbers configured. SMSes to them will not be sent",
+			providerID, len(c.TestNumbers))
+	}
+	if len(c.RetryableErrorCodes) > 0 {
+		s.retryCodes = make(map[string]bool, len(c.RetryableErrorCodes))
+		for _, code := range c.RetryableErrorCodes {
+			s.retryCodes[strings.ToUpper(code)] = true
+		}
+	}
+	if c.DefaultBody != "" {
+		// The template is validated by ParseConfig.
+		s.defBody = template.Must(template.New("body").Parse(c.DefaultBody))
+	}
+	if len(c.BodyTemplatesByCountry) > 0 {
+		s.countryBodies = make(map[string]*template.Template, len(c.BodyTemplatesByCountry))
+		for cc, b := range c.BodyTemplatesByCountry {
+			s.countryBodies[cc] = template.Must(template.New("body").Parse(b))
+		}
 	}
 
-	// We now unmarshal the body.
-	r := solSMSAPIResp{}
-	if err := json.Unmarshal(b, &r); err != nil {
-		return err
+	// Load the optional suppression list.
+	if c.SuppressionFile != "" {
+		f, err := os.Open(c.SuppressionFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening SuppressionFile: %v", err)
+		}
+		defer f.Close()
+
+		n, err := s.suppressed.LoadSuppressionCSV(f)
+		if err != nil {
+			if _, ok := err.(*otpgateway.MalformedRowsError); !ok {
+				return nil, fmt.Errorf("error reading SuppressionFile: %v", err)
+			}
+			log.Printf("%s: %v in SuppressionFile", providerID, err)
+		}
+		log.Printf("%s: loaded %d numbers from SuppressionFile", providerID, n)
 	}
 
-	if r.Code != "" {
-		return errors.New(fmt.Sprintf("send sms error: %s", r.Code))
+	// Optional events emitter.
+	if c.EventsAddress != "" {
+		s.events = otpgateway.NewSocketSink(c.EventsNetwork, c.EventsAddress, 0, 0)
 	}
 
-	if r.Id == "" {
-		return errors.New("send sms id invalid")
+	// Optional async queue.
+	if c.AsyncQueueSize > 0 {
+		s.queue = make(chan job, c.AsyncQueueSize)
+		for i := 0; i < c.Workers; i++ {
+			s.workers.Add(1)
+			go s.worker()
+		}
 	}
 
-	return nil
+	return s, nil
 }
 
-// MaxAddressLen returns the maximum allowed length for the mobile number.
-func (s *sms) MaxAddressLen() int {
-	return maxAddresslen
-}
+// ParseConfig parses and validates the JSON config and fills in the
+// defaults without initializing the HTTP client or any other resources,
+// eg: for validating configs. Invalid fields are returned as *ConfigError.
+func ParseConfig(jsonCfg []byte) (*cfg, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("empty config")
+	}
 
-// MaxOTPLen returns the maximum allowed length of the OTP value.
-func (s *sms) MaxOTPLen() int {
-	return maxOTPlen
+	// The API key is optional if the requests are signed.
+	switch {
+	case c.APIKey == "" && c.SigningSecret == "":
+		return nil, &ConfigError{Field: "APIKey", Reason: "required"}
+	case c.Sender == "":
+		return nil, &ConfigError{Field: "Sender", Reason: "required"}
+	case c.SID == "":
+		return nil, &ConfigError{Field: "SID", Reason: "required"}
+	}
+	switch {
+	case c.SigningSecret == "" && c.SigningScheme != "":
+		return nil, &ConfigError{Field: "SigningSecret", Reason: "required with SigningScheme"}
+	case c.SigningSecret != "" && c.SigningScheme == "":
+		c.SigningScheme = signingHMAC
+	}
+	if c.SigningScheme != "" && c.SigningScheme != signingHMAC && c.SigningScheme != signingWebhook {
+		return nil, &ConfigError{Field: "SigningScheme", Reason: "unknown scheme " + c.SigningScheme}
+	}
+	if c.APIVersion == "" {
+		c.APIVersion = defaultAPIVersion
+	}
+	enc, err := pickEncoding(c.APIVersion, c.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	c.Encoding = enc
+
+	c.HTTPMethod = strings.ToUpper(c.HTTPMethod)
+	if c.HTTPMethod == "" {
+		c.HTTPMethod = http.MethodPost
+	}
+	if !httpMethods[c.HTTPMethod] {
+		return nil, &ConfigError{Field: "HTTPMethod", Reason: "unsupported method " + c.HTTPMethod}
+	}
+
+	c.Channel = strings.ToLower(c.Channel)
+	if c.Channel == "" {
+		c.Channel = defaultChannel
+	}
+	if _, ok := channels[c.Channel]; !ok {
+		return nil, &ConfigError{Field: "Channel", Reason: "unknown channel " + c.Channel}
+	}
+
+	if c.RootURL == "" {
+		c.RootURL = apiURL + c.APIVersion
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/")
+
+	for i, a := range c.Accounts {
+		field := fmt.Sprintf("Accounts[%d]", i)
+		if a == nil {
+			return nil, &ConfigError{Field: field, Reason: "empty account"}
+		}
+		if f := missingCred(a.APIKey, a.Sender, a.SID); f != "" {
+			return nil, &ConfigError{Field: field + "." + f, Reason: "required"}
+		}
+		if len(a.Namespaces) == 0 && len(a.Countries) == 0 {
+			return nil, &ConfigError{Field: field, Reason: "no Namespaces or Countries to route"}
+		}
+		for j, cn := range a.Countries {
+			a.Countries[j] = strings.ToUpper(cn)
+		}
+	}
+
+	if c.NumericFallbackSender != "" {
+		if isAlphanumeric(c.NumericFallbackSender) {
+			return nil, &ConfigError{Field: "NumericFallbackSender", Reason: "should be numeric"}
+		}
+		if len(c.NumericSenderCountries) == 0 {
+			c.NumericSenderCountries = append([]string(nil), defaultNumericSenderCountries...)
+		}
+	}
+	for i, cn := range c.NumericSenderCountries {
+		c.NumericSenderCountries[i] = strings.ToUpper(cn)
+	}
+	if len(c.MetadataFields) > maxMetadataFields {
+		return nil, &ConfigError{Field: "MetadataFields", Reason: fmt.Sprintf("should have <= %d keys", maxMetadataFields)}
+	}
+	if c.AdaptiveSenderThreshold > 0 && c.NumericFallbackSender == "" {
+		return nil, &ConfigError{Field: "AdaptiveSenderThreshold", Reason: "requires NumericFallbackSender"}
+	}
+	if c.AdaptiveSenderCooldown == 0 {
+		c.AdaptiveSenderCooldown = defaultAdaptiveSenderCooldown
+	}
+	if c.DeliveryWaitTimeout == 0 {
+		c.DeliveryWaitTimeout = defaultDeliveryWaitTimeout
+	}
+	if c.DeliveryPollInterval == 0 {
+		c.DeliveryPollInterval = defaultDeliveryPollInterval
+	}
+	for _, l := range [][]string{c.BlockedCountries, c.AllowedCountries} {
+		for i, cn := range l {
+			l[i] = strings.ToUpper(cn)
+		}
+	}
+	for i, p := range c.BlockedPrefixes {
+		p = "+" + strings.TrimPrefix(strings.Replace(p, " ", "", -1), "+")
+		if _, err := strconv.ParseUint(p[1:], 10, 64); err != nil {
+			return nil, &ConfigError{Field: fmt.Sprintf("BlockedPrefixes[%d]", i), Reason: "should be digits"}
+		}
+		c.BlockedPrefixes[i] = p
+	}
+
+	// Verify IDs aren't tied to accounts, so Verify() can't be routed.
+	if c.UseOTPEndpoint && len(c.Accounts) > 0 {
+		return nil, &ConfigError{Field: "UseOTPEndpoint", Reason: "not supported with Accounts"}
+	}
+	if c.UseOTPEndpoint && c.Channel != defaultChannel {
+		return nil, &ConfigError{Field: "UseOTPEndpoint", Reason: "only supported on the sms Channel"}
+	}
+	if c.WaitForDelivery && (c.UseOTPEndpoint || len(c.Accounts) > 0) {
+		return nil, &ConfigError{Field: "WaitForDelivery", Reason: "not supported with Accounts or UseOTPEndpoint"}
+	}
+
+	// HTTP client.
+	if c.Timeout < 0 {
+		return nil, &ConfigError{Field: "Timeout", Reason: "should be >= 0"}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5
+	}
+	if c.MaxIdleConns < 1 {
+		c.MaxIdleConns = 1
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if c.MinTLSVersion != "" {
+		if _, ok := tlsVersions[c.MinTLSVersion]; !ok {
+			return nil, &ConfigError{Field: "MinTLSVersion", Reason: "unsupported version " + c.MinTLSVersion}
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		val  int
+	}{
+		{"StatusCacheTTL", c.StatusCacheTTL},
+		{"PendingStatusCacheTTL", c.PendingStatusCacheTTL},
+		{"DNSCacheTTL", c.DNSCacheTTL},
+		{"GroupOTPDigits", c.GroupOTPDigits},
+		{"MaxSegments", c.MaxSegments},
+		{"MinBodyLen", c.MinBodyLen},
+		{"ResendCooldown", c.ResendCooldown},
+		{"RatePerNamespace", c.RatePerNamespace},
+		{"DedupeWindow", c.DedupeWindow},
+		{"CredentialCacheTTL", c.CredentialCacheTTL},
+		{"SLATimeout", c.SLATimeout},
+		{"AdaptiveSenderThreshold", c.AdaptiveSenderThreshold},
+		{"AdaptiveSenderCooldown", c.AdaptiveSenderCooldown},
+		{"DeliveryWaitTimeout", c.DeliveryWaitTimeout},
+		{"DeliveryPollInterval", c.DeliveryPollInterval},
+		{"MaxResponseHeaderBytes", c.MaxResponseHeaderBytes},
+		{"MaxRetries", c.MaxRetries},
+		{"RetryWait", c.RetryWait},
+		{"AsyncQueueSize", c.AsyncQueueSize},
+		{"Workers", c.Workers},
+	} {
+		if f.val < 0 {
+			return nil, &ConfigError{Field: f.name, Reason: "should be >= 0"}
+		}
+	}
+	if c.RatePerSecond < 0 {
+		return nil, &ConfigError{Field: "RatePerSecond", Reason: "should be >= 0"}
+	}
+	if c.ResponseLogSampleRate < 0 || c.ResponseLogSampleRate > 1 {
+		return nil, &ConfigError{Field: "ResponseLogSampleRate", Reason: "should be between 0 and 1"}
+	}
+	if len(c.RateByCountry) > 0 {
+		// Calling codes may be written with a +.
+		rates := make(map[string]int, len(c.RateByCountry))
+		for cc, r := range c.RateByCountry {
+			field := "RateByCountry[" + cc + "]"
+			code := strings.TrimPrefix(cc, "+")
+			if _, err := strconv.Atoi(code); err != nil || len(code) > 3 || code == "" {
+				return nil, &ConfigError{Field: field, Reason: "invalid calling code"}
+			}
+			if r < 0 {
+				return nil, &ConfigError{Field: field, Reason: "should be >= 0"}
+			}
+			rates[code] = r
+		}
+		c.RateByCountry = rates
+	}
+	if c.CostPerSegment < 0 {
+		return nil, &ConfigError{Field: "CostPerSegment", Reason: "should be >= 0"}
+	}
+	if c.MinBodyLen > maxBodyLen {
+		return nil, &ConfigError{Field: "MinBodyLen", Reason: fmt.Sprintf("should be <= %d", maxBodyLen)}
+	}
+	if c.OTPLength == 0 {
+		c.OTPLength = defaultOTPLen
+	}
+	if c.OTPLength < minOTPLen || c.OTPLength > maxOTPlen {
+		return nil, &ConfigError{Field: "OTPLength", Reason: fmt.Sprintf("should be between %d and %d", minOTPLen, maxOTPlen)}
+	}
+
+	if c.CredentialCacheTTL == 0 {
+		c.CredentialCacheTTL = defaultCredentialTTL
+	}
+
+	if c.DisableLinkTracking == nil {
+		v := true
+		c.DisableLinkTracking = &v
+	}
+	if c.TreatDoubleZeroAsPlus == nil {
+		v := true
+		c.TreatDoubleZeroAsPlus = &v
+	}
+	if len(c.RetryableErrorCodes) > 0 {
+		if c.MaxRetries == 0 {
+			c.MaxRetries = defaultMaxRetries
+		}
+		if c.RetryWait == 0 {
+			c.RetryWait = defaultRetryWait
+		}
+	}
+	c.RetryJitter = strings.ToLower(c.RetryJitter)
+	switch c.RetryJitter {
+	case "":
+		c.RetryJitter = jitterFull
+	case jitterFull, jitterEqual, jitterNone:
+	default:
+		return nil, &ConfigError{Field: "RetryJitter", Reason: "unknown jitter " + c.RetryJitter}
+	}
+	c.Charset = strings.ToLower(c.Charset)
+	switch c.Charset {
+	case "", charsetGSM7, charsetUCS2:
+	default:
+		return nil, &ConfigError{Field: "Charset", Reason: "unknown charset " + c.Charset}
+	}
+	c.RecipientFormat = strings.ToLower(c.RecipientFormat)
+	switch c.RecipientFormat {
+	case "":
+		c.RecipientFormat = recipientsScalar
+	case recipientsScalar, recipientsComma, recipientsArray:
+	default:
+		return nil, &ConfigError{Field: "RecipientFormat", Reason: "unknown format " + c.RecipientFormat}
+	}
+	if c.DefaultBody != "" {
+		if _, err := template.New("body").Parse(c.DefaultBody); err != nil {
+			return nil, &ConfigError{Field: "DefaultBody", Reason: err.Error()}
+		}
+	}
+	if len(c.BodyTemplatesByCountry) > 0 {
+		// Country codes may be written in lower case.
+		bodies := make(map[string]string, len(c.BodyTemplatesByCountry))
+		for cc, b := range c.BodyTemplatesByCountry {
+			field := "BodyTemplatesByCountry[" + cc + "]"
+			if err := checkOTPTemplate(b); err != nil {
+				return nil, &ConfigError{Field: field, Reason: err.Error()}
+			}
+			bodies[strings.ToUpper(cc)] = b
+		}
+		c.BodyTemplatesByCountry = bodies
+	}
+	if c.EventsAddress != "" && c.EventsNetwork == "" {
+		c.EventsNetwork = "tcp"
+	}
+	if c.AsyncQueueSize > 0 && c.Workers == 0 {
+		c.Workers = 1
+	}
+
+	return c, nil
+}
+
+// missingCred returns the name of the first empty account credential.
+func missingCred(apiKey, sender, sid string) string {
+	switch {
+	case apiKey == "":
+		return "APIKey"
+	case sender == "":
+		return "Sender"
+	case sid == "":
+		return "SID"
+	}
+	return ""
+}
+
+// ID returns the Provider's ID.
+func (s *sms) ID() string {
+	return providerID
+}
+
+// ChannelName returns the name of the configured channel.
+func (s *sms) ChannelName() string {
+	return channels[s.cfg.Channel].name
+}
+
+// AddressName returns the e-mail Provider's address name.
+func (*sms) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (s *sms) ChannelDesc() string {
+	within := ""
+	if s.cfg.CodeValidity > 0 {
+		within = " within " + formatValidity(s.cfg.CodeValidity)
+	}
+
+	out := fmt.Sprintf(`
+		We've sent a %d digit code %s to your mobile.
+		Enter it here%s to verify your mobile number.`, s.cfg.OTPLength, channels[s.cfg.Channel].medium, within)
+
+	if s.cfg.MaxAttempts == 1 {
+		out += " You have 1 try."
+	} else if s.cfg.MaxAttempts > 1 {
+		out += fmt.Sprintf(" You have %d tries.", s.cfg.MaxAttempts)
+	}
+	return out
+}
+
+// AddressDesc returns help text for the phone number.
+func (s *sms) AddressDesc() string {
+	return "Please enter your mobile number"
+}
+
+// UIDescriptor describes the SMS channel and the mobile number input.
+func (s *sms) UIDescriptor() otpgateway.UIDescriptor {
+	return otpgateway.UIDescriptor{
+		ID:             s.ID(),
+		ChannelName:    s.ChannelName(),
+		ChannelDesc:    s.ChannelDesc(),
+		AddressName:    s.AddressName(),
+		AddressDesc:    s.AddressDesc(),
+		AddressPattern: uiAddressPattern,
+		AddressExample: "+919876543210",
+		MaxAddressLen:  s.MaxAddressLen(),
+		MaxOTPLen:      s.MaxOTPLen(),
+	}
+}
+
+// ValidateAddress "validates" a phone number.
+func (s *sms) ValidateAddress(to string) error {
+	_, err := s.normalize(to)
+	return err
+}
+
+// normalize validates a phone number and returns it in the form it
+// should be sent to the API. In strict mode, numbers are validated
+// against the numbering plan and are returned in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (s *sms) normalize(to string) (string, error) {
+	to = otpgateway.ParseRecipient(to).Address
+	if *s.cfg.TreatDoubleZeroAsPlus {
+		to = phone.DoubleZeroToPlus(to)
+	}
+	if s.cfg.StrictValidation {
+		n, err := numplan.ParseStrict(to, s.cfg.DefaultRegion)
+		if err != nil {
+			return "", &NumberError{Err: err}
+		}
+		return n, nil
+	}
+
+	// Qualify numbers without a country code with the default region.
+	to = strings.TrimSpace(to)
+	if !strings.HasPrefix(to, "+") {
+		if s.cfg.DefaultRegion != "" {
+			n, err := numplan.Qualify(to, s.cfg.DefaultRegion)
+			if err != nil {
+				return "", &NumberError{Err: err}
+			}
+			return n, nil
+		}
+
+		// A trunk prefixed national number can't be attributed to a country.
+		if strings.HasPrefix(to, "0") {
+			return "", &NumberError{Err: phone.ErrAmbiguous}
+		}
+	} else {
+		// Strip a trunk prefix entered after the country code.
+		to = numplan.StripTrunkPrefix(to)
+	}
+
+	if !reNum.MatchString(to) {
+		return "", &NumberError{}
+	}
+	return to, nil
+}
+
+// apiNumber returns a normalized number in the form it's sent to the API.
+func (s *sms) apiNumber(to string) string {
+	if s.cfg.StripLeadingPlus {
+		return strings.TrimPrefix(to, "+")
+	}
+	return to
+}
+
+// Push pushes out an SMS. If the async queue is enabled, the SMS is
+// enqueued and Push returns immediately. SMSes have no subject and
+// the subject is unused.
+func (s *sms) Push(otp models.OTP, subject string, body []byte) error {
+	if !s.Enabled() {
+		return otpgateway.ErrProviderDisabled
+	}
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if s.queue != nil {
+		if err := s.checkDestination(otp.To); err != nil {
+			return err
+		}
+		if err := s.acquireCooldown(otp); err != nil {
+			return err
+		}
+		return s.enqueue(job{otp: otp, subject: subject, body: append([]byte(nil), body...), expiry: s.retryExpiry(otp)})
+	}
+
+	_, err := s.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// enqueue adds a job to the async queue without blocking.
+func (s *sms) enqueue(j job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case s.queue <- j:
+	default:
+		return ErrQueueFull
+	}
+
+	if s.pending == 0 {
+		s.drained = make(chan struct{})
+	}
+	s.pending++
+	return nil
+}
+
+// worker sends queued SMSes until the queue is closed. Failures are
+// recorded in the stats and events by PushContext.
+func (s *sms) worker() {
+	defer s.workers.Done()
+
+	for j := range s.queue {
+		ctx := context.WithValue(context.Background(), expiryKey{}, j.expiry)
+		if _, err := s.send(ctx, j.otp, j.subject, j.body); err != nil {
+			log.Printf("%s: error sending queued SMS %s: %v", providerID, j.otp.ID, err)
+		}
+
+		s.mu.Lock()
+		s.pending--
+		if s.pending == 0 {
+			close(s.drained)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Flush waits until all queued SMSes have been sent or ctx is done.
+func (s *sms) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.pending == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	ch := s.drained
+	s.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new SMSes, waits for the queued ones to be
+// sent, and closes the events sink.
+func (s *sms) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.queue != nil {
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	// The workers emit events, so the sink is closed after they're done.
+	s.workers.Wait()
+	if c, ok := s.events.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// PushContext pushes out an SMS and returns the message ID issued by
+// Kaleyra. If UseOTPEndpoint is set, the body is ignored and Kaleyra
+// generates and sends the OTP, and the returned ID is the verify ID.
+func (s *sms) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	if !s.Enabled() {
+		return otpgateway.PushResult{}, otpgateway.ErrProviderDisabled
+	}
+
+	// SMSes have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := s.acquireCooldown(otp); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	return s.send(context.WithValue(ctx, expiryKey{}, s.retryExpiry(otp)), otp, subject, body)
+}
+
+// retryExpiry returns the time after which an OTP pushed now is no
+// longer retried, or the zero time if it's retried regardless of expiry.
+func (s *sms) retryExpiry(otp models.OTP) time.Time {
+	ttl := otpgateway.TTLOf(otp)
+	if ttl <= 0 || s.cfg.RetryAfterExpiry {
+		return time.Time{}
+	}
+	return s.clock.Now().Add(ttl)
+}
+
+// send is PushContext without the cooldown check, which the async
+// queue does when jobs are enqueued.
+func (s *sms) send(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	if s.tracer == nil && s.meter == nil {
+		return s.pushContext(ctx, otp, subject, body)
+	}
+
+	var (
+		span  otpgateway.Span
+		code  int
+		start = time.Now()
+	)
+	ctx = context.WithValue(ctx, statusCodeKey{}, &code)
+	if s.tracer != nil {
+		ctx, span = s.tracer.Start(ctx, otpgateway.SpanPush)
+		defer span.End()
+	}
+
+	res, err := s.pushContext(ctx, otp, subject, body)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	attrs := []otpgateway.Attr{
+		{Key: "otp.provider", Value: providerID},
+		{Key: "otp.channel", Value: s.ChannelName()},
+		{Key: "otp.result", Value: result},
+	}
+	if span != nil {
+		span.SetAttributes(append(attrs,
+			otpgateway.Attr{Key: "otp.recipient", Value: phone.Mask(otp.To)},
+			otpgateway.Attr{Key: "otp.status", Value: res.Status},
+			otpgateway.Attr{Key: "http.status_code", Value: code})...)
+		if res.SLABreached {
+			span.SetAttributes(otpgateway.Attr{Key: "otp.sla_breached", Value: true})
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+	}
+	if s.meter != nil {
+		s.meter.Add(ctx, otpgateway.MetricPushes, 1, attrs...)
+		s.meter.Record(ctx, otpgateway.MetricPushDuration, time.Since(start).Seconds(), attrs...)
+		if res.SLABreached {
+			s.meter.Add(ctx, otpgateway.MetricSLABreaches, 1, attrs...)
+		}
+	}
+	return res, err
+}
+
+// acquireCooldown starts the resend cooldown of the OTP's number in its
+// namespace or returns ErrCooldown if it's cooling down. Numbers are
+// normalized so that different spellings share the cooldown.
+func (s *sms) acquireCooldown(otp models.OTP) error {
+	if s.cooldowns == nil {
+		return nil
+	}
+
+	to, err := s.normalize(otp.To)
+	if err != nil {
+		// Invalid numbers fail in the push.
+		return nil
+	}
+	ok, err := s.cooldowns.Acquire(providerID+":"+otp.Namespace+":"+to, time.Duration(s.cfg.ResendCooldown)*time.Second)
+	if err != nil {
+		return fmt.Errorf("error checking resend cooldown: %w", err)
+	}
+	if !ok {
+		return otpgateway.ErrCooldown
+	}
+	return nil
+}
+
+// SetCooldownStore sets a shared store, eg: Redis, for the resend
+// cooldowns so that they're enforced across gateway instances. It has
+// no effect without ResendCooldown.
+func (s *sms) SetCooldownStore(c otpgateway.CooldownStore) {
+	if s.cfg.ResendCooldown > 0 {
+		s.cooldowns = c
+	}
+}
+
+// pushContext is send without the telemetry.
+func (s *sms) pushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	var (
+		l   = otpgateway.LoggerFromContext(ctx, s.log)
+		tag = fmt.Sprintf("%s: [%s %s]", providerID, otp.ID, phone.Mask(otp.To))
+	)
+	if ref := otpgateway.ReferenceFromContext(ctx); ref != "" {
+		tag = fmt.Sprintf("%s: [%s %s ref=%s]", providerID, otp.ID, phone.Mask(otp.To), ref)
+	}
+
+	if s.suppressed.Contains(otp.To) {
+		l.Printf("%s not sending to suppressed number", tag)
+		return otpgateway.PushResult{}, otpgateway.ErrSuppressed
+	}
+
+	// Test numbers are never sent to.
+	if _, ok := s.TestOTP(otp.To); ok {
+		l.Printf("%s skipping SMS to test number", tag)
+		res := otpgateway.PushResult{ID: "test-" + otp.ID, Status: statusDelivered}
+		s.stats.Success()
+		s.emit(otp, res, time.Now(), nil)
+		return res, nil
+	}
+
+	if err := s.checkDestination(otp.To); err != nil {
+		l.Printf("%s not sending to blocked destination: %v", tag, err)
+		return otpgateway.PushResult{}, err
+	}
+
+	// The OTP API generates the body itself.
+	if !s.cfg.UseOTPEndpoint {
+		b, err := s.makeBody(otp, body)
+		if err != nil {
+			return otpgateway.PushResult{}, err
+		}
+		body = b
+	}
+
+	// Exact duplicates of recently sent SMSes aren't sent again.
+	var dupKey string
+	if s.dedupe != nil {
+		dupKey = s.dedupeKey(otp.To, body)
+		if id, ok := s.dedupe.get(dupKey); ok {
+			l.Printf("%s suppressing duplicate of SMS %s", tag, id)
+			if s.cfg.DedupeSilent {
+				return otpgateway.PushResult{ID: id, Status: statusSent}, nil
+			}
+			return otpgateway.PushResult{}, ErrDuplicateSuppressed
+		}
+	}
+
+	var (
+		start = time.Now()
+		res   otpgateway.PushResult
+		err   error
+
+		// Retries that would be sent after expiry are skipped.
+		expiry, _ = ctx.Value(expiryKey{}).(time.Time)
+	)
+	for attempt := 0; ; attempt++ {
+		if s.cfg.UseOTPEndpoint {
+			res, err = s.pushOTP(ctx, otp)
+		} else {
+			res, err = s.push(ctx, otp, body)
+		}
+		if err == nil || attempt >= s.cfg.MaxRetries || !s.isRetryable(err) {
+			break
+		}
+
+		wait := s.retryWait()
+		if !expiry.IsZero() && !s.clock.Now().Add(wait).Before(expiry) {
+			l.Printf("%s not retrying as the OTP expires before the retry: %v", tag, err)
+			break
+		}
+
+		l.Printf("%s retrying (%d) after error: %v", tag, attempt+1, err)
+		s.stats.Retry()
+		s.emitRetry(otp, err)
+
+		select {
+		case <-s.clock.After(wait):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// The push may yet fail waiting for the delivery, so the stats and
+	// the dedupe entry are recorded after it.
+	var took time.Duration
+	if err == nil {
+		took = time.Since(start)
+		l.Printf("%s sent SMS %s (%s) in %v", tag, res.ID, res.Status, took)
+		if s.cfg.WaitForDelivery {
+			res, err = s.waitForDelivery(ctx, res)
+			l.Printf("%s SMS %s delivery status %s", tag, res.ID, res.Status)
+		}
+	}
+	if err != nil {
+		l.Printf("%s error sending SMS: %v", tag, err)
+		s.stats.Fail(err)
+	} else {
+		if sla := time.Duration(s.cfg.SLATimeout) * time.Millisecond; sla > 0 && took > sla {
+			l.Printf("%s SMS %s breached the SLA of %v", tag, res.ID, sla)
+			res.SLABreached = true
+			s.stats.SLABreach()
+		}
+		if n := s.stats.Success(); n > 0 {
+			l.Printf("%s recovered after %d failed SMSes", tag, n)
+		}
+		if dupKey != "" {
+			s.dedupe.set(dupKey, res.ID, true)
+		}
+	}
+	s.emit(otp, res, start, err)
+	return res, err
+}
+
+// waitForDelivery polls the delivery status of a sent SMS until it's
+// terminal or the DeliveryWaitTimeout elapses and returns the result
+// with the last status.
+func (s *sms) waitForDelivery(ctx context.Context, res otpgateway.PushResult) (otpgateway.PushResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.cfg.DeliveryWaitTimeout)*time.Millisecond)
+	defer cancel()
+
+	t := time.NewTicker(time.Duration(s.cfg.DeliveryPollInterval) * time.Millisecond)
+	defer t.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			res.Status = statusPending
+			if lastErr != nil {
+				return res, fmt.Errorf("%w: %s: last lookup error: %v", ErrDeliveryTimeout, res.ID, lastErr)
+			}
+			return res, fmt.Errorf("%w: %s", ErrDeliveryTimeout, res.ID)
+		}
+
+		// Lookups may fail before the message is indexed, so errors are
+		// retried till the timeout.
+		st, terminal, err := s.lookupStatus(ctx, res.ID)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !terminal {
+			continue
+		}
+
+		s.statuses.set(res.ID, st, true)
+		res.Status = st
+		if st == statusFailed {
+			return res, fmt.Errorf("%w: %s", ErrDeliveryFailed, res.ID)
+		}
+		return res, nil
+	}
+}
+
+// Preview renders the SMS exactly as Push would send it without sending
+// it. With UseOTPEndpoint, Kaleyra generates the body, so the body is
+// empty.
+func (s *sms) Preview(otp models.OTP, subject string, body []byte) (otpgateway.PreviewResult, error) {
+	to, err := s.normalize(otp.To)
+	if err != nil {
+		return otpgateway.PreviewResult{}, err
+	}
+
+	if err := s.checkDestination(to); err != nil {
+		return otpgateway.PreviewResult{}, err
+	}
+
+	res := otpgateway.PreviewResult{
+		To:     to,
+		Sender: s.sender(s.route(otp.Namespace, to), to),
+	}
+	if s.cfg.UseOTPEndpoint {
+		return res, nil
+	}
+
+	b, err := s.makeBody(otp, body)
+	if err != nil {
+		return otpgateway.PreviewResult{}, err
+	}
+	res.Body = string(b)
+	res.Segments = gsm.Segments(res.Body)
+	res.Cost = float64(res.Segments) * s.cfg.CostPerSegment
+	return res, nil
+}
+
+// PushOTP pushes an SMS with the OTP in the DefaultBody, or in "Your
+// verification code is <OTP>" if there's none, to the number. The OTP
+// is validated and the rendered body should be within MaxBodyLen.
+func (s *sms) PushOTP(ctx context.Context, to, otp string) (otpgateway.PushResult, error) {
+	if err := s.ValidateOTP(otp); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	var (
+		o    = models.OTP{To: to, OTP: otp}
+		body []byte
+	)
+	if s.defBody == nil {
+		body = []byte(fmt.Sprintf(defaultOTPBody, otp))
+	}
+
+	// The body is rendered here to check its length. Rendering it again
+	// in the push is a no-op for rendered bodies.
+	b, err := s.makeBody(o, body)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	if n := utf8.RuneCount(b); n > s.MaxBodyLen() {
+		return otpgateway.PushResult{}, fmt.Errorf("%w: %d > %d", ErrBodyTooLong, n, s.MaxBodyLen())
+	}
+	return s.PushContext(ctx, o, "", b)
+}
+
+// makeBody prepares the body for sending, substituting the template of
+// the destination's country from BodyTemplatesByCountry, or the
+// DefaultBody if the body is empty.
+//
+// If GroupOTPDigits is set, the OTP in the body is replaced with its
+// grouped form. The OTP itself (that's stored and verified) is unchanged.
+func (s *sms) makeBody(otp models.OTP, body []byte) ([]byte, error) {
+	var (
+		grouped = s.groupDigits(otp.OTP)
+		empty   = len(bytes.TrimSpace(body)) == 0
+		tpl     = s.countryBody(otp.To)
+	)
+	if tpl == nil && empty {
+		if s.defBody == nil {
+			return nil, ErrEmptyBody
+		}
+		tpl = s.defBody
+	}
+
+	if tpl != nil {
+		var b bytes.Buffer
+		data := otpgateway.NewTemplateContext(otp, s.senderFor(otp.Namespace, otp.To))
+		data.OTP = grouped
+		if err := tpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("error rendering body template: %v", err)
+		}
+		body = b.Bytes()
+	} else if grouped != otp.OTP {
+		body = bytes.Replace(body, []byte(otp.OTP), []byte(grouped), -1)
+	}
+
+	if s.cfg.TransliterateToGSM {
+		body = []byte(gsm.Transliterate(string(body)))
+	}
+
+	// Control characters (eg: NUL from a broken template) are invalid in
+	// SMSes and trip up carriers, especially in JSON payloads.
+	body = stripControlChars(body)
+
+	if err := s.checkCharset(body); err != nil {
+		return nil, err
+	}
+	if err := s.checkContent(otp, body); err != nil {
+		return nil, err
+	}
+
+	// The rendered body, including any OTP digit separators, counts
+	// towards the length. MaxSegments allows multipart bodies instead.
+	if s.cfg.MaxSegments == 0 && len([]rune(string(body))) > s.MaxBodyLen() {
+		return nil, ErrBodyTooLong
+	}
+
+	if s.cfg.MinBodyLen > 0 {
+		if n := len([]rune(string(bytes.TrimSpace(body)))); n < s.cfg.MinBodyLen {
+			return nil, fmt.Errorf("%w: %d < %d", ErrBodyTooShort, n, s.cfg.MinBodyLen)
+		}
+	}
+
+	if s.cfg.MaxSegments > 0 {
+		if n := gsm.Segments(string(body)); n > s.cfg.MaxSegments {
+			return nil, fmt.Errorf("%w: %d > %d", ErrTooManySegments, n, s.cfg.MaxSegments)
+		}
+	}
+	return body, nil
+}
+
+// checkCharset returns ErrUnsupportedCharacter with the first character
+// of the body that can't be encoded in the configured Charset.
+func (s *sms) checkCharset(body []byte) error {
+	i := -1
+	switch s.cfg.Charset {
+	case charsetGSM7:
+		i = gsm.IndexNonGSM7(string(body))
+	case charsetUCS2:
+		i = gsm.IndexNonUCS2(string(body))
+	}
+	if i < 0 {
+		return nil
+	}
+	r, _ := utf8.DecodeRune(body[i:])
+	return fmt.Errorf("%w: %q (U+%04X) at position %d", ErrUnsupportedCharacter, r, r, utf8.RuneCount(body[:i])+1)
+}
+
+// stripControlChars removes the control characters other than newlines,
+// carriage returns, and tabs from the body.
+func stripControlChars(body []byte) []byte {
+	if bytes.IndexFunc(body, isStrippedControl) == -1 {
+		return body
+	}
+	return bytes.Map(func(c rune) rune {
+		if isStrippedControl(c) {
+			return -1
+		}
+		return c
+	}, body)
+}
+
+// isStrippedControl checks whether a character is a control character
+// that's stripped from bodies.
+func isStrippedControl(c rune) bool {
+	return unicode.IsControl(c) && c != '\n' && c != '\r' && c != '\t'
+}
+
+// countryBody returns the body template of the number's country from
+// BodyTemplatesByCountry, if any.
+func (s *sms) countryBody(to string) *template.Template {
+	if s.countryBodies == nil {
+		return nil
+	}
+	n, err := s.normalize(to)
+	if err != nil {
+		return nil
+	}
+	return s.countryBodies[numplan.Region(n)]
+}
+
+// checkOTPTemplate checks that a body template parses and renders the OTP.
+func checkOTPTemplate(body string) error {
+	t, err := template.New("body").Parse(body)
+	if err != nil {
+		return err
+	}
+
+	const probe = "0000000000"
+	var b bytes.Buffer
+	if err := t.Execute(&b, otpgateway.TemplateContext{OTP: probe}); err != nil {
+		return err
+	}
+	if !strings.Contains(b.String(), probe) {
+		return errors.New("template doesn't include {{ .OTP }}")
+	}
+	return nil
+}
+
+// checkContent checks the body, without the OTP, for URLs and the
+// BannedSubstrings.
+func (s *sms) checkContent(otp models.OTP, body []byte) error {
+	if !s.cfg.BlockURLsInBody && len(s.cfg.BannedSubstrings) == 0 {
+		return nil
+	}
+
+	b := string(body)
+	if otp.OTP != "" {
+		b = strings.Replace(b, s.groupDigits(otp.OTP), " ", -1)
+		b = strings.Replace(b, otp.OTP, " ", -1)
+	}
+
+	if s.cfg.BlockURLsInBody && reURL.MatchString(b) {
+		return fmt.Errorf("%w: URL", ErrContentBlocked)
+	}
+	b = strings.ToLower(b)
+	for _, sub := range s.cfg.BannedSubstrings {
+		if sub != "" && strings.Contains(b, strings.ToLower(sub)) {
+			return fmt.Errorf("%w: banned substring %q", ErrContentBlocked, sub)
+		}
+	}
+	return nil
+}
+
+// groupDigits splits the OTP into groups of GroupOTPDigits separated
+// by spaces, eg: 482910 => 482 910.
+func (s *sms) groupDigits(otp string) string {
+	n := s.cfg.GroupOTPDigits
+	if n < 1 || len(otp) <= n {
+		return otp
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(otp); i += n {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		end := i + n
+		if end > len(otp) {
+			end = len(otp)
+		}
+		b.WriteString(otp[i:end])
+	}
+	return b.String()
+}
+
+// TestOTP returns the fixed OTP for a configured test number.
+func (s *sms) TestOTP(to string) (string, bool) {
+	if len(s.cfg.TestNumbers) == 0 {
+		return "", false
+	}
+	otp, ok := s.cfg.TestNumbers[to]
+	return otp, ok
+}
+
+// Verify verifies an OTP generated by Kaleyra's OTP API against
+// the verify ID returned by PushContext.
+// Verifications aren't cached as an OTP can only be verified once.
+func (s *sms) Verify(ctx context.Context, verifyID, code string) error {
+	var p = url.Values{}
+	p.Set("verify_id", verifyID)
+	p.Set("otp", code)
+
+	var r verifyAPIResp
+	if err := s.do(ctx, s.def, "/verify/validate", p, &r); err != nil {
+		return err
+	}
+	if r.Error != nil {
+		if r.Error.Code == "E912" {
+			return ErrOTPMismatch
+		}
+		return fmt.Errorf("verify otp error: %s: %s", r.Error.Code, r.Error.Message)
+	}
+	if r.Code != "" {
+		return fmt.Errorf("verify otp error: %s", r.Code)
+	}
+	return nil
+}
+
+// DeliveryStatus looks up the delivery status (sent, delivered, failed)
+// of a message with the default account.
+func (s *sms) DeliveryStatus(ctx context.Context, id string) (string, error) {
+	if st, ok := s.statuses.get(id); ok {
+		return st, nil
+	}
+
+	st, terminal, err := s.lookupStatus(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	s.statuses.set(id, st, terminal)
+	return st, nil
+}
+
+// lookupStatus looks up the delivery status of a message bypassing the
+// status cache and reports whether it's terminal.
+func (s *sms) lookupStatus(ctx context.Context, id string) (string, bool, error) {
+	var p = url.Values{}
+	p.Set("id", id)
+
+	var r solSMSAPIResp
+	if err := s.doMethod(ctx, http.MethodGet, s.def, "/messages", p, &r); err != nil {
+		return "", false, err
+	}
+	if r.Code != "" {
+		return "", false, newKaleyraError(r)
+	}
+
+	var msgs []struct {
+		ID     messageID `json:"id"`
+		Status string    `json:"status"`
+	}
+	if len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, &msgs); err != nil {
+			return "", false, fmt.Errorf("error parsing status response: %v", err)
+		}
+	}
+	if len(msgs) == 0 {
+		return "", false, ErrMessageNotFound
+	}
+
+	st, terminal := dlrStatuses[strings.ToUpper(msgs[0].Status)]
+	if !terminal {
+		st = statusSent
+	}
+	return st, terminal, nil
+}
+
+// Warmup validates the credentials of the default and the routed
+// accounts by looking up their last message, which doesn't send an SMS.
+func (s *sms) Warmup(ctx context.Context) error {
+	for _, acc := range append([]*account{s.def}, s.accounts...) {
+		var (
+			code int
+			p    = url.Values{}
+			r    solSMSAPIResp
+		)
+		p.Set("limit", "1")
+
+		err := s.doMethod(context.WithValue(ctx, statusCodeKey{}, &code), http.MethodGet, acc, "/messages", p, &r)
+		if code == http.StatusUnauthorized || code == http.StatusForbidden {
+			return fmt.Errorf("%w: SID %s (HTTP %d)", ErrAuthFailed, acc.SID, code)
+		}
+		if err != nil {
+			return fmt.Errorf("error validating SID %s: %w", acc.SID, err)
+		}
+		if r.Code != "" {
+			return fmt.Errorf("error validating SID %s: %w", acc.SID, newKaleyraError(r))
+		}
+		if code < 200 || code > 299 {
+			return fmt.Errorf("error validating SID %s: HTTP %d", acc.SID, code)
+		}
+	}
+	return nil
+}
+
+// push makes the API request to send an SMS.
+func (s *sms) push(ctx context.Context, otp models.OTP, body []byte) (otpgateway.PushResult, error) {
+	to, err := s.normalize(otp.To)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	if err := s.wait(ctx, otp.Namespace, to); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	var (
+		acc  = s.route(otp.Namespace, to)
+		p    = s.payload(s.sender(acc, to), s.apiNumber(to), string(body))
+		path = channels[s.cfg.Channel].path
+	)
+	if ref := otpgateway.ReferenceFromContext(ctx); ref != "" {
+		p.Set("custom", ref)
+	}
+	if m := otpgateway.MetadataFromContext(ctx); m != nil {
+		for i, k := range s.cfg.MetadataFields {
+			if v, ok := m[k]; ok {
+				p.Set(fmt.Sprintf("custom%d", i+1), v)
+			}
+		}
+	}
+
+	ctx, tm := s.withTiming(ctx)
+	ctx, sent := s.withAudit(ctx)
+	ctx, raw := s.withResponseLog(ctx)
+
+	// The status code may already be recorded for the telemetry.
+	code, ok := ctx.Value(statusCodeKey{}).(*int)
+	if !ok {
+		code = new(int)
+		ctx = context.WithValue(ctx, statusCodeKey{}, code)
+	}
+	r := solSMSAPIResp{}
+	err = s.do(ctx, acc, path, p, &r)
+	s.record(sent, acc, path, p, otp, string(r.Id))
+	s.logResponse(ctx, raw, acc, path, p, otp, err != nil || r.Code != "")
+	if err != nil {
+		if errors.Is(err, ErrUnparsableResponse) && !s.cfg.StrictResponseParsing {
+			return s.softSuccess(ctx, otp, err, tm), nil
+		}
+		return otpgateway.PushResult{}, err
+	}
+
+	if r.Code != "" {
+		e := newKaleyraError(r)
+		e.Status = *code
+		return otpgateway.PushResult{}, e
+	}
+
+	// The message was accepted but is pending delivery.
+	if pendingStatuses[strings.ToUpper(r.Status)] {
+		if !s.cfg.AcceptPending {
+			return otpgateway.PushResult{}, fmt.Errorf("send sms pending: %s", r.Status)
+		}
+		return otpgateway.PushResult{ID: string(r.Id), Status: statusQueued, Timing: tm}, nil
+	}
+
+	if r.Id == "" {
+		return otpgateway.PushResult{}, errors.New("send sms id invalid")
+	}
+	if r.Status == "" && s.cfg.StrictResponseParsing {
+		return otpgateway.PushResult{}, fmt.Errorf("%w: no status", ErrUnparsableResponse)
+	}
+
+	return otpgateway.PushResult{ID: string(r.Id), Status: statusSent, Timing: tm}, nil
+}
+
+// payload returns the params of a message on the configured channel.
+func (s *sms) payload(sender, to, body string) url.Values {
+	p := url.Values{}
+	s.setRecipients(p, []string{to})
+
+	switch s.cfg.Channel {
+	case "whatsapp":
+		p.Set("channel", "whatsapp")
+		p.Set("from", sender)
+		p.Set("type", "text")
+		p.Set("body", body)
+	case "voice":
+		// The text is read out to the recipient.
+		t, _ := json.Marshal([]map[string]map[string]string{{"message": {"text": body}}})
+		p.Set("bridge", sender)
+		p.Set("target", string(t))
+	default:
+		p.Set("sender", sender)
+		p.Set("body", body)
+		if *s.cfg.DisableLinkTracking {
+			p.Set("shorten_url", "0")
+		}
+	}
+	return p
+}
+
+// setRecipients sets the to param to the list of numbers serialized as
+// per the RecipientFormat. A scalar to only takes the first number.
+// Arrays are set as multiple values that encode() writes as a JSON
+// array and forms as repeated params.
+func (s *sms) setRecipients(p url.Values, to []string) {
+	if len(to) == 0 {
+		return
+	}
+	switch s.cfg.RecipientFormat {
+	case recipientsComma:
+		p.Set("to", strings.Join(to, ","))
+	case recipientsArray:
+		p["to"] = append([]string(nil), to...)
+	default:
+		p.Set("to", to[0])
+	}
+}
+
+// recipients returns the numbers in the to param of a request.
+func (s *sms) recipients(p url.Values) []string {
+	if s.cfg.RecipientFormat == recipientsComma {
+		if to := p.Get("to"); to != "" {
+			return strings.Split(to, ",")
+		}
+		return nil
+	}
+	return p["to"]
+}
+
+// retryWait returns the RetryWait with the configured jitter applied.
+func (s *sms) retryWait() time.Duration {
+	d := time.Duration(s.cfg.RetryWait) * time.Millisecond
+	if d <= 0 {
+		return 0
+	}
+
+	switch s.cfg.RetryJitter {
+	case jitterFull:
+		return time.Duration(rand.Int63n(int64(d) + 1))
+	case jitterEqual:
+		return d/2 + time.Duration(rand.Int63n(int64(d-d/2)+1))
+	}
+	return d
+}
+
+// dedupeKey returns the dedupe hash of an SMS to a number.
+func (s *sms) dedupeKey(to string, body []byte) string {
+	if n, err := s.normalize(to); err == nil {
+		to = n
+	}
+	h := sha256.New()
+	h.Write([]byte(to))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// softSuccess logs a warning about a 2xx response that couldn't be
+// interpreted and returns a sent result without a message ID, as the
+// SMS was likely accepted.
+func (s *sms) softSuccess(ctx context.Context, otp models.OTP, err error, tm *otpgateway.RequestTiming) otpgateway.PushResult {
+	otpgateway.LoggerFromContext(ctx, s.log).Printf("%s: [%s %s] WARNING: treating 2xx response as sent: %v",
+		providerID, otp.ID, phone.Mask(otp.To), err)
+	return otpgateway.PushResult{Status: statusSent, Timing: tm}
+}
+
+// pushOTP makes the API request to Kaleyra's OTP API to generate
+// and send an OTP.
+func (s *sms) pushOTP(ctx context.Context, otp models.OTP) (otpgateway.PushResult, error) {
+	to, err := s.normalize(otp.To)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	if err := s.wait(ctx, otp.Namespace, to); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	var (
+		acc = s.route(otp.Namespace, to)
+		p   = url.Values{}
+	)
+	p.Set("sender", s.sender(acc, to))
+	s.setRecipients(p, []string{s.apiNumber(to)})
+
+	ctx, tm := s.withTiming(ctx)
+	ctx, sent := s.withAudit(ctx)
+	var r verifyAPIResp
+	err = s.do(ctx, acc, "/verify", p, &r)
+	s.record(sent, acc, "/verify", p, otp, string(r.Data.VerifyID))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	if r.Error != nil {
+		return otpgateway.PushResult{}, fmt.Errorf("send otp error: %s: %s", r.Error.Code, r.Error.Message)
+	}
+	if r.Code != "" {
+		return otpgateway.PushResult{}, fmt.Errorf("send otp error: %s", r.Code)
+	}
+	if r.Data.VerifyID == "" {
+		return otpgateway.PushResult{}, errors.New("send otp verify id invalid")
+	}
+
+	return otpgateway.PushResult{ID: string(r.Data.VerifyID), Status: statusSent, Timing: tm}, nil
+}
+
+// route returns the account that an SMS of the given namespace to
+// the given number is sent from. Namespace rules take precedence over
+// country rules.
+func (s *sms) route(namespace, to string) *account {
+	if len(s.accounts) == 0 {
+		return s.def
+	}
+	for _, a := range s.accounts {
+		for _, n := range a.Namespaces {
+			if n == namespace {
+				return a
+			}
+		}
+	}
+
+	region := numplan.Region(to)
+	if region == "" {
+		return s.def
+	}
+	for _, a := range s.accounts {
+		for _, c := range a.Countries {
+			if c == region {
+				return a
+			}
+		}
+	}
+	return s.def
+}
+
+// checkDestination checks a number against the BlockedPrefixes,
+// BlockedCountries, and AllowedCountries. Numbers whose country can't
+// be determined are blocked if AllowedCountries is set.
+func (s *sms) checkDestination(to string) error {
+	if len(s.cfg.BlockedPrefixes) == 0 && len(s.cfg.BlockedCountries) == 0 && len(s.cfg.AllowedCountries) == 0 {
+		return nil
+	}
+
+	n, err := s.normalize(to)
+	if err != nil {
+		// Invalid numbers fail in the push.
+		return nil
+	}
+	if !strings.HasPrefix(n, "+") {
+		n = "+" + n
+	}
+	for _, p := range s.cfg.BlockedPrefixes {
+		if strings.HasPrefix(n, p) {
+			return fmt.Errorf("%w: prefix %s", ErrCountryBlocked, p)
+		}
+	}
+
+	region := numplan.Region(n)
+	for _, c := range s.cfg.BlockedCountries {
+		if c == region {
+			return fmt.Errorf("%w: %s", ErrCountryBlocked, region)
+		}
+	}
+	if len(s.cfg.AllowedCountries) == 0 {
+		return nil
+	}
+	for _, c := range s.cfg.AllowedCountries {
+		if c == region {
+			return nil
+		}
+	}
+	if region == "" {
+		region = "unknown country"
+	}
+	return fmt.Errorf("%w: %s not allowed", ErrCountryBlocked, region)
+}
+
+// sender returns the account's sender for a number, or the
+// NumericFallbackSender if the sender is alphanumeric and the number's
+// country doesn't allow alphanumeric senders.
+func (s *sms) sender(acc *account, to string) string {
+	if s.cfg.NumericFallbackSender == "" || !isAlphanumeric(acc.Sender) {
+		return acc.Sender
+	}
+
+	region := numplan.Region(to)
+	for _, c := range s.cfg.NumericSenderCountries {
+		if c == region {
+			return s.cfg.NumericFallbackSender
+		}
+	}
+	if s.alphaSwitched(region) {
+		return s.cfg.NumericFallbackSender
+	}
+	return acc.Sender
+}
+
+// alphaSwitched checks whether SMSes to the country have been switched
+// to the NumericFallbackSender after failing from alphanumeric senders.
+// Store errors keep the alphanumeric sender.
+func (s *sms) alphaSwitched(region string) bool {
+	if s.senderFails == nil || region == "" {
+		return false
+	}
+	n, err := s.senderFails.Get(providerID + ":alpha-switch:" + region)
+	if err != nil {
+		s.log.Printf("%s: error checking the sender of %s: %v", providerID, region, err)
+		return false
+	}
+	return n > 0
+}
+
+// alphaFailed counts a failed delivery to a number and switches its
+// country to the NumericFallbackSender for AdaptiveSenderCooldown once
+// the failures reach AdaptiveSenderThreshold. Only failures of SMSes
+// that are currently sent from an alphanumeric sender are counted.
+func (s *sms) alphaFailed(to string) {
+	if s.senderFails == nil {
+		return
+	}
+
+	// Numbers in callbacks don't have the +.
+	if !strings.HasPrefix(to, "+") {
+		to = "+" + to
+	}
+	region := numplan.Region(to)
+	if region == "" || !isAlphanumeric(s.sender(s.route("", to), to)) {
+		return
+	}
+
+	ttl := time.Duration(s.cfg.AdaptiveSenderCooldown) * time.Second
+	n, err := s.senderFails.Incr(providerID+":alpha-fail:"+region, ttl)
+	if err != nil {
+		s.log.Printf("%s: error counting the failed delivery to %s: %v", providerID, region, err)
+		return
+	}
+	if n < s.cfg.AdaptiveSenderThreshold {
+		return
+	}
+
+	// The failure count started before the switch, so it expires
+	// before the switch does and the alphanumeric sender starts afresh.
+	if _, err := s.senderFails.Incr(providerID+":alpha-switch:"+region, ttl); err != nil {
+		s.log.Printf("%s: error switching the sender of %s: %v", providerID, region, err)
+		return
+	}
+	if n == s.cfg.AdaptiveSenderThreshold {
+		s.log.Printf("%s: %d failed deliveries to %s from alphanumeric senders. Sending from %s for %v",
+			providerID, n, region, s.cfg.NumericFallbackSender, ttl)
+	}
+}
+
+// isAlphanumeric checks whether a sender has anything other than
+// digits and a leading +.
+func isAlphanumeric(sender string) bool {
+	for _, c := range strings.TrimPrefix(sender, "+") {
+		if c < '0' || c > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// withResponseLog returns a context that records the response of the
+// request made with it if responses are logged.
+func (s *sms) withResponseLog(ctx context.Context) (context.Context, *apiResponse) {
+	if s.cfg.ResponseLogSampleRate == 0 {
+		return ctx, nil
+	}
+	r := &apiResponse{}
+	return context.WithValue(ctx, responseKey{}, r), r
+}
+
+// logResponse logs the response of a request made to the given API path
+// with the params if it failed or is sampled. The recipient is masked
+// and the OTP and the API key are redacted.
+func (s *sms) logResponse(ctx context.Context, r *apiResponse, acc *account, path string, p url.Values, otp models.OTP, failed bool) {
+	if r == nil {
+		return
+	}
+	failed = failed || r.status < 200 || r.status > 299
+	if !failed && rand.Float64() >= s.cfg.ResponseLogSampleRate {
+		return
+	}
+
+	// Numbers may be echoed with or without the +.
+	var rep []string
+	for _, n := range s.recipients(p) {
+		rep = append(rep, n, phone.Mask(n))
+		if n = strings.TrimPrefix(n, "+"); n != "" {
+			rep = append(rep, n, phone.Mask(n))
+		}
+	}
+	if otp.OTP != "" {
+		mask := strings.Repeat("*", len(otp.OTP))
+		rep = append(rep, s.groupDigits(otp.OTP), s.groupDigits(mask), otp.OTP, mask)
+	}
+	if acc.APIKey != "" {
+		rep = append(rep, acc.APIKey, otpgateway.Redacted)
+	}
+	body := strings.NewReplacer(rep...).Replace(string(r.body))
+
+	otpgateway.LoggerFromContext(ctx, s.log).Printf("%s: [%s %s] %s response (HTTP %d, failed=%v): %s",
+		providerID, otp.ID, phone.Mask(otp.To), path, r.status, failed, body)
+}
+
+// withAudit returns a context that records the request made with it
+// for the audit record if there's an AuditSink.
+func (s *sms) withAudit(ctx context.Context) (context.Context, *sentRequest) {
+	if s.audit == nil {
+		return ctx, nil
+	}
+	sr := &sentRequest{}
+	return context.WithValue(ctx, sentKey{}, sr), sr
+}
+
+// record emits the audit record of a request made to the given API
+// path with the params to the AuditSink. The recipient is masked and
+// the OTP in the body is replaced with asterisks. Requests that
+// weren't made (eg: failed signing) aren't recorded.
+func (s *sms) record(sr *sentRequest, acc *account, path string, p url.Values, otp models.OTP, msgID string) {
+	if sr == nil || sr.header == nil {
+		return
+	}
+
+	m := make(url.Values, len(p))
+	for k, v := range p {
+		m[k] = append([]string(nil), v...)
+	}
+	if to := s.recipients(m); len(to) > 0 {
+		masked := make([]string, len(to))
+		for i, n := range to {
+			masked[i] = phone.Mask(n)
+		}
+		delete(m, "to")
+		s.setRecipients(m, masked)
+	}
+	// Voice calls carry the text in the target.
+	for _, k := range []string{"body", "target"} {
+		if b := m.Get(k); b != "" && otp.OTP != "" {
+			mask := strings.Repeat("*", len(otp.OTP))
+			b = strings.Replace(b, s.groupDigits(otp.OTP), s.groupDigits(mask), -1)
+			m.Set(k, strings.Replace(b, otp.OTP, mask, -1))
+		}
+	}
+
+	// GET params are sent in the query string and recorded as a form.
+	var body string
+	if s.cfg.HTTPMethod == http.MethodGet {
+		body = m.Encode()
+	} else {
+		b, _, err := s.encode(m)
+		if err != nil {
+			return
+		}
+		body = b.buf.String()
+		b.Close()
+	}
+
+	s.audit.Record(otpgateway.SentRecord{
+		Provider:  providerID,
+		Endpoint:  acc.rootURL + path,
+		Method:    s.cfg.HTTPMethod,
+		Headers:   sr.header,
+		Body:      body,
+		Timestamp: sr.at,
+		MessageID: msgID,
+	})
+}
+
+// withTiming returns a context that records the timing of the request
+// made with it and the timing it's recorded to if DetailedTiming is
+// enabled. The timing is complete once the request returns.
+func (s *sms) withTiming(ctx context.Context) (context.Context, *otpgateway.RequestTiming) {
+	if !s.cfg.DetailedTiming {
+		return ctx, nil
+	}
+	t := &timing{}
+	return context.WithValue(ctx, timingKey{}, t), &t.res
+}
+
+// trace adds the timing hooks to a trace and starts the timing.
+func (t *timing) trace(tr *httptrace.ClientTrace) {
+	t.mu.Lock()
+	t.start = time.Now()
+	t.mu.Unlock()
+
+	tr.DNSStart = func(httptrace.DNSStartInfo) { t.mark(&t.dns) }
+	tr.DNSDone = func(httptrace.DNSDoneInfo) { t.since(&t.dns, &t.res.DNS) }
+	tr.ConnectStart = func(string, string) { t.mark(&t.conn) }
+	tr.ConnectDone = func(_, _ string, err error) {
+		if err == nil {
+			t.since(&t.conn, &t.res.Connect)
+		}
+	}
+	tr.TLSHandshakeStart = func() { t.mark(&t.tls) }
+	tr.TLSHandshakeDone = func(tls.ConnectionState, error) { t.since(&t.tls, &t.res.TLS) }
+	tr.GotConn = func(i httptrace.GotConnInfo) {
+		t.mu.Lock()
+		t.res.Reused = i.Reused
+		t.mu.Unlock()
+	}
+	tr.GotFirstResponseByte = func() { t.since(&t.start, &t.res.FirstByte) }
+}
+
+// mark records the start of a phase.
+func (t *timing) mark(at *time.Time) {
+	t.mu.Lock()
+	*at = time.Now()
+	t.mu.Unlock()
+}
+
+// since records the duration of a phase that started at start.
+func (t *timing) since(start *time.Time, d *time.Duration) {
+	t.mu.Lock()
+	*d = time.Since(*start)
+	t.mu.Unlock()
+}
+
+// done records the total duration of the request.
+func (t *timing) done() {
+	t.since(&t.start, &t.res.Total)
+}
+
+// pickEncoding returns the explicit request encoding if it's set or
+// the default encoding of the API version.
+func pickEncoding(version, enc string) (string, error) {
+	switch enc {
+	case encodingForm, encodingJSON:
+		return enc, nil
+	case "":
+	default:
+		return "", &ConfigError{Field: "Encoding", Reason: "unknown encoding " + enc}
+	}
+
+	enc, ok := versionEncodings[version]
+	if !ok {
+		return "", &ConfigError{Field: "APIVersion", Reason: "unknown version " + version}
+	}
+	return enc, nil
+}
+
+// do makes a POST request with the params encoded as per the configured
+// encoding to the given API path of an account and unmarshals the JSON
+// response into out.
+func (s *sms) do(ctx context.Context, acc *account, path string, p url.Values, out interface{}) error {
+	return s.doMethod(ctx, s.cfg.HTTPMethod, acc, path, p, out)
+}
+
+// doMethod is do with an explicit HTTP method.
+func (s *sms) doMethod(ctx context.Context, method string, acc *account, path string, p url.Values, out interface{}) error {
+	var (
+		req *http.Request
+
+		// Payload that's signed.
+		signed []byte
+	)
+	if method == http.MethodGet {
+		// GET requests have no body and the params are always
+		// sent in the query string.
+		q := p.Encode()
+		r, err := http.NewRequest(http.MethodGet, acc.rootURL+path+"?"+q, nil)
+		if err != nil {
+			return err
+		}
+		req = r
+		signed = []byte(q)
+	} else {
+		body, ctype, err := s.encode(p)
+		if err != nil {
+			return err
+		}
+		r, err := http.NewRequest(method, acc.rootURL+path, body)
+		if err != nil {
+			body.Close()
+			return err
+		}
+		r.ContentLength = int64(body.Len())
+		r.Header.Set("Content-Type", ctype)
+		req = r
+		signed = body.buf.Bytes()
+	}
+	key := acc.APIKey
+	if acc == s.def && s.creds != nil {
+		k, err := s.creds.get(ctx)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return fmt.Errorf("error fetching API key: %w", err)
+		}
+		key = k
+	}
+	if key != "" {
+		req.Header.Set("api-key", key)
+	}
+	if s.cfg.SigningSecret != "" {
+		if err := s.sign(req, signed); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return err
+		}
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Trace whether the request was written fully to distinguish
+	// write side failures from response side failures.
+	var wrote int32
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(i httptrace.WroteRequestInfo) {
+			if i.Err == nil {
+				atomic.StoreInt32(&wrote, 1)
+			}
+		},
+	}
+	tm, _ := ctx.Value(timingKey{}).(*timing)
+	if tm != nil {
+		tm.trace(trace)
+		defer tm.done()
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+	if sr, ok := ctx.Value(sentKey{}).(*sentRequest); ok {
+		sr.header = otpgateway.RedactHeaders(req.Header, "api-key", "Authorization")
+		sr.at = time.Now()
+	}
+
+	resp, err := s.h.Do(req)
+	if err != nil {
+		return &RequestError{Err: err, Sent: atomic.LoadInt32(&wrote) == 1}
+	}
+	defer resp.Body.Close()
+	if c, ok := ctx.Value(statusCodeKey{}).(*int); ok {
+		*c = resp.StatusCode
+	}
+
+	// The key may have been rotated before the cached copy expired.
+	if resp.StatusCode == http.StatusUnauthorized && acc == s.def && s.creds != nil {
+		s.creds.invalidate()
+	}
+
+	// As Accept-Encoding is set explicitly, the transport doesn't
+	// decompress the response.
+	var rd io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error decompressing response: %v", err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+
+	// Read the response into a pooled buffer. Unmarshal copies the
+	// values, so the buffer can be reused right after.
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	defer bufPool.Put(b)
+	if _, err := b.ReadFrom(rd); err != nil {
+		return err
+	}
+	if r, ok := ctx.Value(responseKey{}).(*apiResponse); ok {
+		r.status = resp.StatusCode
+		r.body = append([]byte(nil), b.Bytes()...)
+	}
+
+	// We now unmarshal the body.
+	if err := json.Unmarshal(b.Bytes(), out); err != nil {
+		if b.Len() > 0 && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return fmt.Errorf("%w (HTTP %d): %v", ErrUnparsableResponse, resp.StatusCode, err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("%w (HTTP %d): %v", otpgateway.ErrRateLimited, resp.StatusCode, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// bufPool is a pool of buffers for encoding requests and reading
+// responses.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// pooledBody is a request body backed by a pooled buffer that's
+// returned to the pool when the transport closes the body, which
+// may happen after the request returns.
+type pooledBody struct {
+	*bytes.Reader
+	buf    *bytes.Buffer
+	closed int32
+}
+
+// Close returns the buffer to the pool.
+func (b *pooledBody) Close() error {
+	if atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		bufPool.Put(b.buf)
+	}
+	return nil
+}
+
+// encode encodes the params as a form or JSON as per the configured
+// encoding into a pooled request body and returns it with the
+// content type.
+func (s *sms) encode(p url.Values) (*pooledBody, string, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	// Params are written in the sorted order of the keys
+	// like url.Values.Encode().
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ctype := "application/x-www-form-urlencoded"
+	if s.cfg.Encoding == encodingJSON {
+		ctype = "application/json"
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			var v interface{} = p.Get(k)
+			if k == "to" && s.cfg.RecipientFormat == recipientsArray {
+				v = p[k]
+			}
+			kb, _ := json.Marshal(k)
+			vb, err := json.Marshal(v)
+			if err != nil {
+				bufPool.Put(buf)
+				return nil, "", err
+			}
+			buf.Write(kb)
+			buf.WriteByte(':')
+			buf.Write(vb)
+		}
+		buf.WriteByte('}')
+	} else {
+		for _, k := range keys {
+			ek := url.QueryEscape(k)
+			for _, v := range p[k] {
+				if buf.Len() > 0 {
+					buf.WriteByte('&')
+				}
+				buf.WriteString(ek)
+				buf.WriteByte('=')
+				buf.WriteString(url.QueryEscape(v))
+			}
+		}
+	}
+
+	return &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}, ctype, nil
+}
+
+// resolver looks up the IPs of a host. *net.Resolver implements it.
+type resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsCache dials connections to hosts using their cached IPs and
+// resolves them again with the system resolver once they expire.
+type dnsCache struct {
+	res    resolver
+	ttl    time.Duration
+	clock  clock.Clock
+	dialer *net.Dialer
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []net.IPAddr
+	expires time.Time
+}
+
+func newDNSCache(ttl time.Duration, d *net.Dialer) *dnsCache {
+	return &dnsCache{
+		res:     net.DefaultResolver,
+		ttl:     ttl,
+		clock:   clock.Real,
+		dialer:  d,
+		entries: make(map[string]dnsEntry),
+	}
+}
+
+// lookup returns the cached IPs of the host or resolves them.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(e.expires) {
+		return e.ips, nil
+	}
+
+	ips, err := c.res.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{ips: ips, expires: c.clock.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return ips, nil
+}
+
+// DialContext dials addr trying each of the host's IPs in order.
+// If none of them can be dialed, the host is evicted from the cache
+// so that the next dial resolves it again.
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = c.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.entries, host)
+	c.mu.Unlock()
+	return nil, err
+}
+
+// credCache caches the API key fetched from a CredentialProvider.
+type credCache struct {
+	p     otpgateway.CredentialProvider
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	key     string
+	expires time.Time
+}
+
+// get returns the cached key or fetches it if it has expired.
+// Concurrent requests wait for a single fetch.
+func (c *credCache) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if c.key != "" && now.Before(c.expires) {
+		return c.key, nil
+	}
+
+	key, err := c.p.APIKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", errors.New("empty API key")
+	}
+	c.key = key
+	c.expires = now.Add(c.ttl)
+	return key, nil
+}
+
+// invalidate discards the cached key.
+func (c *credCache) invalidate() {
+	c.mu.Lock()
+	c.key = ""
+	c.mu.Unlock()
+}
+
+// sign attaches the signature headers of the configured scheme
+// to the request.
+func (s *sms) sign(req *http.Request, payload []byte) error {
+	secret := []byte(s.cfg.SigningSecret)
+	if s.cfg.SigningScheme == signingWebhook {
+		return webhook.SignRequest(req, secret, payload)
+	}
+
+	ts := strconv.FormatInt(s.clock.Now().Unix(), 10)
+	req.Header.Set(headerTimestamp, ts)
+	req.Header.Set(headerSignature, signHMAC(secret, ts, payload))
+	return nil
+}
+
+// signHMAC returns the hex HMAC-SHA256 of the timestamp followed
+// by the payload.
+func signHMAC(secret []byte, ts string, payload []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(ts))
+	m.Write(payload)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// rateLimiter paces pushes with a token bucket per destination country
+// and a shared one for the other countries. A nil limiter doesn't limit.
+type rateLimiter struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	def     *bucket
+	buckets map[string]*bucket
+}
+
+// bucket is a token bucket that refills at rate tokens per second up to
+// a burst of rate tokens. tokens go negative when pushes are waiting.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for the default rate and the rates by
+// calling code or nil if there are no limits. 0 = unlimited.
+func newRateLimiter(def int, byCountry map[string]int) *rateLimiter {
+	l := &rateLimiter{
+		clock:   clock.Real,
+		buckets: make(map[string]*bucket, len(byCountry)),
+	}
+	if def > 0 {
+		l.def = &bucket{rate: float64(def), tokens: float64(def)}
+	}
+	for cc, r := range byCountry {
+		// An explicit 0 exempts the country from the default rate.
+		var b *bucket
+		if r > 0 {
+			b = &bucket{rate: float64(r), tokens: float64(r)}
+		}
+		l.buckets[cc] = b
+	}
+	if l.def == nil && len(byCountry) == 0 {
+		return nil
+	}
+	return l
+}
+
+// reserve takes a token from the bucket of the calling code and returns
+// how long to wait before sending.
+func (l *rateLimiter) reserve(cc string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[cc]
+	if !ok {
+		b = l.def
+	}
+	if b == nil {
+		return 0
+	}
+	return b.take(l.clock.Now())
+}
+
+// take refills the bucket, takes a token from it, and returns how long
+// to wait for the token.
+func (b *bucket) take(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refill adds the tokens accrued since the last refill.
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+}
+
+// wait blocks until a push to the calling code is within the rate
+// or the context is done.
+func (l *rateLimiter) wait(ctx context.Context, cc string) error {
+	if l == nil {
+		return nil
+	}
+	return sleep(ctx, l.clock, l.reserve(cc))
+}
+
+// wait waits for the push's turn under the namespace's rate and then,
+// the destination country's, so that pushes held up by the namespace
+// rate don't take up the country's shared budget.
+func (s *sms) wait(ctx context.Context, namespace, to string) error {
+	if err := s.nsLimiter.wait(ctx, namespace); err != nil {
+		return err
+	}
+	return s.limiter.wait(ctx, numplan.CallingCode(to))
+}
+
+// sleep waits for d or until the context is done.
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) error {
+	if d == 0 {
+		return nil
+	}
+
+	select {
+	case <-clk.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nsLimiter paces pushes with a token bucket per namespace so that one
+// namespace can't exhaust the rates shared by all. Buckets that have
+// been idle and full for nsBucketIdle are evicted. A nil limiter
+// doesn't limit.
+type nsLimiter struct {
+	rate  float64
+	clock clock.Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newNSLimiter returns a namespace limiter for the rate per second or
+// nil if it's 0 (unlimited).
+func newNSLimiter(rate int) *nsLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &nsLimiter{
+		rate:    float64(rate),
+		clock:   clock.Real,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// reserve takes a token from the namespace's bucket and returns how long
+// to wait before sending.
+func (l *nsLimiter) reserve(ns string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.lastSweep) >= nsBucketIdle {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[ns]
+	if !ok {
+		b = &bucket{rate: l.rate, tokens: l.rate}
+		l.buckets[ns] = b
+	}
+	return b.take(now)
+}
+
+// sweep evicts the buckets that have been idle for nsBucketIdle and
+// have refilled, which are the same as new ones.
+func (l *nsLimiter) sweep(now time.Time) {
+	for ns, b := range l.buckets {
+		if now.Sub(b.last) < nsBucketIdle {
+			continue
+		}
+		b.refill(now)
+		if b.tokens >= b.rate {
+			delete(l.buckets, ns)
+		}
+	}
+	l.lastSweep = now
+}
+
+// wait blocks until a push of the namespace is within the rate or the
+// context is done.
+func (l *nsLimiter) wait(ctx context.Context, ns string) error {
+	if l == nil {
+		return nil
+	}
+	return sleep(ctx, l.clock, l.reserve(ns))
+}
+
+// statusCache caches statuses by ID. Terminal statuses don't change
+// and are cached for longer than the in-flight ones. A nil cache
+// caches nothing.
+type statusCache struct {
+	ttl        time.Duration
+	pendingTTL time.Duration
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]statusEntry
+}
+
+type statusEntry struct {
+	status  string
+	expires time.Time
+}
+
+// newStatusCache returns a status cache or nil if both the TTLs are 0.
+func newStatusCache(ttl, pendingTTL time.Duration) *statusCache {
+	if ttl == 0 && pendingTTL == 0 {
+		return nil
+	}
+	return &statusCache{
+		ttl:        ttl,
+		pendingTTL: pendingTTL,
+		clock:      clock.Real,
+		entries:    make(map[string]statusEntry),
+	}
+}
+
+// get returns the unexpired status of an ID.
+func (c *statusCache) get(id string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return "", false
+	}
+	if !c.clock.Now().Before(e.expires) {
+		delete(c.entries, id)
+		return "", false
+	}
+	return e.status, true
+}
+
+// set caches the status of an ID with the TTL for its kind.
+func (c *statusCache) set(id, status string, terminal bool) {
+	if c == nil {
+		return
+	}
+	ttl := c.pendingTTL
+	if terminal {
+		ttl = c.ttl
+	}
+	if ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if len(c.entries) >= maxStatusCacheSize {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxStatusCacheSize {
+			return
+		}
+	}
+	c.entries[id] = statusEntry{status: status, expires: now.Add(ttl)}
+}
+
+// isRetryable tells if an error is a Kaleyra error whose code or
+// message is one of the RetryableErrorCodes.
+func (s *sms) isRetryable(err error) bool {
+	if len(s.retryCodes) == 0 {
+		return false
+	}
+
+	var e *KaleyraError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return s.retryCodes[strings.ToUpper(e.Code)] || s.retryCodes[strings.ToUpper(e.Detail)]
+}
+
+// ParseDLR parses a delivery report posted by Kaleyra to the callback
+// URL. The custom reference sent with the message is returned in
+// DLR.Reference.
+func ParseDLR(r *http.Request) (DLR, error) {
+	if err := r.ParseForm(); err != nil {
+		return DLR{}, err
+	}
+	return parseDLR(r.Form)
+}
+
+// parseDLR parses the params of a delivery report.
+func parseDLR(f url.Values) (DLR, error) {
+	d := DLR{
+		ID:        f.Get("id"),
+		To:        f.Get("to"),
+		Status:    f.Get("status"),
+		Error:     f.Get("error"),
+		Reference: f.Get("custom"),
+	}
+	if d.ID == "" {
+		return d, errors.New("invalid DLR: no message id")
+	}
+	return d, nil
+}
+
+// formatValidity formats a duration in seconds as a human readable
+// string, eg: "5 minutes".
+func formatValidity(sec int) string {
+	var (
+		n    = sec
+		unit = "second"
+	)
+	if sec >= 3600 && sec%3600 == 0 {
+		n, unit = sec/3600, "hour"
+	} else if sec >= 60 && sec%60 == 0 {
+		n, unit = sec/60, "minute"
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// emit emits a send or fail event to the events sink, if there's one.
+func (s *sms) emit(otp models.OTP, res otpgateway.PushResult, start time.Time, err error) {
+	if s.events == nil {
+		return
+	}
+
+	e := otpgateway.Event{
+		Type:      otpgateway.EventSend,
+		Provider:  providerID,
+		Namespace: otp.Namespace,
+		ID:        otp.ID,
+		MessageID: res.ID,
+		Duration:  float64(time.Since(start)) / float64(time.Millisecond),
+		Timestamp: start,
+	}
+	if err != nil {
+		e.Type = otpgateway.EventFail
+		e.Error = err.Error()
+	}
+	s.events.Emit(e)
+}
+
+// emitRetry emits a retry event.
+func (s *sms) emitRetry(otp models.OTP, err error) {
+	if s.events == nil {
+		return
+	}
+
+	s.events.Emit(otpgateway.Event{
+		Type:      otpgateway.EventRetry,
+		Provider:  providerID,
+		Namespace: otp.Namespace,
+		ID:        otp.ID,
+		Error:     err.Error(),
+		Timestamp: time.Now(),
+	})
+}
+
+// ParseWebhook parses Kaleyra's delivery report and inbound SMS
+// callbacks sent as query params, a form, or JSON. Kaleyra doesn't sign
+// callbacks, so they're authenticated by the CallbackToken in the
+// callback URL. Inbound SMSes other than opt-outs are ignored.
+func (s *sms) ParseWebhook(r *http.Request, body []byte) ([]otpgateway.Event, error) {
+	q := r.URL.Query()
+	if s.cfg.CallbackToken == "" || !hmac.Equal([]byte(q.Get("token")), []byte(s.cfg.CallbackToken)) {
+		return nil, otpgateway.ErrWebhookUnauthorized
+	}
+
+	switch {
+	case len(bytes.TrimSpace(body)) == 0:
+	case strings.HasPrefix(r.Header.Get("Content-Type"), "application/json"):
+		// JSON callbacks are mapped to params so that they're parsed the
+		// same way. IDs may be numbers.
+		var f map[string]messageID
+		if err := json.Unmarshal(body, &f); err != nil {
+			return nil, fmt.Errorf("error parsing callback: %v", err)
+		}
+		for k, v := range f {
+			q.Set(k, string(v))
+		}
+	default:
+		f, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing callback: %v", err)
+		}
+		for k, v := range f {
+			q[k] = v
+		}
+	}
+
+	// Inbound SMSes carry the subscriber's number in mobile.
+	if msg, mobile := q.Get("message"), q.Get("mobile"); msg != "" && mobile != "" {
+		if !otpgateway.IsOptOut(msg) {
+			return nil, nil
+		}
+		return []otpgateway.Event{{Type: otpgateway.EventOptOut, Address: mobile}}, nil
+	}
+
+	d, err := parseDLR(q)
+	if err != nil || d.Status == "" {
+		return nil, errors.New("unknown callback")
+	}
+	st, ok := dlrStatuses[strings.ToUpper(d.Status)]
+	if !ok {
+		st = statusSent
+	}
+	if st == statusFailed && d.To != "" {
+		s.alphaFailed(d.To)
+	}
+	return []otpgateway.Event{{
+		Type:      otpgateway.EventDelivery,
+		MessageID: d.ID,
+		Status:    st,
+		Error:     d.Error,
+		Address:   d.To,
+		Reference: d.Reference,
+	}}, nil
+}
+
+// LoadSuppressionCSV loads numbers from the first column of a CSV into
+// the Provider's suppression list. It's safe to call while sending.
+func (s *sms) LoadSuppressionCSV(r io.Reader) (int, error) {
+	return s.suppressed.LoadSuppressionCSV(r)
+}
+
+// SetCounterStore sets a shared store, eg: Redis, for the failed
+// delivery counts of AdaptiveSenderThreshold so that the sender switch
+// is shared across gateway instances. It has no effect without
+// AdaptiveSenderThreshold.
+func (s *sms) SetCounterStore(c otpgateway.CounterStore) {
+	if s.cfg.AdaptiveSenderThreshold > 0 {
+		s.senderFails = c
+	}
+}
+
+// SetTelemetry sets the tracer and the meter that pushes are traced and
+// measured with. Either can be nil. It should be called before pushing.
+func (s *sms) SetTelemetry(t otpgateway.Tracer, m otpgateway.Meter) {
+	s.tracer = t
+	s.meter = m
+}
+
+// SetCredentialProvider sets the source of the default account's API
+// key, which is fetched for requests and cached for CredentialCacheTTL.
+// The routed Accounts use their static keys.
+func (s *sms) SetCredentialProvider(p otpgateway.CredentialProvider) {
+	if p == nil {
+		s.creds = nil
+		return
+	}
+	s.creds = &credCache{
+		p:     p,
+		ttl:   time.Duration(s.cfg.CredentialCacheTTL) * time.Second,
+		clock: s.clock,
+	}
+}
+
+// SetAuditSink sets the sink that the audit records of the requests
+// sent to the API are emitted to.
+func (s *sms) SetAuditSink(a otpgateway.AuditSink) {
+	s.audit = a
+}
+
+// Stats returns a snapshot of the Provider's delivery stats.
+func (s *sms) Stats() otpgateway.ProviderStats {
+	return s.stats.Stats()
+}
+
+// LastError returns the error of the most recent failed push and when
+// it happened. It is cleared by a successful push.
+func (s *sms) LastError() (error, time.Time) {
+	return s.stats.LastError()
+}
+
+// SenderIdentity returns the sender of the default account.
+func (s *sms) SenderIdentity() string {
+	return s.def.Sender
+}
+
+// SenderFor returns the sender of the account that SMSes to the given
+// number are routed to by country.
+func (s *sms) SenderFor(to string) string {
+	return s.senderFor("", to)
+}
+
+// senderFor returns the sender of the account that SMSes in the
+// namespace to the given number are routed to.
+func (s *sms) senderFor(namespace, to string) string {
+	n, err := s.normalize(to)
+	if err != nil {
+		return s.def.Sender
+	}
+	return s.sender(s.route(namespace, n), n)
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (s *sms) MaxAddressLen() int {
+	return maxAddresslen
+}
+
+// MaxOTPLen returns the configured OTPLength.
+func (s *sms) MaxOTPLen() int {
+	return s.cfg.OTPLength
+}
+
+// ValidateOTP checks that an OTP is OTPLength digits.
+func (s *sms) ValidateOTP(otp string) error {
+	if len(otp) != s.cfg.OTPLength {
+		return fmt.Errorf("%w: should be %d digits", ErrInvalidOTP, s.cfg.OTPLength)
+	}
+	for _, c := range otp {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("%w: should be %d digits", ErrInvalidOTP, s.cfg.OTPLength)
+		}
+	}
+	return nil
 }
 
 // MaxBodyLen returns the max permitted body size.
 func (s *sms) MaxBodyLen() int {
-	return 140
+	return maxBodyLen
 }
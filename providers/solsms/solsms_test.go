@@ -0,0 +1,3258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/gsm"
+	"github.com/zplzpl/otpgateway/internal/clock"
+	"github.com/zplzpl/otpgateway/internal/testutil"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+	"github.com/zplzpl/otpgateway/webhook"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+919876543210",
+	OTP:       "482910",
+}
+
+type dummySink struct {
+	events []otpgateway.Event
+	closed bool
+	mu     sync.Mutex
+}
+
+func (d *dummySink) Emit(e otpgateway.Event) {
+	d.mu.Lock()
+	d.events = append(d.events, e)
+	d.mu.Unlock()
+}
+
+func (d *dummySink) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	return nil
+}
+
+// testServer is a mock Kaleyra API server that records the last request.
+type testServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	req    *http.Request
+	params url.Values
+}
+
+// newTestServer returns a mock Kaleyra API server that responds with
+// the given status code and body.
+func newTestServer(code int, body string) *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ts.mu.Lock()
+		ts.req = r
+		ts.params = r.Form
+		ts.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	}))
+	return ts
+}
+
+// lastParams returns the params of the last request received by the server.
+func (ts *testServer) lastParams() url.Values {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.params
+}
+
+// lastRequest returns the last request received by the server.
+func (ts *testServer) lastRequest() *http.Request {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.req
+}
+
+// newTestSMS returns an instance of the provider pointed to the given
+// mock server URL with optional JSON config fields.
+func newTestSMS(t *testing.T, rootURL string, extra string) *sms {
+	cfg := `{"RootURL": "` + rootURL + `", "APIKey": "key", "SID": "sid", "Sender": "SENDER"`
+	if extra != "" {
+		cfg += ", " + extra
+	}
+	p, err := New([]byte(cfg + "}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*sms)
+}
+
+func TestEvents(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	var (
+		s    = newTestSMS(t, srv.URL, "")
+		sink = &dummySink{}
+	)
+	s.events = sink
+
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Len(t, sink.events, 1)
+	assert.Equal(t, otpgateway.EventSend, sink.events[0].Type)
+	assert.Equal(t, providerID, sink.events[0].Provider)
+	assert.Equal(t, mockOTP.Namespace, sink.events[0].Namespace)
+	assert.Equal(t, mockOTP.ID, sink.events[0].ID)
+	assert.Empty(t, sink.events[0].Error)
+
+	// Failed send.
+	srvErr := newTestServer(http.StatusBadRequest, `{"code": "E101"}`)
+	defer srvErr.Close()
+	s = newTestSMS(t, srvErr.URL, "")
+	s.events = sink
+
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Len(t, sink.events, 2)
+	assert.Equal(t, otpgateway.EventFail, sink.events[1].Type)
+	assert.Contains(t, sink.events[1].Error, "E101")
+
+	// The sink is closed with the provider, with or without a queue.
+	for _, c := range []string{"", `"AsyncQueueSize": 10`} {
+		sink := &dummySink{}
+		s := newTestSMS(t, srv.URL, c)
+		s.events = sink
+		assert.NoError(t, s.Close())
+		assert.True(t, sink.closed, "sink not closed: %s", c)
+	}
+
+	// SocketSinks are created for the EventsAddress and closed.
+	s = newTestSMS(t, srv.URL, `"EventsNetwork": "udp", "EventsAddress": "127.0.0.1:9"`)
+	ss, ok := s.events.(*otpgateway.SocketSink)
+	if assert.True(t, ok) {
+		assert.NoError(t, s.Close())
+		ss.Emit(otpgateway.Event{})
+		assert.Equal(t, uint64(1), ss.Dropped(), "SocketSink not closed")
+	}
+}
+
+func TestTransliterateToGSM(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Disabled.
+	s := newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910 — don’t share it")))
+	assert.Equal(t, "Your code is 482910 — don’t share it", srv.lastParams().Get("body"))
+
+	// Enabled.
+	s = newTestSMS(t, srv.URL, `"TransliterateToGSM": true`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("“482910” is your code — don’t share it")))
+	assert.Equal(t, `"482910" is your code - don't share it`, srv.lastParams().Get("body"))
+	assert.Contains(t, srv.lastParams().Get("body"), mockOTP.OTP, "OTP not preserved")
+}
+
+func TestCharset(t *testing.T) {
+	c := testutil.NewCarrier(testutil.OK(`{"id": "msg1"}`))
+	defer c.Close()
+
+	// Not validated by default.
+	s := newTestSMS(t, c.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910 😀")))
+
+	// GSM-7 only.
+	s = newTestSMS(t, c.URL, `"Charset": "GSM7"`)
+	c.Reset()
+	err := s.Push(mockOTP, "", []byte("Your code is 482910 😀"))
+	assert.True(t, errors.Is(err, ErrUnsupportedCharacter), err)
+	assert.Contains(t, err.Error(), "(U+1F600) at position 21")
+	_, err = s.Preview(mockOTP, "", []byte("Your code is 482910 — don’t share it"))
+	assert.True(t, errors.Is(err, ErrUnsupportedCharacter), err)
+	assert.Contains(t, err.Error(), "'—'")
+	assert.Empty(t, c.Requests(), "unsupported body sent")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910. Price: €0 [free]")))
+
+	// ... after the transliteration.
+	s = newTestSMS(t, c.URL, `"Charset": "gsm7", "TransliterateToGSM": true`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910 — don’t share it")))
+	if r, ok := c.LastRequest(); assert.True(t, ok) {
+		assert.Equal(t, "Your code is 482910 - don't share it", r.Form.Get("body"))
+	}
+
+	// UCS-2 takes the non GSM-7 characters but not emoji outside the BMP.
+	s = newTestSMS(t, c.URL, `"Charset": "ucs2"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("आपका कोड 482910 है")))
+	err = s.Push(mockOTP, "", []byte("आपका कोड 482910 👍"))
+	assert.True(t, errors.Is(err, ErrUnsupportedCharacter), err)
+	assert.Contains(t, err.Error(), "(U+1F44D) at position 17")
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "Charset": "ascii"}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "Charset", e.Field)
+	}
+}
+
+func TestChannelDesc(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Defaults.
+	s := newTestSMS(t, srv.URL, "")
+	assert.Contains(t, s.ChannelDesc(), "6 digit code")
+	assert.NotContains(t, s.ChannelDesc(), "within")
+	assert.NotContains(t, s.ChannelDesc(), "tries")
+
+	s = newTestSMS(t, srv.URL, `"MaxAttempts": 3, "CodeValidity": 300`)
+	assert.Contains(t, s.ChannelDesc(), "within 5 minutes")
+	assert.Contains(t, s.ChannelDesc(), "You have 3 tries")
+
+	s = newTestSMS(t, srv.URL, `"MaxAttempts": 1, "CodeValidity": 90`)
+	assert.Contains(t, s.ChannelDesc(), "within 90 seconds")
+	assert.Contains(t, s.ChannelDesc(), "You have 1 try")
+}
+
+func TestRequestError(t *testing.T) {
+	// A server that resets the connection after reading the request
+	// headers, before the body is fully written.
+	lnReset := newTCPServer(t, func(c *net.TCPConn) {
+		bufio.NewReader(c).ReadString('\n')
+		c.SetLinger(0)
+	})
+	defer lnReset.Close()
+
+	// A server that reads the full request and closes the connection
+	// without responding.
+	lnClose := newTCPServer(t, func(c *net.TCPConn) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err == nil {
+			ioutil.ReadAll(req.Body)
+		}
+	})
+	defer lnClose.Close()
+
+	// The request is sent directly as Push rejects bodies this long.
+	var (
+		s    = newTestSMS(t, "http://"+lnReset.Addr().String(), "")
+		p    = url.Values{"body": {strings.Repeat("x", 8*1024*1024)}}
+		rErr *RequestError
+	)
+	err := s.do(context.Background(), s.def, "/messages", p, &solSMSAPIResp{})
+	assert.True(t, errors.As(err, &rErr), "error is not a RequestError")
+	assert.False(t, rErr.Sent, "partial write reported as sent")
+	assert.True(t, rErr.SafeToRetry())
+
+	s = newTestSMS(t, "http://"+lnClose.Addr().String(), "")
+	err = s.Push(mockOTP, "", []byte("Your code is 482910"))
+	assert.True(t, errors.As(err, &rErr), "error is not a RequestError")
+	assert.True(t, rErr.Sent, "full write reported as not sent")
+	assert.False(t, rErr.SafeToRetry())
+}
+
+// newTCPServer starts a raw TCP server that handles every connection
+// with fn and closes it.
+func newTCPServer(t *testing.T, fn func(c *net.TCPConn)) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fn(c.(*net.TCPConn))
+			c.Close()
+		}
+	}()
+	return ln
+}
+
+func TestStats(t *testing.T) {
+	var (
+		srv    = newTestServer(http.StatusOK, `{"id": "msg1"}`)
+		srvErr = newTestServer(http.StatusBadRequest, `{"code": "E101"}`)
+	)
+	defer srv.Close()
+	defer srvErr.Close()
+
+	s := newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Point the provider to the failing server.
+	s.def.rootURL = srvErr.URL + "/sid"
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	st := s.Stats()
+	assert.Equal(t, uint64(2), st.Sent)
+	assert.Equal(t, uint64(1), st.Failed)
+	assert.Equal(t, uint64(0), st.Retried)
+	assert.Contains(t, st.LastError, "E101")
+	assert.False(t, st.LastSuccess.IsZero())
+}
+
+func TestOTPEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/sid/verify":
+			assert.Equal(t, mockOTP.To, r.Form.Get("to"))
+			assert.Equal(t, "SENDER", r.Form.Get("sender"))
+			w.Write([]byte(`{"id": "req1", "data": {"verify_id": "verify1"}}`))
+		case "/sid/verify/validate":
+			if r.Form.Get("verify_id") == "verify1" && r.Form.Get("otp") == "1234" {
+				w.Write([]byte(`{"id": "req2", "data": {"message": "OTP verified successfully."}}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "E912", "message": "Incorrect OTP"}}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"UseOTPEndpoint": true`)
+	res, err := s.PushContext(context.Background(), mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "verify1", res.ID, "verify ID doesn't match")
+
+	assert.NoError(t, s.Verify(context.Background(), res.ID, "1234"))
+	assert.Equal(t, ErrOTPMismatch, s.Verify(context.Background(), res.ID, "0000"))
+}
+
+func TestTransport(t *testing.T) {
+	s := newTestSMS(t, "http://localhost", "")
+	tr := s.h.Transport.(*http.Transport)
+	assert.Equal(t, time.Second*defaultIdleConnTimeout, tr.IdleConnTimeout)
+	assert.Equal(t, 1, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 0, tr.MaxConnsPerHost)
+
+	s = newTestSMS(t, "http://localhost", `"IdleConnTimeout": 10, "MaxConnsPerHost": 20, "MaxIdleConns": 5`)
+	tr = s.h.Transport.(*http.Transport)
+	assert.Equal(t, time.Second*10, tr.IdleConnTimeout)
+	assert.Equal(t, 5, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 20, tr.MaxConnsPerHost)
+	assert.False(t, tr.DisableKeepAlives)
+
+	s = newTestSMS(t, "http://localhost", `"DisableKeepAlives": true`)
+	tr = s.h.Transport.(*http.Transport)
+	assert.True(t, tr.DisableKeepAlives)
+}
+
+func TestStrictValidation(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// The digits check accepts any number.
+	s := newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.ValidateAddress("+911111111111"))
+
+	s = newTestSMS(t, srv.URL, `"StrictValidation": true`)
+	assert.NoError(t, s.ValidateAddress("+919876543210"), "valid mobile rejected")
+	assert.Error(t, s.ValidateAddress("+911111111111"), "invalid number accepted")
+	assert.Error(t, s.ValidateAddress("+442071838750"), "landline accepted")
+
+	// Numbers are sent in the E.164 format.
+	o := mockOTP
+	o.To = "+91 98765-43210"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+919876543210", srv.lastParams().Get("to"))
+}
+
+func TestReference(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, "")
+	ctx := otpgateway.WithReference(context.Background(), "order-123")
+	res, err := s.PushContext(ctx, mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "order-123", srv.lastParams().Get("custom"), "reference not sent")
+
+	// No reference.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	_, ok := srv.lastParams()["custom"]
+	assert.False(t, ok, "empty reference sent")
+
+	// Mock DLR callback from Kaleyra echoing the reference.
+	p := url.Values{}
+	p.Set("id", res.ID)
+	p.Set("to", mockOTP.To)
+	p.Set("status", "DELIVRD")
+	p.Set("custom", "order-123")
+	req := httptest.NewRequest(http.MethodPost, "/dlr", strings.NewReader(p.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	d, err := ParseDLR(req)
+	assert.NoError(t, err)
+	assert.Equal(t, DLR{ID: "msg1", To: mockOTP.To, Status: "DELIVRD", Reference: "order-123"}, d)
+
+	_, err = ParseDLR(httptest.NewRequest(http.MethodGet, "/dlr", nil))
+	assert.Error(t, err, "DLR without id accepted")
+}
+
+func TestAcceptPending(t *testing.T) {
+	var (
+		srvQueued   = newTestServer(http.StatusAccepted, `{"id": "msg1", "status": "QUEUED"}`)
+		srvRejected = newTestServer(http.StatusBadRequest, `{"code": "E110", "status": "REJECTED"}`)
+	)
+	defer srvQueued.Close()
+	defer srvRejected.Close()
+
+	// Pending responses are failures unless accepted.
+	s := newTestSMS(t, srvQueued.URL, "")
+	_, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err, "pending response accepted")
+
+	s = newTestSMS(t, srvQueued.URL, `"AcceptPending": true`)
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "msg1", Status: "queued"}, res)
+
+	// A rejected response is always an error.
+	s = newTestSMS(t, srvRejected.URL, `"AcceptPending": true`)
+	_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err, "rejected response accepted")
+}
+
+func TestSuppression(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	f, err := ioutil.TempFile("", "suppression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("number\n+919999999999\n")
+	f.Close()
+
+	s := newTestSMS(t, srv.URL, `"SuppressionFile": "`+f.Name()+`"`)
+	o := mockOTP
+	o.To = "+919999999999"
+	assert.Equal(t, otpgateway.ErrSuppressed, s.Push(o, "", []byte("Your code is 482910")))
+
+	// Load more numbers while sends are in flight.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			n, err := s.LoadSuppressionCSV(strings.NewReader(fmt.Sprintf("+1415555%04d\n", i)))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+		}()
+	}
+	wg.Wait()
+
+	o.To = "+14155550003"
+	assert.Equal(t, otpgateway.ErrSuppressed, s.Push(o, "", []byte("Your code is 482910")))
+}
+
+func TestEmptyBody(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Error on empty.
+	s := newTestSMS(t, srv.URL, "")
+	assert.Equal(t, ErrEmptyBody, s.Push(mockOTP, "", nil))
+	assert.Equal(t, ErrEmptyBody, s.Push(mockOTP, "", []byte(" \n\t ")))
+
+	// Default body substitution.
+	s = newTestSMS(t, srv.URL, `"DefaultBody": "Your verification code is {{ .OTP }}"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("  ")))
+	assert.Equal(t, "Your verification code is 482910", srv.lastParams().Get("body"))
+
+	// Non-empty bodies are sent as is.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Code: 482910")))
+	assert.Equal(t, "Code: 482910", srv.lastParams().Get("body"))
+
+	_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "DefaultBody": "{{ .OTP"}`))
+	assert.Error(t, err, "invalid DefaultBody accepted")
+}
+
+func TestGroupOTPDigits(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// No grouping.
+	s := newTestSMS(t, srv.URL, `"GroupOTPDigits": 0`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "Your code is 482910", srv.lastParams().Get("body"))
+
+	s = newTestSMS(t, srv.URL, `"GroupOTPDigits": 3, "DefaultBody": "Code: {{ .OTP }}"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "Your code is 482 910", srv.lastParams().Get("body"))
+	assert.Equal(t, "482910", mockOTP.OTP, "OTP modified")
+
+	// Default body.
+	assert.NoError(t, s.Push(mockOTP, "", nil))
+	assert.Equal(t, "Code: 482 910", srv.lastParams().Get("body"))
+
+	o := mockOTP
+	o.OTP = "1234567"
+	assert.Equal(t, "123 456 7", s.groupDigits(o.OTP))
+
+	// The separator pushes the body over the max length.
+	body := strings.Repeat("x", s.MaxBodyLen()-len(mockOTP.OTP)) + mockOTP.OTP
+	assert.Equal(t, ErrBodyTooLong, s.Push(mockOTP, "", []byte(body)))
+}
+
+func TestTestNumbers(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"TestNumbers": {"+15550001111": "123456"}`)
+	o, ok := s.TestOTP("+15550001111")
+	assert.True(t, ok)
+	assert.Equal(t, "123456", o)
+	_, ok = s.TestOTP(mockOTP.To)
+	assert.False(t, ok)
+
+	// Test numbers short circuit.
+	to := mockOTP
+	to.To = "+15550001111"
+	res, err := s.PushContext(context.Background(), to, "", []byte("Your code is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "delivered", res.Status)
+	assert.Nil(t, srv.lastParams(), "test number was sent to")
+
+	// Others are sent normally.
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.Equal(t, mockOTP.To, srv.lastParams().Get("to"))
+	assert.Equal(t, uint64(2), s.Stats().Sent)
+
+	// Not configured.
+	s = newTestSMS(t, srv.URL, "")
+	_, ok = s.TestOTP("+15550001111")
+	assert.False(t, ok)
+}
+
+func TestAsyncQueue(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		sent = append(sent, r.Form.Get("body"))
+		mu.Unlock()
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"AsyncQueueSize": 10, "Workers": 3`)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, s.Push(mockOTP, "", []byte(fmt.Sprintf("Your code is 482910 %d", i))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.NoError(t, s.Flush(ctx))
+	assert.Len(t, sent, 10)
+	assert.Equal(t, uint64(10), s.Stats().Sent)
+
+	// Flushing an empty queue returns immediately.
+	assert.NoError(t, s.Flush(ctx))
+
+	assert.NoError(t, s.Close())
+	assert.Equal(t, ErrQueueClosed, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Close())
+}
+
+func TestAsyncQueueFull(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"AsyncQueueSize": 2`)
+
+	// The first SMS is picked up by the (blocked) worker and the next
+	// two fill the queue.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	time.Sleep(time.Millisecond * 50)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, ErrQueueFull, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Flush times out while the worker is blocked.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Flush(ctx))
+
+	// Close waits for the queued SMSes to be sent.
+	close(unblock)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, uint64(3), s.Stats().Sent)
+}
+
+func TestAccountRouting(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+		keys  []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		keys = append(keys, r.Header.Get("api-key"))
+		mu.Unlock()
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"Accounts": [
+		{"SID": "brand", "APIKey": "brandkey", "Sender": "BRAND", "Namespaces": ["brandapp"]},
+		{"SID": "uk", "APIKey": "ukkey", "Sender": "UKSENDER", "Countries": ["gb"]}
+	]`)
+
+	push := func(namespace, to string) {
+		o := mockOTP
+		o.Namespace = namespace
+		o.To = to
+		assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	}
+
+	// Namespace based routing.
+	push("brandapp", "+919876543210")
+	// Namespace rules take precedence over countries.
+	push("brandapp", "+447400123456")
+
+	// Country based routing.
+	push("myapp", "+447400123456")
+
+	// Default account.
+	push("myapp", "+919876543210")
+
+	assert.Equal(t, []string{"/brand/messages", "/brand/messages", "/uk/messages", "/sid/messages"}, paths)
+	assert.Equal(t, []string{"brandkey", "brandkey", "ukkey", "key"}, keys)
+
+	// Invalid accounts.
+	for _, c := range []string{
+		`"Accounts": [{"SID": "brand", "APIKey": "brandkey", "Namespaces": ["brandapp"]}]`,
+		`"Accounts": [{"SID": "brand", "APIKey": "brandkey", "Sender": "BRAND"}]`,
+		`"UseOTPEndpoint": true, "Accounts": [{"SID": "uk", "APIKey": "ukkey", "Sender": "UK", "Countries": ["GB"]}]`,
+	} {
+		_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", ` + c + `}`))
+		assert.Error(t, err, c)
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	var (
+		s      = newTestSMS(t, srv.URL, "")
+		def    = &bytes.Buffer{}
+		ctxBuf = &bytes.Buffer{}
+	)
+	s.log = log.New(def, "", 0)
+
+	ctx := otpgateway.WithLogger(context.Background(), log.New(ctxBuf, "req-1 ", 0))
+	ctx = otpgateway.WithReference(ctx, "order-123")
+	_, err := s.PushContext(ctx, mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Contains(t, ctxBuf.String(), "req-1 solsms: [myotpid +91******3210 ref=order-123] sent SMS msg1 (sent)")
+	assert.NotContains(t, ctxBuf.String(), mockOTP.To, "recipient not masked")
+	assert.Empty(t, def.String())
+
+	// Without a ctx logger, the default logger is used.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Contains(t, def.String(), "solsms: [myotpid +91******3210] sent SMS msg1")
+}
+
+func TestEncoding(t *testing.T) {
+	for _, c := range []struct {
+		extra string
+		enc   string
+	}{
+		{"", "form"},
+		{`"APIVersion": "v1"`, "form"},
+		{`"APIVersion": "v2"`, "json"},
+		{`"APIVersion": "v2", "Encoding": "form"`, "form"},
+		{`"APIVersion": "v1", "Encoding": "json"`, "json"},
+	} {
+		s := newTestSMS(t, "http://localhost", c.extra)
+		assert.Equal(t, c.enc, s.cfg.Encoding, c.extra)
+	}
+
+	for _, c := range []string{`"APIVersion": "v9"`, `"Encoding": "xml"`} {
+		_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", ` + c + `}`))
+		assert.Error(t, err, c)
+	}
+
+	// The default root URL is versioned.
+	s := newTestSMS(t, "", `"APIVersion": "v2"`)
+	assert.Equal(t, "https://api.kaleyra.io/v2/sid", s.def.rootURL)
+
+	// JSON requests.
+	var (
+		ctype string
+		body  map[string]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctype = r.Header.Get("Content-Type")
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &body)
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	s = newTestSMS(t, srv.URL, `"APIVersion": "v2"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "application/json", ctype)
+	assert.Equal(t, "SENDER", body["sender"])
+	assert.Equal(t, "Your code is 482910", body["body"])
+}
+
+func TestKaleyraError(t *testing.T) {
+	for _, c := range []struct {
+		resp string
+		err  KaleyraError
+	}{
+		{`{"code": "E101"}`, KaleyraError{Code: "E101", Status: http.StatusBadRequest}},
+		{`{"code": "E413", "message": "Invalid parameters", "data": {}}`,
+			KaleyraError{Code: "E413", Detail: "Invalid parameters", Status: http.StatusBadRequest}},
+		{`{"code": "E413", "message": "Invalid parameters",
+			"data": [{"to": "+919876543210", "code": "E607", "message": "Number is in DND"}]}`,
+			KaleyraError{Code: "E607", Field: "+919876543210", Detail: "Number is in DND", Status: http.StatusBadRequest}},
+		{`{"code": "E413", "message": "Invalid parameters",
+			"data": {"errors": [{"field": "sender", "message": "Sender ID not approved"}]}}`,
+			KaleyraError{Code: "E413", Field: "sender", Detail: "Sender ID not approved", Status: http.StatusBadRequest}},
+	} {
+		srv := newTestServer(http.StatusBadRequest, c.resp)
+		s := newTestSMS(t, srv.URL, "")
+
+		err := s.Push(mockOTP, "", []byte("Your code is 482910"))
+		var ke *KaleyraError
+		if assert.True(t, errors.As(err, &ke), c.resp) {
+			assert.Equal(t, c.err, *ke)
+		}
+		srv.Close()
+	}
+
+	e := &KaleyraError{Code: "E607", Field: "+919876543210", Detail: "Number is in DND"}
+	assert.Equal(t, "send sms error: E607: +919876543210: Number is in DND", e.Error())
+}
+
+func TestSenderIdentity(t *testing.T) {
+	s := newTestSMS(t, "http://localhost", "")
+	assert.Equal(t, "SENDER", s.SenderIdentity())
+	assert.Equal(t, "SENDER", s.SenderFor("+447400123456"))
+
+	// Per-country senders.
+	s = newTestSMS(t, "http://localhost", `"Accounts": [
+		{"SID": "uk", "APIKey": "ukkey", "Sender": "UKSENDER", "Countries": ["GB"]}
+	]`)
+	assert.Equal(t, "SENDER", s.SenderIdentity())
+	assert.Equal(t, "UKSENDER", s.SenderFor("+447400123456"))
+	assert.Equal(t, "SENDER", s.SenderFor("+919876543210"))
+	assert.Equal(t, "SENDER", s.SenderFor("invalid"))
+}
+
+func TestMaxSegments(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"MaxSegments": 2`)
+	for _, c := range []struct {
+		body string
+		ok   bool
+	}{
+		// GSM-7: 153 chars per part.
+		{"Your code is 482910", true},
+		{"482910 " + strings.Repeat("a", 299), true},
+		{"482910 " + strings.Repeat("a", 300), false},
+
+		// UCS-2: 67 chars per part.
+		{"482910 " + strings.Repeat("क", 60), true},
+		{"482910 " + strings.Repeat("क", 127), true},
+		{"482910 " + strings.Repeat("क", 128), false},
+	} {
+		err := s.Push(mockOTP, "", []byte(c.body))
+		if c.ok {
+			assert.NoError(t, err, "%d chars", len([]rune(c.body)))
+		} else {
+			assert.True(t, errors.Is(err, ErrTooManySegments), "%d chars", len([]rune(c.body)))
+		}
+	}
+
+	// Without MaxSegments, bodies are limited to MaxBodyLen.
+	s = newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("482910 "+strings.Repeat("a", maxBodyLen-7))))
+	assert.True(t, errors.Is(s.Push(mockOTP, "", []byte("482910 "+strings.Repeat("a", maxBodyLen-6))), ErrBodyTooLong))
+}
+
+func TestRetryableErrorCodes(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	// Fails with the given code for the first n calls.
+	newServer := func(code string, n int) *httptest.Server {
+		calls = 0
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls++
+			c := calls
+			mu.Unlock()
+			if c <= n {
+				w.Write([]byte(`{"code": "` + code + `", "message": "failed"}`))
+				return
+			}
+			w.Write([]byte(`{"id": "msg1"}`))
+		}))
+	}
+	cfg := `"RetryableErrorCodes": ["e110"], "MaxRetries": 2, "RetryWait": 1`
+
+	// Retried and succeeds.
+	srv := newServer("E110", 2)
+	s := newTestSMS(t, srv.URL, cfg)
+	sink := &dummySink{}
+	s.events = sink
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, uint64(2), s.Stats().Retried)
+	assert.Len(t, sink.events, 3)
+	assert.Equal(t, otpgateway.EventRetry, sink.events[0].Type)
+	srv.Close()
+
+	// Retries exhausted.
+	srv = newServer("E110", 5)
+	s = newTestSMS(t, srv.URL, cfg)
+	_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	srv.Close()
+
+	// Permanent codes aren't retried.
+	srv = newServer("E413", 1)
+	s = newTestSMS(t, srv.URL, cfg)
+	_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, uint64(0), s.Stats().Retried)
+	srv.Close()
+
+	// No retries by default.
+	srv = newServer("E110", 1)
+	s = newTestSMS(t, srv.URL, "")
+	_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+	srv.Close()
+}
+
+func TestGzipResponse(t *testing.T) {
+	var accept string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"id": "msg1", "status": "sent"}`))
+		gz.Close()
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, "")
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.Equal(t, "gzip", accept)
+
+	// Uncompressed responses are read as is.
+	plain := newTestServer(http.StatusOK, `{"id": "msg2"}`)
+	defer plain.Close()
+	s = newTestSMS(t, plain.URL, "")
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg2", res.ID)
+}
+
+func TestDefaultRegion(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Without a default region, national numbers are ambiguous.
+	s := newTestSMS(t, srv.URL, "")
+	err := s.ValidateAddress("09876543210")
+	assert.True(t, errors.Is(err, phone.ErrAmbiguous), err)
+	assert.NoError(t, s.ValidateAddress("+919876543210"))
+
+	// National numbers are qualified with the default region.
+	s = newTestSMS(t, srv.URL, `"DefaultRegion": "IN"`)
+	o := mockOTP
+	o.To = "09876543210"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+919876543210", srv.lastParams().Get("to"))
+
+	o.To = "9876543210"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+919876543210", srv.lastParams().Get("to"))
+
+	// Numbers with a country code are left as is.
+	o.To = "+447400123456"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+447400123456", srv.lastParams().Get("to"))
+
+	// ... except for trunk prefixes entered after the country code.
+	for _, extra := range []string{"", `"DefaultRegion": "IN"`, `"StrictValidation": true`} {
+		s := newTestSMS(t, srv.URL, extra)
+		for in, out := range map[string]string{
+			"+9109876543210": "+919876543210",
+			"+4407400123456": "+447400123456",
+		} {
+			o.To = in
+			assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")), extra)
+			assert.Equal(t, out, srv.lastParams().Get("to"), extra)
+		}
+	}
+
+	// Trunk prefixes of national numbers are stripped for other regions too.
+	s = newTestSMS(t, srv.URL, `"DefaultRegion": "GB"`)
+	o.To = "07400 123456"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+447400123456", srv.lastParams().Get("to"))
+	o.To = "015123456789"
+	s = newTestSMS(t, srv.URL, `"DefaultRegion": "DE"`)
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+4915123456789", srv.lastParams().Get("to"))
+
+	// Strict validation uses the default region too.
+	s = newTestSMS(t, srv.URL, `"DefaultRegion": "IN", "StrictValidation": true`)
+	assert.NoError(t, s.ValidateAddress("09876543210"))
+	s = newTestSMS(t, srv.URL, `"StrictValidation": true`)
+	assert.Error(t, s.ValidateAddress("09876543210"))
+}
+
+func TestRegistry(t *testing.T) {
+	p, err := otpgateway.NewProvider(providerID, []byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, providerID, p.ID())
+
+	_, err = otpgateway.NewProvider(providerID, []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestInterfaces(t *testing.T) {
+	p, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER"}`))
+	assert.NoError(t, err)
+
+	_, ok := p.(otpgateway.Provider)
+	assert.True(t, ok, "not a Provider")
+	_, ok = p.(otpgateway.ResultPusher)
+	assert.True(t, ok, "not a ResultPusher")
+	_, ok = p.(otpgateway.Verifier)
+	assert.True(t, ok, "not a Verifier")
+	_, ok = p.(otpgateway.TestOTPer)
+	assert.True(t, ok, "not a TestOTPer")
+	_, ok = p.(otpgateway.SenderResolver)
+	assert.True(t, ok, "not a SenderResolver")
+}
+
+func TestUIDescriptor(t *testing.T) {
+	s := newTestSMS(t, "http://localhost", "")
+	d := s.UIDescriptor()
+	assert.Equal(t, providerID, d.ID)
+	assert.Equal(t, "SMS", d.ChannelName)
+	assert.Equal(t, addressName, d.AddressName)
+	assert.Equal(t, s.AddressDesc(), d.AddressDesc)
+	assert.Equal(t, s.MaxOTPLen(), d.MaxOTPLen)
+	assert.Equal(t, phone.MaxE164Len, d.MaxAddressLen)
+	assert.Equal(t, d, otpgateway.DescribeUI(s))
+
+	// The pattern matches valid numbers in full and the example is one.
+	re := regexp.MustCompile(`^(?:` + d.AddressPattern + `)$`)
+	assert.NoError(t, s.ValidateAddress(d.AddressExample))
+	assert.True(t, len(d.AddressExample) <= d.MaxAddressLen)
+	for _, n := range []string{d.AddressExample, "+447400123456", "919876543210"} {
+		assert.True(t, re.MatchString(n), n)
+		assert.NoError(t, s.ValidateAddress(n), n)
+	}
+	for _, n := range []string{"", "phone", "+91 abc", "12345"} {
+		assert.False(t, re.MatchString(n), n)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Invalid numbers.
+	for _, extra := range []string{"", `"DefaultRegion": "IN"`, `"StrictValidation": true`} {
+		s := newTestSMS(t, srv.URL, extra)
+		for _, n := range []string{"phone", "+91 abc"} {
+			err := s.ValidateAddress(n)
+			var e *NumberError
+			assert.True(t, errors.As(err, &e), err)
+			assert.True(t, errors.Is(err, otpgateway.ErrInvalidAddress), err)
+			assert.Equal(t, http.StatusBadRequest, otpgateway.HTTPStatusFor(err), err)
+		}
+	}
+	s := newTestSMS(t, srv.URL, "")
+	err := s.ValidateAddress("09876543210")
+	assert.True(t, errors.Is(err, phone.ErrAmbiguous), err)
+	assert.Equal(t, http.StatusBadRequest, otpgateway.HTTPStatusFor(err))
+	assert.Equal(t, "invalid mobile number", (&NumberError{}).Error())
+
+	// Suppressed numbers.
+	assert.NoError(t, s.suppressed.Add("+919999999999"))
+	o := mockOTP
+	o.To = "+919999999999"
+	assert.Equal(t, http.StatusUnprocessableEntity, otpgateway.HTTPStatusFor(s.Push(o, "", []byte("Your code is 482910"))))
+
+	// Resend cooldowns.
+	s = newTestSMS(t, srv.URL, `"ResendCooldown": 30`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, http.StatusTooManyRequests, otpgateway.HTTPStatusFor(s.Push(mockOTP, "", []byte("Your code is 482910"))))
+
+	// The API being down.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	s = newTestSMS(t, down.URL, "")
+	err = s.Push(mockOTP, "", []byte("Your code is 482910"))
+	var re *RequestError
+	assert.True(t, errors.As(err, &re), err)
+	assert.Equal(t, http.StatusBadGateway, otpgateway.HTTPStatusFor(err))
+
+	// The API rate limiting requests, with and without an error body.
+	for _, body := range []string{`{"code": "E429", "message": "Too many requests"}`, `<html>Too many requests</html>`} {
+		limited := newTestServer(http.StatusTooManyRequests, body)
+		s = newTestSMS(t, limited.URL, "")
+		err = s.Push(mockOTP, "", []byte("Your code is 482910"))
+		assert.True(t, errors.Is(err, otpgateway.ErrRateLimited), err)
+		assert.Equal(t, http.StatusTooManyRequests, otpgateway.HTTPStatusFor(err), body)
+		limited.Close()
+	}
+
+	// Other API errors.
+	var ke *KaleyraError
+	rejected := newTestServer(http.StatusBadRequest, `{"code": "E101", "message": "Invalid sender"}`)
+	defer rejected.Close()
+	s = newTestSMS(t, rejected.URL, "")
+	err = s.Push(mockOTP, "", []byte("Your code is 482910"))
+	assert.True(t, errors.As(err, &ke), err)
+	assert.Equal(t, http.StatusInternalServerError, otpgateway.HTTPStatusFor(err))
+}
+
+func TestRecipientName(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Names are dropped and the bare numbers are sent to.
+	s := newTestSMS(t, srv.URL, "")
+	o := mockOTP
+	for _, to := range []string{"+919876543210", "John Doe <+919876543210>", `"Doe, John" <+919876543210>`, "<+919876543210>"} {
+		assert.NoError(t, s.ValidateAddress(to), to)
+
+		o.To = to
+		res, err := s.PushContext(context.Background(), o, "", []byte("Your code is 482910"))
+		assert.NoError(t, err, to)
+		assert.Equal(t, "msg1", res.ID)
+		assert.Equal(t, "+919876543210", srv.lastParams().Get("to"), to)
+		assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")), to)
+		assert.Equal(t, "+919876543210", srv.lastParams().Get("to"), to)
+	}
+	assert.Error(t, s.ValidateAddress("John Doe <phone>"))
+}
+
+func TestSetEnabled(t *testing.T) {
+	c := testutil.NewCarrier(testutil.OK(`{"id": "msg1"}`))
+	defer c.Close()
+
+	for _, extra := range []string{"", `"AsyncQueueSize": 10`} {
+		s := newTestSMS(t, c.URL, extra)
+		assert.True(t, s.Enabled())
+
+		// Disabled providers reject sends without calling the API.
+		s.SetEnabled(false)
+		assert.Equal(t, otpgateway.ErrProviderDisabled, s.Push(mockOTP, "", []byte("Your code is 482910")), extra)
+		_, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		assert.Equal(t, otpgateway.ErrProviderDisabled, err, extra)
+		assert.Equal(t, uint64(0), s.Stats().Failed, extra)
+
+		s.SetEnabled(true)
+		_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		assert.NoError(t, err, extra)
+	}
+	assert.Len(t, c.Requests(), 2)
+}
+
+func TestDoubleZeroPrefix(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// A leading 00 is the international call prefix by default.
+	o := mockOTP
+	for _, extra := range []string{"", `"DefaultRegion": "IN"`, `"StrictValidation": true`} {
+		s := newTestSMS(t, srv.URL, extra)
+		for in, out := range map[string]string{
+			"00447400123456":  "+447400123456",
+			"00919876543210":  "+919876543210",
+			"009109876543210": "+919876543210",
+		} {
+			o.To = in
+			assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")), extra)
+			assert.Equal(t, out, srv.lastParams().Get("to"), extra)
+		}
+	}
+
+	// With it turned off, 00 is a trunk prefixed national number.
+	s := newTestSMS(t, srv.URL, `"TreatDoubleZeroAsPlus": false`)
+	err := s.ValidateAddress("00447400123456")
+	assert.True(t, errors.Is(err, phone.ErrAmbiguous), err)
+	assert.NoError(t, s.ValidateAddress("+447400123456"))
+}
+
+func TestDisableLinkTracking(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// Disabled by default.
+	s := newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "0", srv.lastParams().Get("shorten_url"))
+
+	s = newTestSMS(t, srv.URL, `"DisableLinkTracking": true`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "0", srv.lastParams().Get("shorten_url"))
+
+	// Left to the account's setting.
+	s = newTestSMS(t, srv.URL, `"DisableLinkTracking": false`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	_, ok := srv.lastParams()["shorten_url"]
+	assert.False(t, ok)
+}
+
+func BenchmarkPush(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"RootURL": "` + srv.URL + `", "APIKey": "key", "SID": "sid", "Sender": "SENDER", "MaxIdleConns": 10}`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	s := p.(*sms)
+	s.log = log.New(ioutil.Discard, "", 0)
+	body := []byte("Your verification code is 482910. Do not share it with anyone.")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.PushContext(context.Background(), mockOTP, "", body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	p := url.Values{}
+	p.Set("sender", "SENDER")
+	p.Set("to", "+919876543210")
+	p.Set("body", "Your code is 482910 & <don't> share it")
+
+	// The encoded bodies match the standard encoders.
+	s := newTestSMS(t, "http://localhost", "")
+	b, ctype, err := s.encode(p)
+	assert.NoError(t, err)
+	out, _ := ioutil.ReadAll(b)
+	assert.Equal(t, p.Encode(), string(out))
+	assert.Equal(t, "application/x-www-form-urlencoded", ctype)
+	b.Close()
+	b.Close()
+
+	s = newTestSMS(t, "http://localhost", `"Encoding": "json"`)
+	b, ctype, err = s.encode(p)
+	assert.NoError(t, err)
+	out, _ = ioutil.ReadAll(b)
+	exp, _ := json.Marshal(map[string]string{"sender": p.Get("sender"), "to": p.Get("to"), "body": p.Get("body")})
+	assert.Equal(t, string(exp), string(out))
+	assert.Equal(t, "application/json", ctype)
+	b.Close()
+}
+
+func TestMessageID(t *testing.T) {
+	for _, c := range []struct {
+		resp string
+		id   string
+		err  bool
+	}{
+		{`{"id": "9dc4d3a3-1b3c-4ac5-a4ad-cb8a3b1f3b9d"}`, "9dc4d3a3-1b3c-4ac5-a4ad-cb8a3b1f3b9d", false},
+		{`{"id": 1511882900176220123}`, "1511882900176220123", false},
+		{`{"id": null}`, "", true},
+		{`{"status": "sent"}`, "", true},
+	} {
+		srv := newTestServer(http.StatusOK, c.resp)
+		s := newTestSMS(t, srv.URL, "")
+		res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		if c.err {
+			assert.Error(t, err, c.resp)
+		} else {
+			assert.NoError(t, err, c.resp)
+		}
+		assert.Equal(t, c.id, res.ID, c.resp)
+		srv.Close()
+	}
+
+	// Verify IDs of the OTP API.
+	srv := newTestServer(http.StatusOK, `{"data": {"verify_id": 42}}`)
+	defer srv.Close()
+	s := newTestSMS(t, srv.URL, `"UseOTPEndpoint": true`)
+	res, err := s.PushContext(context.Background(), mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", res.ID)
+
+	var m messageID
+	assert.Error(t, json.Unmarshal([]byte(`{}`), &m))
+}
+
+// countingResolver resolves every host to 127.0.0.1 and counts lookups.
+type countingResolver struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.n++
+	r.mu.Unlock()
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func (r *countingResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+func TestDNSCache(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	s := newTestSMS(t, "http://api.kaleyra.test:"+port, `"DNSCacheTTL": 60, "DisableKeepAlives": true`)
+
+	res := &countingResolver{}
+	clk := clock.NewFake(time.Now())
+	s.dns.res = res
+	s.dns.clock = clk
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Push(mockOTP, "", []byte("your code")))
+	}
+	assert.Equal(t, 1, res.count(), "host resolved more than once within the TTL")
+
+	// Expired entries are resolved again.
+	clk.Advance(time.Minute)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("your code")))
+	assert.Equal(t, 2, res.count(), "expired host not resolved again")
+
+	// Cancelled contexts aren't dialed.
+	clk.Advance(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.dns.DialContext(ctx, "tcp", "api.kaleyra.test:"+port)
+	assert.True(t, errors.Is(err, context.Canceled), "cancelled context dialed")
+}
+
+func TestHTTPMethod(t *testing.T) {
+	var (
+		method string
+		query  url.Values
+		body   url.Values
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		query = r.URL.Query()
+		b, _ := ioutil.ReadAll(r.Body)
+		body, _ = url.ParseQuery(string(b))
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	// POST (default) and PUT send the params in the body.
+	for _, m := range []string{"", "put"} {
+		s := newTestSMS(t, srv.URL, `"HTTPMethod": "`+m+`"`)
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+		if m == "" {
+			assert.Equal(t, http.MethodPost, method)
+		} else {
+			assert.Equal(t, http.MethodPut, method)
+		}
+		assert.Equal(t, "Your code is 482910", body.Get("body"), m)
+		assert.Empty(t, query, m)
+	}
+
+	// GET sends them in the query string, even with the JSON encoding.
+	s := newTestSMS(t, srv.URL, `"HTTPMethod": "GET", "Encoding": "json"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, http.MethodGet, method)
+	assert.Equal(t, "Your code is 482910", query.Get("body"))
+	assert.Equal(t, "SENDER", query.Get("sender"))
+	assert.Empty(t, body)
+
+	_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "HTTPMethod": "DELETE"}`))
+	assert.Error(t, err, "invalid HTTPMethod accepted")
+}
+
+func TestStatusCache(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		hits   = map[string]int{}
+		status = map[string]string{"msg1": "DELIVRD", "msg2": "AWAITED-DLR"}
+
+		// verified is the verify IDs whose OTP has been used up.
+		verified = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/sid/messages":
+			assert.Equal(t, http.MethodGet, r.Method)
+			id := r.URL.Query().Get("id")
+			hits[id]++
+			st, ok := status[id]
+			if !ok {
+				w.Write([]byte(`{"data": []}`))
+				return
+			}
+			fmt.Fprintf(w, `{"data": [{"id": "%s", "status": "%s"}]}`, id, st)
+		case "/sid/verify/validate":
+			r.ParseForm()
+			hits["verify"]++
+			if id := r.Form.Get("verify_id"); r.Form.Get("otp") == "1234" && !verified[id] {
+				verified[id] = true
+				w.Write([]byte(`{"data": {"message": "OTP verified"}}`))
+				return
+			}
+			w.Write([]byte(`{"error": {"code": "E912", "message": "Invalid OTP"}}`))
+		}
+	}))
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"StatusCacheTTL": 3600, "PendingStatusCacheTTL": 10`)
+	clk := clock.NewFake(time.Now())
+	s.statuses.clock = clk
+	hitCount := func(id string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[id]
+	}
+
+	// Terminal statuses aren't fetched again.
+	for i := 0; i < 3; i++ {
+		st, err := s.DeliveryStatus(context.Background(), "msg1")
+		assert.NoError(t, err)
+		assert.Equal(t, "delivered", st)
+	}
+	clk.Advance(time.Minute)
+	s.DeliveryStatus(context.Background(), "msg1")
+	assert.Equal(t, 1, hitCount("msg1"), "terminal status fetched again")
+
+	// In-flight statuses are fetched again after the short TTL.
+	st, err := s.DeliveryStatus(context.Background(), "msg2")
+	assert.NoError(t, err)
+	assert.Equal(t, "sent", st)
+	s.DeliveryStatus(context.Background(), "msg2")
+	assert.Equal(t, 1, hitCount("msg2"))
+
+	mu.Lock()
+	status["msg2"] = "UNDELIV"
+	mu.Unlock()
+	clk.Advance(11 * time.Second)
+	st, err = s.DeliveryStatus(context.Background(), "msg2")
+	assert.NoError(t, err)
+	assert.Equal(t, "failed", st)
+	assert.Equal(t, 2, hitCount("msg2"), "pending status not fetched again")
+
+	_, err = s.DeliveryStatus(context.Background(), "unknown")
+	assert.True(t, errors.Is(err, ErrMessageNotFound))
+
+	// Verifications aren't cached as OTPs are single use.
+	assert.NoError(t, s.Verify(context.Background(), "v1", "1234"))
+	assert.True(t, errors.Is(s.Verify(context.Background(), "v1", "1234"), ErrOTPMismatch), "used OTP verified again")
+	assert.True(t, errors.Is(s.Verify(context.Background(), "v1", "0000"), ErrOTPMismatch))
+	assert.Equal(t, 3, hitCount("verify"))
+
+	// No caching by default.
+	s = newTestSMS(t, srv.URL, "")
+	assert.Nil(t, s.statuses)
+	s.DeliveryStatus(context.Background(), "msg1")
+	s.DeliveryStatus(context.Background(), "msg1")
+	assert.Equal(t, 3, hitCount("msg1"))
+}
+
+func TestMinBodyLen(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"MinBodyLen": 10`)
+	for _, c := range []struct {
+		body string
+		ok   bool
+	}{
+		{"482910", false},
+		{"  482910 \n", false},
+		{"482910 abc", true},
+		{"Your code is 482910", true},
+		// Characters, not bytes.
+		{"482910 कोड", true},
+	} {
+		err := s.Push(mockOTP, "", []byte(c.body))
+		if c.ok {
+			assert.NoError(t, err, c.body)
+		} else {
+			assert.True(t, errors.Is(err, ErrBodyTooShort), c.body)
+		}
+	}
+
+	// Rendered default bodies are checked too.
+	s = newTestSMS(t, srv.URL, `"MinBodyLen": 10, "DefaultBody": "{{ .OTP }}"`)
+	assert.True(t, errors.Is(s.Push(mockOTP, "", nil), ErrBodyTooShort))
+
+	// Off by default.
+	s = newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("1")))
+
+	_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "MinBodyLen": -1}`))
+	assert.Error(t, err)
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	newTLSServer := func(max uint16) *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id": "msg1"}`))
+		}))
+		srv.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: max}
+		srv.StartTLS()
+		return srv
+	}
+	newSMS := func(srv *httptest.Server, extra string) *sms {
+		s := newTestSMS(t, srv.URL, extra)
+		pool := x509.NewCertPool()
+		pool.AddCert(srv.Certificate())
+		tr := s.h.Transport.(*http.Transport)
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.RootCAs = pool
+		return s
+	}
+
+	old := newTLSServer(tls.VersionTLS11)
+	defer old.Close()
+	tls12 := newTLSServer(tls.VersionTLS12)
+	defer tls12.Close()
+
+	s := newSMS(old, `"MinTLSVersion": "1.2"`)
+	assert.Equal(t, uint16(tls.VersionTLS12), s.h.Transport.(*http.Transport).TLSClientConfig.MinVersion)
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")), "TLS 1.1 handshake accepted")
+
+	s = newSMS(tls12, `"MinTLSVersion": "1.2"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	s = newSMS(tls12, `"MinTLSVersion": "1.3"`)
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")), "TLS 1.2 handshake accepted")
+
+	_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "MinTLSVersion": "1.4"}`))
+	assert.Error(t, err, "invalid MinTLSVersion accepted")
+}
+
+func TestParseConfig(t *testing.T) {
+	const base = `"APIKey": "key", "SID": "sid", "Sender": "SENDER"`
+
+	c, err := ParseConfig([]byte(`{` + base + `}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://api.kaleyra.io/v1", c.RootURL)
+	assert.Equal(t, "form", c.Encoding)
+	assert.Equal(t, http.MethodPost, c.HTTPMethod)
+	assert.Equal(t, 5, c.Timeout)
+	assert.True(t, *c.DisableLinkTracking)
+	assert.True(t, *c.TreatDoubleZeroAsPlus)
+
+	for _, tc := range []struct {
+		cfg   string
+		field string
+	}{
+		{`"SID": "sid", "Sender": "SENDER"`, "APIKey"},
+		{`"APIKey": "key", "SID": "sid"`, "Sender"},
+		{`"APIKey": "key", "Sender": "SENDER"`, "SID"},
+		{base + `, "APIVersion": "v9"`, "APIVersion"},
+		{base + `, "Encoding": "xml"`, "Encoding"},
+		{base + `, "HTTPMethod": "DELETE"`, "HTTPMethod"},
+		{base + `, "Accounts": [{"APIKey": "k2", "SID": "sid2", "Countries": ["US"]}]`, "Accounts[0].Sender"},
+		{base + `, "Accounts": [{"APIKey": "k2", "SID": "sid2", "Sender": "S2"}]`, "Accounts[0]"},
+		{base + `, "Accounts": [{"APIKey": "k2", "SID": "sid2", "Sender": "S2", "Countries": ["US"]}], "UseOTPEndpoint": true`, "UseOTPEndpoint"},
+		{base + `, "Timeout": -1`, "Timeout"},
+		{base + `, "MinTLSVersion": "1.4"`, "MinTLSVersion"},
+		{base + `, "DNSCacheTTL": -1`, "DNSCacheTTL"},
+		{base + `, "StatusCacheTTL": -1`, "StatusCacheTTL"},
+		{base + `, "PendingStatusCacheTTL": -1`, "PendingStatusCacheTTL"},
+		{base + `, "GroupOTPDigits": -1`, "GroupOTPDigits"},
+		{base + `, "MaxSegments": -1`, "MaxSegments"},
+		{base + `, "MinBodyLen": 200`, "MinBodyLen"},
+		{base + `, "MaxRetries": -1`, "MaxRetries"},
+		{base + `, "RetryWait": -1`, "RetryWait"},
+		{base + `, "AsyncQueueSize": -1`, "AsyncQueueSize"},
+		{base + `, "Workers": -1`, "Workers"},
+		{base + `, "SLATimeout": -1`, "SLATimeout"},
+		{base + `, "ResponseLogSampleRate": -0.1`, "ResponseLogSampleRate"},
+		{base + `, "ResponseLogSampleRate": 1.5`, "ResponseLogSampleRate"},
+		{base + `, "DefaultBody": "{{ .OTP "`, "DefaultBody"},
+	} {
+		_, err := ParseConfig([]byte(`{` + tc.cfg + `}`))
+		var e *ConfigError
+		if assert.True(t, errors.As(err, &e), "%s: %v", tc.field, err) {
+			assert.Equal(t, tc.field, e.Field)
+		}
+	}
+
+	// Malformed JSON isn't a field error.
+	_, err = ParseConfig([]byte(`{`))
+	assert.Error(t, err)
+	_, err = ParseConfig([]byte(`null`))
+	assert.Error(t, err)
+}
+
+func TestDisplayNameIgnored(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// SMSes have no display name and are sent from the Sender.
+	s := newTestSMS(t, srv.URL, `"DisplayName": "My App"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "SENDER", srv.lastParams().Get("sender"))
+	assert.Equal(t, "SENDER", s.SenderIdentity())
+}
+
+func TestSigning(t *testing.T) {
+	// Known-good signature of a fixture payload.
+	assert.Equal(t, "e2fb103bf06a24c939f8b5dd5fb29c1fc56959e39fe72c8c387fc05ee4cc0594",
+		signHMAC([]byte("secret"), "1700000000", []byte(`{"body":"Your code is 482910","to":"+919876543210"}`)))
+
+	var (
+		hdr  http.Header
+		body []byte
+		rawQ string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr = r.Header
+		rawQ = r.URL.RawQuery
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	// hmac-sha256 without an API key.
+	s, err := New([]byte(`{"RootURL": "` + srv.URL + `", "SID": "sid", "Sender": "SENDER", "SigningSecret": "secret", "Encoding": "json"}`))
+	assert.NoError(t, err)
+	sm := s.(*sms)
+	sm.clock = clock.NewFake(time.Unix(1700000000, 0))
+	assert.NoError(t, sm.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Empty(t, hdr.Get("api-key"))
+	assert.Equal(t, "1700000000", hdr.Get("X-Timestamp"))
+	assert.Equal(t, signHMAC([]byte("secret"), "1700000000", body), hdr.Get("X-Signature"))
+	assert.Contains(t, string(body), `"body":"Your code is 482910"`)
+
+	// GET requests sign the query string.
+	sm.cfg.HTTPMethod = http.MethodGet
+	assert.NoError(t, sm.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, signHMAC([]byte("secret"), "1700000000", []byte(rawQ)), hdr.Get("X-Signature"))
+
+	// webhook scheme along with the API key.
+	sm = newTestSMS(t, srv.URL, `"SigningSecret": "secret", "SigningScheme": "webhook"`)
+	assert.NoError(t, sm.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "key", hdr.Get("api-key"))
+	assert.NoError(t, webhook.VerifyWebhook([]byte("secret"), hdr, body, 0, nil))
+
+	// No signing by default.
+	sm = newTestSMS(t, srv.URL, "")
+	assert.NoError(t, sm.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "key", hdr.Get("api-key"))
+	assert.Empty(t, hdr.Get("X-Signature"))
+
+	for _, c := range []string{
+		`"SID": "sid", "Sender": "SENDER"`,
+		`"APIKey": "key", "SID": "sid", "Sender": "SENDER", "SigningScheme": "webhook"`,
+		`"APIKey": "key", "SID": "sid", "Sender": "SENDER", "SigningSecret": "secret", "SigningScheme": "md5"`,
+	} {
+		_, err := ParseConfig([]byte(`{` + c + `}`))
+		assert.Error(t, err, c)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"DefaultBody": "Your code is {{ .OTP }}", "GroupOTPDigits": 3,
+		"CostPerSegment": 0.25, "Accounts": [{"SID": "sid2", "APIKey": "k2", "Sender": "INSEND", "Countries": ["IN"]}]`)
+
+	for _, body := range []string{"", "Code: 482910 " + strings.Repeat("क", 80)} {
+		p, err := s.Preview(mockOTP, "", []byte(body))
+		assert.NoError(t, err)
+
+		// The preview matches what's sent.
+		assert.NoError(t, s.Push(mockOTP, "", []byte(body)))
+		sent := srv.lastParams()
+		assert.Equal(t, sent.Get("body"), p.Body)
+		assert.Equal(t, sent.Get("sender"), p.Sender)
+		assert.Equal(t, sent.Get("to"), p.To)
+		assert.Equal(t, gsm.Segments(p.Body), p.Segments)
+		assert.Equal(t, float64(p.Segments)*0.25, p.Cost)
+	}
+	p, _ := s.Preview(mockOTP, "", []byte("Code: 482910 "+strings.Repeat("क", 80)))
+	assert.Equal(t, 2, p.Segments)
+
+	p, err := s.Preview(mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PreviewResult{
+		To:       "+919876543210",
+		Sender:   "INSEND",
+		Body:     "Your code is 482 910",
+		Segments: 1,
+		Cost:     0.25,
+	}, p)
+
+	// Errors are the same as Push's.
+	s = newTestSMS(t, srv.URL, `"MinBodyLen": 10`)
+	_, err = s.Preview(mockOTP, "", []byte("482910"))
+	assert.True(t, errors.Is(err, ErrBodyTooShort))
+}
+
+// memSpan, memTracer and memMeter record spans and metrics in memory.
+type memSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *memSpan) SetAttributes(attrs ...otpgateway.Attr) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *memSpan) RecordError(err error) { s.err = err }
+func (s *memSpan) End()                  { s.ended = true }
+
+type memTracer struct {
+	spans []*memSpan
+}
+
+func (t *memTracer) Start(ctx context.Context, name string) (context.Context, otpgateway.Span) {
+	s := &memSpan{name: name, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+type memMeter struct {
+	counts map[string]int64
+	values map[string][]float64
+}
+
+func (m *memMeter) Add(ctx context.Context, name string, n int64, attrs ...otpgateway.Attr) {
+	for _, a := range attrs {
+		if a.Key == "otp.result" {
+			name += "." + a.Value.(string)
+		}
+	}
+	m.counts[name] += n
+}
+
+func (m *memMeter) Record(ctx context.Context, name string, v float64, attrs ...otpgateway.Attr) {
+	m.values[name] = append(m.values[name], v)
+}
+
+func TestTelemetry(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+	srvErr := newTestServer(http.StatusBadRequest, `{"code": "E101", "message": "invalid sender"}`)
+	defer srvErr.Close()
+
+	var (
+		tr = &memTracer{}
+		m  = &memMeter{counts: map[string]int64{}, values: map[string][]float64{}}
+	)
+	s := newTestSMS(t, srv.URL, "")
+	s.SetTelemetry(tr, m)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	s.def.rootURL = srvErr.URL + "/sid"
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	assert.Len(t, tr.spans, 2)
+	sp := tr.spans[0]
+	assert.Equal(t, otpgateway.SpanPush, sp.name)
+	assert.True(t, sp.ended)
+	assert.NoError(t, sp.err)
+	assert.Equal(t, map[string]interface{}{
+		"otp.provider":     "solsms",
+		"otp.channel":      "SMS",
+		"otp.result":       "success",
+		"otp.recipient":    "+91******3210",
+		"otp.status":       "sent",
+		"http.status_code": http.StatusOK,
+	}, sp.attrs)
+
+	sp = tr.spans[1]
+	assert.Error(t, sp.err)
+	assert.Equal(t, "failure", sp.attrs["otp.result"])
+	assert.Equal(t, http.StatusBadRequest, sp.attrs["http.status_code"])
+
+	assert.Equal(t, int64(1), m.counts[otpgateway.MetricPushes+".success"])
+	assert.Equal(t, int64(1), m.counts[otpgateway.MetricPushes+".failure"])
+	assert.Len(t, m.values[otpgateway.MetricPushDuration], 2)
+
+	// Nothing is recorded without telemetry.
+	s.SetTelemetry(nil, nil)
+	s.Push(mockOTP, "", []byte("Your code is 482910"))
+	assert.Len(t, tr.spans, 2)
+}
+
+func TestRateByCountry(t *testing.T) {
+	s := newTestSMS(t, "http://localhost", `"RateByCountry": {"+91": 1, "1": 0}, "RatePerSecond": 2`)
+	clk := clock.NewFake(time.Now())
+	s.limiter.clock = clk
+	l := s.limiter
+
+	// Countries are paced independently.
+	assert.Equal(t, time.Duration(0), l.reserve("91"))
+	assert.Equal(t, time.Second, l.reserve("91"))
+	assert.Equal(t, 2*time.Second, l.reserve("91"))
+
+	// The default applies to the unlisted countries, which share it.
+	assert.Equal(t, time.Duration(0), l.reserve("44"))
+	assert.Equal(t, time.Duration(0), l.reserve("49"))
+	assert.Equal(t, 500*time.Millisecond, l.reserve("44"))
+
+	// 0 is unlimited.
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), l.reserve("1"))
+	}
+
+	// Buckets refill over time.
+	clk.Advance(5 * time.Second)
+	assert.Equal(t, time.Duration(0), l.reserve("91"))
+	assert.Equal(t, time.Duration(0), l.reserve("44"))
+
+	// Pushes over the rate wait for their turn.
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+	s = newTestSMS(t, srv.URL, `"RateByCountry": {"91": 1}`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.PushContext(ctx, mockOTP, "", []byte("Your code is 482910"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	// Other countries aren't held up.
+	o := mockOTP
+	o.To = "+447400123456"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+
+	// No limiter without rates.
+	assert.Nil(t, newTestSMS(t, srv.URL, "").limiter)
+
+	for _, c := range []string{`"RatePerSecond": -1`, `"RateByCountry": {"91": -1}`, `"RateByCountry": {"IN": 1}`} {
+		_, err := ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", ` + c + `}`))
+		assert.Error(t, err, c)
+	}
+}
+
+func TestForceHTTP2(t *testing.T) {
+	var proto string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Proto
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	for _, c := range []struct {
+		extra string
+		proto string
+	}{
+		{`"MinTLSVersion": "1.2"`, "HTTP/1.1"},
+		{`"MinTLSVersion": "1.2", "ForceHTTP2": true`, "HTTP/2.0"},
+	} {
+		s := newTestSMS(t, srv.URL, c.extra)
+		s.h.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+		assert.Equal(t, c.proto, proto, c.extra)
+	}
+}
+
+func TestStripLeadingPlus(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	for _, c := range []struct {
+		extra string
+		to    string
+	}{
+		{"", "+919876543210"},
+		{`"StripLeadingPlus": true`, "919876543210"},
+		{`"StripLeadingPlus": true, "DefaultRegion": "IN"`, "919876543210"},
+		{`"StripLeadingPlus": true, "StrictValidation": true`, "919876543210"},
+	} {
+		s := newTestSMS(t, srv.URL, c.extra)
+		assert.NoError(t, s.ValidateAddress("+919876543210"), c.extra)
+
+		o := mockOTP
+		o.To = "+919876543210"
+		assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")), c.extra)
+		assert.Equal(t, c.to, srv.lastParams().Get("to"), c.extra)
+	}
+
+	// Numbers entered without the + are still accepted.
+	s := newTestSMS(t, srv.URL, `"StripLeadingPlus": true`)
+	assert.NoError(t, s.ValidateAddress("919876543210"))
+	o := mockOTP
+	o.To = "919876543210"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "919876543210", srv.lastParams().Get("to"))
+}
+
+func TestLastError(t *testing.T) {
+	var (
+		srv    = newTestServer(http.StatusOK, `{"id": "msg1"}`)
+		srvErr = newTestServer(http.StatusBadRequest, `{"code": "E101"}`)
+		buf    = &bytes.Buffer{}
+	)
+	defer srv.Close()
+	defer srvErr.Close()
+
+	s := newTestSMS(t, srvErr.URL, "")
+	s.log = log.New(buf, "", 0)
+	err, _ := s.LastError()
+	assert.NoError(t, err)
+
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	err, at := s.LastError()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "E101")
+	assert.False(t, at.IsZero(), "last error time not set")
+	assert.NotContains(t, buf.String(), "recovered")
+
+	// A success clears the error and logs the recovery.
+	s.def.rootURL = srv.URL + "/sid"
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	err, at = s.LastError()
+	assert.NoError(t, err)
+	assert.True(t, at.IsZero())
+	assert.Contains(t, buf.String(), "recovered after 2 failed SMSes")
+
+	buf.Reset()
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NotContains(t, buf.String(), "recovered")
+}
+
+func TestOTPLength(t *testing.T) {
+	s := newTestSMS(t, "http://127.0.0.1", "")
+	assert.Equal(t, 6, s.MaxOTPLen())
+	assert.NoError(t, s.ValidateOTP("482910"))
+
+	for _, n := range []int{4, 8, 10} {
+		s := newTestSMS(t, "http://127.0.0.1", fmt.Sprintf(`"OTPLength": %d`, n))
+		assert.Equal(t, n, s.MaxOTPLen())
+		assert.Contains(t, s.ChannelDesc(), fmt.Sprintf("%d digit code", n))
+		assert.NoError(t, s.ValidateOTP(strings.Repeat("7", n)))
+		assert.True(t, errors.Is(s.ValidateOTP(strings.Repeat("7", n-1)), ErrInvalidOTP), "short OTP accepted")
+		assert.True(t, errors.Is(s.ValidateOTP(strings.Repeat("7", n+1)), ErrInvalidOTP), "long OTP accepted")
+		assert.True(t, errors.Is(s.ValidateOTP(strings.Repeat("a", n)), ErrInvalidOTP), "non-numeric OTP accepted")
+	}
+
+	for _, n := range []int{-1, 3, 11} {
+		_, err := New([]byte(fmt.Sprintf(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "OTPLength": %d}`, n)))
+		var cErr *ConfigError
+		if assert.True(t, errors.As(err, &cErr), "OTPLength %d accepted", n) {
+			assert.Equal(t, "OTPLength", cErr.Field)
+		}
+	}
+}
+
+func TestResendCooldown(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	// In-process cooldowns.
+	s := newTestSMS(t, srv.URL, `"ResendCooldown": 30, "DefaultRegion": "IN"`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, otpgateway.ErrCooldown, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Different spellings of the number share the cooldown.
+	o := mockOTP
+	o.To = "9876543210"
+	assert.Equal(t, otpgateway.ErrCooldown, s.Push(o, "", []byte("Your code is 482910")))
+	o.To = "+14155551234"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+
+	// Other namespaces have their own cooldowns.
+	o.Namespace = "other"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, uint64(3), s.Stats().Sent)
+	assert.Equal(t, uint64(0), s.Stats().Failed, "cooldowns counted as failures")
+
+	// Queued pushes are checked when they're enqueued.
+	s = newTestSMS(t, srv.URL, `"ResendCooldown": 30, "AsyncQueueSize": 10`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, otpgateway.ErrCooldown, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, uint64(1), s.Stats().Sent)
+
+	// Disabled by default.
+	s = newTestSMS(t, srv.URL, "")
+	s.SetCooldownStore(otpgateway.NewMemCooldownStore())
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+}
+
+func TestResendCooldownRedis(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	rd, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	port, _ := strconv.Atoi(rd.Port())
+	conf := otpgateway.RedisConf{Host: rd.Host(), Port: port}
+
+	// Two gateway instances sharing the Redis.
+	a := newTestSMS(t, srv.URL, `"ResendCooldown": 30`)
+	a.SetCooldownStore(otpgateway.NewRedisCooldownStore(conf))
+	b := newTestSMS(t, srv.URL, `"ResendCooldown": 30`)
+	b.SetCooldownStore(otpgateway.NewRedisCooldownStore(conf))
+
+	assert.NoError(t, a.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, otpgateway.ErrCooldown, b.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, otpgateway.ErrCooldown, a.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, uint64(0), b.Stats().Sent)
+
+	rd.FastForward(30 * time.Second)
+	assert.NoError(t, b.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Redis errors fail the push.
+	rd.Close()
+	err = a.Push(mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err)
+	assert.NotEqual(t, otpgateway.ErrCooldown, err)
+}
+
+func TestContentBlocking(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"BlockURLsInBody": true, "BannedSubstrings": ["Password", ""]`)
+	for _, b := range []string{
+		"Your code is 482910. Don't share it with anyone.",
+		"482910 is your Acme code, e.g. for logins. Valid for 5 mins.",
+	} {
+		assert.NoError(t, s.Push(mockOTP, "", []byte(b)), b)
+	}
+
+	for _, b := range []string{
+		"Your code is 482910. Visit https://evil.example/login",
+		"Your code is 482910. Go to www.example.com",
+		"Your code is 482910 at acme-login.com/verify",
+		"Your code is 482910. Reply with your PASSWORD",
+	} {
+		err := s.Push(mockOTP, "", []byte(b))
+		assert.True(t, errors.Is(err, ErrContentBlocked), b)
+	}
+
+	// The OTP itself is exempt.
+	s = newTestSMS(t, srv.URL, `"BannedSubstrings": ["4829"], "GroupOTPDigits": 3`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	o := mockOTP
+	o.OTP = "ab.com"
+	s = newTestSMS(t, srv.URL, `"BlockURLsInBody": true`)
+	assert.NoError(t, s.Push(o, "", []byte("Your code is ab.com")))
+
+	// Disabled by default.
+	s = newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910. Visit https://example.com")))
+}
+
+func TestNumericFallbackSender(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"NumericFallbackSender": "+14155550100"`)
+	assert.Equal(t, []string{"US", "CA"}, s.cfg.NumericSenderCountries)
+
+	// Alphanumeric senders are allowed in India.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "SENDER", srv.lastParams().Get("sender"))
+
+	// ... and not in the US.
+	o := mockOTP
+	o.To = "+14155551234"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+14155550100", srv.lastParams().Get("sender"))
+	assert.Equal(t, "+14155550100", s.SenderFor(o.To))
+
+	res, err := s.Preview(o, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "+14155550100", res.Sender)
+
+	// Custom countries.
+	s = newTestSMS(t, srv.URL, `"NumericFallbackSender": "14155550100", "NumericSenderCountries": ["in"]`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "14155550100", srv.lastParams().Get("sender"))
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "SENDER", srv.lastParams().Get("sender"))
+
+	// Numeric senders are kept.
+	s = newTestSMS(t, srv.URL, `"Sender": "+14155550199", "NumericFallbackSender": "+14155550100"`)
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "+14155550199", srv.lastParams().Get("sender"))
+
+	// Disabled by default.
+	s = newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "SENDER", srv.lastParams().Get("sender"))
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "NumericFallbackSender": "ACME"}`))
+	var cErr *ConfigError
+	if assert.True(t, errors.As(err, &cErr)) {
+		assert.Equal(t, "NumericFallbackSender", cErr.Field)
+	}
+}
+
+func TestDetailedTiming(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	s := newTestSMS(t, srv.URL, `"DetailedTiming": true, "MinTLSVersion": "1.2"`)
+	s.h.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	if assert.NotNil(t, res.Timing) {
+		tm := res.Timing
+		assert.False(t, tm.Reused)
+		assert.True(t, tm.Connect > 0, "connect timing not set")
+		assert.True(t, tm.TLS > 0, "TLS timing not set")
+		assert.True(t, tm.FirstByte >= 10*time.Millisecond, "first byte timing not set")
+		assert.True(t, tm.Total >= tm.FirstByte, "total timing not set")
+	}
+
+	// Reused connections have no connect or TLS timings.
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	if assert.NotNil(t, res.Timing) {
+		assert.True(t, res.Timing.Reused, "connection not reused")
+		assert.Equal(t, time.Duration(0), res.Timing.Connect)
+		assert.Equal(t, time.Duration(0), res.Timing.TLS)
+		assert.True(t, res.Timing.FirstByte > 0)
+	}
+
+	// Disabled by default.
+	s = newTestSMS(t, srv.URL, `"MinTLSVersion": "1.2"`)
+	s.h.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Nil(t, res.Timing)
+}
+
+func TestStrictResponseParsing(t *testing.T) {
+	var (
+		srvNoStatus = newTestServer(http.StatusOK, `{"id": "msg1", "data": {"unexpected": true}}`)
+		srvBad      = newTestServer(http.StatusOK, `{"id": "msg1", "status": ["sent"]}`)
+		srvErr      = newTestServer(http.StatusInternalServerError, `<html>Internal error</html>`)
+		buf         = &bytes.Buffer{}
+	)
+	defer srvNoStatus.Close()
+	defer srvBad.Close()
+	defer srvErr.Close()
+
+	// Lenient.
+	s := newTestSMS(t, srvNoStatus.URL, "")
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "msg1", Status: "sent"}, res)
+
+	s = newTestSMS(t, srvBad.URL, "")
+	s.log = log.New(buf, "", 0)
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{Status: "sent"}, res)
+	assert.Contains(t, buf.String(), "WARNING: treating 2xx response as sent")
+	assert.Equal(t, uint64(1), s.Stats().Sent)
+
+	// Non 2xx responses fail regardless.
+	s = newTestSMS(t, srvErr.URL, "")
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Strict.
+	for _, u := range []string{srvNoStatus.URL, srvBad.URL} {
+		s = newTestSMS(t, u, `"StrictResponseParsing": true`)
+		_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		assert.True(t, errors.Is(err, ErrUnparsableResponse), u)
+	}
+}
+
+func TestRatePerNamespace(t *testing.T) {
+	s := newTestSMS(t, "http://localhost", `"RatePerNamespace": 1`)
+	clk := clock.NewFake(time.Now())
+	s.nsLimiter.clock = clk
+	l := s.nsLimiter
+
+	// Namespaces are paced independently.
+	assert.Equal(t, time.Duration(0), l.reserve("a"))
+	assert.Equal(t, time.Second, l.reserve("a"))
+	assert.Equal(t, 2*time.Second, l.reserve("a"))
+	assert.Equal(t, time.Duration(0), l.reserve("b"))
+	assert.Equal(t, time.Second, l.reserve("b"))
+
+	// Idle buckets are evicted once they've refilled.
+	clk.Advance(nsBucketIdle)
+	assert.Equal(t, time.Duration(0), l.reserve("b"))
+	assert.Len(t, l.buckets, 1, "idle bucket not evicted")
+	_, ok := l.buckets["a"]
+	assert.False(t, ok)
+
+	// A bucket in use isn't.
+	clk.Advance(nsBucketIdle / 2)
+	l.reserve("b")
+	clk.Advance(nsBucketIdle / 2)
+	l.reserve("c")
+	assert.Len(t, l.buckets, 2)
+
+	// Pushes over the rate wait for their turn without holding up
+	// other namespaces.
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+	s = newTestSMS(t, srv.URL, `"RatePerNamespace": 1`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.PushContext(ctx, mockOTP, "", []byte("Your code is 482910"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	o := mockOTP
+	o.Namespace = "other"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+
+	assert.Nil(t, newTestSMS(t, srv.URL, "").nsLimiter)
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "RatePerNamespace": -1}`))
+	assert.Error(t, err)
+}
+
+func TestDedupe(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"DedupeWindow": 60`)
+	clk := clock.NewFake(time.Now())
+	s.dedupe.clock = clk
+
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Duplicates within the window are suppressed.
+	assert.Equal(t, ErrDuplicateSuppressed, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, uint64(1), s.Stats().Sent)
+
+	// Other bodies and numbers aren't.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 112233")))
+	o := mockOTP
+	o.To = "+14155551234"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, uint64(3), s.Stats().Sent)
+
+	// Duplicates after the window are sent.
+	clk.Advance(time.Minute)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, uint64(4), s.Stats().Sent)
+
+	// Failed SMSes aren't deduped.
+	srvErr := newTestServer(http.StatusBadRequest, `{"code": "E101"}`)
+	defer srvErr.Close()
+	s = newTestSMS(t, srvErr.URL, `"DedupeWindow": 60`)
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NotEqual(t, ErrDuplicateSuppressed, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	// Silent.
+	s = newTestSMS(t, srv.URL, `"DedupeWindow": 60, "DedupeSilent": true`)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "msg1", Status: "sent"}, res)
+	assert.Equal(t, uint64(1), s.Stats().Sent)
+
+	// Disabled by default.
+	s = newTestSMS(t, srv.URL, "")
+	assert.Nil(t, s.dedupe)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+}
+
+func TestControlChars(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Content-Type") == "application/json" {
+			var p map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&p), "invalid JSON payload")
+			body = p["body"]
+		} else {
+			r.ParseForm()
+			body = r.Form.Get("body")
+		}
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	for _, enc := range []string{"form", "json"} {
+		s := newTestSMS(t, srv.URL, `"Encoding": "`+enc+`"`)
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is\n482910\x00\x1b\r\tThanks")), enc)
+		assert.Equal(t, "Your code is\n482910\r\tThanks", body, enc)
+	}
+
+	assert.Equal(t, []byte("Your code is 482910"), stripControlChars([]byte("Your code is 482910")))
+	assert.Equal(t, []byte("आपका 482910"), stripControlChars([]byte("आपका\u0007 482910\u009f")))
+}
+
+func TestBodyTemplatesByCountry(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"DefaultBody": "Code: {{ .OTP }}", "GroupOTPDigits": 3,
+		"BodyTemplatesByCountry": {"in": "{{ .OTP }} is your OTP. Do not share this OTP with anyone."}`)
+
+	// The country's template replaces the body and the DefaultBody.
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "482 910 is your OTP. Do not share this OTP with anyone.", srv.lastParams().Get("body"))
+	assert.NoError(t, s.Push(mockOTP, "", nil))
+	assert.Equal(t, "482 910 is your OTP. Do not share this OTP with anyone.", srv.lastParams().Get("body"))
+
+	// Other countries fall back to the body and the DefaultBody.
+	o := mockOTP
+	o.To = "+14155551234"
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	assert.Equal(t, "Your code is 482 910", srv.lastParams().Get("body"))
+	assert.NoError(t, s.Push(o, "", nil))
+	assert.Equal(t, "Code: 482 910", srv.lastParams().Get("body"))
+
+	// Templates must include the OTP.
+	for _, tpl := range []string{"Your code is ready", "{{ .OTP "} {
+		_, err := ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER",
+			"BodyTemplatesByCountry": {"IN": "` + tpl + `"}}`))
+		var e *ConfigError
+		if assert.True(t, errors.As(err, &e), tpl) {
+			assert.Equal(t, "BodyTemplatesByCountry[IN]", e.Field)
+		}
+	}
+}
+
+func TestBodyTemplateContext(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"DefaultBody": "{{ .OTP }} for {{ .Extra.name }} to {{ .To }} on {{ .Namespace }} from {{ .Sender }}{{ if not .Expiry.IsZero }}, expires soon{{ end }}"`)
+	o := mockOTP
+	o.Namespace = "myapp"
+	o.TTL = time.Minute
+	o.Extra = []byte(`{"name": "John"}`)
+	assert.NoError(t, s.Push(o, "", nil))
+	assert.Equal(t, "482910 for John to +919876543210 on myapp from SENDER, expires soon", srv.lastParams().Get("body"))
+
+	// The sender is that of the account the namespace is routed to.
+	s = newTestSMS(t, srv.URL, `"DefaultBody": "{{ .OTP }} from {{ .Sender }}",
+		"Accounts": [{"SID": "brand", "APIKey": "brandkey", "Sender": "BRAND", "Namespaces": ["brandapp"]}]`)
+	o.Namespace = "brandapp"
+	assert.NoError(t, s.Push(o, "", nil))
+	assert.Equal(t, "BRAND", srv.lastParams().Get("sender"))
+	assert.Equal(t, "482910 from BRAND", srv.lastParams().Get("body"))
+}
+
+type auditRecorder struct {
+	mu      sync.Mutex
+	records []otpgateway.SentRecord
+}
+
+func (a *auditRecorder) Record(r otpgateway.SentRecord) {
+	a.mu.Lock()
+	a.records = append(a.records, r)
+	a.mu.Unlock()
+}
+
+func TestAuditRecord(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1", "status": "OK"}`)
+	defer srv.Close()
+
+	for _, enc := range []string{"form", "json"} {
+		var (
+			a = &auditRecorder{}
+			s = newTestSMS(t, srv.URL, `"APIKey": "s3cr3t-api-key", "SigningSecret": "s3cr3t-signing",
+				"GroupOTPDigits": 3, "Encoding": "`+enc+`"`)
+		)
+		s.SetAuditSink(a)
+
+		start := time.Now()
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+		if !assert.Len(t, a.records, 1, enc) {
+			continue
+		}
+
+		r := a.records[0]
+		assert.Equal(t, providerID, r.Provider)
+		assert.Equal(t, srv.URL+"/sid/messages", r.Endpoint)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "msg1", r.MessageID)
+		assert.False(t, r.Timestamp.Before(start))
+		assert.Equal(t, otpgateway.Redacted, r.Headers.Get("api-key"))
+		assert.Equal(t, srv.lastRequest().Header.Get(headerSignature), r.Headers.Get(headerSignature))
+
+		// The body is as sent with the recipient and the OTP masked.
+		if enc == "json" {
+			var p map[string]string
+			assert.NoError(t, json.Unmarshal([]byte(r.Body), &p))
+			assert.Equal(t, "+91******3210", p["to"])
+			assert.Equal(t, "Your code is *** ***", p["body"])
+			assert.Equal(t, "SENDER", p["sender"])
+		} else {
+			p, err := url.ParseQuery(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "+91******3210", p.Get("to"))
+			assert.Equal(t, "Your code is *** ***", p.Get("body"))
+		}
+
+		// The hex signature header may contain digits of the recipient
+		// or the OTP by chance, so they're only checked in the body.
+		b, _ := json.Marshal(r)
+		for _, secret := range []string{"s3cr3t-api-key", "s3cr3t-signing"} {
+			assert.NotContains(t, string(b), secret, enc)
+		}
+		for _, secret := range []string{mockOTP.To[3:], "482"} {
+			assert.NotContains(t, r.Body, secret, enc)
+		}
+	}
+
+	// Failed requests are recorded without a message ID.
+	fail := newTestServer(http.StatusBadRequest, `{"code": "E101", "message": "invalid number"}`)
+	defer fail.Close()
+	a := &auditRecorder{}
+	s := newTestSMS(t, fail.URL, "")
+	s.SetAuditSink(a)
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	if assert.Len(t, a.records, 1) {
+		assert.Empty(t, a.records[0].MessageID)
+	}
+}
+
+func TestChannel(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1", "status": "OK"}`)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		channel string
+		name    string
+		path    string
+		params  map[string]string
+	}{
+		{"", "SMS", "/sid/messages", map[string]string{
+			"sender": "SENDER", "to": "+919876543210", "body": "Your code is 482910", "shorten_url": "0"}},
+		{"WhatsApp", "WhatsApp", "/sid/messages", map[string]string{
+			"channel": "whatsapp", "from": "SENDER", "to": "+919876543210", "type": "text", "body": "Your code is 482910"}},
+		{"voice", "Voice", "/sid/voice/outbound", map[string]string{
+			"bridge": "SENDER", "to": "+919876543210", "target": `[{"message":{"text":"Your code is 482910"}}]`}},
+	} {
+		s := newTestSMS(t, srv.URL, `"Channel": "`+tc.channel+`"`)
+		assert.Equal(t, tc.name, s.ChannelName(), tc.channel)
+
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")), tc.channel)
+		assert.Equal(t, tc.path, srv.lastRequest().URL.Path, tc.channel)
+
+		p := srv.lastParams()
+		assert.Len(t, p, len(tc.params), tc.channel)
+		for k, v := range tc.params {
+			assert.Equal(t, v, p.Get(k), "%s: %s", tc.channel, k)
+		}
+	}
+
+	s := newTestSMS(t, srv.URL, `"Channel": "whatsapp"`)
+	assert.Contains(t, s.ChannelDesc(), "6 digit code on WhatsApp")
+
+	for _, c := range []string{`"Channel": "fax"`, `"Channel": "voice", "UseOTPEndpoint": true`} {
+		_, err := New([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", ` + c + `}`))
+		var e *ConfigError
+		assert.True(t, errors.As(err, &e), c)
+	}
+}
+
+func TestRetryWithinValidity(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("body") == "blocked" {
+			<-unblock
+			w.Write([]byte(`{"id": "msg1"}`))
+			return
+		}
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.Write([]byte(`{"code": "E110", "message": "failed"}`))
+	}))
+	defer srv.Close()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := calls
+		calls = 0
+		return n
+	}
+	const (
+		cfg  = `"RetryableErrorCodes": ["E110"], "MaxRetries": 2, "RetryWait": 100, "RetryJitter": "none"`
+		wait = 100 * time.Millisecond
+	)
+
+	// push pushes an SMS in the background, advancing the clock past the
+	// given number of retry waits.
+	clk := clock.NewFake(time.Now())
+	push := func(s *sms, o models.OTP, retries int) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.PushContext(context.Background(), o, "", []byte("Your code is 482910"))
+			done <- err
+		}()
+		for i := 0; i < retries; i++ {
+			clk.BlockUntil(1)
+			clk.Advance(wait)
+		}
+		return <-done
+	}
+
+	// The OTP expires before the first retry, which is suppressed.
+	s := newTestSMS(t, srv.URL, cfg)
+	s.clock = clk
+	o := mockOTP
+	o.TTL = 50 * time.Millisecond
+	assert.Error(t, push(s, o, 0))
+	assert.Equal(t, 1, count())
+	assert.Equal(t, uint64(0), s.Stats().Retried)
+
+	// The OTP expires after the first retry.
+	o.TTL = 150 * time.Millisecond
+	assert.Error(t, push(s, o, 1))
+	assert.Equal(t, 2, count())
+
+	// The retries are sent within the validity.
+	o.TTL = 0
+	o.TTLSeconds = 10
+	assert.Error(t, push(s, o, 2))
+	assert.Equal(t, 3, count())
+
+	// ... or regardless of it.
+	s = newTestSMS(t, srv.URL, cfg+`, "RetryAfterExpiry": true`)
+	s.clock = clk
+	o.TTLSeconds = 0
+	o.TTL = time.Millisecond
+	assert.Error(t, push(s, o, 2))
+	assert.Equal(t, 3, count())
+
+	// The time spent in the async queue counts towards the validity. The
+	// worker is blocked on the first SMS while the second one waits in
+	// the queue, by which time its only retry would be after expiry.
+	s = newTestSMS(t, srv.URL, cfg+`, "AsyncQueueSize": 2`)
+	s.clock = clk
+	assert.NoError(t, s.Push(mockOTP, "", []byte("blocked")))
+	o.TTL = 150 * time.Millisecond
+	assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+	clk.Advance(wait)
+	close(unblock)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 1, count())
+	assert.Equal(t, uint64(0), s.Stats().Retried)
+}
+
+func TestRetryJitter(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	const wait = 100 * time.Millisecond
+	for _, tc := range []struct {
+		jitter   string
+		min, max time.Duration
+	}{
+		{"", 0, wait},
+		{"equal", wait / 2, wait},
+		{"none", wait, wait},
+	} {
+		s := newTestSMS(t, srv.URL, `"RetryableErrorCodes": ["E110"], "RetryWait": 100, "RetryJitter": "`+tc.jitter+`"`)
+
+		// Count the waits in each tenth of the range.
+		var (
+			buckets = map[int]int{}
+			lo, hi  = wait, time.Duration(0)
+		)
+		for i := 0; i < 1000; i++ {
+			d := s.retryWait()
+			if d < lo {
+				lo = d
+			}
+			if d > hi {
+				hi = d
+			}
+			if tc.max > tc.min {
+				buckets[int((d-tc.min)*10/(tc.max-tc.min+1))]++
+			}
+		}
+		assert.True(t, lo >= tc.min && hi <= tc.max, "%s: waits %v - %v out of range", tc.jitter, lo, hi)
+		if tc.max == tc.min {
+			continue
+		}
+
+		// Spread across the range rather than clustered.
+		assert.Len(t, buckets, 10, tc.jitter)
+		for b, n := range buckets {
+			assert.True(t, n > 50, "%s: %d waits in bucket %d", tc.jitter, n, b)
+		}
+	}
+
+	_, err := ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "RetryJitter": "random"}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "RetryJitter", e.Field)
+	}
+}
+
+func TestParseWebhook(t *testing.T) {
+	var (
+		s      = newTestSMS(t, "http://localhost", `"CallbackToken": "s3cr3t"`)
+		events []otpgateway.Event
+		h      = otpgateway.WebhookMux(func(e otpgateway.Event) { events = append(events, e) }, s)
+	)
+	call := func(method, query, ctype, body string) int {
+		req := httptest.NewRequest(method, "/webhooks/solsms?"+query, strings.NewReader(body))
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Delivery reports as query params, a form, and JSON.
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, "token=s3cr3t&id=msg1&status=DELIVRD&to=919876543210&custom=order-123", "", ""))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "token=s3cr3t", "application/x-www-form-urlencoded",
+		"id=msg2&status=UNDELIV&error=absent+subscriber&to=919876543210"))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "token=s3cr3t", "application/json",
+		`{"id": 12345, "status": "SENT", "to": "919876543210", "custom": "order-456"}`))
+
+	// Inbound SMSes. Only opt-outs are emitted.
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "token=s3cr3t", "application/json",
+		`{"mobile": "919876543210", "message": "Stop"}`))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "token=s3cr3t", "application/json",
+		`{"mobile": "919876543210", "message": "hello"}`))
+
+	if assert.Len(t, events, 4) {
+		for _, e := range events {
+			assert.Equal(t, providerID, e.Provider)
+			assert.Equal(t, "919876543210", e.Address)
+		}
+		assert.Equal(t, otpgateway.Event{Type: otpgateway.EventDelivery, Provider: providerID, MessageID: "msg1",
+			Status: otpgateway.StatusDelivered, Address: "919876543210", Reference: "order-123", Timestamp: events[0].Timestamp}, events[0])
+		assert.Equal(t, otpgateway.StatusFailed, events[1].Status)
+		assert.Equal(t, "absent subscriber", events[1].Error)
+		assert.Equal(t, "12345", events[2].MessageID)
+		assert.Equal(t, statusSent, events[2].Status, "in-flight status not normalized")
+		assert.Equal(t, "order-456", events[2].Reference)
+		assert.Equal(t, otpgateway.EventOptOut, events[3].Type)
+	}
+
+	// Unauthenticated and unknown callbacks.
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "token=wrong&id=msg1&status=DELIVRD", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "id=msg1&status=DELIVRD", "", ""))
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodGet, "token=s3cr3t&foo=bar", "", ""))
+
+	// Callbacks are rejected without a CallbackToken.
+	s = newTestSMS(t, "http://localhost", "")
+	_, err := s.ParseWebhook(httptest.NewRequest(http.MethodGet, "/?token=&id=msg1&status=DELIVRD", nil), nil)
+	assert.True(t, errors.Is(err, otpgateway.ErrWebhookUnauthorized))
+	assert.Len(t, events, 4)
+}
+
+func TestMaxResponseHeaderBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Junk", strings.Repeat("x", 64<<10))
+		w.Write([]byte(`{"id": "msg1", "status": "OK"}`))
+	}))
+	defer srv.Close()
+
+	// Go's default limit allows the headers.
+	s := newTestSMS(t, srv.URL, "")
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+
+	s = newTestSMS(t, srv.URL, `"MaxResponseHeaderBytes": 4096`)
+	err := s.Push(mockOTP, "", []byte("Your code is 482910"))
+	var e *RequestError
+	if assert.True(t, errors.As(err, &e), "%v", err) {
+		assert.Contains(t, e.Error(), "header")
+	}
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "MaxResponseHeaderBytes": -1}`))
+	var ce *ConfigError
+	if assert.True(t, errors.As(err, &ce)) {
+		assert.Equal(t, "MaxResponseHeaderBytes", ce.Field)
+	}
+}
+
+func TestBlockedDestinations(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1", "status": "OK"}`)
+	defer srv.Close()
+
+	var (
+		in = mockOTP
+		us = mockOTP
+		gb = mockOTP
+	)
+	us.To = "+14155551234"
+	gb.To = "+447400123456"
+
+	// Blocked prefix.
+	s := newTestSMS(t, srv.URL, `"BlockedPrefixes": ["+44 7400", "1415"]`)
+	assert.True(t, errors.Is(s.Push(gb, "", []byte("Your code is 482910")), ErrCountryBlocked))
+	assert.True(t, errors.Is(s.Push(us, "", []byte("Your code is 482910")), ErrCountryBlocked))
+	assert.NoError(t, s.Push(in, "", []byte("Your code is 482910")))
+
+	// Blocked country.
+	s = newTestSMS(t, srv.URL, `"BlockedCountries": ["gb"]`)
+	assert.True(t, errors.Is(s.Push(gb, "", []byte("Your code is 482910")), ErrCountryBlocked))
+	assert.NoError(t, s.Push(us, "", []byte("Your code is 482910")))
+	_, err := s.Preview(gb, "", []byte("Your code is 482910"))
+	assert.True(t, errors.Is(err, ErrCountryBlocked), "Preview not blocked")
+
+	// Allowlist only.
+	s = newTestSMS(t, srv.URL, `"AllowedCountries": ["IN", "US"]`)
+	assert.NoError(t, s.Push(in, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(us, "", []byte("Your code is 482910")))
+	assert.True(t, errors.Is(s.Push(gb, "", []byte("Your code is 482910")), ErrCountryBlocked))
+
+	// A blocked number isn't sent to.
+	queued := newTestServer(http.StatusOK, `{"id": "msg1", "status": "OK"}`)
+	defer queued.Close()
+	s = newTestSMS(t, queued.URL, `"BlockedCountries": ["IN"], "AsyncQueueSize": 10`)
+	assert.True(t, errors.Is(s.Push(in, "", []byte("Your code is 482910")), ErrCountryBlocked))
+	assert.NoError(t, s.Close())
+	assert.Nil(t, queued.lastRequest(), "SMS sent to blocked country")
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "BlockedPrefixes": ["+44x"]}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "BlockedPrefixes[0]", e.Field)
+	}
+}
+
+type rotatingCreds struct {
+	mu      sync.Mutex
+	version int
+	fetches int
+	err     error
+}
+
+func (r *rotatingCreds) APIKey(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fetches++
+	if r.err != nil {
+		return "", r.err
+	}
+	return fmt.Sprintf("key-v%d", r.version), nil
+}
+
+func (r *rotatingCreds) rotate() {
+	r.mu.Lock()
+	r.version++
+	r.mu.Unlock()
+}
+
+func TestCredentialProvider(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		valid = "key-v1"
+		keys  []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("api-key"))
+		if r.Header.Get("api-key") != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code": "E001", "message": "unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"id": "msg1", "status": "OK"}`))
+	}))
+	defer srv.Close()
+	lastKey := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return keys[len(keys)-1]
+	}
+
+	// The static key is used without a CredentialProvider.
+	s := newTestSMS(t, srv.URL, `"CredentialCacheTTL": 30`)
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "key", lastKey())
+
+	clk := clock.NewFake(time.Now())
+	s.clock = clk
+	creds := &rotatingCreds{version: 1}
+	s.SetCredentialProvider(creds)
+
+	// The key is cached.
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+		assert.Equal(t, "key-v1", lastKey())
+	}
+	assert.Equal(t, 1, creds.fetches)
+
+	// The rotated key is fetched once the cached one expires.
+	creds.rotate()
+	mu.Lock()
+	valid = "key-v2"
+	mu.Unlock()
+	clk.Advance(31 * time.Second)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "key-v2", lastKey())
+	assert.Equal(t, 2, creds.fetches)
+
+	// A key revoked before it expires is refetched after the 401.
+	creds.rotate()
+	mu.Lock()
+	valid = "key-v3"
+	mu.Unlock()
+	assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Equal(t, "key-v3", lastKey())
+
+	// Fetch errors fail the push.
+	creds.err = errors.New("vault sealed")
+	clk.Advance(31 * time.Second)
+	err := s.Push(mockOTP, "", []byte("Your code is 482910"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vault sealed")
+}
+
+func TestSLATimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	// Under the SLA.
+	s := newTestSMS(t, srv.URL, `"SLATimeout": 5000`)
+	m := &memMeter{counts: map[string]int64{}, values: map[string][]float64{}}
+	s.SetTelemetry(&memTracer{}, m)
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.False(t, res.SLABreached)
+	assert.Zero(t, m.counts[otpgateway.MetricSLABreaches+".success"])
+	assert.Zero(t, s.Stats().SLABreached)
+
+	// Over the SLA, the push still succeeds.
+	s = newTestSMS(t, srv.URL, `"SLATimeout": 10`)
+	m = &memMeter{counts: map[string]int64{}, values: map[string][]float64{}}
+	tr := &memTracer{}
+	s.SetTelemetry(tr, m)
+	res, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.True(t, res.SLABreached)
+	assert.Equal(t, int64(1), m.counts[otpgateway.MetricSLABreaches+".success"])
+	assert.Equal(t, int64(1), m.counts[otpgateway.MetricPushes+".success"])
+	assert.Equal(t, true, tr.spans[0].attrs["otp.sla_breached"])
+	assert.Equal(t, uint64(1), s.Stats().SLABreached)
+	assert.Equal(t, uint64(1), s.Stats().Sent)
+}
+
+func TestWarmup(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []*http.Request
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		reqs = append(reqs, r)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("api-key") != "key" && r.Header.Get("api-key") != "key2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code": "RBC201", "message": "invalid API key"}`))
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer srv.Close()
+
+	// Valid credentials of the default and the routed accounts.
+	s := newTestSMS(t, srv.URL, `"Accounts": [{"APIKey": "key2", "SID": "sid2", "Sender": "S2", "Countries": ["US"]}]`)
+	assert.NoError(t, s.Warmup(context.Background()))
+	assert.NoError(t, otpgateway.Warmup(context.Background(), s))
+	if assert.Len(t, reqs, 4) {
+		assert.Equal(t, http.MethodGet, reqs[0].Method)
+		assert.Equal(t, "/sid/messages", reqs[0].URL.Path)
+		assert.Equal(t, "/sid2/messages", reqs[1].URL.Path)
+		assert.Empty(t, reqs[0].URL.Query().Get("to"), "warmup sent a message")
+	}
+	assert.Zero(t, s.Stats().Sent)
+
+	// Invalid default key.
+	s = newTestSMS(t, srv.URL, `"APIKey": "bad"`)
+	err := s.Warmup(context.Background())
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+	err = otpgateway.Warmup(context.Background(), s)
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+	assert.Contains(t, err.Error(), "solsms")
+
+	// Invalid key of a routed account.
+	s = newTestSMS(t, srv.URL, `"Accounts": [{"APIKey": "bad", "SID": "sid2", "Sender": "S2", "Countries": ["US"]}]`)
+	err = s.Warmup(context.Background())
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+	assert.Contains(t, err.Error(), "sid2")
+}
+
+func TestRecipientFormat(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		body = string(b)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "msg1"}`))
+	}))
+	defer srv.Close()
+
+	to := []string{"+919876543210", "+14155551234"}
+	for _, tc := range []struct {
+		format, enc string
+		single      string
+		multi       string
+	}{
+		{"", "form", "to=%2B919876543210", "to=%2B919876543210"},
+		{"scalar", "json", `"to":"+919876543210"`, `"to":"+919876543210"`},
+		{"comma", "form", "to=%2B919876543210", "to=%2B919876543210%2C%2B14155551234"},
+		{"comma", "json", `"to":"+919876543210"`, `"to":"+919876543210,+14155551234"`},
+		{"array", "form", "to=%2B919876543210", "to=%2B919876543210&to=%2B14155551234"},
+		{"array", "json", `"to":["+919876543210"]`, `"to":["+919876543210","+14155551234"]`},
+	} {
+		name := tc.format + "/" + tc.enc
+		s := newTestSMS(t, srv.URL, `"RecipientFormat": "`+tc.format+`", "Encoding": "`+tc.enc+`"`)
+
+		// A single recipient is sent in the format too.
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")), name)
+		mu.Lock()
+		assert.Contains(t, body, tc.single, name)
+		mu.Unlock()
+
+		p := url.Values{}
+		s.setRecipients(p, to)
+		b, _, err := s.encode(p)
+		assert.NoError(t, err, name)
+		assert.Contains(t, b.buf.String(), tc.multi, name)
+		b.Close()
+		if tc.format != "" && tc.format != "scalar" {
+			assert.Equal(t, to, s.recipients(p), name)
+		}
+	}
+
+	_, err := ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "RecipientFormat": "xml"}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "RecipientFormat", e.Field)
+	}
+}
+
+// fakeCounters is a CounterStore on a fake clock.
+type fakeCounters struct {
+	mu     sync.Mutex
+	clock  *clock.Fake
+	counts map[string]int
+	exp    map[string]time.Time
+}
+
+func (f *fakeCounters) Incr(key string, ttl time.Duration) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.exp[key]; !ok || !f.clock.Now().Before(e) {
+		f.counts[key] = 0
+		f.exp[key] = f.clock.Now().Add(ttl)
+	}
+	f.counts[key]++
+	return f.counts[key], nil
+}
+
+func (f *fakeCounters) Get(key string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.exp[key]; ok && f.clock.Now().Before(e) {
+		return f.counts[key], nil
+	}
+	return 0, nil
+}
+
+func TestAdaptiveSender(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	var (
+		s = newTestSMS(t, srv.URL, `"CallbackToken": "s3cr3t", "NumericFallbackSender": "15550001111",
+			"AdaptiveSenderThreshold": 3, "AdaptiveSenderCooldown": 60`)
+		clk   = clock.NewFake(time.Now())
+		store = &fakeCounters{clock: clk, counts: map[string]int{}, exp: map[string]time.Time{}}
+		gb    = "+447400123456"
+	)
+	s.SetCounterStore(store)
+	dlr := func(status, mobile string) {
+		r := httptest.NewRequest(http.MethodGet, "/?token=s3cr3t&id=msg1&status="+status+"&to="+mobile, nil)
+		_, err := s.ParseWebhook(r, nil)
+		assert.NoError(t, err)
+	}
+	sentFrom := func(to string) string {
+		o := mockOTP
+		o.To = to
+		assert.NoError(t, s.Push(o, "", []byte("Your code is 482910")))
+		return srv.lastParams().Get("sender")
+	}
+
+	// Failures under the threshold and successful deliveries keep
+	// the alphanumeric sender.
+	dlr("UNDELIV", "919876543210")
+	dlr("DELIVRD", "919876543210")
+	dlr("EXPIRED", "919876543210")
+	assert.Equal(t, "SENDER", s.SenderFor(mockOTP.To))
+	assert.Equal(t, "SENDER", sentFrom(mockOTP.To))
+
+	// The threshold switches the country to the long code.
+	dlr("UNDELIV", "919876543210")
+	assert.Equal(t, "15550001111", s.SenderFor(mockOTP.To))
+	assert.Equal(t, "15550001111", sentFrom(mockOTP.To))
+	assert.Equal(t, "15550001111", sentFrom("+919812345678"))
+	assert.Equal(t, "SENDER", sentFrom(gb), "other countries switched")
+
+	// Failures of the long code don't extend the switch.
+	clk.Advance(30 * time.Second)
+	dlr("UNDELIV", "919876543210")
+	assert.Equal(t, "15550001111", sentFrom(mockOTP.To))
+
+	// The alphanumeric sender is tried again after the cooldown with
+	// the failures reset.
+	clk.Advance(31 * time.Second)
+	assert.Equal(t, "SENDER", sentFrom(mockOTP.To))
+	dlr("UNDELIV", "919876543210")
+	assert.Equal(t, "SENDER", sentFrom(mockOTP.To))
+
+	_, err := ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "AdaptiveSenderThreshold": 3}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "AdaptiveSenderThreshold", e.Field)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	srv := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv.Close()
+
+	s := newTestSMS(t, srv.URL, `"MetadataFields": ["campaign", "tenant", "segment"]`)
+	ctx := otpgateway.WithMetadata(context.Background(), map[string]string{
+		"campaign": "diwali",
+		"segment":  "new",
+		"unmapped": "x",
+	})
+	_, err := s.PushContext(otpgateway.WithReference(ctx, "order-123"), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+
+	p := srv.lastParams()
+	assert.Equal(t, "diwali", p.Get("custom1"))
+	assert.Equal(t, "new", p.Get("custom3"))
+	assert.Equal(t, "order-123", p.Get("custom"))
+	for _, k := range []string{"custom2", "custom4", "unmapped"} {
+		_, ok := p[k]
+		assert.False(t, ok, k)
+	}
+
+	// Without MetadataFields, metadata isn't sent.
+	s = newTestSMS(t, srv.URL, "")
+	_, err = s.PushContext(ctx, mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	_, ok := srv.lastParams()["custom1"]
+	assert.False(t, ok)
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "MetadataFields": ["a", "b", "c", "d", "e"]}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "MetadataFields", e.Field)
+	}
+}
+
+func TestWaitForDelivery(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		statuses []string
+		lookups  int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"id": "msg1"}`))
+			return
+		}
+
+		// Each lookup returns the next status, repeating the last one.
+		mu.Lock()
+		defer mu.Unlock()
+		st := statuses[len(statuses)-1]
+		if lookups < len(statuses) {
+			st = statuses[lookups]
+		}
+		lookups++
+		if st == "" {
+			w.Write([]byte(`{"data": []}`))
+			return
+		}
+		fmt.Fprintf(w, `{"data": [{"id": "%s", "status": "%s"}]}`, r.URL.Query().Get("id"), st)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		statuses []string
+		status   string
+		err      error
+	}{
+		{"delivered", []string{"", "SENT", "DELIVRD"}, "delivered", nil},
+		{"failed", []string{"SENT", "UNDELIV"}, "failed", ErrDeliveryFailed},
+		{"timeout", []string{"SENT"}, "pending", ErrDeliveryTimeout},
+	} {
+		mu.Lock()
+		statuses, lookups = tc.statuses, 0
+		mu.Unlock()
+
+		s := newTestSMS(t, srv.URL, `"WaitForDelivery": true, "DeliveryWaitTimeout": 200, "DeliveryPollInterval": 10,
+			"StatusCacheTTL": 60, "PendingStatusCacheTTL": 60, "DedupeWindow": 60`)
+		res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		if tc.err == nil {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.True(t, errors.Is(err, tc.err), "%s: %v", tc.name, err)
+		}
+		assert.Equal(t, "msg1", res.ID, tc.name)
+		assert.Equal(t, tc.status, res.Status, tc.name)
+
+		mu.Lock()
+		assert.True(t, lookups >= len(tc.statuses), "%s: %d lookups", tc.name, lookups)
+		mu.Unlock()
+		if tc.err != ErrDeliveryTimeout {
+			st, err := s.DeliveryStatus(context.Background(), "msg1")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, st, "%s: terminal status not cached", tc.name)
+		}
+
+		// Undelivered SMSes count as failures and can be resent.
+		_, err = s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+		st := s.Stats()
+		if tc.err == nil {
+			assert.Equal(t, uint64(1), st.Sent, tc.name)
+			assert.True(t, errors.Is(err, ErrDuplicateSuppressed), "%s: %v", tc.name, err)
+		} else {
+			assert.Equal(t, uint64(0), st.Sent, tc.name)
+			assert.Equal(t, uint64(2), st.Failed, tc.name)
+			assert.True(t, errors.Is(err, tc.err), "%s: undelivered SMS deduped: %v", tc.name, err)
+		}
+	}
+
+	// Without WaitForDelivery, PushContext returns as soon as it's sent.
+	srv2 := newTestServer(http.StatusOK, `{"id": "msg1"}`)
+	defer srv2.Close()
+	s := newTestSMS(t, srv2.URL, "")
+	res, err := s.PushContext(context.Background(), mockOTP, "", []byte("Your code is 482910"))
+	assert.NoError(t, err)
+	assert.Equal(t, statusSent, res.Status)
+	assert.Equal(t, http.MethodPost, srv2.lastRequest().Method)
+
+	_, err = ParseConfig([]byte(`{"APIKey": "key", "SID": "sid", "Sender": "SENDER", "WaitForDelivery": true, "UseOTPEndpoint": true}`))
+	var e *ConfigError
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, "WaitForDelivery", e.Field)
+	}
+}
+
+func TestResponseLogSampling(t *testing.T) {
+	// responses returns the response log lines.
+	responses := func(buf *bytes.Buffer) []string {
+		var out []string
+		for _, l := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(l, " response (HTTP ") {
+				out = append(out, l)
+			}
+		}
+		return out
+	}
+
+	c := testutil.NewCarrier(testutil.OK(`{"id": "msg1", "status": "sent", "to": "919876543210", "body": "Your code is 482910"}`))
+	defer c.Close()
+
+	// Responses aren't logged by default.
+	buf := &bytes.Buffer{}
+	s := newTestSMS(t, c.URL, "")
+	s.log = log.New(buf, "", 0)
+	assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	assert.Empty(t, responses(buf))
+
+	// A fraction of the successful responses are logged.
+	const n = 1000
+	s = newTestSMS(t, c.URL, `"ResponseLogSampleRate": 0.25`)
+	s.log = log.New(buf, "", 0)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	}
+	lines := responses(buf)
+	assert.InDelta(t, n/4, len(lines), n/10, "sampled responses don't match the rate")
+
+	// The recipient is masked and the OTP and the API key are redacted.
+	l := lines[0]
+	assert.Contains(t, l, `"id": "msg1"`)
+	assert.Contains(t, l, "failed=false")
+	assert.NotContains(t, l, "482910")
+	assert.NotContains(t, l, "919876543210")
+	assert.Contains(t, l, phone.Mask("919876543210"))
+
+	// Errors are always logged.
+	c.SetDefault(testutil.JSON(http.StatusBadRequest, `{"code": "E101", "message": "invalid key", "key": "key"}`))
+	buf.Reset()
+	s = newTestSMS(t, c.URL, `"ResponseLogSampleRate": 0.01`)
+	s.log = log.New(buf, "", 0)
+	for i := 0; i < 50; i++ {
+		assert.Error(t, s.Push(mockOTP, "", []byte("Your code is 482910")))
+	}
+	lines = responses(buf)
+	assert.Len(t, lines, 50)
+	for _, l := range lines {
+		assert.Contains(t, l, "HTTP 400, failed=true")
+		assert.Contains(t, l, otpgateway.Redacted)
+		assert.NotContains(t, l, `"key": "key"`)
+	}
+}
+
+func TestPushOTP(t *testing.T) {
+	c := testutil.NewCarrier(testutil.OK(`{"id": "msg1"}`))
+	defer c.Close()
+	body := func() string {
+		r, ok := c.LastRequest()
+		assert.True(t, ok, "no request")
+		return r.Form.Get("body")
+	}
+
+	// Without a DefaultBody, the standard body is sent.
+	var p otpgateway.OTPPusher = newTestSMS(t, c.URL, "")
+	res, err := p.PushOTP(context.Background(), "+919876543210", "482910")
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", res.ID)
+	assert.Equal(t, "Your verification code is 482910", body())
+	assert.Equal(t, "+919876543210", c.Requests()[0].Form.Get("to"))
+
+	// The DefaultBody is rendered with the OTP.
+	p = newTestSMS(t, c.URL, `"DefaultBody": "Use {{ .OTP }} to sign in. Don't share it.", "GroupOTPDigits": 3`)
+	_, err = p.PushOTP(context.Background(), "+919876543210", "482910")
+	assert.NoError(t, err)
+	assert.Equal(t, "Use 482 910 to sign in. Don't share it.", body())
+
+	// Bodies over the max length and invalid OTPs aren't sent.
+	c.Reset()
+	p = newTestSMS(t, c.URL, `"DefaultBody": "{{ .OTP }} `+strings.Repeat("x", maxBodyLen)+`"`)
+	_, err = p.PushOTP(context.Background(), "+919876543210", "482910")
+	assert.True(t, errors.Is(err, ErrBodyTooLong), err)
+	_, err = p.PushOTP(context.Background(), "+919876543210", "48291")
+	assert.True(t, errors.Is(err, ErrInvalidOTP), err)
+	_, err = p.PushOTP(context.Background(), "phone", "482910")
+	assert.Error(t, err)
+	assert.Empty(t, c.Requests())
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+	"github.com/zplzpl/otpgateway/phone/numplan"
+)
+
+const (
+	providerID  = "spryng"
+	channelName = "SMS"
+	addressName = "Mobile number"
+	apiURL      = "https://rest.spryngsms.com/v1"
+	statusSent  = "sent"
+	maxOTPLen   = 6
+	maxBodyLen  = 140
+
+	routeBusiness = "business"
+	routeEconomy  = "economy"
+
+	// Max length of alphanumeric and numeric originators.
+	maxAlphaSenderLen   = 11
+	maxNumericSenderLen = 14
+
+	// Max size of the response that's read.
+	maxRespLen = 1 << 16
+)
+
+// Errors mapped from Spryng's error responses.
+var (
+	ErrAuthFailed        = errors.New("authentication failed")
+	ErrRouteNotPermitted = errors.New("route not permitted")
+	ErrInvalidNumber     = errors.New("invalid mobile number")
+	ErrInvalidSender     = errors.New("invalid sender")
+	ErrRejected          = errors.New("message rejected")
+)
+
+// fieldErrors maps the request fields of validation errors to errors.
+var fieldErrors = map[string]error{
+	"route":      ErrRouteNotPermitted,
+	"recipients": ErrInvalidNumber,
+	"originator": ErrInvalidSender,
+}
+
+// noAlphaSenderCountries are the destination countries that don't
+// allow alphanumeric senders.
+var noAlphaSenderCountries = map[string]bool{
+	"US": true,
+	"CA": true,
+}
+
+// spryng is the Provider for Spryng's SMS REST API.
+type spryng struct {
+	cfg   *cfg
+	alpha bool
+	h     *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*spryng)(nil)
+	_ otpgateway.ResultPusher = (*spryng)(nil)
+)
+
+type cfg struct {
+	RootURL string `json:"RootURL"`
+	APIKey  string `json:"APIKey"`
+	From    string `json:"From"`
+	Route   string `json:"Route"`
+	Timeout int    `json:"Timeout"`
+}
+
+// message is the JSON body of a send message request.
+type message struct {
+	Body       string   `json:"body"`
+	Encoding   string   `json:"encoding"`
+	Originator string   `json:"originator"`
+	Recipients []string `json:"recipients"`
+	Route      string   `json:"route"`
+	Reference  string   `json:"reference,omitempty"`
+}
+
+// apiResp is a send message or an error response.
+type apiResp struct {
+	ID string `json:"id"`
+
+	Message string              `json:"message"`
+	Errors  map[string][]string `json:"errors"`
+}
+
+// New returns an instance of the Spryng Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	APIKey: "", // API key,
+// 	From: "", // Sender (originator) number or alphanumeric ID,
+// 	Route: "business", // Optional. business, economy, or a route ID
+// 	Timeout: 5 // Optional HTTP timeout in seconds
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.APIKey == "" || c.From == "" {
+		return nil, errors.New("invalid APIKey or From")
+	}
+
+	alpha, err := checkSender(c.From)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Route = strings.ToLower(c.Route)
+	if c.Route == "" {
+		c.Route = routeBusiness
+	}
+	if c.Route != routeBusiness && c.Route != routeEconomy && !isDigits(c.Route) {
+		return nil, fmt.Errorf("unknown Route %s", c.Route)
+	}
+
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/")
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &spryng{
+		cfg:   c,
+		alpha: alpha,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// checkSender validates the length of a sender and reports whether
+// it's alphanumeric.
+func checkSender(from string) (bool, error) {
+	num := strings.TrimPrefix(from, "+")
+	if isDigits(num) {
+		if len(num) > maxNumericSenderLen {
+			return false, fmt.Errorf("numeric From should be <= %d digits", maxNumericSenderLen)
+		}
+		return false, nil
+	}
+
+	if len(from) > maxAlphaSenderLen {
+		return true, fmt.Errorf("alphanumeric From should be <= %d characters", maxAlphaSenderLen)
+	}
+	return true, nil
+}
+
+// isDigits checks whether a string is all digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ID returns the Provider's ID.
+func (*spryng) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (*spryng) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*spryng) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (*spryng) ChannelDesc() string {
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, maxOTPLen)
+}
+
+// AddressDesc returns help text for the phone number.
+func (*spryng) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +31612345678"
+}
+
+// ValidateAddress validates a phone number in the E.164 format and,
+// with an alphanumeric sender, that its country allows them.
+// Names of recipients written as "Name <number>" are dropped.
+func (s *spryng) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	if s.alpha && noAlphaSenderCountries[numplan.Region(to)] {
+		return fmt.Errorf("%w: alphanumeric senders aren't allowed to %s", ErrInvalidSender, numplan.Region(to))
+	}
+	return nil
+}
+
+// Push sends the OTP SMS. SMSes have no subject and the subject is unused.
+func (s *spryng) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := s.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP SMS on the configured route and returns the
+// message ID issued by Spryng.
+func (s *spryng) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// SMSes have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := s.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	b, err := json.Marshal(message{
+		Body:       string(body),
+		Encoding:   "auto",
+		Originator: s.cfg.From,
+		Recipients: []string{strings.TrimPrefix(otp.To, "+")},
+		Route:      s.cfg.Route,
+		Reference:  otpgateway.ReferenceFromContext(ctx),
+	})
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.RootURL+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.h.Do(req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRespLen))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	id, err := parseResp(resp.StatusCode, rb)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	return otpgateway.PushResult{ID: id, Status: statusSent}, nil
+}
+
+// parseResp returns the message ID of a send message response or the
+// error mapped from an error response, eg:
+// {"message": "The given data was invalid.", "errors": {"route": ["..."]}}
+func parseResp(code int, b []byte) (string, error) {
+	var r apiResp
+	if err := json.Unmarshal(b, &r); err != nil && code >= 200 && code <= 299 {
+		return "", fmt.Errorf("error parsing response (HTTP %d): %v", code, err)
+	}
+
+	if code >= 200 && code <= 299 {
+		if r.ID == "" {
+			return "", errors.New("send sms id invalid")
+		}
+		return r.ID, nil
+	}
+
+	if code == http.StatusUnauthorized || code == http.StatusForbidden {
+		return "", fmt.Errorf("%w: %s (HTTP %d)", ErrAuthFailed, r.Message, code)
+	}
+
+	// Map the first of the field errors in a stable order.
+	fields := make([]string, 0, len(r.Errors))
+	for f := range r.Errors {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		// Array fields are reported by index, eg: recipients.0.
+		if e, ok := fieldErrors[strings.SplitN(f, ".", 2)[0]]; ok {
+			return "", fmt.Errorf("%w: %s (HTTP %d)", e, strings.Join(r.Errors[f], "; "), code)
+		}
+	}
+
+	msg := r.Message
+	if len(fields) > 0 {
+		msg += ": " + strings.Join(r.Errors[fields[0]], "; ")
+	}
+	return "", fmt.Errorf("%w: %s (HTTP %d)", ErrRejected, msg, code)
+}
+
+// SenderIdentity returns the sender messages are sent from.
+func (s *spryng) SenderIdentity() string {
+	return s.cfg.From
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (*spryng) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*spryng) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (*spryng) MaxBodyLen() int {
+	return maxBodyLen
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+31612345678",
+	OTP:       "123456",
+}
+
+const (
+	respSuccess = `{
+		"id": "09a2e5e6-9f0f-4b4a-b5c3-5ea3bd1a8b5e",
+		"encoding": "plain",
+		"originator": "MYAPP",
+		"body": "Your code is 123456",
+		"reference": null,
+		"credits": 1,
+		"scheduled_at": "2026-10-14T10:00:00+00:00",
+		"canceled_at": null,
+		"created_at": "2026-10-14T10:00:00+00:00",
+		"updated_at": "2026-10-14T10:00:00+00:00",
+		"links": {"self": "/messages/09a2e5e6-9f0f-4b4a-b5c3-5ea3bd1a8b5e"}
+	}`
+
+	respRouteNotPermitted = `{
+		"message": "The given data was invalid.",
+		"errors": {
+			"route": ["The selected route is not permitted for this account."]
+		}
+	}`
+)
+
+func TestPush(t *testing.T) {
+	var (
+		req  message
+		auth string
+		code = http.StatusOK
+		resp = respSuccess
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages", r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"RootURL": "` + srv.URL + `", "APIKey": "KEY123", "From": "MYAPP", "Route": "ECONOMY"}`))
+	assert.NoError(t, err)
+	s := p.(*spryng)
+
+	ctx := otpgateway.WithReference(context.Background(), "order-1")
+	res, err := s.PushContext(ctx, mockOTP, "", []byte("Your code is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "09a2e5e6-9f0f-4b4a-b5c3-5ea3bd1a8b5e", Status: "sent"}, res)
+	assert.Equal(t, "Bearer KEY123", auth)
+	assert.Equal(t, message{
+		Body:       "Your code is 123456",
+		Encoding:   "auto",
+		Originator: "MYAPP",
+		Recipients: []string{"31612345678"},
+		Route:      "economy",
+		Reference:  "order-1",
+	}, req)
+
+	// Route not permitted.
+	code, resp = http.StatusUnprocessableEntity, respRouteNotPermitted
+	err = s.Push(mockOTP, "", []byte("Your code is 123456"))
+	assert.True(t, errors.Is(err, ErrRouteNotPermitted), "error not mapped")
+	assert.Contains(t, err.Error(), "not permitted for this account")
+}
+
+func TestParseResp(t *testing.T) {
+	for _, c := range []struct {
+		code int
+		body string
+		id   string
+		err  error
+	}{
+		{http.StatusOK, `{"id": "m1"}`, "m1", nil},
+		{http.StatusUnauthorized, `{"message": "Unauthenticated."}`, "", ErrAuthFailed},
+		{http.StatusUnprocessableEntity, `{"message": "The given data was invalid.", "errors": {"recipients.0": ["Invalid"], "originator": ["Too long"]}}`, "", ErrInvalidSender},
+		{http.StatusUnprocessableEntity, `{"message": "The given data was invalid.", "errors": {"recipients.0": ["Invalid"]}}`, "", ErrInvalidNumber},
+		{http.StatusUnprocessableEntity, `{"message": "The given data was invalid.", "errors": {"body": ["Required"]}}`, "", ErrRejected},
+		{http.StatusBadGateway, `<html></html>`, "", ErrRejected},
+	} {
+		id, err := parseResp(c.code, []byte(c.body))
+		assert.Equal(t, c.id, id, c.body)
+		if c.err == nil {
+			assert.NoError(t, err, c.body)
+		} else {
+			assert.True(t, errors.Is(err, c.err), c.body)
+		}
+	}
+
+	_, err := parseResp(http.StatusOK, []byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestValidateAddress(t *testing.T) {
+	p, err := New([]byte(`{"APIKey": "KEY123", "From": "MYAPP"}`))
+	assert.NoError(t, err)
+	s := p.(*spryng)
+	assert.Equal(t, "business", s.cfg.Route)
+
+	assert.NoError(t, s.ValidateAddress("+31612345678"))
+	assert.Error(t, s.ValidateAddress("0612345678"))
+
+	// Alphanumeric senders aren't allowed to the US.
+	assert.True(t, errors.Is(s.ValidateAddress("+14155551234"), ErrInvalidSender))
+
+	p, err = New([]byte(`{"APIKey": "KEY123", "From": "+31970102030"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, p.(*spryng).ValidateAddress("+14155551234"))
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []string{
+		`{"From": "MYAPP"}`,
+		`{"APIKey": "KEY123"}`,
+		`{"APIKey": "KEY123", "From": "MYAPPLICATION"}`,
+		`{"APIKey": "KEY123", "From": "+312345678901234"}`,
+		`{"APIKey": "KEY123", "From": "MYAPP", "Route": "premium"}`,
+	} {
+		_, err := New([]byte(c))
+		assert.Error(t, err, c)
+	}
+
+	p, err := New([]byte(`{"APIKey": "KEY123", "From": "MYAPP", "Route": "1234"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", p.(*spryng).cfg.Route)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID   = "telnyx"
+	channelName  = "SMS"
+	addressName  = "Mobile number"
+	apiURL       = "https://api.telnyx.com/v2"
+	statusSent   = "sent"
+	statusQueued = "queued"
+	maxOTPLen    = 6
+	maxBodyLen   = 140
+
+	// Max size of the response that's read.
+	maxRespLen = 1 << 16
+)
+
+// Errors mapped from Telnyx error codes and HTTP statuses.
+var (
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrInvalidNumber   = fmt.Errorf("%w: invalid mobile number", otpgateway.ErrInvalidAddress)
+	ErrInvalidSender   = errors.New("invalid sender")
+	ErrOptedOut        = fmt.Errorf("%w: recipient has opted out", otpgateway.ErrSuppressed)
+	ErrInsufficientBal = errors.New("insufficient account balance")
+	ErrRejected        = errors.New("message rejected")
+)
+
+// codeErrors maps Telnyx error codes to errors.
+var codeErrors = map[string]error{
+	"10009": ErrAuthFailed,
+	"10010": ErrInsufficientBal,
+	"40300": ErrOptedOut,
+	"40305": ErrInvalidSender,
+	"40310": ErrInvalidNumber,
+}
+
+// failedStatuses are the per-recipient statuses of messages that
+// won't be delivered.
+var failedStatuses = map[string]bool{
+	"sending_failed":  true,
+	"delivery_failed": true,
+}
+
+// APIError is the first error in an error response's errors[] envelope.
+// It unwraps to the error mapped from Code or the HTTP StatusCode, or
+// ErrRejected.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Title      string
+	Detail     string
+
+	// JSON pointer to the request field the error pertains to, eg: /to.
+	Pointer string
+
+	err error
+}
+
+// Error returns the error message.
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%v: %s", e.err, e.Title)
+	if e.Detail != "" && e.Detail != e.Title {
+		msg += ": " + e.Detail
+	}
+	if e.Pointer != "" {
+		msg += " (" + e.Pointer + ")"
+	}
+	return fmt.Sprintf("%s (code %s, HTTP %d)", msg, e.Code, e.StatusCode)
+}
+
+// Unwrap returns the mapped error.
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// telnyx is the Provider for Telnyx's messaging API.
+type telnyx struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*telnyx)(nil)
+	_ otpgateway.ResultPusher = (*telnyx)(nil)
+)
+
+type cfg struct {
+	RootURL            string `json:"RootURL"`
+	APIKey             string `json:"APIKey"`
+	From               string `json:"From"`
+	MessagingProfileID string `json:"MessagingProfileID"`
+	Timeout            int    `json:"Timeout"`
+}
+
+// message is the JSON body of a send message request.
+type message struct {
+	From               string `json:"from,omitempty"`
+	MessagingProfileID string `json:"messaging_profile_id,omitempty"`
+	To                 string `json:"to"`
+	Text               string `json:"text"`
+}
+
+// apiResp is a send message response.
+type apiResp struct {
+	Data struct {
+		ID string `json:"id"`
+		To []struct {
+			PhoneNumber string `json:"phone_number"`
+			Status      string `json:"status"`
+		} `json:"to"`
+	} `json:"data"`
+
+	Errors []struct {
+		Code   string `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+		Source struct {
+			Pointer string `json:"pointer"`
+		} `json:"source"`
+	} `json:"errors"`
+}
+
+// New returns an instance of the Telnyx Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	APIKey: "", // API key (V2),
+// 	From: "", // Sender number or alphanumeric ID. Required unless MessagingProfileID is set
+// 	MessagingProfileID: "", // Optional. Messaging profile to send from
+// 	Timeout: 5 // Optional HTTP timeout in seconds
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.APIKey == "" {
+		return nil, errors.New("invalid APIKey")
+	}
+	if c.From == "" && c.MessagingProfileID == "" {
+		return nil, errors.New("From or MessagingProfileID is required")
+	}
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/")
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &telnyx{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (*telnyx) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (*telnyx) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*telnyx) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (*telnyx) ChannelDesc() string {
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, maxOTPLen)
+}
+
+// AddressDesc returns help text for the phone number.
+func (*telnyx) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +14155551234"
+}
+
+// ValidateAddress validates a phone number in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (*telnyx) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	return nil
+}
+
+// Push sends the OTP SMS. SMSes have no subject and the subject is unused.
+func (t *telnyx) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := t.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP SMS and returns the message ID issued by
+// Telnyx with the recipient's status.
+func (t *telnyx) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// SMSes have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := t.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	b, err := json.Marshal(message{
+		From:               t.cfg.From,
+		MessagingProfileID: t.cfg.MessagingProfileID,
+		To:                 otp.To,
+		Text:               string(body),
+	})
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.cfg.RootURL+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+t.cfg.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.h.Do(req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	defer resp.Body.Close()
+
+	rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRespLen))
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	return parseResp(resp.StatusCode, rb)
+}
+
+// parseResp interprets a send message response.
+func parseResp(code int, b []byte) (otpgateway.PushResult, error) {
+	var r apiResp
+	// Error responses without the JSON envelope (eg: from proxies) are
+	// mapped by the HTTP status.
+	if err := json.Unmarshal(b, &r); err != nil && code >= 200 && code <= 299 {
+		return otpgateway.PushResult{}, fmt.Errorf("error parsing response (HTTP %d): %v", code, err)
+	}
+
+	if len(r.Errors) > 0 || code < 200 || code > 299 {
+		e := &APIError{StatusCode: code}
+		if len(r.Errors) > 0 {
+			e.Code = r.Errors[0].Code
+			e.Title = r.Errors[0].Title
+			e.Detail = r.Errors[0].Detail
+			e.Pointer = r.Errors[0].Source.Pointer
+		}
+
+		switch err, ok := codeErrors[e.Code]; {
+		case ok:
+			e.err = err
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			e.err = ErrAuthFailed
+		case code == http.StatusTooManyRequests:
+			e.err = otpgateway.ErrRateLimited
+		default:
+			e.err = ErrRejected
+		}
+		return otpgateway.PushResult{}, e
+	}
+
+	if r.Data.ID == "" {
+		return otpgateway.PushResult{}, errors.New("send sms id invalid")
+	}
+
+	status := statusQueued
+	if len(r.Data.To) > 0 {
+		switch s := r.Data.To[0].Status; {
+		case failedStatuses[s]:
+			return otpgateway.PushResult{}, fmt.Errorf("%w: %s", ErrRejected, s)
+		case s == "sending" || s == "sent":
+			status = statusSent
+		case s == "delivered":
+			status = s
+		}
+	}
+	return otpgateway.PushResult{ID: r.Data.ID, Status: status}, nil
+}
+
+// SenderIdentity returns the number or ID messages are sent from or
+// the messaging profile ID.
+func (t *telnyx) SenderIdentity() string {
+	if t.cfg.From != "" {
+		return t.cfg.From
+	}
+	return t.cfg.MessagingProfileID
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (*telnyx) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*telnyx) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (*telnyx) MaxBodyLen() int {
+	return maxBodyLen
+}
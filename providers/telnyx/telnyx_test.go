@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "+14155551234",
+	OTP:       "123456",
+}
+
+const (
+	respSuccess = `{
+		"data": {
+			"record_type": "message",
+			"direction": "outbound",
+			"id": "40317159-6d8d-4e4c-8e4f-0c9a5f8bd2f1",
+			"type": "SMS",
+			"from": {"phone_number": "+14155550100"},
+			"to": [{"phone_number": "+14155551234", "status": "queued", "carrier": "T-MOBILE USA, INC.", "line_type": "Wireless"}],
+			"text": "Your code is 123456",
+			"parts": 1
+		}
+	}`
+
+	respInvalidTo = `{
+		"errors": [{
+			"code": "40310",
+			"title": "Invalid 'to' address",
+			"detail": "The 'to' address should be in E.164 format.",
+			"source": {"pointer": "/to"},
+			"meta": {"url": "https://developers.telnyx.com/docs/overview/errors/40310"}
+		}]
+	}`
+)
+
+func TestPush(t *testing.T) {
+	var (
+		req  message
+		auth string
+		code = http.StatusOK
+		resp = respSuccess
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages", r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"RootURL": "` + srv.URL + `/", "APIKey": "KEY123", "From": "+14155550100"}`))
+	assert.NoError(t, err)
+	tx := p.(*telnyx)
+
+	res, err := tx.PushContext(context.Background(), mockOTP, "", []byte("Your code is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, otpgateway.PushResult{ID: "40317159-6d8d-4e4c-8e4f-0c9a5f8bd2f1", Status: "queued"}, res)
+	assert.Equal(t, "Bearer KEY123", auth)
+	assert.Equal(t, message{From: "+14155550100", To: "+14155551234", Text: "Your code is 123456"}, req)
+
+	// 422 validation error.
+	code, resp = http.StatusUnprocessableEntity, respInvalidTo
+	_, err = tx.PushContext(context.Background(), mockOTP, "", []byte("Your code is 123456"))
+	assert.True(t, errors.Is(err, ErrInvalidNumber), "error not mapped")
+	var apiErr *APIError
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+		assert.Equal(t, "40310", apiErr.Code)
+		assert.Equal(t, "/to", apiErr.Pointer)
+	}
+	assert.Contains(t, err.Error(), "Invalid 'to' address")
+
+	// Names of recipients are dropped.
+	code, resp = http.StatusOK, respSuccess
+	o := mockOTP
+	o.To = `"Alice B" <+14155551234>`
+	assert.NoError(t, tx.ValidateAddress(o.To))
+	assert.NoError(t, tx.Push(o, "", []byte("Your code is 123456")))
+	assert.Equal(t, "+14155551234", req.To)
+
+	// Numbers are validated before sending.
+	o.To = "4155551234"
+	assert.Error(t, tx.Push(o, "", []byte("Your code is 123456")))
+}
+
+func TestParseResp(t *testing.T) {
+	for _, c := range []struct {
+		code   int
+		body   string
+		status string
+		err    error
+	}{
+		{http.StatusOK, `{"data": {"id": "m1", "to": [{"status": "sent"}]}}`, "sent", nil},
+		{http.StatusOK, `{"data": {"id": "m1", "to": [{"status": "delivered"}]}}`, "delivered", nil},
+		{http.StatusOK, `{"data": {"id": "m1"}}`, "queued", nil},
+		{http.StatusOK, `{"data": {"id": "m1", "to": [{"status": "sending_failed"}]}}`, "", ErrRejected},
+		{http.StatusOK, `{"data": {}}`, "", nil},
+		{http.StatusUnauthorized, `{"errors": [{"code": "10009", "title": "Authentication failed"}]}`, "", ErrAuthFailed},
+		{http.StatusUnauthorized, ``, "", ErrAuthFailed},
+		{http.StatusTooManyRequests, `{"errors": [{"code": "10011", "title": "Too many requests"}]}`, "", otpgateway.ErrRateLimited},
+		{http.StatusBadRequest, `{"errors": [{"code": "40305", "title": "Invalid 'from' address"}]}`, "", ErrInvalidSender},
+		{http.StatusBadGateway, `<html>Bad gateway</html>`, "", ErrRejected},
+	} {
+		res, err := parseResp(c.code, []byte(c.body))
+		assert.Equal(t, c.status, res.Status, c.body)
+		switch {
+		case c.err != nil:
+			assert.True(t, errors.Is(err, c.err), c.body)
+		case c.status == "":
+			assert.Error(t, err, c.body)
+		default:
+			assert.NoError(t, err, c.body)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte(`{"From": "+14155550100"}`))
+	assert.Error(t, err, "missing APIKey accepted")
+
+	_, err = New([]byte(`{"APIKey": "KEY123"}`))
+	assert.Error(t, err, "missing From accepted")
+
+	p, err := New([]byte(`{"APIKey": "KEY123", "MessagingProfileID": "prof1"}`))
+	assert.NoError(t, err)
+	tx := p.(*telnyx)
+	assert.Equal(t, apiURL, tx.cfg.RootURL)
+	assert.Equal(t, "prof1", tx.SenderIdentity())
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for _, c := range []struct {
+		code int
+		body string
+		want int
+	}{
+		{http.StatusTooManyRequests, `{"errors": [{"code": "10011", "title": "Too many requests"}]}`, http.StatusTooManyRequests},
+		{http.StatusBadRequest, `{"errors": [{"code": "40300", "title": "Blocked due to STOP message"}]}`, http.StatusUnprocessableEntity},
+		{http.StatusBadRequest, `{"errors": [{"code": "40310", "title": "Invalid 'to' address"}]}`, http.StatusBadRequest},
+		{http.StatusUnauthorized, `{"errors": [{"code": "10009", "title": "Authentication failed"}]}`, http.StatusInternalServerError},
+	} {
+		_, err := parseResp(c.code, []byte(c.body))
+		assert.Equal(t, c.want, otpgateway.HTTPStatusFor(err), c.body)
+	}
+}
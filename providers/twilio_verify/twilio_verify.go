@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID     = "twilio_verify"
+	channelName    = "SMS"
+	addressName    = "Mobile number"
+	apiURL         = "https://verify.twilio.com/v2"
+	statusSent     = "sent"
+	statusApproved = "approved"
+	channelSMS     = "sms"
+	channelCall    = "call"
+	defaultCodeLen = 6
+	maxOTPLen      = 10
+	maxBodyLen     = 140
+
+	// Header of the signature of Twilio's callbacks.
+	headerSignature = "X-Twilio-Signature"
+)
+
+// Errors mapped from Twilio Verify error codes.
+var (
+	ErrOTPMismatch          = errors.New("OTP does not match")
+	ErrMaxAttempts          = errors.New("max verification attempts reached")
+	ErrExpired              = errors.New("verification not found or expired")
+	ErrInvalidCredentials   = errors.New("invalid API credentials")
+	ErrInvalidNumber        = errors.New("invalid mobile number")
+	ErrUnknownVerifyFailure = errors.New("unknown verification failure")
+)
+
+// codeErrors maps Twilio error codes to errors.
+var codeErrors = map[int]error{
+	20003: ErrInvalidCredentials,
+	20404: ErrExpired,
+	60200: ErrInvalidNumber,
+	60202: ErrMaxAttempts,
+	60203: ErrMaxAttempts,
+}
+
+// verify is the Twilio Verify Provider where Twilio generates, sends,
+// and verifies the OTP.
+type verify struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider      = (*verify)(nil)
+	_ otpgateway.ResultPusher  = (*verify)(nil)
+	_ otpgateway.Verifier      = (*verify)(nil)
+	_ otpgateway.WebhookParser = (*verify)(nil)
+)
+
+type cfg struct {
+	RootURL    string `json:"RootURL"`
+	AccountSID string `json:"AccountSID"`
+	AuthToken  string `json:"AuthToken"`
+	ServiceSID string `json:"ServiceSID"`
+	Channel    string `json:"Channel"`
+	CodeLength int    `json:"CodeLength"`
+	Timeout    int    `json:"Timeout"`
+
+	// Public scheme and host of the callback URLs configured in
+	// Twilio, eg: https://otp.example.com, for verifying the callback
+	// signatures behind proxies. Defaults to the request's.
+	WebhookURL string `json:"WebhookURL"`
+}
+
+// apiResp represents a Verification or a VerificationCheck response.
+type apiResp struct {
+	SID    string `json:"sid"`
+	Status string `json:"status"`
+	Valid  bool   `json:"valid"`
+}
+
+// apiError represents Twilio's error response.
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// New returns an instance of the Twilio Verify Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	AccountSID: "", // Twilio account SID,
+// 	AuthToken: "", // Twilio auth token,
+// 	ServiceSID: "", // Verify service SID,
+// 	Channel: "sms", // Optional. sms or call
+// 	CodeLength: 6, // Optional. OTP length configured in the Verify service
+// 	Timeout: 5, // Optional HTTP timeout in seconds
+// 	WebhookURL: "" // Optional. Public scheme and host of the callback URLs
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.AccountSID == "" || c.AuthToken == "" || c.ServiceSID == "" {
+		return nil, errors.New("invalid AccountSID or AuthToken or ServiceSID")
+	}
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/") + "/Services/" + c.ServiceSID
+
+	if c.Channel == "" {
+		c.Channel = channelSMS
+	}
+	if c.Channel != channelSMS && c.Channel != channelCall {
+		return nil, errors.New("Channel should be sms or call")
+	}
+	if c.CodeLength == 0 {
+		c.CodeLength = defaultCodeLen
+	}
+	if c.CodeLength < 4 || c.CodeLength > maxOTPLen {
+		return nil, fmt.Errorf("CodeLength should be between 4 and %d", maxOTPLen)
+	}
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &verify{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (v *verify) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (v *verify) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*verify) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the verification Provider.
+func (v *verify) ChannelDesc() string {
+	if v.cfg.Channel == channelCall {
+		return fmt.Sprintf(`
+		You'll receive a call with a %d digit code on your mobile.
+		Enter it here to verify your mobile number.`, v.cfg.CodeLength)
+	}
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, v.cfg.CodeLength)
+}
+
+// AddressDesc returns help text for the phone number.
+func (v *verify) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +14155551234"
+}
+
+// ValidateAddress validates a phone number in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (v *verify) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return ErrInvalidNumber
+	}
+	return nil
+}
+
+// SenderIdentity returns an empty string as the sender is configured
+// in the Verify service.
+func (v *verify) SenderIdentity() string {
+	return ""
+}
+
+// Push starts a verification. Twilio generates and sends the OTP,
+// so the subject and the body are unused.
+func (v *verify) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := v.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext starts a verification and returns the verification SID
+// in the result, which has to be passed to Verify.
+func (v *verify) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// Verifications have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := v.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	p := url.Values{}
+	p.Set("To", otp.To)
+	p.Set("Channel", v.cfg.Channel)
+
+	r, err := v.do(ctx, v.cfg.RootURL+"/Verifications", p)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	if r.SID == "" {
+		return otpgateway.PushResult{}, errors.New("verification sid invalid")
+	}
+	return otpgateway.PushResult{ID: r.SID, Status: statusSent}, nil
+}
+
+// Verify checks the code entered by the user against the verification
+// started with the given verification SID.
+func (v *verify) Verify(ctx context.Context, sid, code string) error {
+	p := url.Values{}
+	p.Set("VerificationSid", sid)
+	p.Set("Code", code)
+
+	r, err := v.do(ctx, v.cfg.RootURL+"/VerificationCheck", p)
+	if err != nil {
+		return err
+	}
+	if r.Status != statusApproved || !r.Valid {
+		return ErrOTPMismatch
+	}
+	return nil
+}
+
+// do makes a POST request to the API and maps errors responses to errors.
+func (v *verify) do(ctx context.Context, u string, p url.Values) (apiResp, error) {
+	req, err := http.NewRequest("POST", u, strings.NewReader(p.Encode()))
+	if err != nil {
+		return apiResp{}, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(v.cfg.AccountSID, v.cfg.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.h.Do(req)
+	if err != nil {
+		return apiResp{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return apiResp{}, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e apiError
+		if err := json.Unmarshal(b, &e); err != nil {
+			return apiResp{}, fmt.Errorf("%w: HTTP %d", ErrUnknownVerifyFailure, resp.StatusCode)
+		}
+		me, ok := codeErrors[e.Code]
+		if !ok {
+			me = ErrUnknownVerifyFailure
+		}
+		return apiResp{}, fmt.Errorf("%w: %s (code %d)", me, e.Message, e.Code)
+	}
+
+	var r apiResp
+	if err := json.Unmarshal(b, &r); err != nil {
+		return apiResp{}, err
+	}
+	return r, nil
+}
+
+// ParseWebhook parses Twilio's message status and inbound message
+// callbacks after verifying their signature with the AuthToken.
+// Inbound messages other than opt-outs are ignored.
+func (v *verify) ParseWebhook(r *http.Request, body []byte) ([]otpgateway.Event, error) {
+	p, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing callback: %v", err)
+	}
+
+	sig, _ := base64.StdEncoding.DecodeString(r.Header.Get(headerSignature))
+	if !hmac.Equal(sig, v.sign(v.webhookURL(r), p)) {
+		return nil, otpgateway.ErrWebhookUnauthorized
+	}
+
+	status := p.Get("MessageStatus")
+	if status == "" {
+		status = p.Get("SmsStatus")
+	}
+	switch {
+	case status == "received":
+		if !strings.EqualFold(p.Get("OptOutType"), "STOP") && !otpgateway.IsOptOut(p.Get("Body")) {
+			return nil, nil
+		}
+		return []otpgateway.Event{{Type: otpgateway.EventOptOut, Address: p.Get("From")}}, nil
+	case status != "" && p.Get("MessageSid") != "":
+		e := otpgateway.Event{
+			Type:      otpgateway.EventDelivery,
+			MessageID: p.Get("MessageSid"),
+			Status:    statusSent,
+			Address:   p.Get("To"),
+		}
+		switch status {
+		case otpgateway.StatusDelivered:
+			e.Status = otpgateway.StatusDelivered
+		case "undelivered", otpgateway.StatusFailed:
+			e.Status = otpgateway.StatusFailed
+			if c := p.Get("ErrorCode"); c != "" {
+				e.Error = "error code " + c
+			}
+		}
+		return []otpgateway.Event{e}, nil
+	}
+	return nil, errors.New("unknown callback")
+}
+
+// webhookURL returns the URL Twilio sent a callback to.
+func (v *verify) webhookURL(r *http.Request) string {
+	if v.cfg.WebhookURL != "" {
+		return strings.TrimRight(v.cfg.WebhookURL, "/") + r.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// sign returns Twilio's signature of a callback, the HMAC-SHA1 of the
+// URL followed by the sorted params and their values.
+func (v *verify) sign(u string, p url.Values) []byte {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m := hmac.New(sha1.New, []byte(v.cfg.AuthToken))
+	m.Write([]byte(u))
+	for _, k := range keys {
+		for _, val := range p[k] {
+			m.Write([]byte(k + val))
+		}
+	}
+	return m.Sum(nil)
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (v *verify) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (v *verify) MaxOTPLen() int {
+	return v.cfg.CodeLength
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (v *verify) MaxBodyLen() int {
+	return maxBodyLen
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	To: "+14155551234",
+}
+
+// newTestServer returns a mock Twilio Verify API.
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != "AC1" || p != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code": 20003, "message": "Authenticate", "status": 401}`))
+			return
+		}
+		r.ParseForm()
+
+		switch r.URL.Path {
+		case "/Services/VA1/Verifications":
+			assert.Equal(t, "sms", r.Form.Get("Channel"))
+			assert.NotContains(t, r.Form, "campaign", "metadata sent")
+			switch r.Form.Get("To") {
+			case "+14155551234":
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"sid": "VE1", "status": "pending", "valid": false}`))
+			default:
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"code": 60203, "message": "Max send attempts reached", "status": 429}`))
+			}
+		case "/Services/VA1/VerificationCheck":
+			switch r.Form.Get("VerificationSid") {
+			case "VE1":
+				if r.Form.Get("Code") == "123456" {
+					w.Write([]byte(`{"sid": "VE1", "status": "approved", "valid": true}`))
+					return
+				}
+				w.Write([]byte(`{"sid": "VE1", "status": "pending", "valid": false}`))
+			case "locked":
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"code": 60202, "message": "Max check attempts reached", "status": 429}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"code": 20404, "message": "The requested resource was not found", "status": 404}`))
+			}
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+}
+
+func newTestVerify(t *testing.T, rootURL string) *verify {
+	p, err := New([]byte(`{"RootURL": "` + rootURL + `", "AccountSID": "AC1", "AuthToken": "token", "ServiceSID": "VA1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*verify)
+}
+
+func TestStart(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	v := newTestVerify(t, srv.URL)
+	res, err := v.PushContext(context.Background(), mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "VE1", res.ID)
+
+	// Twilio Verify doesn't support arbitrary metadata, which is ignored.
+	ctx := otpgateway.WithMetadata(context.Background(), map[string]string{"campaign": "diwali"})
+	res, err = v.PushContext(ctx, mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "VE1", res.ID)
+
+	o := mockOTP
+	o.To = "+14155550000"
+	_, err = v.PushContext(context.Background(), o, "", nil)
+	assert.True(t, errors.Is(err, ErrMaxAttempts), "max attempts error not mapped")
+
+	o.To = "4155551234"
+	assert.True(t, errors.Is(v.Push(o, "", nil), ErrInvalidNumber), "non E.164 number accepted")
+
+	v.cfg.AuthToken = "wrong"
+	_, err = v.PushContext(context.Background(), mockOTP, "", nil)
+	assert.True(t, errors.Is(err, ErrInvalidCredentials), "auth error not mapped")
+}
+
+func TestCheck(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	v := newTestVerify(t, srv.URL)
+	assert.NoError(t, v.Verify(context.Background(), "VE1", "123456"))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "VE1", "000000"), ErrOTPMismatch))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "locked", "000000"), ErrMaxAttempts))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "unknown", "123456"), ErrExpired))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte(`{"AccountSID": "AC1", "AuthToken": "token"}`))
+	assert.Error(t, err, "missing ServiceSID accepted")
+
+	_, err = New([]byte(`{"AccountSID": "AC1", "AuthToken": "token", "ServiceSID": "VA1", "Channel": "email"}`))
+	assert.Error(t, err, "invalid Channel accepted")
+
+	_, err = New([]byte(`{"AccountSID": "AC1", "AuthToken": "token", "ServiceSID": "VA1", "CodeLength": 12}`))
+	assert.Error(t, err, "invalid CodeLength accepted")
+
+	v := newTestVerify(t, "http://localhost/")
+	assert.Equal(t, "http://localhost/Services/VA1", v.cfg.RootURL)
+	assert.Equal(t, 6, v.MaxOTPLen())
+}
+
+func TestParseWebhook(t *testing.T) {
+	v := newTestVerify(t, "http://localhost")
+
+	// The example from Twilio's docs.
+	v.cfg.AuthToken = "12345"
+	req := httptest.NewRequest(http.MethodPost, "/myapp.php?foo=1&bar=2", nil)
+	p := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	v.cfg.WebhookURL = "https://mycompany.com/"
+	assert.Equal(t, "0/KCTR6DLpKmkAf8muzZqo1nDgQ=", base64.StdEncoding.EncodeToString(v.sign(v.webhookURL(req), p)))
+
+	var (
+		events []otpgateway.Event
+		h      = otpgateway.WebhookMux(func(e otpgateway.Event) { events = append(events, e) }, v)
+	)
+	post := func(p url.Values, sign bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/twilio_verify", strings.NewReader(p.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if sign {
+			req.Header.Set(headerSignature, base64.StdEncoding.EncodeToString(v.sign(v.webhookURL(req), p)))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Status callbacks.
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}, "To": {"+14155551234"}}, true))
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM2"}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}}, true))
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM3"}, "MessageStatus": {"sent"}}, true))
+
+	// Inbound messages. Only opt-outs are emitted.
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM4"}, "SmsStatus": {"received"}, "From": {"+14155551234"}, "Body": {"STOP"}}, true))
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM5"}, "SmsStatus": {"received"}, "From": {"+14155551234"}, "Body": {"Arrêt"}, "OptOutType": {"STOP"}}, true))
+	assert.Equal(t, http.StatusOK, post(url.Values{"MessageSid": {"SM6"}, "SmsStatus": {"received"}, "From": {"+14155551234"}, "Body": {"hi"}}, true))
+
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, otpgateway.Event{Type: otpgateway.EventDelivery, Provider: providerID, MessageID: "SM1",
+			Status: otpgateway.StatusDelivered, Address: "+14155551234", Timestamp: events[0].Timestamp}, events[0])
+		assert.Equal(t, otpgateway.StatusFailed, events[1].Status)
+		assert.Equal(t, "error code 30003", events[1].Error)
+		assert.Equal(t, statusSent, events[2].Status)
+		for _, e := range events[3:] {
+			assert.Equal(t, otpgateway.EventOptOut, e.Type)
+			assert.Equal(t, "+14155551234", e.Address)
+		}
+	}
+
+	// Unsigned and tampered callbacks.
+	assert.Equal(t, http.StatusUnauthorized, post(url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}}, false))
+	req = httptest.NewRequest(http.MethodPost, "/webhooks/twilio_verify", strings.NewReader("MessageSid=SM1&MessageStatus=failed"))
+	req.Header.Set(headerSignature, base64.StdEncoding.EncodeToString(v.sign(v.webhookURL(req), url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}})))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Len(t, events, 5)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+const (
+	providerID  = "vonage_verify"
+	channelName = "SMS"
+	addressName = "Mobile number"
+	apiURL      = "https://api.nexmo.com/verify"
+	statusOK    = "0"
+	statusSent  = "sent"
+
+	defaultCodeLen = 4
+)
+
+// Errors mapped from Vonage Verify statuses.
+var (
+	ErrOTPMismatch          = errors.New("OTP does not match")
+	ErrWorkflowTerminated   = errors.New("wrong OTP entered too many times, verification terminated")
+	ErrExpired              = errors.New("verification not found or expired")
+	ErrConcurrentVerify     = errors.New("a verification is already in progress for this number")
+	ErrUnsupportedNetwork   = errors.New("destination network is not supported")
+	ErrInvalidCredentials   = errors.New("invalid API credentials")
+	ErrThrottled            = fmt.Errorf("%w: request was throttled", otpgateway.ErrRateLimited)
+	ErrUnknownVerifyFailure = errors.New("unknown verification failure")
+)
+
+// statusErrors maps Vonage Verify API statuses to errors.
+var statusErrors = map[string]error{
+	"1":  ErrThrottled,
+	"4":  ErrInvalidCredentials,
+	"6":  ErrExpired,
+	"10": ErrConcurrentVerify,
+	"15": ErrUnsupportedNetwork,
+	"16": ErrOTPMismatch,
+	"17": ErrWorkflowTerminated,
+}
+
+// verify is the Vonage Verify Provider where Vonage generates, sends,
+// and verifies the OTP.
+type verify struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*verify)(nil)
+	_ otpgateway.ResultPusher = (*verify)(nil)
+	_ otpgateway.Verifier     = (*verify)(nil)
+)
+
+type cfg struct {
+	RootURL    string `json:"RootURL"`
+	APIKey     string `json:"APIKey"`
+	APISecret  string `json:"APISecret"`
+	Brand      string `json:"Brand"`
+	CodeLength int    `json:"CodeLength"`
+	WorkflowID int    `json:"WorkflowID"`
+	Timeout    int    `json:"Timeout"`
+}
+
+// apiResp represents the response from the Vonage Verify API.
+type apiResp struct {
+	RequestID string `json:"request_id"`
+	Status    string `json:"status"`
+	ErrorText string `json:"error_text"`
+}
+
+// New returns an instance of the Vonage Verify Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	RootURL: "", // Optional root URL of the API,
+// 	APIKey: "", // API key,
+// 	APISecret: "", // API secret,
+// 	Brand: "", // Brand name shown in the message,
+// 	CodeLength: 4, // Optional. OTP length (4 or 6)
+// 	WorkflowID: 0, // Optional. Vonage workflow ID
+// 	Timeout: 5 // Optional HTTP timeout in seconds
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.APIKey == "" || c.APISecret == "" || c.Brand == "" {
+		return nil, errors.New("invalid APIKey or APISecret or Brand")
+	}
+	if c.RootURL == "" {
+		c.RootURL = apiURL
+	}
+	c.RootURL = strings.TrimRight(c.RootURL, "/")
+
+	if c.CodeLength == 0 {
+		c.CodeLength = defaultCodeLen
+	}
+	if c.CodeLength != 4 && c.CodeLength != 6 {
+		return nil, errors.New("CodeLength should be 4 or 6")
+	}
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &verify{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (v *verify) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (v *verify) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*verify) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the SMS verification Provider.
+func (v *verify) ChannelDesc() string {
+	return fmt.Sprintf(`
+		We've sent a %d digit code in an SMS to your mobile.
+		Enter it here to verify your mobile number.`, v.cfg.CodeLength)
+}
+
+// AddressDesc returns help text for the phone number.
+func (v *verify) AddressDesc() string {
+	return "Please enter your mobile number with the country code, eg: +14155551234"
+}
+
+// ValidateAddress validates a phone number in the E.164 format.
+// Names of recipients written as "Name <number>" are dropped.
+func (v *verify) ValidateAddress(to string) error {
+	to = otpgateway.ParseRecipient(to).Address
+	if !phone.IsE164(to) {
+		return errors.New("invalid mobile number")
+	}
+	return nil
+}
+
+// Push starts a verification. Vonage generates and sends the OTP,
+// so the subject and the body are unused.
+func (v *verify) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := v.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext starts a verification and returns the Vonage request_id
+// in the result, which has to be passed to Verify.
+func (v *verify) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	// Verifications have no recipient names.
+	otp.To = otpgateway.ParseRecipient(otp.To).Address
+	if err := v.ValidateAddress(otp.To); err != nil {
+		return otpgateway.PushResult{}, err
+	}
+
+	p := url.Values{}
+	p.Set("number", strings.TrimPrefix(otp.To, "+"))
+	p.Set("brand", v.cfg.Brand)
+	p.Set("code_length", strconv.Itoa(v.cfg.CodeLength))
+	if v.cfg.WorkflowID != 0 {
+		p.Set("workflow_id", strconv.Itoa(v.cfg.WorkflowID))
+	}
+
+	r, err := v.do(ctx, v.cfg.RootURL+"/json", p)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	if r.RequestID == "" {
+		return otpgateway.PushResult{}, errors.New("verify request_id invalid")
+	}
+	return otpgateway.PushResult{ID: r.RequestID, Status: statusSent}, nil
+}
+
+// Verify checks the code entered by the user against the verification
+// started with the given request_id.
+func (v *verify) Verify(ctx context.Context, requestID, code string) error {
+	p := url.Values{}
+	p.Set("request_id", requestID)
+	p.Set("code", code)
+
+	_, err := v.do(ctx, v.cfg.RootURL+"/check/json", p)
+	return err
+}
+
+// do makes a POST request to the API and maps non-zero statuses to errors.
+func (v *verify) do(ctx context.Context, u string, p url.Values) (apiResp, error) {
+	p.Set("api_key", v.cfg.APIKey)
+	p.Set("api_secret", v.cfg.APISecret)
+
+	req, err := http.NewRequest("POST", u, strings.NewReader(p.Encode()))
+	if err != nil {
+		return apiResp{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.h.Do(req)
+	if err != nil {
+		return apiResp{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return apiResp{}, err
+	}
+
+	var r apiResp
+	if err := json.Unmarshal(b, &r); err != nil {
+		return apiResp{}, err
+	}
+
+	if r.Status != statusOK {
+		e, ok := statusErrors[r.Status]
+		if !ok {
+			e = ErrUnknownVerifyFailure
+		}
+		return r, fmt.Errorf("%w: %s (status %s)", e, r.ErrorText, r.Status)
+	}
+	return r, nil
+}
+
+// SenderIdentity returns the brand name the messages are sent with.
+func (v *verify) SenderIdentity() string {
+	return v.cfg.Brand
+}
+
+// MaxAddressLen returns the maximum allowed length for the mobile number.
+func (v *verify) MaxAddressLen() int {
+	return phone.MaxE164Len
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (v *verify) MaxOTPLen() int {
+	return v.cfg.CodeLength
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (v *verify) MaxBodyLen() int {
+	return 140
+}
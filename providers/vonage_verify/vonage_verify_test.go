@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+var mockOTP = models.OTP{
+	To: "+14155551234",
+}
+
+// newTestServer returns a mock Vonage Verify API.
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "key", r.Form.Get("api_key"))
+		assert.Equal(t, "secret", r.Form.Get("api_secret"))
+
+		switch r.URL.Path {
+		case "/json":
+			switch r.Form.Get("number") {
+			case "14155551234":
+				assert.Equal(t, "MyApp", r.Form.Get("brand"))
+				w.Write([]byte(`{"request_id": "req1", "status": "0"}`))
+			case "14155550001":
+				w.Write([]byte(`{"status": "1", "error_text": "Throttled"}`))
+			default:
+				w.Write([]byte(`{"request_id": "req2", "status": "10", "error_text": "Concurrent verifications to the same number are not allowed"}`))
+			}
+		case "/check/json":
+			switch r.Form.Get("request_id") {
+			case "req1":
+				if r.Form.Get("code") == "1234" {
+					w.Write([]byte(`{"request_id": "req1", "status": "0", "event_id": "ev1"}`))
+					return
+				}
+				w.Write([]byte(`{"request_id": "req1", "status": "16", "error_text": "The code provided does not match the expected value"}`))
+			case "locked":
+				w.Write([]byte(`{"request_id": "locked", "status": "17", "error_text": "The wrong code was provided too many times. Workflow terminated"}`))
+			default:
+				w.Write([]byte(`{"status": "6", "error_text": "Request was not found or it has been verified already."}`))
+			}
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+}
+
+func newTestVerify(t *testing.T, rootURL string) *verify {
+	p, err := New([]byte(`{"RootURL": "` + rootURL + `", "APIKey": "key", "APISecret": "secret", "Brand": "MyApp"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.(*verify)
+}
+
+func TestStart(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	v := newTestVerify(t, srv.URL)
+	res, err := v.PushContext(context.Background(), mockOTP, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "req1", res.ID)
+
+	o := mockOTP
+	o.To = "+14155550000"
+	_, err = v.PushContext(context.Background(), o, "", nil)
+	assert.True(t, errors.Is(err, ErrConcurrentVerify), "concurrent verification error not mapped")
+
+	o.To = "4155551234"
+	assert.Error(t, v.Push(o, "", nil), "non E.164 number accepted")
+
+	// Throttled requests are reported as 429s.
+	o.To = "+14155550001"
+	_, err = v.PushContext(context.Background(), o, "", nil)
+	assert.True(t, errors.Is(err, ErrThrottled))
+	assert.Equal(t, http.StatusTooManyRequests, otpgateway.HTTPStatusFor(err))
+}
+
+func TestCheck(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	v := newTestVerify(t, srv.URL)
+	assert.NoError(t, v.Verify(context.Background(), "req1", "1234"))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "req1", "0000"), ErrOTPMismatch))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "locked", "0000"), ErrWorkflowTerminated))
+	assert.True(t, errors.Is(v.Verify(context.Background(), "unknown", "1234"), ErrExpired))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte(`{"APIKey": "key", "APISecret": "secret"}`))
+	assert.Error(t, err, "missing Brand accepted")
+
+	_, err = New([]byte(`{"APIKey": "key", "APISecret": "secret", "Brand": "MyApp", "CodeLength": 5}`))
+	assert.Error(t, err, "invalid CodeLength accepted")
+
+	v := newTestVerify(t, "http://localhost")
+	assert.Equal(t, 4, v.MaxOTPLen())
+}
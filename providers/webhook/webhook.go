@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/webhook"
+)
+
+const (
+	providerID  = "webhook"
+	channelName = "Webhook"
+	addressName = "Address"
+	statusSent  = "sent"
+
+	maxAddressLen = 256
+	maxOTPLen     = 12
+	maxBodyLen    = 4096
+)
+
+// hook is a generic Provider that POSTs OTPs as JSON to a URL,
+// optionally signed with an HMAC secret.
+type hook struct {
+	cfg *cfg
+	h   *http.Client
+}
+
+// Compile time checks for the interfaces the Provider implements.
+var (
+	_ otpgateway.Provider     = (*hook)(nil)
+	_ otpgateway.ResultPusher = (*hook)(nil)
+)
+
+type cfg struct {
+	URL     string            `json:"URL"`
+	Secret  string            `json:"Secret"`
+	Headers map[string]string `json:"Headers"`
+	Timeout int               `json:"Timeout"`
+
+	// HTTP method (POST, PUT, GET). Default POST. GET requests send
+	// the payload fields in the query string and the signature is
+	// computed over the encoded query string.
+	HTTPMethod string `json:"HTTPMethod"`
+}
+
+// httpMethods are the allowed request methods.
+var httpMethods = map[string]bool{
+	http.MethodPost: true,
+	http.MethodPut:  true,
+	http.MethodGet:  true,
+}
+
+// payload is the JSON body POSTed to the webhook URL.
+type payload struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	OTP       string `json:"otp"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+// New returns an instance of the webhook Provider. cfg is
+// configuration represented as a JSON string. Supported options are.
+// {
+// 	URL: "", // URL to POST the OTPs to,
+// 	Secret: "", // Optional. HMAC secret for signing the payloads
+// 	Headers: {}, // Optional. Additional HTTP headers
+// 	Timeout: 5, // Optional HTTP timeout in seconds
+// 	HTTPMethod: "POST" // Optional. HTTP method (POST, PUT, GET)
+// }
+func New(jsonCfg []byte) (interface{}, error) {
+	var c *cfg
+	if err := json.Unmarshal(jsonCfg, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" {
+		return nil, errors.New("invalid URL")
+	}
+	c.HTTPMethod = strings.ToUpper(c.HTTPMethod)
+	if c.HTTPMethod == "" {
+		c.HTTPMethod = http.MethodPost
+	}
+	if !httpMethods[c.HTTPMethod] {
+		return nil, fmt.Errorf("unsupported HTTPMethod %s", c.HTTPMethod)
+	}
+
+	t := 5
+	if c.Timeout != 0 {
+		t = c.Timeout
+	}
+
+	return &hook{
+		cfg: c,
+		h: &http.Client{
+			Timeout: time.Duration(t) * time.Second,
+		}}, nil
+}
+
+// ID returns the Provider's ID.
+func (*hook) ID() string {
+	return providerID
+}
+
+// ChannelName returns the Provider's name.
+func (*hook) ChannelName() string {
+	return channelName
+}
+
+// AddressName returns the Provider's address name.
+func (*hook) AddressName() string {
+	return addressName
+}
+
+// ChannelDesc returns help text for the webhook Provider.
+func (*hook) ChannelDesc() string {
+	return `
+		We've sent a verification code to your address.
+		Enter it here to verify your address.`
+}
+
+// AddressDesc returns help text for the address.
+func (*hook) AddressDesc() string {
+	return "Please enter your address"
+}
+
+// ValidateAddress checks that the address is not empty.
+func (*hook) ValidateAddress(to string) error {
+	if to == "" || len(to) > maxAddressLen {
+		return errors.New("invalid address")
+	}
+	return nil
+}
+
+// Push sends the OTP to the webhook URL.
+func (h *hook) Push(otp models.OTP, subject string, body []byte) error {
+	_, err := h.PushContext(context.Background(), otp, subject, body)
+	return err
+}
+
+// PushContext sends the OTP to the webhook URL. Any 2xx response is
+// treated as a success.
+func (h *hook) PushContext(ctx context.Context, otp models.OTP, subject string, body []byte) (otpgateway.PushResult, error) {
+	pl := payload{
+		Namespace: otp.Namespace,
+		ID:        otp.ID,
+		To:        otp.To,
+		OTP:       otp.OTP,
+		Subject:   subject,
+		Body:      string(body),
+	}
+
+	var (
+		req *http.Request
+		b   []byte
+		err error
+	)
+	if h.cfg.HTTPMethod == http.MethodGet {
+		b = []byte(pl.query().Encode())
+		req, err = http.NewRequest(http.MethodGet, addQuery(h.cfg.URL, string(b)), nil)
+		if err != nil {
+			return otpgateway.PushResult{}, err
+		}
+	} else {
+		b, err = json.Marshal(pl)
+		if err != nil {
+			return otpgateway.PushResult{}, err
+		}
+		req, err = http.NewRequest(h.cfg.HTTPMethod, h.cfg.URL, bytes.NewReader(b))
+		if err != nil {
+			return otpgateway.PushResult{}, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req = req.WithContext(ctx)
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if h.cfg.Secret != "" {
+		if err := webhook.SignRequest(req, []byte(h.cfg.Secret), b); err != nil {
+			return otpgateway.PushResult{}, err
+		}
+	}
+
+	resp, err := h.h.Do(req)
+	if err != nil {
+		return otpgateway.PushResult{}, err
+	}
+	defer func() {
+		// Drain the body so that the connection can be reused.
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return otpgateway.PushResult{}, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return otpgateway.PushResult{ID: otp.ID, Status: statusSent}, nil
+}
+
+// query returns the payload as query params with the JSON field names.
+func (p payload) query() url.Values {
+	return url.Values{
+		"namespace": {p.Namespace},
+		"id":        {p.ID},
+		"to":        {p.To},
+		"otp":       {p.OTP},
+		"subject":   {p.Subject},
+		"body":      {p.Body},
+	}
+}
+
+// addQuery appends the encoded query string to the URL which
+// may already have one.
+func addQuery(u, q string) string {
+	if strings.Contains(u, "?") {
+		return u + "&" + q
+	}
+	return u + "?" + q
+}
+
+// SenderIdentity returns an empty string as webhooks have no sender.
+func (*hook) SenderIdentity() string {
+	return ""
+}
+
+// MaxAddressLen returns the maximum allowed length for the address.
+func (*hook) MaxAddressLen() int {
+	return maxAddressLen
+}
+
+// MaxOTPLen returns the maximum allowed length of the OTP value.
+func (*hook) MaxOTPLen() int {
+	return maxOTPLen
+}
+
+// MaxBodyLen returns the max permitted body size.
+func (*hook) MaxBodyLen() int {
+	return maxBodyLen
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/models"
+	"github.com/zplzpl/otpgateway/webhook"
+)
+
+var mockOTP = models.OTP{
+	Namespace: "myapp",
+	ID:        "myotpid",
+	To:        "user@example.com",
+	OTP:       "123456",
+}
+
+func TestPush(t *testing.T) {
+	var (
+		hdr  http.Header
+		body []byte
+		code = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"URL": "` + srv.URL + `", "Secret": "secret", "Headers": {"X-App": "otp"}}`))
+	assert.NoError(t, err)
+	h := p.(*hook)
+
+	res, err := h.PushContext(context.Background(), mockOTP, "Verification", []byte("Your OTP is 123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, "myotpid", res.ID)
+	assert.Equal(t, "otp", hdr.Get("X-App"))
+	assert.NoError(t, webhook.VerifyWebhook([]byte("secret"), hdr, body, 0, nil))
+
+	var pl payload
+	assert.NoError(t, json.Unmarshal(body, &pl))
+	assert.Equal(t, "123456", pl.OTP)
+	assert.Equal(t, "user@example.com", pl.To)
+	assert.Equal(t, "Your OTP is 123456", pl.Body)
+
+	// Unsigned.
+	p, err = New([]byte(`{"URL": "` + srv.URL + `"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, p.(*hook).Push(mockOTP, "", nil))
+	assert.Empty(t, hdr.Get(webhook.HeaderSignature))
+
+	// Non 2xx.
+	code = http.StatusInternalServerError
+	assert.Error(t, h.Push(mockOTP, "", nil))
+
+	_, err = New([]byte(`{}`))
+	assert.Error(t, err)
+}
+
+func TestInterfaces(t *testing.T) {
+	p, err := New([]byte(`{"URL": "http://localhost"}`))
+	assert.NoError(t, err)
+
+	_, ok := p.(otpgateway.Provider)
+	assert.True(t, ok, "not a Provider")
+	_, ok = p.(otpgateway.ResultPusher)
+	assert.True(t, ok, "not a ResultPusher")
+}
+
+func TestHTTPMethod(t *testing.T) {
+	var (
+		method string
+		hdr    http.Header
+		query  url.Values
+		rawQ   string
+		body   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		hdr = r.Header
+		query = r.URL.Query()
+		rawQ = r.URL.RawQuery
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	p, err := New([]byte(`{"URL": "` + srv.URL + `", "HTTPMethod": "put"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, p.(*hook).Push(mockOTP, "", []byte("Your OTP is 123456")))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Contains(t, string(body), `"otp":"123456"`)
+
+	// GET sends the payload in the query string and signs it.
+	p, err = New([]byte(`{"URL": "` + srv.URL + `/hook?app=1", "HTTPMethod": "GET", "Secret": "secret"}`))
+	assert.NoError(t, err)
+	assert.NoError(t, p.(*hook).Push(mockOTP, "", []byte("Your OTP is 123456")))
+	assert.Equal(t, http.MethodGet, method)
+	assert.Empty(t, body)
+	assert.Equal(t, "1", query.Get("app"))
+	assert.Equal(t, "123456", query.Get("otp"))
+	assert.Equal(t, "Your OTP is 123456", query.Get("body"))
+	assert.NoError(t, webhook.VerifyWebhook([]byte("secret"), hdr, []byte(strings.TrimPrefix(rawQ, "app=1&")), 0, nil))
+
+	_, err = New([]byte(`{"URL": "` + srv.URL + `", "HTTPMethod": "PATCH"}`))
+	assert.Error(t, err, "invalid HTTPMethod accepted")
+}
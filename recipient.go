@@ -0,0 +1,43 @@
+package otpgateway
+
+import "strings"
+
+// Recipient is an address with an optional display name. Rich channels
+// (eg: e-mail) that carry names can be sent to recipients written as
+// "Name <address>" in OTPs' To, and other channels use the bare address.
+type Recipient struct {
+	Name    string
+	Address string
+}
+
+// ParseRecipient parses a recipient written as "Name <address>", where
+// the name may be quoted, or a bare address. It doesn't validate the
+// address, which is left to the Provider.
+func ParseRecipient(to string) Recipient {
+	to = strings.TrimSpace(to)
+	if !strings.HasSuffix(to, ">") {
+		return Recipient{Address: to}
+	}
+	i := strings.LastIndex(to, "<")
+	if i < 0 {
+		return Recipient{Address: to}
+	}
+
+	name := strings.TrimSpace(to[:i])
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		name = strings.Replace(name[1:len(name)-1], `\"`, `"`, -1)
+	}
+	return Recipient{
+		Name:    name,
+		Address: strings.TrimSpace(to[i+1 : len(to)-1]),
+	}
+}
+
+// String returns the recipient as "Name <address>" or the bare address
+// if it has no name.
+func (r Recipient) String() string {
+	if r.Name == "" {
+		return r.Address
+	}
+	return r.Name + " <" + r.Address + ">"
+}
@@ -0,0 +1,25 @@
+package otpgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecipient(t *testing.T) {
+	for in, out := range map[string]Recipient{
+		"+919876543210":                     {Address: "+919876543210"},
+		" john@doe.com ":                    {Address: "john@doe.com"},
+		"John Doe <john@doe.com>":           {Name: "John Doe", Address: "john@doe.com"},
+		`"Doe, John" <john@doe.com>`:        {Name: "Doe, John", Address: "john@doe.com"},
+		`"John \"JD\" Doe" <+919876543210>`: {Name: `John "JD" Doe`, Address: "+919876543210"},
+		"John <+91 98765 43210>":            {Name: "John", Address: "+91 98765 43210"},
+		"<+919876543210>":                   {Address: "+919876543210"},
+		"john>":                             {Address: "john>"},
+	} {
+		assert.Equal(t, out, ParseRecipient(in), in)
+	}
+
+	assert.Equal(t, "John Doe <john@doe.com>", Recipient{Name: "John Doe", Address: "john@doe.com"}.String())
+	assert.Equal(t, "john@doe.com", Recipient{Address: "john@doe.com"}.String())
+}
@@ -0,0 +1,74 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownProvider is returned by NewProvider when there's no
+// Provider registered with the given ID.
+var ErrUnknownProvider = errors.New("unknown provider")
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ProviderFactory)
+)
+
+// Register makes a Provider factory available by the given ID to
+// NewProvider. It's meant to be called from the init() of Provider
+// packages and panics if the ID is registered twice or if the
+// factory is nil.
+func Register(id string, f ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if f == nil {
+		panic("otpgateway: Register factory is nil for " + id)
+	}
+	if _, ok := registry[id]; ok {
+		panic("otpgateway: Register called twice for " + id)
+	}
+	registry[id] = f
+}
+
+// NewProvider returns a new instance of the Provider registered with
+// the given ID initialised with the JSON config.
+func NewProvider(id string, jsonCfg []byte) (Provider, error) {
+	registryMu.RLock()
+	f, ok := registry[id]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, id)
+	}
+	return f(jsonCfg)
+}
+
+// NewProviderWithWarmup is like NewProvider but also runs the new
+// Provider's Warmup, if it implements Warmer, and returns its error.
+func NewProviderWithWarmup(ctx context.Context, id string, jsonCfg []byte) (Provider, error) {
+	p, err := NewProvider(id, jsonCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := Warmup(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Providers returns the sorted IDs of the registered Providers.
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	out := make([]string, 0, len(registry))
+	for id := range registry {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,52 @@
+package otpgateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	Register("fake", func(cfg []byte) (Provider, error) {
+		if string(cfg) == "bad" {
+			return nil, errors.New("invalid config")
+		}
+		return &healthProv{id: "fake"}, nil
+	})
+	assert.Contains(t, Providers(), "fake")
+
+	p, err := NewProvider("fake", []byte("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, "fake", p.ID())
+
+	// Factory errors are returned.
+	_, err = NewProvider("fake", []byte("bad"))
+	assert.EqualError(t, err, "invalid config")
+
+	// Unknown ID.
+	_, err = NewProvider("unknown", nil)
+	assert.True(t, errors.Is(err, ErrUnknownProvider))
+
+	// Warmup.
+	errAuth := errors.New("invalid credentials")
+	Register("warm", func(cfg []byte) (Provider, error) {
+		w := &warmProv{healthProv: healthProv{id: "warm"}}
+		if string(cfg) == "badkey" {
+			w.err = errAuth
+		}
+		return w, nil
+	})
+	p, err = NewProviderWithWarmup(context.Background(), "warm", []byte("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.(*warmProv).warmed)
+	_, err = NewProviderWithWarmup(context.Background(), "warm", []byte("badkey"))
+	assert.True(t, errors.Is(err, errAuth))
+	_, err = NewProviderWithWarmup(context.Background(), "unknown", nil)
+	assert.True(t, errors.Is(err, ErrUnknownProvider))
+
+	// Duplicate and nil registrations.
+	assert.Panics(t, func() { Register("fake", func([]byte) (Provider, error) { return nil, nil }) })
+	assert.Panics(t, func() { Register("nil", nil) })
+}
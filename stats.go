@@ -0,0 +1,95 @@
+package otpgateway
+
+import (
+	"sync"
+	"time"
+)
+
+// ProviderStats is a snapshot of a Provider's delivery counters.
+type ProviderStats struct {
+	Sent        uint64    `json:"sent"`
+	Failed      uint64    `json:"failed"`
+	Retried     uint64    `json:"retried"`
+	SLABreached uint64    `json:"sla_breached"`
+	LastError   string    `json:"last_error"`
+	LastErrorAt time.Time `json:"last_error_at"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// StatsCounter is a concurrency safe counter of ProviderStats that
+// Providers can use to keep in-process delivery stats.
+type StatsCounter struct {
+	stats ProviderStats
+	mu    sync.Mutex
+
+	// Error of the current failing streak and its length.
+	lastErr   error
+	lastErrAt time.Time
+	failing   int
+}
+
+// ErrorReporter is an optional interface implemented by Providers that
+// keep the error of their most recent failed send.
+type ErrorReporter interface {
+	// LastError returns the error of the most recent send and when it
+	// happened, or nil if the send succeeded.
+	LastError() (error, time.Time)
+}
+
+// Success records a successful send and clears the last error. It
+// returns the number of consecutive failures the success ended,
+// for logging recoveries.
+func (c *StatsCounter) Success() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Sent++
+	c.stats.LastSuccess = time.Now()
+
+	n := c.failing
+	c.lastErr = nil
+	c.lastErrAt = time.Time{}
+	c.failing = 0
+	return n
+}
+
+// Fail records a failed send.
+func (c *StatsCounter) Fail(err error) {
+	c.mu.Lock()
+	c.stats.Failed++
+	c.stats.LastError = err.Error()
+	c.stats.LastErrorAt = time.Now()
+	c.lastErr = err
+	c.lastErrAt = c.stats.LastErrorAt
+	c.failing++
+	c.mu.Unlock()
+}
+
+// LastError returns the error of the most recent send and when it
+// happened. It is nil if the most recent send succeeded.
+func (c *StatsCounter) LastError() (error, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr, c.lastErrAt
+}
+
+// Retry records a retried send.
+func (c *StatsCounter) Retry() {
+	c.mu.Lock()
+	c.stats.Retried++
+	c.mu.Unlock()
+}
+
+// SLABreach records a successful send that breached the SLA.
+func (c *StatsCounter) SLABreach() {
+	c.mu.Lock()
+	c.stats.SLABreached++
+	c.mu.Unlock()
+}
+
+// Stats returns a copy of the current stats.
+func (c *StatsCounter) Stats() ProviderStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
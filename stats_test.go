@@ -0,0 +1,62 @@
+package otpgateway
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsCounter(t *testing.T) {
+	var c StatsCounter
+	assert.Equal(t, ProviderStats{}, c.Stats())
+
+	c.Success()
+	c.Retry()
+	c.Fail(errors.New("send failed"))
+	c.Success()
+
+	s := c.Stats()
+	assert.Equal(t, uint64(2), s.Sent)
+	assert.Equal(t, uint64(1), s.Failed)
+	assert.Equal(t, uint64(1), s.Retried)
+	assert.Equal(t, "send failed", s.LastError)
+	assert.False(t, s.LastSuccess.IsZero(), "last success not set")
+	assert.False(t, s.LastErrorAt.IsZero(), "last error time not set")
+
+	// The last error is cleared by a success.
+	err, at := c.LastError()
+	assert.NoError(t, err)
+	assert.True(t, at.IsZero())
+
+	// Concurrent updates.
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			c.Success()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(102), c.Stats().Sent)
+}
+
+func TestStatsCounterLastError(t *testing.T) {
+	var c StatsCounter
+	err, _ := c.LastError()
+	assert.NoError(t, err)
+
+	c.Fail(errors.New("first"))
+	c.Fail(errors.New("second"))
+	err, at := c.LastError()
+	assert.EqualError(t, err, "second")
+	assert.False(t, at.IsZero(), "last error time not set")
+
+	assert.Equal(t, 2, c.Success(), "failing streak not returned")
+	err, at = c.LastError()
+	assert.NoError(t, err)
+	assert.True(t, at.IsZero())
+	assert.Equal(t, 0, c.Success())
+}
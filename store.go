@@ -60,7 +60,15 @@ func NewRedisStore(c RedisConf) Store {
 	if c.KeyPrefix == "" {
 		c.KeyPrefix = "OTP"
 	}
-	pool := &redis.Pool{
+	return &redisStore{
+		pool:      newRedisPool(c),
+		keyPrefix: c.KeyPrefix,
+	}
+}
+
+// newRedisPool returns a Redis connection pool.
+func newRedisPool(c RedisConf) *redis.Pool {
+	return &redis.Pool{
 		Wait:      true,
 		MaxActive: c.MaxActive,
 		MaxIdle:   c.MaxIdle,
@@ -77,10 +85,6 @@ func NewRedisStore(c RedisConf) Store {
 			return c, err
 		},
 	}
-	return &redisStore{
-		pool:      pool,
-		keyPrefix: c.KeyPrefix,
-	}
 }
 
 // Ping checks if Redis server is reachable
@@ -0,0 +1,126 @@
+package otpgateway
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/zplzpl/otpgateway/phone"
+)
+
+// ErrSuppressed is returned by Providers when the address is on
+// the suppression list.
+var ErrSuppressed = errors.New("address is on the suppression list")
+
+// MalformedRowsError is returned by LoadSuppressionCSV when one or more
+// rows were skipped because they didn't contain a valid number.
+type MalformedRowsError struct {
+	// Lines are the line numbers of the skipped rows.
+	Lines []int
+}
+
+func (e *MalformedRowsError) Error() string {
+	return fmt.Sprintf("skipped %d malformed rows", len(e.Lines))
+}
+
+// SuppressionList is a concurrency safe set of phone numbers (for
+// instance, numbers that have opted out) that messages should not
+// be sent to. Numbers are stored in the normalized E.164 format.
+type SuppressionList struct {
+	nums map[string]struct{}
+	mu   sync.RWMutex
+}
+
+// NewSuppressionList returns an empty SuppressionList.
+func NewSuppressionList() *SuppressionList {
+	return &SuppressionList{nums: make(map[string]struct{})}
+}
+
+// Add normalizes and adds a number to the list.
+func (l *SuppressionList) Add(num string) error {
+	n, err := phone.Normalize(num)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.nums[n] = struct{}{}
+	l.mu.Unlock()
+	return nil
+}
+
+// Contains tells if a number is on the list.
+func (l *SuppressionList) Contains(num string) bool {
+	n, err := phone.Normalize(num)
+	if err != nil {
+		return false
+	}
+
+	l.mu.RLock()
+	_, ok := l.nums[n]
+	l.mu.RUnlock()
+	return ok
+}
+
+// Len returns the number of numbers on the list.
+func (l *SuppressionList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.nums)
+}
+
+// LoadSuppressionCSV loads numbers from the first column of a CSV
+// (eg: an opt-out list exported from a carrier) into the list and returns
+// the number of rows loaded. Rows without a valid number are skipped and
+// reported in a *MalformedRowsError after the valid rows are loaded.
+// A header row is skipped. It's safe to call while the list is in use.
+func (l *SuppressionList) LoadSuppressionCSV(r io.Reader) (int, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+
+	var (
+		nums    []string
+		skipped []int
+		line    = 0
+	)
+	for {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				skipped = append(skipped, line)
+				continue
+			}
+			return 0, err
+		}
+
+		n, err := phone.Normalize(rec[0])
+		if err != nil {
+			// Header.
+			if line == 1 {
+				continue
+			}
+			skipped = append(skipped, line)
+			continue
+		}
+		nums = append(nums, n)
+	}
+
+	// Parse outside the lock and add in one go to not block lookups.
+	l.mu.Lock()
+	for _, n := range nums {
+		l.nums[n] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	if len(skipped) > 0 {
+		return len(nums), &MalformedRowsError{Lines: skipped}
+	}
+	return len(nums), nil
+}
@@ -0,0 +1,62 @@
+package otpgateway
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSuppressionCSV(t *testing.T) {
+	l := NewSuppressionList()
+	n, err := l.LoadSuppressionCSV(strings.NewReader(`number,opted_out_at
++919876543210,2019-01-01
+"+1 (415) 555-1234",2019-01-02
++442071838750
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, l.Len())
+	assert.True(t, l.Contains("+919876543210"))
+	assert.True(t, l.Contains("+14155551234"), "number not normalized")
+	assert.True(t, l.Contains("+91 98765 43210"), "lookup not normalized")
+	assert.False(t, l.Contains("+919999999999"))
+}
+
+func TestLoadSuppressionCSVMalformed(t *testing.T) {
+	l := NewSuppressionList()
+	n, err := l.LoadSuppressionCSV(strings.NewReader(`+919876543210
+not-a-number
++14155551234
+12345
+`))
+	assert.Equal(t, 2, n)
+
+	var mErr *MalformedRowsError
+	assert.True(t, errors.As(err, &mErr), "malformed rows not reported")
+	assert.Equal(t, []int{2, 4}, mErr.Lines)
+	assert.True(t, l.Contains("+14155551234"), "valid rows not loaded")
+}
+
+func TestLoadSuppressionCSVConcurrent(t *testing.T) {
+	l := NewSuppressionList()
+	l.Add("+919876543210")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			l.LoadSuppressionCSV(strings.NewReader(fmt.Sprintf("+1415555%04d\n", i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.True(t, l.Contains("+919876543210"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 11, l.Len())
+}
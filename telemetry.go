@@ -0,0 +1,48 @@
+package otpgateway
+
+import "context"
+
+// Attr is a span or metric attribute.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a traced unit of work, for instance, an OpenTelemetry span.
+type Span interface {
+	SetAttributes(attrs ...Attr)
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans. It's meant to be a thin adapter over an
+// OpenTelemetry trace.Tracer so that the gateway and the Providers
+// don't depend on the OpenTelemetry SDK.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Meter records metrics, for instance, with instruments created from
+// an OpenTelemetry MeterProvider.
+type Meter interface {
+	// Add adds n to a counter.
+	Add(ctx context.Context, name string, n int64, attrs ...Attr)
+
+	// Record records a value in a histogram.
+	Record(ctx context.Context, name string, v float64, attrs ...Attr)
+}
+
+// Metric and span names recorded by Providers.
+const (
+	MetricPushes       = "otpgateway.pushes"
+	MetricPushDuration = "otpgateway.push.duration"
+	MetricSLABreaches  = "otpgateway.push.sla_breaches"
+	SpanPush           = "otpgateway.push"
+)
+
+// Instrumented is an optional interface implemented by Providers that
+// emit traces and metrics. Without it (or with nil values), nothing
+// is recorded.
+type Instrumented interface {
+	SetTelemetry(t Tracer, m Meter)
+}
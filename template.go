@@ -0,0 +1,67 @@
+package otpgateway
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/zplzpl/otpgateway/models"
+)
+
+// TemplateContext is the data that body and subject templates are
+// rendered with by the gateway and the Providers, so that templates
+// can be written the same way for every channel, eg:
+// {{ .OTP }} is your {{ .Namespace }} code. It expires at {{ .Expiry.Format "15:04" }}.
+type TemplateContext struct {
+	OTP       string
+	To        string
+	Namespace string
+
+	// Sender is the sender identity the message appears from.
+	Sender string
+
+	// Expiry is when the OTP expires. It's zero if the OTP's TTL
+	// is unknown.
+	Expiry time.Time
+
+	// Extra is the top level fields of the OTP's extra JSON object.
+	// Values that aren't strings are in their JSON form.
+	Extra map[string]string
+}
+
+// NewTemplateContext returns the TemplateContext of an OTP sent from
+// the given sender.
+func NewTemplateContext(otp models.OTP, sender string) TemplateContext {
+	c := TemplateContext{
+		OTP:       otp.OTP,
+		To:        otp.To,
+		Namespace: otp.Namespace,
+		Sender:    sender,
+		Extra:     make(map[string]string),
+	}
+
+	if ttl := TTLOf(otp); ttl > 0 {
+		c.Expiry = time.Now().Add(ttl)
+	}
+
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(otp.Extra, &extra); err == nil {
+		for k, v := range extra {
+			var s string
+			if err := json.Unmarshal(v, &s); err == nil {
+				c.Extra[k] = s
+			} else {
+				c.Extra[k] = string(v)
+			}
+		}
+	}
+	return c
+}
+
+// SenderOf returns the sender identity that messages to the given
+// address are sent from by the Provider.
+func SenderOf(p Provider, to string) string {
+	if s, ok := p.(SenderResolver); ok {
+		return s.SenderFor(to)
+	}
+	return p.SenderIdentity()
+}
@@ -0,0 +1,45 @@
+package otpgateway
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway/models"
+)
+
+func TestTemplateContext(t *testing.T) {
+	otp := models.OTP{
+		Namespace: "myapp",
+		To:        "+919876543210",
+		OTP:       "482910",
+		TTL:       time.Minute * 5,
+		Extra:     []byte(`{"name": "John", "attempt": 2, "tags": ["a"]}`),
+	}
+
+	start := time.Now()
+	c := NewTemplateContext(otp, "MYAPP")
+	assert.WithinDuration(t, start.Add(time.Minute*5), c.Expiry, time.Second)
+
+	tpl := template.Must(template.New("body").Parse(
+		`{{ .OTP }}|{{ .To }}|{{ .Namespace }}|{{ .Sender }}|{{ .Expiry.Format "2006-01-02 15:04" }}|` +
+			`{{ .Extra.name }}|{{ .Extra.attempt }}|{{ .Extra.tags }}|{{ index .Extra "missing" }}`))
+	var b bytes.Buffer
+	assert.NoError(t, tpl.Execute(&b, c))
+	assert.Equal(t, "482910|+919876543210|myapp|MYAPP|"+c.Expiry.Format("2006-01-02 15:04")+`|John|2|["a"]|`, b.String())
+
+	// TTLSeconds is used when the OTP is read back from the store.
+	c = NewTemplateContext(models.OTP{TTLSeconds: 60}, "")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), c.Expiry, time.Second)
+
+	// No TTL or extra.
+	c = NewTemplateContext(models.OTP{Extra: []byte(`[1]`)}, "")
+	assert.True(t, c.Expiry.IsZero())
+	assert.Empty(t, c.Extra)
+}
+
+func TestSenderOf(t *testing.T) {
+	assert.Equal(t, "dummysender", SenderOf(&healthProv{id: "dummy"}, "+919876543210"))
+}
@@ -0,0 +1,48 @@
+package otpgateway
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrProviderDisabled is returned by the pushes of Providers that are
+// disabled with SetEnabled.
+var ErrProviderDisabled = errors.New("provider is disabled")
+
+// Toggler is an optional interface implemented by Providers that can be
+// disabled at runtime, for instance, to take a failing Provider out of
+// service without a redeploy. Disabled Providers fail pushes with
+// ErrProviderDisabled and are skipped by composite Providers.
+type Toggler interface {
+	SetEnabled(enabled bool)
+	Enabled() bool
+}
+
+// Toggle is a concurrency safe Toggler that Providers can embed. The
+// zero value is enabled.
+type Toggle struct {
+	disabled int32
+}
+
+// SetEnabled enables or disables the Provider.
+func (t *Toggle) SetEnabled(enabled bool) {
+	var v int32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&t.disabled, v)
+}
+
+// Enabled tells if the Provider is enabled.
+func (t *Toggle) Enabled() bool {
+	return atomic.LoadInt32(&t.disabled) == 0
+}
+
+// IsEnabled tells if a Provider is enabled. Providers that don't
+// implement Toggler are always enabled.
+func IsEnabled(p Provider) bool {
+	if t, ok := p.(Toggler); ok {
+		return t.Enabled()
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package otpgateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type toggledProv struct {
+	healthProv
+	Toggle
+}
+
+func TestToggle(t *testing.T) {
+	var tg Toggle
+	assert.True(t, tg.Enabled(), "zero value not enabled")
+	tg.SetEnabled(false)
+	assert.False(t, tg.Enabled())
+	tg.SetEnabled(true)
+	assert.True(t, tg.Enabled())
+
+	p := &toggledProv{}
+	assert.True(t, IsEnabled(p))
+	p.SetEnabled(false)
+	assert.False(t, IsEnabled(p))
+	assert.True(t, IsEnabled(&healthProv{}), "non Toggler disabled")
+}
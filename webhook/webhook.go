@@ -0,0 +1,138 @@
+// Package webhook implements HMAC signing and verification of webhook
+// payloads. The signature covers a timestamp and a random nonce along
+// with the body so that receivers can reject replayed payloads by
+// verifying with a NonceStore.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+// Headers in which the signature parameters are sent.
+const (
+	HeaderTimestamp = "X-OTP-Timestamp"
+	HeaderNonce     = "X-OTP-Nonce"
+	HeaderSignature = "X-OTP-Signature"
+
+	// DefaultSkew is the default window within which a payload's
+	// timestamp is accepted.
+	DefaultSkew = time.Minute * 5
+)
+
+// Verification errors.
+var (
+	ErrMissingSignature = errors.New("missing webhook signature headers")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpired          = errors.New("webhook timestamp outside the allowed window")
+	ErrReplayed         = errors.New("webhook nonce already seen")
+)
+
+// NonceStore remembers the nonces of verified payloads. Its method set
+// is that of otpgateway.CooldownStore, so the in-process and Redis
+// cooldown stores (shared by all instances) can be used as is.
+type NonceStore interface {
+	// Acquire records key for ttl if it isn't recorded and reports
+	// whether it did. false means the key was already seen.
+	Acquire(key string, ttl time.Duration) (bool, error)
+}
+
+// clk is replaced in tests.
+var clk = clock.Real
+
+// Sign returns the hex encoded HMAC-SHA256 signature of the timestamp,
+// nonce, and body.
+func Sign(secret []byte, ts int64, nonce string, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(strconv.FormatInt(ts, 10)))
+	m.Write([]byte("."))
+	m.Write([]byte(nonce))
+	m.Write([]byte("."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// SignRequest sets the timestamp, a random nonce, and the signature
+// of body on the request's headers.
+func SignRequest(req *http.Request, secret, body []byte) error {
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return err
+	}
+
+	var (
+		ts    = clk.Now().Unix()
+		nonce = hex.EncodeToString(n)
+	)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(secret, ts, nonce, body))
+	return nil
+}
+
+// VerifyWebhook verifies the signature in the headers against the body
+// and rejects payloads whose timestamp is more than skew away from the
+// current time. If skew is 0, DefaultSkew is used. If nonces isn't nil,
+// payloads whose nonce was already seen are rejected with ErrReplayed.
+// Nonces are remembered until the payload's timestamp falls out of the
+// window.
+func VerifyWebhook(secret []byte, h http.Header, body []byte, skew time.Duration, nonces NonceStore) error {
+	var (
+		tsStr = h.Get(HeaderTimestamp)
+		nonce = h.Get(HeaderNonce)
+		sig   = h.Get(HeaderSignature)
+	)
+	if tsStr == "" || nonce == "" || sig == "" {
+		return ErrMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(Sign(secret, ts, nonce, body))) {
+		return ErrInvalidSignature
+	}
+
+	if skew == 0 {
+		skew = DefaultSkew
+	}
+	var (
+		now = clk.Now()
+		t   = time.Unix(ts, 0)
+		d   = now.Sub(t)
+	)
+	if d < 0 {
+		d = -d
+	}
+	if d > skew {
+		return ErrExpired
+	}
+
+	// Only the nonces of authentic payloads are recorded so that forged
+	// ones can't fill up the store.
+	if nonces == nil {
+		return nil
+	}
+	ttl := t.Add(skew).Sub(now)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	ok, err := nonces.Acquire("webhook-nonce:"+nonce, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrReplayed
+	}
+	return nil
+}
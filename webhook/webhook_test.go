@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zplzpl/otpgateway"
+	"github.com/zplzpl/otpgateway/internal/clock"
+)
+
+var (
+	secret = []byte("secret")
+	body   = []byte(`{"otp": "123456"}`)
+)
+
+func newSignedReq(t *testing.T) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, SignRequest(req, secret, body))
+	return req
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	// Fresh, valid payload.
+	req := newSignedReq(t)
+	assert.NoError(t, VerifyWebhook(secret, req.Header, body, 0, nil))
+	assert.Len(t, req.Header.Get(HeaderNonce), 32)
+
+	// Nonces are unique.
+	assert.NotEqual(t, req.Header.Get(HeaderNonce), newSignedReq(t).Header.Get(HeaderNonce))
+
+	// Wrong secret and tampered body.
+	assert.Equal(t, ErrInvalidSignature, VerifyWebhook([]byte("wrong"), req.Header, body, 0, nil))
+	assert.Equal(t, ErrInvalidSignature, VerifyWebhook(secret, req.Header, []byte("{}"), 0, nil))
+
+	// Missing headers.
+	assert.Equal(t, ErrMissingSignature, VerifyWebhook(secret, http.Header{}, body, 0, nil))
+}
+
+func TestVerifyWebhookTamperedNonce(t *testing.T) {
+	req := newSignedReq(t)
+	req.Header.Set(HeaderNonce, "0123456789abcdef0123456789abcdef")
+	assert.Equal(t, ErrInvalidSignature, VerifyWebhook(secret, req.Header, body, 0, nil))
+
+	// Tampered timestamp.
+	req = newSignedReq(t)
+	ts, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts+1, 10))
+	assert.Equal(t, ErrInvalidSignature, VerifyWebhook(secret, req.Header, body, 0, nil))
+}
+
+func TestVerifyWebhookExpired(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	clk = fake
+	defer func() { clk = clock.Real }()
+
+	// Verify 10 minutes after signing.
+	req := newSignedReq(t)
+	fake.Advance(time.Minute * 10)
+
+	assert.Equal(t, ErrExpired, VerifyWebhook(secret, req.Header, body, 0, nil))
+	assert.Equal(t, ErrExpired, VerifyWebhook(secret, req.Header, body, time.Minute, nil))
+	assert.NoError(t, VerifyWebhook(secret, req.Header, body, time.Minute*15, nil))
+
+	// Timestamps too far in the future are rejected too.
+	req = newSignedReq(t)
+	fake.Advance(-time.Minute * 10)
+	assert.Equal(t, ErrExpired, VerifyWebhook(secret, req.Header, body, 0, nil))
+}
+
+func TestVerifyWebhookReplay(t *testing.T) {
+	nonces := otpgateway.NewMemCooldownStore()
+
+	req := newSignedReq(t)
+	assert.NoError(t, VerifyWebhook(secret, req.Header, body, 0, nonces))
+	assert.Equal(t, ErrReplayed, VerifyWebhook(secret, req.Header, body, 0, nonces), "replayed payload accepted")
+
+	// Other payloads are accepted.
+	assert.NoError(t, VerifyWebhook(secret, newSignedReq(t).Header, body, 0, nonces))
+
+	// Nonces of payloads that fail verification aren't recorded.
+	req = newSignedReq(t)
+	assert.Equal(t, ErrInvalidSignature, VerifyWebhook([]byte("wrong"), req.Header, body, 0, nonces))
+	assert.NoError(t, VerifyWebhook(secret, req.Header, body, 0, nonces))
+}
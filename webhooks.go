@@ -0,0 +1,109 @@
+package otpgateway
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Max size of a callback body that's read.
+const maxWebhookBodyLen = 1 << 20
+
+// ErrWebhookUnauthorized is returned (wrapped) by WebhookParsers when
+// a callback's signature or token is missing or invalid.
+var ErrWebhookUnauthorized = errors.New("invalid webhook signature")
+
+// WebhookParser is an optional interface implemented by Providers that
+// can parse their backend's callbacks, for instance, delivery reports
+// and inbound (STOP) messages, into EventDelivery and EventOptOut
+// Events. Implementations verify the callback's authenticity. Callbacks
+// that aren't of interest return no events.
+type WebhookParser interface {
+	ParseWebhook(r *http.Request, body []byte) ([]Event, error)
+}
+
+// optOutKeywords are the standard (CTIA) opt-out keywords.
+var optOutKeywords = map[string]bool{
+	"STOP":        true,
+	"STOPALL":     true,
+	"UNSUBSCRIBE": true,
+	"CANCEL":      true,
+	"END":         true,
+	"QUIT":        true,
+}
+
+// IsOptOut checks whether the text of an inbound message is an opt-out
+// keyword, eg: "STOP".
+func IsOptOut(text string) bool {
+	return optOutKeywords[strings.ToUpper(strings.Trim(text, " \t\r\n.!"))]
+}
+
+// WebhookHandler returns an HTTP handler that parses the Provider's
+// callbacks with its WebhookParser and emits the events to sink.
+// Callbacks that fail verification are rejected with a 401 and
+// unparsable ones with a 400. Providers that aren't WebhookParsers
+// respond with a 404.
+func WebhookHandler(p Provider, sink func(Event)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wp, ok := p.(WebhookParser)
+		if !ok {
+			http.Error(w, "provider doesn't support webhooks", http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBodyLen))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+
+		events, err := wp.ParseWebhook(r, body)
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, ErrWebhookUnauthorized) {
+				code = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		now := time.Now()
+		for _, e := range events {
+			if e.Provider == "" {
+				e.Provider = p.ID()
+			}
+			if e.Timestamp.IsZero() {
+				e.Timestamp = now
+			}
+			sink(e)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// WebhookMux returns an HTTP handler that routes callbacks to the
+// WebhookHandlers of the Providers by the Provider ID in the last
+// segment of the path, eg: /webhooks/solsms.
+func WebhookMux(sink func(Event), providers ...Provider) http.Handler {
+	handlers := make(map[string]http.Handler, len(providers))
+	for _, p := range providers {
+		handlers[p.ID()] = WebhookHandler(p, sink)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[path.Base(r.URL.Path)]
+		if !ok {
+			http.Error(w, "unknown provider", http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
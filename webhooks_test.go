@@ -0,0 +1,66 @@
+package otpgateway
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookProv parses "<status> <message id>" callbacks signed with a
+// token header.
+type webhookProv struct {
+	healthProv
+}
+
+func (w *webhookProv) ParseWebhook(r *http.Request, body []byte) ([]Event, error) {
+	if r.Header.Get("X-Token") != "secret" {
+		return nil, ErrWebhookUnauthorized
+	}
+	f := strings.Fields(string(body))
+	if len(f) != 2 {
+		return nil, errors.New("invalid callback")
+	}
+	return []Event{{Type: EventDelivery, Status: f[0], MessageID: f[1]}}, nil
+}
+
+func TestWebhookMux(t *testing.T) {
+	var events []Event
+	h := WebhookMux(func(e Event) { events = append(events, e) },
+		&webhookProv{healthProv{id: "hook"}}, &healthProv{id: "plain"})
+
+	post := func(path, token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-Token", token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("/webhooks/hook", "secret", "delivered msg1"))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventDelivery, events[0].Type)
+		assert.Equal(t, "hook", events[0].Provider, "provider not set")
+		assert.Equal(t, "msg1", events[0].MessageID)
+		assert.Equal(t, StatusDelivered, events[0].Status)
+		assert.False(t, events[0].Timestamp.IsZero(), "timestamp not set")
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("/webhooks/hook", "wrong", "delivered msg1"))
+	assert.Equal(t, http.StatusBadRequest, post("/webhooks/hook", "secret", "junk"))
+	assert.Equal(t, http.StatusNotFound, post("/webhooks/plain", "secret", "delivered msg1"))
+	assert.Equal(t, http.StatusNotFound, post("/webhooks/unknown", "secret", "delivered msg1"))
+	assert.Len(t, events, 1)
+}
+
+func TestIsOptOut(t *testing.T) {
+	for _, s := range []string{"STOP", "stop", " Stop.\n", "UNSUBSCRIBE", "quit!"} {
+		assert.True(t, IsOptOut(s), s)
+	}
+	for _, s := range []string{"", "don't stop", "STOPPED", "START"} {
+		assert.False(t, IsOptOut(s), s)
+	}
+}